@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/backup"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/media"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+)
+
+const usage = `Usage:
+  server                          start the API server
+  server backup                   write a backup to BACKUP_DIR
+  server restore [-check] <file>  restore a backup (stop the server first);
+                                  -check only validates the archive
+  server storage-copy             copy the uploads in the data directory to
+                                  the configured STORAGE_BACKEND
+  server reprocess-images         strip the metadata of images uploaded
+                                  before it was stripped, and add variants`
+
+// runCommand runs a maintenance subcommand and returns the exit code
+func runCommand(cfg *config.Config, args []string) int {
+	store, err := storage.New(cfg.Storage, cfg.DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize storage: %v\n", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "backup":
+		db, err := database.New(cfg.DatabasePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		info, err := backup.Create(ctx, db, store, cfg.Backup.Dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+			return 1
+		}
+		if err := backup.Rotate(cfg.Backup.Dir, cfg.Backup.Keep); err != nil {
+			fmt.Fprintf(os.Stderr, "Backup rotation failed: %v\n", err)
+		}
+		fmt.Printf("Backup written: %s (%d bytes)\n", info.Name, info.SizeBytes)
+		return 0
+
+	case "restore":
+		flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+		check := flags.Bool("check", false, "only validate the archive")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		archive := flags.Arg(0)
+
+		if *check {
+			manifest, err := backup.Validate(archive)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Archive is not valid: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Archive is valid: backup of %s, %d files\n", manifest.CreatedAt.Format("2006-01-02 15:04:05"), len(manifest.Files))
+			return 0
+		}
+
+		manifest, err := backup.Restore(ctx, archive, cfg.DatabasePath, store)
+		if err == database.ErrInUse {
+			fmt.Fprintln(os.Stderr, "Restore failed: the server is still running, stop it first")
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Restored backup of %s (%d files) to %s\n", manifest.CreatedAt.Format("2006-01-02 15:04:05"), len(manifest.Files), cfg.DatabasePath)
+		return 0
+
+	case "reprocess-images":
+		db, err := database.New(cfg.DatabasePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		rewritten, err := media.Reprocess(ctx, db, store)
+		for _, filename := range rewritten {
+			fmt.Println("Reprocessed", filename)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Reprocessing failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Reprocessed %d images\n", len(rewritten))
+		return 0
+
+	case "storage-copy":
+		if store.Name() == "local" {
+			fmt.Fprintln(os.Stderr, "STORAGE_BACKEND is local: set it to the backend to copy the uploads to")
+			return 2
+		}
+		copied, skipped, err := storage.Copy(ctx, store, storage.NewLocal(cfg.DataDir), storage.Prefixes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Copy failed after %d files: %v\n", copied, err)
+			return 1
+		}
+		fmt.Printf("Copied %d files to %s, %d were already there\n", copied, store.Name(), skipped)
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
@@ -14,10 +14,64 @@ type Config struct {
 	DatabasePath string
 	DataDir      string
 	FrontendURL  string
+	APIURL       string
 	JWT          JWTConfig
 	SMTP         SMTPConfig
 	ReminderDays int
 	CORSOrigins  []string
+	// QRLogoPath is a PNG or JPEG placed in the centre of generated QR
+	// codes; empty uses the built-in club logo
+	QRLogoPath string
+	// MediaOrphanDays is how long an uploaded image may go unused before the
+	// daily cleanup deletes it; 0 leaves cleanup to the admin
+	MediaOrphanDays int
+	Storage         StorageConfig
+	Backup          BackupConfig
+	// TranslationFallback are the languages tried, in order, when content
+	// is not translated in the requested language
+	TranslationFallback []string
+	Translator          TranslatorConfig
+}
+
+// TranslatorConfig selects the machine translation service that proposes
+// draft translations to admins
+type TranslatorConfig struct {
+	// Provider is "deepl", "libretranslate" or "fake"; empty disables
+	// machine translation
+	Provider          string
+	DeepLKey          string
+	LibreTranslateURL string
+	LibreTranslateKey string
+	// SourceLang is translated from when the request names no source
+	SourceLang string
+}
+
+// BackupConfig controls the scheduled backups of the database and uploads
+type BackupConfig struct {
+	Dir string
+	// IntervalHours between scheduled backups; 0 disables them
+	IntervalHours int
+	// Keep is how many backups rotation leaves in Dir
+	Keep int
+}
+
+// StorageConfig selects where uploaded images and files are kept
+type StorageConfig struct {
+	// Backend is "local" (below DataDir) or "s3"
+	Backend string
+	// Redirect serves files by redirecting to a signed URL of the backend
+	// instead of streaming them through the API
+	Redirect         bool
+	URLExpiryMinutes int
+	S3Endpoint       string
+	S3Region         string
+	S3Bucket         string
+	S3AccessKey      string
+	S3SecretKey      string
+	S3Prefix         string
+	// S3PathStyle puts the bucket in the path instead of the host name, as
+	// MinIO and most self-hosted servers expect
+	S3PathStyle bool
 }
 
 type JWTConfig struct {
@@ -37,13 +91,17 @@ type SMTPConfig struct {
 func Load() *Config {
 	dbPath := getEnv("DATABASE_PATH", "./data/geocaching.db")
 	dataDir := filepath.Dir(dbPath)
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:5173")
 
 	return &Config{
 		Port:         getEnv("PORT", "8080"),
 		Env:          getEnv("ENV", "development"),
 		DatabasePath: dbPath,
 		DataDir:      dataDir,
-		FrontendURL:  getEnv("FRONTEND_URL", "http://localhost:5173"),
+		FrontendURL:  frontendURL,
+		// Public base URL of this API, used for payment provider webhooks.
+		// nginx serves it under /api on the frontend domain by default.
+		APIURL: strings.TrimSuffix(getEnv("API_URL", frontendURL+"/api"), "/"),
 		JWT: JWTConfig{
 			Secret:      getEnv("JWT_SECRET", "change-me-in-production"),
 			ExpiryHours: getEnvInt("JWT_EXPIRY_HOURS", 24),
@@ -58,6 +116,34 @@ func Load() *Config {
 		},
 		ReminderDays: getEnvInt("REMINDER_DAYS", 3),
 		CORSOrigins:  strings.Split(getEnv("CORS_ORIGINS", "http://localhost:5173"), ","),
+		QRLogoPath:   getEnv("QR_LOGO_PATH", ""),
+
+		MediaOrphanDays:     getEnvInt("MEDIA_ORPHAN_DAYS", 0),
+		TranslationFallback: parseLanguageList(getEnv("TRANSLATION_FALLBACK", "NL,EN")),
+		Storage: StorageConfig{
+			Backend:          strings.ToLower(getEnv("STORAGE_BACKEND", "local")),
+			Redirect:         getEnv("STORAGE_REDIRECT", "false") == "true",
+			URLExpiryMinutes: getEnvInt("STORAGE_URL_EXPIRY_MINUTES", 60),
+			S3Endpoint:       getEnv("S3_ENDPOINT", ""),
+			S3Region:         getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:         getEnv("S3_BUCKET", ""),
+			S3AccessKey:      getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey:      getEnv("S3_SECRET_KEY", ""),
+			S3Prefix:         getEnv("S3_PREFIX", ""),
+			S3PathStyle:      getEnv("S3_PATH_STYLE", "true") == "true",
+		},
+		Translator: TranslatorConfig{
+			Provider:          strings.ToLower(getEnv("TRANSLATOR", "")),
+			DeepLKey:          getEnv("DEEPL_API_KEY", ""),
+			LibreTranslateURL: getEnv("LIBRETRANSLATE_URL", ""),
+			LibreTranslateKey: getEnv("LIBRETRANSLATE_API_KEY", ""),
+			SourceLang:        strings.ToUpper(getEnv("TRANSLATION_SOURCE", "NL")),
+		},
+		Backup: BackupConfig{
+			Dir:           getEnv("BACKUP_DIR", filepath.Join(dataDir, "backups")),
+			IntervalHours: getEnvInt("BACKUP_INTERVAL_HOURS", 24),
+			Keep:          getEnvInt("BACKUP_KEEP", 7),
+		},
 	}
 }
 
@@ -68,6 +154,17 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// parseLanguageList turns "nl, en" into [NL EN]
+func parseLanguageList(value string) []string {
+	languages := []string{}
+	for _, code := range strings.Split(value, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			languages = append(languages, code)
+		}
+	}
+	return languages
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
@@ -85,8 +182,37 @@ func (c *Config) IsProduction() bool {
 	return c.Env == "production"
 }
 
-// Validate checks for security issues in production configuration
+// Validate checks for security issues in production configuration and for
+// an incomplete storage or translator setup
 func (c *Config) Validate() error {
+	switch c.Storage.Backend {
+	case "local":
+	case "s3":
+		if c.Storage.S3Bucket == "" || c.Storage.S3AccessKey == "" || c.Storage.S3SecretKey == "" {
+			return errors.New("S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY must be set for s3 storage")
+		}
+	default:
+		return errors.New("STORAGE_BACKEND must be local or s3")
+	}
+
+	switch c.Translator.Provider {
+	case "":
+	case "deepl":
+		if c.Translator.DeepLKey == "" {
+			return errors.New("DEEPL_API_KEY must be set for the deepl translator")
+		}
+	case "libretranslate":
+		if c.Translator.LibreTranslateURL == "" {
+			return errors.New("LIBRETRANSLATE_URL must be set for the libretranslate translator")
+		}
+	case "fake":
+		if !c.IsDevelopment() {
+			return errors.New("the fake translator is only allowed in development")
+		}
+	default:
+		return errors.New("TRANSLATOR must be deepl, libretranslate or fake")
+	}
+
 	if c.IsProduction() {
 		if c.JWT.Secret == "change-me-in-production" {
 			return errors.New("JWT_SECRET must be set to a secure value in production")
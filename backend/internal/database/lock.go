@@ -0,0 +1,23 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrInUse is returned by Lock while another process holds the lock
+var ErrInUse = errors.New("database is in use by another process")
+
+// Lock takes an exclusive lock on dbPath for as long as the returned
+// release function is not called. The server holds it while it runs, so
+// maintenance commands that replace the database file can refuse to run
+// next to it. The lock lives on a .lock file next to the database and is
+// released by the OS when the process exits, so a crash leaves nothing
+// stale behind.
+func Lock(dbPath string) (release func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+	return lockFile(dbPath + ".lock")
+}
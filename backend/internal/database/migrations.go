@@ -383,12 +383,709 @@ func (db *DB) Migrate() error {
 				);
 			`,
 		},
+		{
+			// payment_events logs the webhooks of every payment provider
+			name: "create_payment_events_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS payment_events (
+					id TEXT PRIMARY KEY,
+					provider TEXT NOT NULL DEFAULT 'stripe',
+					type TEXT NOT NULL,
+					payload TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'received',
+					error TEXT NOT NULL DEFAULT '',
+					order_id INTEGER,
+					registration_id INTEGER,
+					attempts INTEGER NOT NULL DEFAULT 0,
+					received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					processed_at DATETIME,
+					FOREIGN KEY (order_id) REFERENCES shop_orders(id) ON DELETE SET NULL,
+					FOREIGN KEY (registration_id) REFERENCES event_registrations(id) ON DELETE SET NULL
+				);
+			`,
+		},
+		{
+			name: "create_payment_events_received_index",
+			sql: `
+				CREATE INDEX IF NOT EXISTS idx_payment_events_received
+				ON payment_events(received_at);
+			`,
+		},
+		{
+			name: "create_shop_orders_payment_intent_index",
+			sql: `
+				CREATE INDEX IF NOT EXISTS idx_shop_orders_payment_intent
+				ON shop_orders(stripe_payment_intent_id);
+			`,
+		},
+		{
+			name: "add_payment_provider_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN payment_provider TEXT NOT NULL DEFAULT 'stripe';
+			`,
+		},
+		{
+			name: "add_mollie_api_key_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN mollie_api_key TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_payment_provider_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN payment_provider TEXT NOT NULL DEFAULT 'stripe';
+			`,
+		},
+		{
+			name: "add_provider_payment_id_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN provider_payment_id TEXT;
+			`,
+		},
+		{
+			name: "create_shop_orders_provider_payment_index",
+			sql: `
+				CREATE INDEX IF NOT EXISTS idx_shop_orders_provider_payment
+				ON shop_orders(provider_payment_id);
+			`,
+		},
+		{
+			name: "add_lang_code_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN lang_code TEXT NOT NULL DEFAULT 'NL';
+			`,
+		},
+		{
+			name: "add_tracking_number_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN tracking_number TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_invoice_org_name_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN invoice_org_name TEXT NOT NULL DEFAULT 'Geocaching Brughia VZW';
+			`,
+		},
+		{
+			name: "add_invoice_org_address_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN invoice_org_address TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_invoice_enterprise_number_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN invoice_enterprise_number TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_invoice_vat_number_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN invoice_vat_number TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_invoice_vat_rate_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN invoice_vat_rate REAL NOT NULL DEFAULT 0;
+			`,
+		},
+		{
+			name: "add_invoice_vat_note_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN invoice_vat_note TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			// Numbers are assigned per year as MAX(sequence) + 1 in a single
+			// statement and rows are never deleted, which keeps them gapless.
+			name: "create_shop_invoices_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS shop_invoices (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					order_id INTEGER NOT NULL UNIQUE REFERENCES shop_orders(id),
+					year INTEGER NOT NULL,
+					sequence INTEGER NOT NULL,
+					number TEXT NOT NULL UNIQUE,
+					data TEXT NOT NULL,
+					issued_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					UNIQUE (year, sequence)
+				);
+			`,
+		},
+		{
+			name: "add_refunded_cents_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN refunded_cents INTEGER NOT NULL DEFAULT 0;
+			`,
+		},
+		{
+			name: "backfill_refunded_cents_on_shop_orders",
+			sql: `
+				UPDATE shop_orders SET refunded_cents = amount_cents
+				WHERE status = 'refunded' AND refunded_cents = 0;
+			`,
+		},
+		{
+			name: "add_payment_gross_cents_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN payment_gross_cents INTEGER;
+			`,
+		},
+		{
+			name: "add_payment_fee_cents_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN payment_fee_cents INTEGER;
+			`,
+		},
+		{
+			name: "add_payment_net_cents_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN payment_net_cents INTEGER;
+			`,
+		},
+		{
+			name: "create_shop_orders_created_index",
+			sql: `
+				CREATE INDEX IF NOT EXISTS idx_shop_orders_created ON shop_orders(created_at);
+			`,
+		},
+		{
+			name: "add_member_price_cents_to_shop_items",
+			sql: `
+				ALTER TABLE shop_items ADD COLUMN member_price_cents INTEGER;
+			`,
+		},
+		{
+			name: "create_shop_discount_codes_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS shop_discount_codes (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					code TEXT NOT NULL UNIQUE COLLATE NOCASE,
+					description TEXT NOT NULL DEFAULT '',
+					kind TEXT NOT NULL CHECK (kind IN ('percentage', 'fixed', 'member')),
+					value INTEGER NOT NULL DEFAULT 0,
+					item_id INTEGER REFERENCES shop_items(id) ON DELETE CASCADE,
+					max_uses INTEGER,
+					valid_from DATETIME,
+					valid_until DATETIME,
+					active INTEGER NOT NULL DEFAULT 1,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+			`,
+		},
+		{
+			name: "add_discount_code_id_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN discount_code_id INTEGER REFERENCES shop_discount_codes(id) ON DELETE SET NULL;
+			`,
+		},
+		{
+			name: "add_discount_code_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN discount_code TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_discount_cents_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN discount_cents INTEGER NOT NULL DEFAULT 0;
+			`,
+		},
+		{
+			name: "add_token_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN token TEXT;
+			`,
+		},
+		{
+			name: "backfill_shop_order_tokens",
+			sql: `
+				UPDATE shop_orders SET token = lower(hex(randomblob(16))) WHERE token IS NULL;
+			`,
+		},
+		{
+			name: "create_shop_orders_token_index",
+			sql: `
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_shop_orders_token ON shop_orders(token);
+				CREATE INDEX IF NOT EXISTS idx_shop_orders_buyer_email ON shop_orders(buyer_email COLLATE NOCASE);
+			`,
+		},
+		{
+			name: "create_shop_pickup_points_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS shop_pickup_points (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					name TEXT NOT NULL,
+					address TEXT NOT NULL DEFAULT '',
+					description TEXT NOT NULL DEFAULT '',
+					event_id INTEGER REFERENCES events(id) ON DELETE SET NULL,
+					active INTEGER NOT NULL DEFAULT 1,
+					sort_order INTEGER NOT NULL DEFAULT 0,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+			`,
+		},
+		{
+			name: "create_shop_pickup_slots_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS shop_pickup_slots (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					pickup_point_id INTEGER NOT NULL REFERENCES shop_pickup_points(id) ON DELETE CASCADE,
+					starts_at DATETIME NOT NULL,
+					ends_at DATETIME NOT NULL,
+					capacity INTEGER,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_shop_pickup_slots_point ON shop_pickup_slots(pickup_point_id, starts_at);
+			`,
+		},
+		{
+			name: "add_pickup_slot_id_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN pickup_slot_id INTEGER REFERENCES shop_pickup_slots(id) ON DELETE SET NULL;
+			`,
+		},
+		{
+			name: "add_picked_up_at_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN picked_up_at DATETIME;
+			`,
+		},
+		{
+			name: "create_shop_orders_pickup_slot_index",
+			sql: `
+				CREATE INDEX IF NOT EXISTS idx_shop_orders_pickup_slot ON shop_orders(pickup_slot_id);
+			`,
+		},
+		{
+			name: "add_carrier_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN carrier TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_shipped_at_to_shop_orders",
+			sql: `
+				ALTER TABLE shop_orders ADD COLUMN shipped_at DATETIME;
+			`,
+		},
+		{
+			name: "backfill_shop_orders_shipped_at",
+			sql: `
+				UPDATE shop_orders SET shipped_at = updated_at WHERE status = 'shipped' AND shipped_at IS NULL;
+			`,
+		},
+		{
+			name: "create_event_registration_settings_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_registration_settings (
+					event_id INTEGER PRIMARY KEY REFERENCES events(id) ON DELETE CASCADE,
+					enabled INTEGER NOT NULL DEFAULT 0,
+					capacity INTEGER,
+					waitlist_enabled INTEGER NOT NULL DEFAULT 1,
+					max_per_registration INTEGER NOT NULL DEFAULT 10,
+					closes_at DATETIME,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+			`,
+		},
+		{
+			name: "create_event_ticket_types_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_ticket_types (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+					name TEXT NOT NULL,
+					description TEXT NOT NULL DEFAULT '',
+					price_cents INTEGER NOT NULL DEFAULT 0,
+					capacity INTEGER,
+					active INTEGER NOT NULL DEFAULT 1,
+					sort_order INTEGER NOT NULL DEFAULT 0,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_event_ticket_types_event ON event_ticket_types(event_id, sort_order);
+			`,
+		},
+		{
+			name: "create_event_registrations_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_registrations (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					token TEXT UNIQUE NOT NULL,
+					event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+					name TEXT NOT NULL,
+					email TEXT NOT NULL,
+					lang_code TEXT NOT NULL DEFAULT 'NL',
+					status TEXT NOT NULL DEFAULT 'pending',
+					amount_cents INTEGER NOT NULL DEFAULT 0,
+					refunded_cents INTEGER NOT NULL DEFAULT 0,
+					payment_provider TEXT NOT NULL DEFAULT '',
+					stripe_session_id TEXT,
+					stripe_payment_intent_id TEXT,
+					provider_payment_id TEXT,
+					hold_until DATETIME,
+					confirmed_at DATETIME,
+					notes TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_event_registrations_event ON event_registrations(event_id, status, created_at);
+				CREATE INDEX IF NOT EXISTS idx_event_registrations_email ON event_registrations(email COLLATE NOCASE);
+			`,
+		},
+		{
+			name: "create_event_attendees_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_attendees (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					registration_id INTEGER NOT NULL REFERENCES event_registrations(id) ON DELETE CASCADE,
+					ticket_type_id INTEGER REFERENCES event_ticket_types(id) ON DELETE SET NULL,
+					name TEXT NOT NULL,
+					price_cents INTEGER NOT NULL DEFAULT 0,
+					ticket_code TEXT UNIQUE NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_event_attendees_registration ON event_attendees(registration_id);
+			`,
+		},
+		{
+			name: "add_pretix_api_url_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN pretix_api_url TEXT NOT NULL DEFAULT 'https://pretix.eu/api/v1';
+			`,
+		},
+		{
+			name: "add_pretix_organizer_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN pretix_organizer TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "add_pretix_api_token_to_shop_settings",
+			sql: `
+				ALTER TABLE shop_settings ADD COLUMN pretix_api_token TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "create_event_pretix_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_pretix (
+					event_id INTEGER PRIMARY KEY,
+					event_slug TEXT NOT NULL UNIQUE,
+					public_url TEXT NOT NULL DEFAULT '',
+					quotas TEXT NOT NULL DEFAULT '[]',
+					available INTEGER NOT NULL DEFAULT 0,
+					synced_at DATETIME,
+					orders_synced_at DATETIME,
+					sync_error TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			name: "create_pretix_orders_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS pretix_orders (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					event_id INTEGER NOT NULL,
+					code TEXT NOT NULL,
+					status TEXT NOT NULL,
+					email TEXT NOT NULL DEFAULT '',
+					total_cents INTEGER NOT NULL DEFAULT 0,
+					positions INTEGER NOT NULL DEFAULT 0,
+					checked_in INTEGER NOT NULL DEFAULT 0,
+					testmode INTEGER NOT NULL DEFAULT 0,
+					ordered_at DATETIME,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+					UNIQUE(event_id, code)
+				);
+				CREATE INDEX IF NOT EXISTS idx_pretix_orders_event ON pretix_orders(event_id, status);
+			`,
+		},
+		{
+			name: "add_checked_in_at_to_event_attendees",
+			sql: `
+				ALTER TABLE event_attendees ADD COLUMN checked_in_at DATETIME;
+			`,
+		},
+		{
+			name: "add_checked_in_by_to_event_attendees",
+			sql: `
+				ALTER TABLE event_attendees ADD COLUMN checked_in_by TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			name: "create_event_checkin_keys_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_checkin_keys (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					event_id INTEGER NOT NULL,
+					label TEXT NOT NULL,
+					key_hash TEXT NOT NULL UNIQUE,
+					expires_at DATETIME NOT NULL,
+					last_used_at DATETIME,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			name: "create_event_checkins_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_checkins (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					event_id INTEGER NOT NULL,
+					attendee_id INTEGER NOT NULL,
+					operator TEXT NOT NULL,
+					user_id INTEGER,
+					key_id INTEGER,
+					duplicate INTEGER NOT NULL DEFAULT 0,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+					FOREIGN KEY (attendee_id) REFERENCES event_attendees(id) ON DELETE CASCADE,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+					FOREIGN KEY (key_id) REFERENCES event_checkin_keys(id) ON DELETE SET NULL
+				);
+				CREATE INDEX IF NOT EXISTS idx_event_checkins_event ON event_checkins(event_id, created_at);
+			`,
+		},
+		{
+			name: "create_event_media_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_media (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					event_id INTEGER NOT NULL,
+					kind TEXT NOT NULL,
+					filename TEXT NOT NULL UNIQUE,
+					original_name TEXT NOT NULL DEFAULT '',
+					content_type TEXT NOT NULL DEFAULT '',
+					size_bytes INTEGER NOT NULL DEFAULT 0,
+					sort_order INTEGER NOT NULL DEFAULT 0,
+					is_cover INTEGER NOT NULL DEFAULT 0,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+				);
+				CREATE INDEX IF NOT EXISTS idx_event_media_event ON event_media(event_id, kind, sort_order);
+			`,
+		},
+		{
+			name: "create_event_media_translations_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_media_translations (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					media_id INTEGER NOT NULL,
+					lang_code TEXT NOT NULL,
+					caption TEXT NOT NULL,
+					FOREIGN KEY (media_id) REFERENCES event_media(id) ON DELETE CASCADE,
+					FOREIGN KEY (lang_code) REFERENCES languages(code) ON DELETE CASCADE,
+					UNIQUE(media_id, lang_code)
+				);
+			`,
+		},
+		{
+			name: "create_event_types_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_types (
+					code TEXT PRIMARY KEY,
+					icon TEXT NOT NULL DEFAULT '',
+					gc_type_id INTEGER,
+					sort_order INTEGER NOT NULL DEFAULT 0,
+					active INTEGER NOT NULL DEFAULT 1,
+					builtin INTEGER NOT NULL DEFAULT 0,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+			`,
+		},
+		{
+			name: "create_event_type_translations_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS event_type_translations (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					type_code TEXT NOT NULL,
+					lang_code TEXT NOT NULL,
+					label TEXT NOT NULL,
+					FOREIGN KEY (type_code) REFERENCES event_types(code) ON DELETE CASCADE,
+					FOREIGN KEY (lang_code) REFERENCES languages(code) ON DELETE CASCADE,
+					UNIQUE(type_code, lang_code)
+				);
+			`,
+		},
+		{
+			// gc_type_id is the geocaching.com cache type of the event listing;
+			// club meetings are not published there
+			name: "seed_event_types",
+			sql: `
+				INSERT OR IGNORE INTO event_types (code, icon, gc_type_id, sort_order, builtin) VALUES
+				('REGULAR',   '/assets/media/eventtypes/REGULAR.png', 6,    0, 1),
+				('CITO',      '/assets/media/eventtypes/CITO.png',    13,   1, 1),
+				('MEGA',      '/assets/media/eventtypes/MEGA.png',    453,  2, 1),
+				('GIGA',      '/assets/media/eventtypes/GIGA.png',    7005, 3, 1),
+				('COMMUNITY', '/assets/media/eventtypes/REGULAR.png', 3653, 4, 1),
+				('BLOCK',     '/assets/media/eventtypes/BLOCK.png',   4738, 5, 1),
+				('CLUB',      '/assets/media/eventtypes/REGULAR.png', NULL, 6, 1);
+			`,
+		},
+		{
+			// events.type used to be free text; fold case variants onto the codes
+			name: "normalize_event_type_case",
+			sql: `
+				UPDATE events SET type = UPPER(TRIM(type))
+				WHERE type NOT IN (SELECT code FROM event_types)
+				  AND UPPER(TRIM(type)) IN (SELECT code FROM event_types);
+			`,
+		},
+		{
+			name: "backfill_unknown_event_types",
+			sql: `
+				UPDATE events SET type = 'REGULAR'
+				WHERE type NOT IN (SELECT code FROM event_types);
+			`,
+		},
+		{
+			name: "add_gc_code_to_events",
+			sql:  `ALTER TABLE events ADD COLUMN gc_code TEXT`,
+		},
+		{
+			name: "create_events_type_index",
+			sql: `
+				CREATE INDEX IF NOT EXISTS idx_events_type ON events(type);
+			`,
+		},
+		{
+			// media records every uploaded image; where an image is used is
+			// worked out from the columns that refer to it
+			name: "create_media_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS media (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					filename TEXT NOT NULL UNIQUE,
+					original_name TEXT NOT NULL DEFAULT '',
+					content_type TEXT NOT NULL DEFAULT '',
+					size_bytes INTEGER NOT NULL DEFAULT 0,
+					width INTEGER NOT NULL DEFAULT 0,
+					height INTEGER NOT NULL DEFAULT 0,
+					uploaded_by INTEGER,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (uploaded_by) REFERENCES users(id) ON DELETE SET NULL
+				);
+			`,
+		},
+		{
+			name: "create_media_translations_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS media_translations (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					media_id INTEGER NOT NULL,
+					lang_code TEXT NOT NULL,
+					alt_text TEXT NOT NULL,
+					FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE CASCADE,
+					FOREIGN KEY (lang_code) REFERENCES languages(code) ON DELETE CASCADE,
+					UNIQUE(media_id, lang_code)
+				);
+			`,
+		},
+		{
+			// machine_translated marks translations proposed by the translator;
+			// they are drafts that are not shown publicly until confirmed
+			name: "add_machine_translated_to_event_translations",
+			sql:  `ALTER TABLE event_translations ADD COLUMN machine_translated INTEGER NOT NULL DEFAULT 0`,
+		},
+		{
+			name: "add_machine_translated_to_message_translations",
+			sql:  `ALTER TABLE message_translations ADD COLUMN machine_translated INTEGER NOT NULL DEFAULT 0`,
+		},
+		{
+			name: "add_machine_translated_to_shop_item_translations",
+			sql:  `ALTER TABLE shop_item_translations ADD COLUMN machine_translated INTEGER NOT NULL DEFAULT 0`,
+		},
+		{
+			name: "add_machine_translated_to_static_content",
+			sql:  `ALTER TABLE static_content ADD COLUMN machine_translated INTEGER NOT NULL DEFAULT 0`,
+		},
+		{
+			// revisions keeps every saved version of an event, message, shop
+			// item, static content property or Golden Key month as a JSON
+			// snapshot of its rows; author is kept when the user is deleted
+			name: "create_revisions_table",
+			sql: `
+				CREATE TABLE IF NOT EXISTS revisions (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					entity TEXT NOT NULL,
+					entity_id TEXT NOT NULL,
+					data TEXT NOT NULL,
+					author_id INTEGER,
+					author TEXT NOT NULL DEFAULT '',
+					note TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE SET NULL
+				);
+				CREATE INDEX IF NOT EXISTS idx_revisions_entity ON revisions(entity, entity_id, id);
+			`,
+		},
+	}
+
+	// ALTER TABLE ... ADD COLUMN migrations fail with "duplicate column" once applied
+	addColumnMigrations := map[string]bool{
+		"add_needs_password_update_to_users":             true,
+		"add_type_to_geocaches":                          true,
+		"add_placed_date_to_geocaches":                   true,
+		"add_banner_text_to_golden_key_settings":         true,
+		"add_found_date_to_golden_key_months":            true,
+		"add_rules_to_golden_key_settings":               true,
+		"add_payment_provider_to_shop_settings":          true,
+		"add_mollie_api_key_to_shop_settings":            true,
+		"add_payment_provider_to_shop_orders":            true,
+		"add_provider_payment_id_to_shop_orders":         true,
+		"add_lang_code_to_shop_orders":                   true,
+		"add_tracking_number_to_shop_orders":             true,
+		"add_invoice_org_name_to_shop_settings":          true,
+		"add_invoice_org_address_to_shop_settings":       true,
+		"add_invoice_enterprise_number_to_shop_settings": true,
+		"add_invoice_vat_number_to_shop_settings":        true,
+		"add_invoice_vat_rate_to_shop_settings":          true,
+		"add_invoice_vat_note_to_shop_settings":          true,
+		"add_refunded_cents_to_shop_orders":              true,
+		"add_payment_gross_cents_to_shop_orders":         true,
+		"add_payment_fee_cents_to_shop_orders":           true,
+		"add_payment_net_cents_to_shop_orders":           true,
+		"add_member_price_cents_to_shop_items":           true,
+		"add_discount_code_id_to_shop_orders":            true,
+		"add_discount_code_to_shop_orders":               true,
+		"add_discount_cents_to_shop_orders":              true,
+		"add_token_to_shop_orders":                       true,
+		"add_pickup_slot_id_to_shop_orders":              true,
+		"add_picked_up_at_to_shop_orders":                true,
+		"add_carrier_to_shop_orders":                     true,
+		"add_shipped_at_to_shop_orders":                  true,
+		"add_pretix_api_url_to_shop_settings":            true,
+		"add_pretix_organizer_to_shop_settings":          true,
+		"add_pretix_api_token_to_shop_settings":          true,
+		"add_checked_in_at_to_event_attendees":           true,
+		"add_checked_in_by_to_event_attendees":           true,
+		"add_gc_code_to_events":                          true,
+
+		"add_machine_translated_to_event_translations":     true,
+		"add_machine_translated_to_message_translations":   true,
+		"add_machine_translated_to_shop_item_translations": true,
+		"add_machine_translated_to_static_content":         true,
 	}
 
 	for _, m := range migrations {
 		if _, err := db.Exec(m.sql); err != nil {
 			// Ignore "duplicate column" errors for ALTER TABLE migrations
-			if m.name == "add_needs_password_update_to_users" || m.name == "add_type_to_geocaches" || m.name == "add_placed_date_to_geocaches" || m.name == "add_banner_text_to_golden_key_settings" || m.name == "add_found_date_to_golden_key_months" || m.name == "add_rules_to_golden_key_settings" {
+			if addColumnMigrations[m.name] {
 				log.Printf("  ✓ %s (column already exists)", m.name)
 				continue
 			}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Migrations log a lot
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestMigrateKeepsPaymentEvents(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO payment_events (id, provider, type, payload) VALUES ('evt_1', 'mollie', 'paid', '{}')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every start runs the migrations again
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	var provider string
+	if err := db.QueryRow("SELECT provider FROM payment_events WHERE id = 'evt_1'").Scan(&provider); err != nil || provider != "mollie" {
+		t.Errorf("event after migrating again: %q, %v", provider, err)
+	}
+}
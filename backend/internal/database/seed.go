@@ -8,7 +8,8 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// SeedDefaults seeds default languages, static content, and admin user if missing
+// SeedDefaults seeds default languages, static content, event type labels,
+// and admin user if missing
 func (db *DB) SeedDefaults() error {
 	// Always ensure all 4 default languages exist
 	log.Println("Ensuring default languages exist...")
@@ -24,6 +25,10 @@ func (db *DB) SeedDefaults() error {
 		return err
 	}
 
+	if err := db.seedEventTypeLabels(); err != nil {
+		return err
+	}
+
 	// Create default admin user if no users exist
 	if err := db.seedDefaultAdmin(); err != nil {
 		return err
@@ -608,6 +613,348 @@ func (db *DB) seedStaticContent() error {
 			"FR": "Retour à la boutique",
 			"DE": "Zurück zum Shop",
 		},
+		"ShopOrderStatusTitle": {
+			"EN": "Your order",
+			"NL": "Je bestelling",
+			"FR": "Votre commande",
+			"DE": "Ihre Bestellung",
+		},
+		"ShopOrderNumber": {
+			"EN": "Order #///id///",
+			"NL": "Bestelling #///id///",
+			"FR": "Commande n°///id///",
+			"DE": "Bestellung #///id///",
+		},
+		"ShopOrderNotFound": {
+			"EN": "This order could not be found.",
+			"NL": "Deze bestelling werd niet gevonden.",
+			"FR": "Cette commande est introuvable.",
+			"DE": "Diese Bestellung wurde nicht gefunden.",
+		},
+		"ShopOrderPlacedOn": {
+			"EN": "Ordered on",
+			"NL": "Besteld op",
+			"FR": "Commandée le",
+			"DE": "Bestellt am",
+		},
+		"ShopOrderQuantity": {
+			"EN": "Quantity",
+			"NL": "Aantal",
+			"FR": "Quantité",
+			"DE": "Anzahl",
+		},
+		"ShopOrderTotal": {
+			"EN": "Total",
+			"NL": "Totaal",
+			"FR": "Total",
+			"DE": "Gesamt",
+		},
+		"ShopOrderRefundedAmount": {
+			"EN": "Refunded",
+			"NL": "Terugbetaald",
+			"FR": "Remboursé",
+			"DE": "Erstattet",
+		},
+		"ShopOrderStatusPending": {
+			"EN": "Awaiting payment",
+			"NL": "Wacht op betaling",
+			"FR": "En attente de paiement",
+			"DE": "Zahlung ausstehend",
+		},
+		"ShopOrderStatusPaid": {
+			"EN": "Paid",
+			"NL": "Betaald",
+			"FR": "Payée",
+			"DE": "Bezahlt",
+		},
+		"ShopOrderStatusConfirmed": {
+			"EN": "Confirmed",
+			"NL": "Bevestigd",
+			"FR": "Confirmée",
+			"DE": "Bestätigt",
+		},
+		"ShopOrderStatusReadyForPickup": {
+			"EN": "Ready for pickup",
+			"NL": "Klaar om af te halen",
+			"FR": "Prête à être retirée",
+			"DE": "Abholbereit",
+		},
+		"ShopOrderStatusShipped": {
+			"EN": "Shipped",
+			"NL": "Verzonden",
+			"FR": "Expédiée",
+			"DE": "Versendet",
+		},
+		"ShopOrderStatusFulfilled": {
+			"EN": "Delivered",
+			"NL": "Afgeleverd",
+			"FR": "Livrée",
+			"DE": "Zugestellt",
+		},
+		"ShopOrderStatusCompleted": {
+			"EN": "Completed",
+			"NL": "Afgerond",
+			"FR": "Terminée",
+			"DE": "Abgeschlossen",
+		},
+		"ShopOrderStatusCancelled": {
+			"EN": "Cancelled",
+			"NL": "Geannuleerd",
+			"FR": "Annulée",
+			"DE": "Storniert",
+		},
+		"ShopOrderStatusRefunded": {
+			"EN": "Refunded",
+			"NL": "Terugbetaald",
+			"FR": "Remboursée",
+			"DE": "Erstattet",
+		},
+		"ShopOrderStatusDisputed": {
+			"EN": "Disputed",
+			"NL": "Betwist",
+			"FR": "Contestée",
+			"DE": "Angefochten",
+		},
+		"ShopOrderPickup": {
+			"EN": "Pickup",
+			"NL": "Afhalen",
+			"FR": "Retrait",
+			"DE": "Abholung",
+		},
+		"ShopOrderPickupQR": {
+			"EN": "Show this code when you pick up your order.",
+			"NL": "Toon deze code wanneer je je bestelling afhaalt.",
+			"FR": "Présentez ce code lors du retrait de votre commande.",
+			"DE": "Zeigen Sie diesen Code bei der Abholung Ihrer Bestellung.",
+		},
+		"ShopOrderPickedUpOn": {
+			"EN": "Picked up on",
+			"NL": "Afgehaald op",
+			"FR": "Retirée le",
+			"DE": "Abgeholt am",
+		},
+		"ShopOrderDelivery": {
+			"EN": "Delivery",
+			"NL": "Levering",
+			"FR": "Livraison",
+			"DE": "Lieferung",
+		},
+		"ShopOrderShippedOn": {
+			"EN": "Shipped on",
+			"NL": "Verzonden op",
+			"FR": "Expédiée le",
+			"DE": "Versendet am",
+		},
+		"ShopOrderTrack": {
+			"EN": "Track your parcel",
+			"NL": "Volg je pakket",
+			"FR": "Suivre votre colis",
+			"DE": "Sendung verfolgen",
+		},
+		"ShopOrderInvoice": {
+			"EN": "Download invoice",
+			"NL": "Factuur downloaden",
+			"FR": "Télécharger la facture",
+			"DE": "Rechnung herunterladen",
+		},
+		"ShopOrderLookupTitle": {
+			"EN": "My orders",
+			"NL": "Mijn bestellingen",
+			"FR": "Mes commandes",
+			"DE": "Meine Bestellungen",
+		},
+		"ShopOrderLookupTxt": {
+			"EN": "Enter the email address you ordered with and we will mail you a link to your orders.",
+			"NL": "Vul het e-mailadres in waarmee je bestelde en we mailen je een link naar je bestellingen.",
+			"FR": "Saisissez l'adresse e-mail utilisée pour commander et nous vous enverrons un lien vers vos commandes.",
+			"DE": "Geben Sie die E-Mail-Adresse Ihrer Bestellung ein, und wir senden Ihnen einen Link zu Ihren Bestellungen.",
+		},
+		"ShopOrderLookupEmail": {
+			"EN": "Email address",
+			"NL": "E-mailadres",
+			"FR": "Adresse e-mail",
+			"DE": "E-Mail-Adresse",
+		},
+		"ShopOrderLookupSend": {
+			"EN": "Send link",
+			"NL": "Link versturen",
+			"FR": "Envoyer le lien",
+			"DE": "Link senden",
+		},
+		"ShopOrderLookupSent": {
+			"EN": "If there are orders for this address, a link to them is on its way.",
+			"NL": "Als er bestellingen zijn voor dit adres, is er een link naar onderweg.",
+			"FR": "S'il existe des commandes pour cette adresse, un lien est en route.",
+			"DE": "Falls es Bestellungen für diese Adresse gibt, ist ein Link unterwegs.",
+		},
+		"ShopOrderLookupExpired": {
+			"EN": "This link is invalid or has expired. Request a new one below.",
+			"NL": "Deze link is ongeldig of verlopen. Vraag hieronder een nieuwe aan.",
+			"FR": "Ce lien est invalide ou a expiré. Demandez-en un nouveau ci-dessous.",
+			"DE": "Dieser Link ist ungültig oder abgelaufen. Fordern Sie unten einen neuen an.",
+		},
+		"ShopOrderLookupNone": {
+			"EN": "No orders found.",
+			"NL": "Geen bestellingen gevonden.",
+			"FR": "Aucune commande trouvée.",
+			"DE": "Keine Bestellungen gefunden.",
+		},
+		"ShopOrderOpen": {
+			"EN": "View",
+			"NL": "Bekijken",
+			"FR": "Voir",
+			"DE": "Ansehen",
+		},
+		"EventRegistrationTitle": {
+			"EN": "Your registration",
+			"NL": "Je inschrijving",
+			"FR": "Votre inscription",
+			"DE": "Ihre Anmeldung",
+		},
+		"EventRegistrationNotFound": {
+			"EN": "This registration was not found.",
+			"NL": "Deze inschrijving werd niet gevonden.",
+			"FR": "Cette inscription est introuvable.",
+			"DE": "Diese Anmeldung wurde nicht gefunden.",
+		},
+		"EventRegistrationStatusConfirmed": {
+			"EN": "Confirmed",
+			"NL": "Bevestigd",
+			"FR": "Confirmée",
+			"DE": "Bestätigt",
+		},
+		"EventRegistrationStatusPending": {
+			"EN": "Awaiting payment",
+			"NL": "Wacht op betaling",
+			"FR": "En attente de paiement",
+			"DE": "Wartet auf Zahlung",
+		},
+		"EventRegistrationStatusWaitlisted": {
+			"EN": "On the waitlist",
+			"NL": "Op de wachtlijst",
+			"FR": "Sur la liste d'attente",
+			"DE": "Auf der Warteliste",
+		},
+		"EventRegistrationStatusExpired": {
+			"EN": "Expired",
+			"NL": "Verlopen",
+			"FR": "Expirée",
+			"DE": "Abgelaufen",
+		},
+		"EventRegistrationStatusCancelled": {
+			"EN": "Cancelled",
+			"NL": "Geannuleerd",
+			"FR": "Annulée",
+			"DE": "Storniert",
+		},
+		"EventRegistrationStatusRefunded": {
+			"EN": "Refunded",
+			"NL": "Terugbetaald",
+			"FR": "Remboursée",
+			"DE": "Erstattet",
+		},
+		"EventRegistrationWaitlistPosition": {
+			"EN": "You are number ///id/// on the waitlist. We will mail you when a place opens up.",
+			"NL": "Je staat op plaats ///id/// van de wachtlijst. We mailen je zodra er een plaats vrijkomt.",
+			"FR": "Vous êtes numéro ///id/// sur la liste d'attente. Nous vous enverrons un e-mail dès qu'une place se libère.",
+			"DE": "Sie sind Nummer ///id/// auf der Warteliste. Wir schreiben Ihnen, sobald ein Platz frei wird.",
+		},
+		"EventRegistrationPayBefore": {
+			"EN": "Pay before ///id/// to keep your place.",
+			"NL": "Betaal voor ///id/// om je plaats te houden.",
+			"FR": "Payez avant le ///id/// pour garder votre place.",
+			"DE": "Bezahlen Sie vor dem ///id///, um Ihren Platz zu behalten.",
+		},
+		"EventRegistrationPay": {
+			"EN": "Pay now",
+			"NL": "Nu betalen",
+			"FR": "Payer maintenant",
+			"DE": "Jetzt bezahlen",
+		},
+		"EventRegistrationPaymentSuccess": {
+			"EN": "Thank you! Your registration is confirmed as soon as the payment comes through.",
+			"NL": "Bedankt! Je inschrijving is bevestigd zodra de betaling binnen is.",
+			"FR": "Merci ! Votre inscription est confirmée dès réception du paiement.",
+			"DE": "Vielen Dank! Ihre Anmeldung ist bestätigt, sobald die Zahlung eingegangen ist.",
+		},
+		"EventRegistrationPaymentCancelled": {
+			"EN": "The payment was not completed. You can try again below.",
+			"NL": "De betaling werd niet voltooid. Je kan het hieronder opnieuw proberen.",
+			"FR": "Le paiement n'a pas abouti. Vous pouvez réessayer ci-dessous.",
+			"DE": "Die Zahlung wurde nicht abgeschlossen. Sie können es unten erneut versuchen.",
+		},
+		"EventRegistrationAmount": {
+			"EN": "Amount",
+			"NL": "Bedrag",
+			"FR": "Montant",
+			"DE": "Betrag",
+		},
+		"EventRegistrationTickets": {
+			"EN": "Tickets",
+			"NL": "Tickets",
+			"FR": "Billets",
+			"DE": "Tickets",
+		},
+		"EventRegistrationTicketsTxt": {
+			"EN": "Show the QR code of each ticket at the entrance.",
+			"NL": "Toon de QR-code van elk ticket aan de ingang.",
+			"FR": "Présentez le code QR de chaque billet à l'entrée.",
+			"DE": "Zeigen Sie den QR-Code jedes Tickets am Eingang.",
+		},
+		"EventRegistrationCancel": {
+			"EN": "Cancel registration",
+			"NL": "Inschrijving annuleren",
+			"FR": "Annuler l'inscription",
+			"DE": "Anmeldung stornieren",
+		},
+		"EventRegistrationCancelConfirm": {
+			"EN": "Are you sure you want to cancel this registration?",
+			"NL": "Weet je zeker dat je deze inschrijving wil annuleren?",
+			"FR": "Voulez-vous vraiment annuler cette inscription ?",
+			"DE": "Möchten Sie diese Anmeldung wirklich stornieren?",
+		},
+		"EventRegistrationError": {
+			"EN": "Something went wrong, please try again later.",
+			"NL": "Er ging iets mis, probeer het later opnieuw.",
+			"FR": "Une erreur s'est produite, veuillez réessayer plus tard.",
+			"DE": "Etwas ist schiefgelaufen, bitte versuchen Sie es später erneut.",
+		},
+		"EventRegistrationToEvent": {
+			"EN": "To the event",
+			"NL": "Naar het evenement",
+			"FR": "Vers l'événement",
+			"DE": "Zur Veranstaltung",
+		},
+		"EventTicketTitle": {
+			"EN": "Ticket",
+			"NL": "Ticket",
+			"FR": "Billet",
+			"DE": "Ticket",
+		},
+		"EventTicketNotFound": {
+			"EN": "This ticket was not found.",
+			"NL": "Dit ticket werd niet gevonden.",
+			"FR": "Ce billet est introuvable.",
+			"DE": "Dieses Ticket wurde nicht gefunden.",
+		},
+		"EventTicketValid": {
+			"EN": "Valid ticket",
+			"NL": "Geldig ticket",
+			"FR": "Billet valable",
+			"DE": "Gültiges Ticket",
+		},
+		"EventTicketInvalid": {
+			"EN": "This ticket is no longer valid",
+			"NL": "Dit ticket is niet meer geldig",
+			"FR": "Ce billet n'est plus valable",
+			"DE": "Dieses Ticket ist nicht mehr gültig",
+		},
+		"EventTicketCheckedIn": {
+			"EN": "Checked in on",
+			"NL": "Ingecheckt op",
+			"FR": "Enregistré le",
+			"DE": "Eingecheckt am",
+		},
 		"CountryBE": {
 			"EN": "Belgium",
 			"NL": "België",
@@ -860,3 +1207,67 @@ func (db *DB) seedStaticContent() error {
 	log.Printf("  ✓ Seeded %d translation keys", len(staticContent))
 	return nil
 }
+
+// seedEventTypeLabels adds the labels of the built-in event types. Like static
+// content, existing (possibly admin-edited) labels are left alone.
+func (db *DB) seedEventTypeLabels() error {
+	labels := map[string]map[string]string{
+		"REGULAR": {
+			"EN": "Event",
+			"NL": "Evenement",
+			"FR": "Événement",
+			"DE": "Event",
+		},
+		"CITO": {
+			"EN": "CITO (Cache In Trash Out)",
+			"NL": "CITO (Cache In Trash Out)",
+			"FR": "CITO (Cache In Trash Out)",
+			"DE": "CITO (Cache In Trash Out)",
+		},
+		"MEGA": {
+			"EN": "Mega-Event",
+			"NL": "Mega-evenement",
+			"FR": "Méga-événement",
+			"DE": "Mega-Event",
+		},
+		"GIGA": {
+			"EN": "Giga-Event",
+			"NL": "Giga-evenement",
+			"FR": "Giga-événement",
+			"DE": "Giga-Event",
+		},
+		"COMMUNITY": {
+			"EN": "Community Celebration Event",
+			"NL": "Community Celebration-evenement",
+			"FR": "Événement Community Celebration",
+			"DE": "Community Celebration Event",
+		},
+		"BLOCK": {
+			"EN": "Block Party",
+			"NL": "Block Party",
+			"FR": "Block Party",
+			"DE": "Block Party",
+		},
+		"CLUB": {
+			"EN": "Club meeting",
+			"NL": "Clubavond",
+			"FR": "Réunion du club",
+			"DE": "Vereinstreffen",
+		},
+	}
+
+	for code, translations := range labels {
+		for langCode, label := range translations {
+			// Only for types that still exist
+			_, err := db.Exec(`
+				INSERT OR IGNORE INTO event_type_translations (type_code, lang_code, label)
+				SELECT code, ?, ? FROM event_types WHERE code = ?
+			`, langCode, label, code)
+			if err != nil {
+				log.Printf("Warning: Could not insert event type label %s/%s: %v", code, langCode, err)
+			}
+		}
+	}
+
+	return nil
+}
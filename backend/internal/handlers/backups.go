@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/backup"
+	"github.com/go-chi/chi/v5"
+)
+
+// GetBackups lists the stored backups, newest first
+func (h *Handler) GetBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := backup.List(h.cfg.Backup.Dir)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, []backup.Info{})
+		return
+	}
+	respondJSON(w, http.StatusOK, backups)
+}
+
+// backupTimeout bounds a backup started from the admin panel; it runs in
+// the background because copying the uploads easily outlasts the request
+// timeout
+const backupTimeout = time.Hour
+
+// backupStatus describes the backup started last from the admin panel
+type backupStatus struct {
+	Running    bool         `json:"running"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Backup     *backup.Info `json:"backup,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// backupJob guards the status of the background backup
+type backupJob struct {
+	mu     sync.Mutex
+	status backupStatus
+}
+
+func (j *backupJob) get() backupStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// CreateBackup starts a backup in the background and rotates the old ones
+// when it is done. GetBackupStatus reports how it went.
+func (h *Handler) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	job := &h.backupJob
+	job.mu.Lock()
+	if job.status.Running {
+		job.mu.Unlock()
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "A backup is already running"})
+		return
+	}
+	now := time.Now().UTC()
+	job.status = backupStatus{Running: true, StartedAt: &now}
+	job.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+		defer cancel()
+		info, err := backup.Create(ctx, h.db, h.store, h.cfg.Backup.Dir)
+		if err != nil {
+			log.Printf("Backup failed: %v", err)
+		} else if err := backup.Rotate(h.cfg.Backup.Dir, h.cfg.Backup.Keep); err != nil {
+			log.Printf("Backup rotation failed: %v", err)
+		}
+
+		finished := time.Now().UTC()
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.status = backupStatus{StartedAt: &now, FinishedAt: &finished, Backup: info}
+		if err != nil {
+			job.status.Error = "Failed to create backup"
+		}
+	}()
+
+	respondJSON(w, http.StatusAccepted, job.get())
+}
+
+// GetBackupStatus reports the backup started last from the admin panel
+func (h *Handler) GetBackupStatus(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, h.backupJob.get())
+}
+
+// DownloadBackup sends a backup archive
+func (h *Handler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, err := backup.Stat(h.cfg.Backup.Dir, name); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Backup not found"})
+		return
+	}
+	f, err := os.Open(filepath.Join(h.cfg.Backup.Dir, name))
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Backup not found"})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read backup"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// DeleteBackup removes a backup archive
+func (h *Handler) DeleteBackup(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, err := backup.Stat(h.cfg.Backup.Dir, name); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Backup not found"})
+		return
+	}
+	if err := os.Remove(filepath.Join(h.cfg.Backup.Dir, name)); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete backup"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Backup deleted"})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func backupStatusOf(t *testing.T, h *Handler) backupStatus {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.GetBackupStatus(w, httptest.NewRequest("GET", "/admin/backups/status", nil))
+	var status backupStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestCreateBackup(t *testing.T) {
+	h := newTestHandler(t)
+	h.cfg.Backup.Dir = filepath.Join(t.TempDir(), "backups")
+	h.cfg.Backup.Keep = 3
+
+	// A backup is refused while another one runs
+	h.backupJob.status.Running = true
+	w := httptest.NewRecorder()
+	h.CreateBackup(w, httptest.NewRequest("POST", "/admin/backups", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("while running: status = %d, want 409", w.Code)
+	}
+	h.backupJob.status.Running = false
+
+	w = httptest.NewRecorder()
+	h.CreateBackup(w, httptest.NewRequest("POST", "/admin/backups", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+	}
+	var started backupStatus
+	json.NewDecoder(w.Body).Decode(&started)
+	if !started.Running || started.StartedAt == nil {
+		t.Errorf("response = %+v, want a running backup", started)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	status := backupStatusOf(t, h)
+	for status.Running && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		status = backupStatusOf(t, h)
+	}
+	if status.Running || status.Error != "" || status.Backup == nil || status.FinishedAt == nil {
+		t.Fatalf("status = %+v, want a finished backup", status)
+	}
+
+	w = httptest.NewRecorder()
+	h.GetBackups(w, httptest.NewRequest("GET", "/admin/backups", nil))
+	var backups []struct {
+		Name string `json:"name"`
+	}
+	json.NewDecoder(w.Body).Decode(&backups)
+	if len(backups) != 1 || backups[0].Name != status.Backup.Name {
+		t.Errorf("backups = %+v, want %s", backups, status.Backup.Name)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/bundle"
+)
+
+// maxBundleSize limits the size of an uploaded content bundle
+const maxBundleSize = 50 << 20
+
+// ExportBundle downloads the site content as a JSON bundle. ?sections= is
+// a comma-separated subset of bundle.Sections.
+func (h *Handler) ExportBundle(w http.ResponseWriter, r *http.Request) {
+	sections, err := bundle.ParseSections(r.URL.Query().Get("sections"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	b, err := bundle.Export(h.db, sections)
+	if err != nil {
+		log.Printf("Content export failed: %v", err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to export content"})
+		return
+	}
+
+	filename := fmt.Sprintf("content-%s.json", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "private, no-store")
+	respondJSON(w, http.StatusOK, b)
+}
+
+// ImportBundle imports a bundle made by ExportBundle. Existing rows are
+// kept (?policy=skip, the default) or overwritten (?policy=overwrite).
+// It is a dry run that only reports unless ?dry_run=false; ?sections=
+// limits the import.
+func (h *Handler) ImportBundle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := bundle.Options{
+		Policy: bundle.Policy(query.Get("policy")),
+		DryRun: query.Get("dry_run") != "false",
+	}
+	if opts.Policy == "" {
+		opts.Policy = bundle.PolicySkip
+	}
+	if opts.Policy != bundle.PolicySkip && opts.Policy != bundle.PolicyOverwrite {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Policy must be skip or overwrite"})
+		return
+	}
+	sections, err := bundle.ParseSections(query.Get("sections"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	opts.Sections = sections
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBundleSize)
+	var b bundle.Bundle
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid bundle"})
+		return
+	}
+	if b.Version != bundle.FormatVersion {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unsupported bundle version %d", b.Version)})
+		return
+	}
+
+	report, err := bundle.Import(r.Context(), h.db, h.store, &b, opts)
+	if err != nil {
+		log.Printf("Content import failed: %v", err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Import failed, nothing was changed: " + err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,593 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/payment"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/xlsx"
+	"github.com/go-chi/chi/v5"
+)
+
+// EventRegistrationOverview is the admin view of an event's registration
+type EventRegistrationOverview struct {
+	EventRegistrationSettings
+	TicketTypes []EventTicketType `json:"ticket_types"`
+	Taken       int               `json:"taken"`
+	Available   *int              `json:"available,omitempty"`
+	// Attendees counts the attendees per registration status
+	Attendees map[string]int `json:"attendees"`
+}
+
+type registrationSettingsRequest struct {
+	Enabled            bool   `json:"enabled"`
+	Capacity           *int   `json:"capacity"`
+	WaitlistEnabled    bool   `json:"waitlist_enabled"`
+	MaxPerRegistration int    `json:"max_per_registration"`
+	ClosesAt           string `json:"closes_at"`
+}
+
+type ticketTypeRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	PriceCents  int    `json:"price_cents"`
+	Capacity    *int   `json:"capacity"`
+	Active      bool   `json:"active"`
+	SortOrder   int    `json:"sort_order"`
+}
+
+// GetEventRegistration returns the registration settings, ticket types and
+// attendee counts of an event
+func (h *Handler) GetEventRegistration(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	var exists int
+	h.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ?", eventID).Scan(&exists)
+	if exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+
+	settings, err := h.loadRegistrationSettings(eventID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	shop, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	usage, err := h.eventSpotUsage(eventID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	types, err := h.loadTicketTypes(eventID, false, usage, shop.Currency)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	overview := EventRegistrationOverview{
+		EventRegistrationSettings: settings,
+		TicketTypes:               types,
+		Taken:                     usage.Total,
+		Attendees:                 map[string]int{},
+	}
+	if settings.Capacity != nil {
+		available := *settings.Capacity - usage.Total
+		if available < 0 {
+			available = 0
+		}
+		overview.Available = &available
+	}
+
+	rows, err := h.db.Query(`
+		SELECT r.status, COUNT(*)
+		FROM event_attendees a
+		JOIN event_registrations r ON a.registration_id = r.id
+		WHERE r.event_id = ?
+		GROUP BY r.status
+	`, eventID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if rows.Scan(&status, &n) == nil {
+			overview.Attendees[status] = n
+		}
+	}
+
+	respondJSON(w, http.StatusOK, overview)
+}
+
+// UpdateEventRegistration saves the registration settings of an event.
+// Raising the capacity moves waitlisted registrations up right away.
+func (h *Handler) UpdateEventRegistration(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	var req registrationSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if req.Capacity != nil && (*req.Capacity < 1 || *req.Capacity > 100000) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Capacity must be between 1 and 100000"})
+		return
+	}
+	if req.MaxPerRegistration == 0 {
+		req.MaxPerRegistration = 10
+	}
+	if req.MaxPerRegistration < 1 || req.MaxPerRegistration > maxAttendeesPerRegistration {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Attendees per registration must be between 1 and %d", maxAttendeesPerRegistration),
+		})
+		return
+	}
+	var closesAt interface{}
+	if v := strings.TrimSpace(req.ClosesAt); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Closing time must be an RFC3339 timestamp"})
+			return
+		}
+		closesAt = t.UTC()
+	}
+
+	var exists int
+	h.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ?", eventID).Scan(&exists)
+	if exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+
+	_, err = h.db.Exec(`
+		INSERT INTO event_registration_settings (event_id, enabled, capacity, waitlist_enabled, max_per_registration, closes_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(event_id) DO UPDATE SET
+			enabled = excluded.enabled, capacity = excluded.capacity, waitlist_enabled = excluded.waitlist_enabled,
+			max_per_registration = excluded.max_per_registration, closes_at = excluded.closes_at,
+			updated_at = CURRENT_TIMESTAMP
+	`, eventID, boolToInt(req.Enabled), nullableInt(req.Capacity), boolToInt(req.WaitlistEnabled),
+		req.MaxPerRegistration, closesAt)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update registration settings"})
+		return
+	}
+
+	h.promoteWaitlist(eventID)
+	h.GetEventRegistration(w, r)
+}
+
+// validateTicketTypeRequest normalizes the request and returns an error message, if any
+func validateTicketTypeRequest(req *ticketTypeRequest) string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = truncateString(strings.TrimSpace(req.Description), 500)
+	if req.Name == "" {
+		return "Name is required"
+	}
+	if len(req.Name) > 100 {
+		return "Name is too long"
+	}
+	if req.PriceCents != 0 && !validatePriceCents(req.PriceCents) {
+		return "Invalid price"
+	}
+	if req.Capacity != nil && (*req.Capacity < 1 || *req.Capacity > 100000) {
+		return "Capacity must be between 1 and 100000"
+	}
+	return ""
+}
+
+func (h *Handler) CreateEventTicketType(w http.ResponseWriter, r *http.Request) {
+	eventID := chi.URLParam(r, "id")
+
+	var req ticketTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if msg := validateTicketTypeRequest(&req); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	var exists int
+	h.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ?", eventID).Scan(&exists)
+	if exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		INSERT INTO event_ticket_types (event_id, name, description, price_cents, capacity, active, sort_order)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, eventID, req.Name, req.Description, req.PriceCents, nullableInt(req.Capacity), boolToInt(req.Active), req.SortOrder)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create ticket type"})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	respondJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "message": "Ticket type created"})
+}
+
+// UpdateEventTicketType changes a ticket type. Tickets already issued keep
+// the price they were bought at.
+func (h *Handler) UpdateEventTicketType(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req ticketTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if msg := validateTicketTypeRequest(&req); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	var eventID int64
+	err := h.db.QueryRow("SELECT event_id FROM event_ticket_types WHERE id = ?", id).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Ticket type not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	_, err = h.db.Exec(`
+		UPDATE event_ticket_types SET
+			name = ?, description = ?, price_cents = ?, capacity = ?, active = ?, sort_order = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, req.Name, req.Description, req.PriceCents, nullableInt(req.Capacity), boolToInt(req.Active), req.SortOrder, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update ticket type"})
+		return
+	}
+
+	h.promoteWaitlist(eventID)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Ticket type updated"})
+}
+
+func (h *Handler) DeleteEventTicketType(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var issued int
+	h.db.QueryRow("SELECT COUNT(*) FROM event_attendees WHERE ticket_type_id = ?", id).Scan(&issued)
+	if issued > 0 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Ticket type has registrations, deactivate it instead"})
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM event_ticket_types WHERE id = ?", id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete ticket type"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Ticket type deleted"})
+}
+
+// AdminEventRegistration is a registration with its attendees as the admin sees it
+type AdminEventRegistration struct {
+	ID              int64           `json:"id"`
+	EventID         int64           `json:"event_id"`
+	Token           string          `json:"token"`
+	Name            string          `json:"name"`
+	Email           string          `json:"email"`
+	LangCode        string          `json:"lang_code"`
+	Status          string          `json:"status"`
+	AmountCents     int             `json:"amount_cents"`
+	AmountDisplay   string          `json:"amount_display"`
+	RefundedCents   int             `json:"refunded_cents,omitempty"`
+	PaymentProvider string          `json:"payment_provider,omitempty"`
+	HoldUntil       *time.Time      `json:"hold_until,omitempty"`
+	ConfirmedAt     *time.Time      `json:"confirmed_at,omitempty"`
+	Notes           string          `json:"notes,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	Attendees       []EventAttendee `json:"attendees"`
+}
+
+const adminEventRegistrationSelect = `
+	SELECT id, event_id, token, name, email, lang_code, status, amount_cents, refunded_cents,
+	       payment_provider, hold_until, confirmed_at, notes, created_at
+	FROM event_registrations`
+
+func scanAdminEventRegistration(rows interface {
+	Scan(dest ...any) error
+}, currency string) (AdminEventRegistration, error) {
+	var reg AdminEventRegistration
+	var holdUntil, confirmedAt sql.NullTime
+	err := rows.Scan(&reg.ID, &reg.EventID, &reg.Token, &reg.Name, &reg.Email, &reg.LangCode, &reg.Status,
+		&reg.AmountCents, &reg.RefundedCents, &reg.PaymentProvider, &holdUntil, &confirmedAt, &reg.Notes, &reg.CreatedAt)
+	if err != nil {
+		return reg, err
+	}
+	reg.AmountDisplay = formatPrice(reg.AmountCents, currency)
+	if holdUntil.Valid && reg.Status == "pending" {
+		reg.HoldUntil = &holdUntil.Time
+	}
+	if confirmedAt.Valid {
+		reg.ConfirmedAt = &confirmedAt.Time
+	}
+	return reg, nil
+}
+
+// GetEventAttendees lists the registrations of an event with their attendees,
+// as JSON or as a CSV/XLSX attendee list with one row per attendee.
+// Query: status (e.g. confirmed, waitlisted), format (json|csv|xlsx)
+func (h *Handler) GetEventAttendees(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+	q := r.URL.Query()
+
+	var eventUUID string
+	err = h.db.QueryRow("SELECT COALESCE(uuid, '') FROM events WHERE id = ?", eventID).Scan(&eventUUID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	shop, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	query := adminEventRegistrationSelect + " WHERE event_id = ?"
+	args := []interface{}{eventID}
+	if status := q.Get("status"); status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += `
+		ORDER BY CASE status WHEN 'confirmed' THEN 0 WHEN 'pending' THEN 1 WHEN 'waitlisted' THEN 2 ELSE 3 END, id`
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	registrations := []AdminEventRegistration{}
+	for rows.Next() {
+		reg, err := scanAdminEventRegistration(rows, shop.Currency)
+		if err != nil {
+			continue
+		}
+		registrations = append(registrations, reg)
+	}
+	rows.Close()
+
+	for i := range registrations {
+		registrations[i].Attendees, err = h.loadAttendees(registrations[i].ID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+	}
+
+	format := q.Get("format")
+	if format != "csv" && format != "xlsx" {
+		respondJSON(w, http.StatusOK, registrations)
+		return
+	}
+
+	sheet := xlsx.Sheet{
+		Name: "Attendees",
+		Headers: []string{"Registration", "Status", "Attendee", "Ticket type", "Price",
+			"Registered by", "Email", "Language", "Registered at", "Ticket code", "Checked in at", "Checked in by"},
+	}
+	for _, reg := range registrations {
+		for _, a := range reg.Attendees {
+			checkedInAt := ""
+			if a.CheckedInAt != nil {
+				checkedInAt = a.CheckedInAt.In(pickupLocation).Format("2006-01-02 15:04")
+			}
+			sheet.Rows = append(sheet.Rows, []interface{}{
+				reg.ID, reg.Status, a.Name, a.TicketType, float64(a.PriceCents) / 100,
+				reg.Name, reg.Email, reg.LangCode, reg.CreatedAt.In(pickupLocation).Format("2006-01-02 15:04"), a.TicketCode,
+				checkedInAt, a.CheckedInBy,
+			})
+		}
+	}
+	respondTable(w, format, "attendees-"+eventUUID, []xlsx.Sheet{sheet})
+}
+
+func (h *Handler) GetEventRegistrationByID(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	shop, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	reg, err := scanAdminEventRegistration(h.db.QueryRow(adminEventRegistrationSelect+" WHERE id = ?", id), shop.Currency)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	reg.Attendees, err = h.loadAttendees(reg.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, reg)
+}
+
+// CancelEventRegistration cancels a registration and frees its spots for the
+// waitlist. Body (optional): {"refund": true, "notify": true}; both default
+// to true. Paid tickets are refunded in full through the provider that took
+// the payment.
+func (h *Handler) CancelEventRegistration(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		Refund *bool `json:"refund"`
+		Notify *bool `json:"notify"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+	}
+	refund := req.Refund == nil || *req.Refund
+	notify := req.Notify == nil || *req.Notify
+
+	var registrationID, eventID int64
+	var status, providerName string
+	var amountCents int
+	var paymentID sql.NullString
+	err := h.db.QueryRow(`
+		SELECT id, event_id, status, amount_cents, payment_provider,
+		       CASE WHEN payment_provider = 'stripe' THEN stripe_payment_intent_id ELSE provider_payment_id END
+		FROM event_registrations WHERE id = ?
+	`, id).Scan(&registrationID, &eventID, &status, &amountCents, &providerName, &paymentID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if status == "cancelled" || status == "refunded" {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Registration is already cancelled"})
+		return
+	}
+
+	newStatus := "cancelled"
+	if refund && status == "confirmed" && amountCents > 0 {
+		if !paymentID.Valid || paymentID.String == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Registration has no captured payment to refund"})
+			return
+		}
+		settings, err := h.getShopSettings()
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch shop settings"})
+			return
+		}
+		provider, err := h.paymentProvider(providerName, settings)
+		if err != nil {
+			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Payment provider for this registration is not available"})
+			return
+		}
+		if err := provider.Refund(r.Context(), paymentID.String, 0, settings.Currency); err != nil {
+			msg := "Refund failed"
+			var providerErr *payment.ProviderError
+			if errors.As(err, &providerErr) {
+				msg = providerErr.Message
+			}
+			respondJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+			return
+		}
+		newStatus = "refunded"
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE event_registrations SET
+			status = ?, refunded_cents = CASE WHEN ? = 'refunded' THEN amount_cents ELSE refunded_cents END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`, newStatus, newStatus, registrationID, status)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to cancel registration"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		if notify {
+			h.notifyRegistration(registrationID, newStatus)
+		}
+		h.promoteWaitlist(eventID)
+	}
+
+	h.GetEventRegistrationByID(w, r)
+}
+
+// PromoteEventRegistration moves a waitlisted or expired registration up
+// regardless of capacity. Free registrations are confirmed; paid ones get a
+// new hold and a mail asking them to pay.
+func (h *Handler) PromoteEventRegistration(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var registrationID int64
+	var status string
+	var amountCents int
+	err := h.db.QueryRow(`
+		SELECT id, status, amount_cents FROM event_registrations WHERE id = ?
+	`, id).Scan(&registrationID, &status, &amountCents)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if status != "waitlisted" && status != "expired" {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Only waitlisted or expired registrations can be moved up"})
+		return
+	}
+
+	now := time.Now().UTC()
+	kind := "confirmed"
+	if amountCents > 0 {
+		settings, settingsErr := h.getShopSettings()
+		if settingsErr != nil || !h.paymentConfigured(settings) {
+			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Online payment is not available"})
+			return
+		}
+		kind = "offered"
+		_, err = h.db.Exec(`
+			UPDATE event_registrations SET status = 'pending', payment_provider = ?, hold_until = ?,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = ?
+		`, settings.PaymentProvider, now.Add(registrationHoldTTL), registrationID, status)
+	} else {
+		_, err = h.db.Exec(`
+			UPDATE event_registrations SET status = 'confirmed', confirmed_at = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = ?
+		`, now, registrationID, status)
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update registration"})
+		return
+	}
+
+	h.notifyRegistration(registrationID, kind)
+	h.GetEventRegistrationByID(w, r)
+}
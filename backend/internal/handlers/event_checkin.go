@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Ticket check-in at the door. Every ticket QR carries the ticket code and
+// an HMAC signature over it; a scan records who checked the attendee in and
+// when, and scanning the same ticket again is logged as a duplicate.
+//
+// Admins scan with their own login. Volunteers without an account get a
+// check-in key for one event that expires on its own; the key's label is
+// recorded as the operator.
+
+// checkinKeyHeader carries a volunteer check-in key
+const checkinKeyHeader = "X-Checkin-Key"
+
+const (
+	defaultCheckinKeyHours = 48
+	maxCheckinKeyHours     = 14 * 24
+)
+
+// ticketInText finds a ticket code and its signature in a scanned QR code:
+// the full ticket URL, or "code.signature"
+var ticketInText = regexp.MustCompile(`([0-9a-fA-F]{32})(?:\?sig=|\.)([0-9a-fA-F]{32})`)
+
+// ticketSignature signs a ticket code. It is cut to 128 bits to keep the QR
+// code easy to scan on a phone.
+func (h *Handler) ticketSignature(code string) string {
+	mac := hmac.New(sha256.New, []byte(h.cfg.JWT.Secret))
+	fmt.Fprintf(mac, "ticket-checkin:%s", code)
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func (h *Handler) verifyTicketSignature(code, sig string) bool {
+	return hmac.Equal([]byte(sig), []byte(h.ticketSignature(code)))
+}
+
+func hashCheckinKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkinOperator is who performed a scan
+type checkinOperator struct {
+	Name   string
+	UserID *int64
+	KeyID  *int64
+}
+
+// CheckInAttendee is what the scanner shows after a scan
+type CheckInAttendee struct {
+	ID               int64      `json:"id"`
+	Name             string     `json:"name"`
+	TicketType       string     `json:"ticket_type,omitempty"`
+	RegistrationID   int64      `json:"registration_id"`
+	RegistrationName string     `json:"registration_name"`
+	Status           string     `json:"status"`
+	CheckedInAt      *time.Time `json:"checked_in_at,omitempty"`
+	CheckedInBy      string     `json:"checked_in_by,omitempty"`
+}
+
+// EventCheckInLog is one recorded scan
+type EventCheckInLog struct {
+	ID           int64     `json:"id"`
+	AttendeeID   int64     `json:"attendee_id"`
+	AttendeeName string    `json:"attendee_name"`
+	Operator     string    `json:"operator"`
+	Duplicate    bool      `json:"duplicate"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// EventCheckInStats is the live check-in count of an event
+type EventCheckInStats struct {
+	CheckedIn  int               `json:"checked_in"`
+	Expected   int               `json:"expected"`
+	Remaining  int               `json:"remaining"`
+	Duplicates int               `json:"duplicates"`
+	Recent     []EventCheckInLog `json:"recent"`
+}
+
+// EventCheckinKey is a volunteer check-in key. The key itself is only
+// returned when it is created.
+type EventCheckinKey struct {
+	ID         int64      `json:"id"`
+	EventID    int64      `json:"event_id"`
+	Label      string     `json:"label"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (h *Handler) loadCheckInAttendee(attendeeID int64) (CheckInAttendee, int64, error) {
+	var a CheckInAttendee
+	var eventID int64
+	var checkedInAt sql.NullTime
+	err := h.db.QueryRow(`
+		SELECT a.id, a.name, COALESCE(t.name, ''), r.id, r.name, r.status, r.event_id, a.checked_in_at, a.checked_in_by
+		FROM event_attendees a
+		JOIN event_registrations r ON a.registration_id = r.id
+		LEFT JOIN event_ticket_types t ON a.ticket_type_id = t.id
+		WHERE a.id = ?
+	`, attendeeID).Scan(&a.ID, &a.Name, &a.TicketType, &a.RegistrationID, &a.RegistrationName, &a.Status,
+		&eventID, &checkedInAt, &a.CheckedInBy)
+	if checkedInAt.Valid {
+		a.CheckedInAt = &checkedInAt.Time
+	}
+	return a, eventID, err
+}
+
+// checkInTicket validates a scanned code for an event and records the
+// check-in. Rejections are written to the response.
+func (h *Handler) checkInTicket(w http.ResponseWriter, eventID int64, scanned string, op checkinOperator) {
+	m := ticketInText.FindStringSubmatch(scanned)
+	if m == nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Not a ticket QR code"})
+		return
+	}
+	code, sig := strings.ToLower(m[1]), strings.ToLower(m[2])
+	if !h.verifyTicketSignature(code, sig) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid ticket signature"})
+		return
+	}
+
+	var attendeeID int64
+	err := h.db.QueryRow("SELECT id FROM event_attendees WHERE ticket_code = ?", code).Scan(&attendeeID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	attendee, ticketEventID, err := h.loadCheckInAttendee(attendeeID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if ticketEventID != eventID {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{"error": "Ticket is for another event", "attendee": attendee})
+		return
+	}
+	if attendee.Status != "confirmed" {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":    fmt.Sprintf("Registration is %s", attendee.Status),
+			"attendee": attendee,
+		})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE event_attendees SET checked_in_at = ?, checked_in_by = ?
+		WHERE id = ? AND checked_in_at IS NULL
+	`, time.Now().UTC(), op.Name, attendeeID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to record check-in"})
+		return
+	}
+	duplicate := false
+	if n, _ := result.RowsAffected(); n == 0 {
+		duplicate = true
+	}
+
+	h.db.Exec(`
+		INSERT INTO event_checkins (event_id, attendee_id, operator, user_id, key_id, duplicate)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, eventID, attendeeID, op.Name, nullablePtrInt64(op.UserID), nullablePtrInt64(op.KeyID), boolToInt(duplicate))
+
+	attendee, _, err = h.loadCheckInAttendee(attendeeID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	stats, _ := h.eventCheckInStats(eventID, 0)
+
+	if duplicate {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":      "Ticket was already checked in",
+			"duplicate":  true,
+			"attendee":   attendee,
+			"checked_in": stats.CheckedIn,
+			"expected":   stats.Expected,
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":    "Checked in",
+		"attendee":   attendee,
+		"checked_in": stats.CheckedIn,
+		"expected":   stats.Expected,
+	})
+}
+
+// eventCheckInStats counts check-ins against the attendees of confirmed
+// registrations and lists the last recentLimit scans
+func (h *Handler) eventCheckInStats(eventID int64, recentLimit int) (EventCheckInStats, error) {
+	stats := EventCheckInStats{Recent: []EventCheckInLog{}}
+	err := h.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN a.checked_in_at IS NOT NULL THEN 1 ELSE 0 END), 0)
+		FROM event_attendees a
+		JOIN event_registrations r ON a.registration_id = r.id
+		WHERE r.event_id = ? AND r.status = 'confirmed'
+	`, eventID).Scan(&stats.Expected, &stats.CheckedIn)
+	if err != nil {
+		return stats, err
+	}
+	stats.Remaining = stats.Expected - stats.CheckedIn
+	h.db.QueryRow("SELECT COUNT(*) FROM event_checkins WHERE event_id = ? AND duplicate = 1", eventID).Scan(&stats.Duplicates)
+
+	if recentLimit <= 0 {
+		return stats, nil
+	}
+	rows, err := h.db.Query(`
+		SELECT c.id, c.attendee_id, a.name, c.operator, c.duplicate, c.created_at
+		FROM event_checkins c
+		JOIN event_attendees a ON c.attendee_id = a.id
+		WHERE c.event_id = ?
+		ORDER BY c.id DESC LIMIT ?
+	`, eventID, recentLimit)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l EventCheckInLog
+		var duplicate int
+		if err := rows.Scan(&l.ID, &l.AttendeeID, &l.AttendeeName, &l.Operator, &duplicate, &l.CreatedAt); err != nil {
+			return stats, err
+		}
+		l.Duplicate = duplicate == 1
+		stats.Recent = append(stats.Recent, l)
+	}
+	return stats, rows.Err()
+}
+
+// checkinKeyEvent resolves the volunteer key on a request to its event
+func (h *Handler) checkinKeyEvent(r *http.Request) (eventID int64, op checkinOperator, ok bool) {
+	key := strings.TrimSpace(r.Header.Get(checkinKeyHeader))
+	if key == "" {
+		return 0, op, false
+	}
+
+	var keyID int64
+	err := h.db.QueryRow(`
+		SELECT id, event_id, label FROM event_checkin_keys WHERE key_hash = ? AND expires_at > ?
+	`, hashCheckinKey(key), time.Now().UTC()).Scan(&keyID, &eventID, &op.Name)
+	if err != nil {
+		return 0, op, false
+	}
+	op.KeyID = &keyID
+
+	h.db.Exec("UPDATE event_checkin_keys SET last_used_at = ? WHERE id = ?", time.Now().UTC(), keyID)
+	return eventID, op, true
+}
+
+type checkInRequest struct {
+	Code string `json:"code"`
+}
+
+// CheckInEventTicket checks in a scanned ticket for an event (admin).
+// Body: {"code": "<scanned QR text>"}
+func (h *Handler) CheckInEventTicket(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	var req checkInRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	user, ok := getUserFromContext(r)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	userID := user.UserID
+	h.checkInTicket(w, eventID, req.Code, checkinOperator{Name: user.Name, UserID: &userID})
+}
+
+// GetEventCheckIns returns the live check-in count and the latest scans (admin)
+func (h *Handler) GetEventCheckIns(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	stats, err := h.eventCheckInStats(eventID, 50)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, http.StatusOK, stats)
+}
+
+// UndoEventCheckIn clears the check-in of an attendee scanned by mistake.
+// The scan log is kept.
+func (h *Handler) UndoEventCheckIn(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	result, err := h.db.Exec(`
+		UPDATE event_attendees SET checked_in_at = NULL, checked_in_by = ''
+		WHERE id = ? AND checked_in_at IS NOT NULL
+	`, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update attendee"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Attendee not found or not checked in"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Check-in undone"})
+}
+
+// VolunteerCheckIn checks in a scanned ticket with a volunteer check-in key
+func (h *Handler) VolunteerCheckIn(w http.ResponseWriter, r *http.Request) {
+	eventID, op, ok := h.checkinKeyEvent(r)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid or expired check-in key"})
+		return
+	}
+
+	var req checkInRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	h.checkInTicket(w, eventID, req.Code, op)
+}
+
+// GetVolunteerCheckIns returns the live check-in count of the key's event
+func (h *Handler) GetVolunteerCheckIns(w http.ResponseWriter, r *http.Request) {
+	eventID, _, ok := h.checkinKeyEvent(r)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid or expired check-in key"})
+		return
+	}
+
+	var title string
+	h.db.QueryRow("SELECT title FROM events WHERE id = ?", eventID).Scan(&title)
+
+	stats, err := h.eventCheckInStats(eventID, 10)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"event_title": title,
+		"stats":       stats,
+	})
+}
+
+// GetEventCheckinKeys lists the volunteer check-in keys of an event
+func (h *Handler) GetEventCheckinKeys(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, event_id, label, expires_at, last_used_at, created_at
+		FROM event_checkin_keys WHERE event_id = ?
+		ORDER BY id DESC
+	`, eventID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []EventCheckinKey{}
+	for rows.Next() {
+		var k EventCheckinKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.EventID, &k.Label, &k.ExpiresAt, &lastUsed, &k.CreatedAt); err != nil {
+			continue
+		}
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// CreateEventCheckinKey creates a volunteer check-in key for an event.
+// Body: {"label": "Gate volunteers", "valid_hours": 48}
+func (h *Handler) CreateEventCheckinKey(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	var req struct {
+		Label      string `json:"label"`
+		ValidHours int    `json:"valid_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	req.Label = truncateString(strings.TrimSpace(req.Label), 100)
+	if req.Label == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Label is required"})
+		return
+	}
+	if req.ValidHours == 0 {
+		req.ValidHours = defaultCheckinKeyHours
+	}
+	if req.ValidHours < 1 || req.ValidHours > maxCheckinKeyHours {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Valid hours must be between 1 and %d", maxCheckinKeyHours)})
+		return
+	}
+
+	var exists int
+	h.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ?", eventID).Scan(&exists)
+	if exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+
+	key, err := newOrderToken()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create key"})
+		return
+	}
+	expiresAt := time.Now().UTC().Add(time.Duration(req.ValidHours) * time.Hour)
+
+	result, err := h.db.Exec(`
+		INSERT INTO event_checkin_keys (event_id, label, key_hash, expires_at) VALUES (?, ?, ?, ?)
+	`, eventID, req.Label, hashCheckinKey(key), expiresAt)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create key"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":          id,
+		"key":         key,
+		"scanner_url": fmt.Sprintf("%s/event/check-in#key=%s", h.cfg.FrontendURL, key),
+		"expires_at":  expiresAt,
+		"message":     "Check-in key created, it is only shown once",
+	})
+}
+
+// DeleteEventCheckinKey revokes a volunteer check-in key
+func (h *Handler) DeleteEventCheckinKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	result, err := h.db.Exec("DELETE FROM event_checkin_keys WHERE id = ?", id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to revoke key"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Check-in key not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Check-in key revoked"})
+}
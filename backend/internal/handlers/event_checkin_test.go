@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newCheckinEvent creates an event with one confirmed attendee and returns
+// the event ID and the attendee's ticket code
+func newCheckinEvent(t *testing.T, h *Handler, n int) (eventID int64, code string) {
+	t.Helper()
+	eventID = exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES (?, 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+	`, fmt.Sprintf("e%d", n))
+	id := exec(t, h, `
+		INSERT INTO event_registrations (token, event_id, name, email, status)
+		VALUES (?, ?, 'Anna', 'anna@example.com', 'confirmed')
+	`, fmt.Sprintf("t%d", n), eventID)
+	code = fmt.Sprintf("%032x", n)
+	exec(t, h, "INSERT INTO event_attendees (registration_id, name, ticket_code) VALUES (?, 'Anna', ?)", id, code)
+	return eventID, code
+}
+
+// newCheckinKey creates a volunteer key for an event through the admin
+// endpoint and returns its ID and the key
+func newCheckinKey(t *testing.T, h *Handler, eventID int64) (int64, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"label":"Gate"}`))
+	h.CreateEventCheckinKey(w, withURLParams(r, "id", fmt.Sprint(eventID)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating key: %d %s", w.Code, w.Body)
+	}
+	var created struct {
+		ID  int64  `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	return created.ID, created.Key
+}
+
+func volunteerScan(h *Handler, key, scanned string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(checkInRequest{Code: scanned})
+	r := httptest.NewRequest("POST", "/events/check-in", strings.NewReader(string(body)))
+	r.Header.Set(checkinKeyHeader, key)
+	w := httptest.NewRecorder()
+	h.VolunteerCheckIn(w, r)
+	return w
+}
+
+func TestTicketSignature(t *testing.T) {
+	h := newTestHandler(t)
+	h.cfg.JWT.Secret = "secret"
+	eventID, code := newCheckinEvent(t, h, 1)
+	sig := h.ticketSignature(code)
+	other := fmt.Sprintf("%032x", 2)
+
+	if !h.verifyTicketSignature(code, sig) {
+		t.Error("the ticket's own signature is rejected")
+	}
+	if h.verifyTicketSignature(other, sig) {
+		t.Error("a signature is accepted for another ticket")
+	}
+	h.cfg.JWT.Secret = "rotated"
+	if h.verifyTicketSignature(code, sig) {
+		t.Error("a signature is accepted after the secret changed")
+	}
+	h.cfg.JWT.Secret = "secret"
+
+	tests := []struct {
+		name    string
+		scanned string
+		want    int
+	}{
+		{"ticket URL", h.ticketURL(code), http.StatusOK},
+		{"code and signature", code + "." + sig, http.StatusOK},
+		{"upper case", strings.ToUpper(code + "." + sig), http.StatusOK},
+		{"wrong signature", code + "." + strings.Repeat("0", 32), http.StatusBadRequest},
+		{"signature of another ticket", other + "." + sig, http.StatusBadRequest},
+		{"code without signature", code, http.StatusBadRequest},
+		{"not a ticket", "https://example.com", http.StatusBadRequest},
+		{"signed but unknown", other + "." + h.ticketSignature(other), http.StatusNotFound},
+	}
+
+	_, key := newCheckinKey(t, h, eventID)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec(t, h, "UPDATE event_attendees SET checked_in_at = NULL")
+			if w := volunteerScan(h, key, tt.scanned); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestDoubleCheckIn(t *testing.T) {
+	h := newTestHandler(t)
+	eventID, code := newCheckinEvent(t, h, 1)
+	_, key := newCheckinKey(t, h, eventID)
+	scanned := h.ticketURL(code)
+
+	// Two volunteers scan the same ticket at the same moment
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = volunteerScan(h, key, scanned).Code
+		}(i)
+	}
+	wg.Wait()
+	if codes[0]+codes[1] != http.StatusOK+http.StatusConflict {
+		t.Fatalf("statuses = %v, want one 200 and one 409", codes)
+	}
+
+	w := volunteerScan(h, key, scanned)
+	var resp struct {
+		Duplicate bool            `json:"duplicate"`
+		Attendee  CheckInAttendee `json:"attendee"`
+		CheckedIn int             `json:"checked_in"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusConflict || !resp.Duplicate || resp.Attendee.CheckedInAt == nil || resp.CheckedIn != 1 {
+		t.Errorf("third scan: %d %+v, want a duplicate of one check-in", w.Code, resp)
+	}
+
+	var scans, duplicates int
+	h.db.QueryRow("SELECT COUNT(*), SUM(duplicate) FROM event_checkins WHERE event_id = ?", eventID).Scan(&scans, &duplicates)
+	if scans != 3 || duplicates != 2 {
+		t.Errorf("%d scans logged with %d duplicates, want 3 with 2", scans, duplicates)
+	}
+}
+
+func TestCheckinKeyRevoked(t *testing.T) {
+	h := newTestHandler(t)
+	eventID, code := newCheckinEvent(t, h, 1)
+	otherEventID, otherCode := newCheckinEvent(t, h, 2)
+	keyID, key := newCheckinKey(t, h, eventID)
+	_, expiredKey := newCheckinKey(t, h, otherEventID)
+	exec(t, h, "UPDATE event_checkin_keys SET expires_at = datetime('now', '-1 minute') WHERE event_id = ?", otherEventID)
+
+	if w := volunteerScan(h, key, h.ticketURL(otherCode)); w.Code != http.StatusConflict {
+		t.Errorf("ticket of another event: %d, want 409", w.Code)
+	}
+	if w := volunteerScan(h, expiredKey, h.ticketURL(otherCode)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired key: %d, want 401", w.Code)
+	}
+	if w := volunteerScan(h, "", h.ticketURL(code)); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: %d, want 401", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	h.DeleteEventCheckinKey(w, withURLParams(httptest.NewRequest("DELETE", "/", nil), "id", fmt.Sprint(keyID)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoking: %d %s", w.Code, w.Body)
+	}
+
+	if w := volunteerScan(h, key, h.ticketURL(code)); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: %d, want 401", w.Code)
+	}
+	r := httptest.NewRequest("GET", "/events/check-in", nil)
+	r.Header.Set(checkinKeyHeader, key)
+	w = httptest.NewRecorder()
+	h.GetVolunteerCheckIns(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("stats with a revoked key: %d, want 401", w.Code)
+	}
+
+	var checkedIn int
+	h.db.QueryRow("SELECT COUNT(*) FROM event_attendees WHERE checked_in_at IS NOT NULL").Scan(&checkedIn)
+	if checkedIn != 0 {
+		t.Errorf("%d attendees checked in, want none", checkedIn)
+	}
+}
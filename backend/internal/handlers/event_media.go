@@ -0,0 +1,584 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Event media are the photos of an event's gallery and its downloadable
+// files (route descriptions, flyers). Photos go through the same validation
+// as every other uploaded image; files are limited to a few document types
+// and always served as downloads.
+
+const (
+	maxMediaFileSize     = 10 << 20
+	maxMediaUploadSize   = 100 << 20
+	maxMediaFilesPerPost = 50
+)
+
+// documentTypes are the downloadable file types with their content type
+var documentTypes = map[string]string{
+	".pdf": "application/pdf",
+	".gpx": "application/gpx+xml",
+	".kml": "application/vnd.google-earth.kml+xml",
+}
+
+var imageContentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+	".gif":  "image/gif",
+}
+
+// EventMedia is a gallery photo or downloadable file of an event
+type EventMedia struct {
+	ID           int64                   `json:"id"`
+	EventID      int64                   `json:"event_id"`
+	Kind         string                  `json:"kind"`
+	URL          string                  `json:"url"`
+	Filename     string                  `json:"filename"`
+	OriginalName string                  `json:"original_name,omitempty"`
+	ContentType  string                  `json:"content_type"`
+	SizeBytes    int64                   `json:"size_bytes"`
+	SortOrder    int                     `json:"sort_order"`
+	IsCover      bool                    `json:"is_cover"`
+	Widths       []int                   `json:"widths,omitempty"` // resized variants of photos, for srcset
+	Translations []EventMediaTranslation `json:"translations"`
+}
+
+type EventMediaTranslation struct {
+	LangCode string `json:"lang_code"`
+	Caption  string `json:"caption"`
+}
+
+// EventGallery is the public media listing of an event
+type EventGallery struct {
+	Cover  *EventMedia  `json:"cover,omitempty"`
+	Images []EventMedia `json:"images"`
+	Files  []EventMedia `json:"files"`
+}
+
+// validateDocument checks the content of a downloadable file against its
+// extension
+func validateDocument(content []byte, ext string) bool {
+	switch ext {
+	case ".pdf":
+		return bytes.HasPrefix(content, []byte("%PDF-"))
+	case ".gpx", ".kml":
+		// XML, possibly after a byte order mark and whitespace
+		trimmed := bytes.TrimLeft(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")), " \t\r\n")
+		root := "<" + strings.TrimPrefix(ext, ".")
+		return bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte(root))
+	}
+	return false
+}
+
+// saveDocument validates a downloadable file and stores it under a new
+// random name in the files directory
+func (h *Handler) saveDocument(ctx context.Context, file io.Reader, originalName string) (string, *uploadError) {
+	ext := strings.ToLower(filepath.Ext(originalName))
+	if _, ok := documentTypes[ext]; !ok {
+		return "", &uploadError{http.StatusBadRequest, "Invalid file type. Allowed: pdf, gpx, kml"}
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", &uploadError{http.StatusInternalServerError, "Failed to read file"}
+	}
+	if !validateDocument(content, ext) {
+		return "", &uploadError{http.StatusBadRequest, "File content does not match declared type"}
+	}
+
+	filename := fmt.Sprintf("%s%s", uuid.New().String(), ext)
+	if err := h.store.Put(ctx, fileKey(filename), content, documentTypes[ext]); err != nil {
+		return "", &uploadError{http.StatusInternalServerError, "Failed to save file"}
+	}
+
+	return filename, nil
+}
+
+// fileKey is the storage key of an event download
+func fileKey(filename string) string {
+	return "files/" + filepath.Base(filename)
+}
+
+func (h *Handler) mediaURL(kind, filename string) string {
+	if kind == "image" {
+		return fmt.Sprintf("%s/images/%s", h.cfg.APIURL, filename)
+	}
+	return fmt.Sprintf("%s/files/%s", h.cfg.APIURL, filename)
+}
+
+const eventMediaSelect = `
+	SELECT id, event_id, kind, filename, original_name, content_type, size_bytes, sort_order, is_cover
+	FROM event_media`
+
+func (h *Handler) scanEventMedia(ctx context.Context, row interface{ Scan(...any) error }) (EventMedia, error) {
+	var m EventMedia
+	var isCover int
+	err := row.Scan(&m.ID, &m.EventID, &m.Kind, &m.Filename, &m.OriginalName, &m.ContentType,
+		&m.SizeBytes, &m.SortOrder, &isCover)
+	m.IsCover = isCover == 1
+	m.URL = h.mediaURL(m.Kind, m.Filename)
+	if err == nil && m.Kind == "image" {
+		m.Widths = h.imageWidths(ctx, m.Filename)
+	}
+	m.Translations = []EventMediaTranslation{}
+	return m, err
+}
+
+// loadEventMedia returns the media of an event in gallery order, with
+// captions in langFilter only when it is set
+func (h *Handler) loadEventMedia(ctx context.Context, eventID int64, langFilter string) ([]EventMedia, error) {
+	rows, err := h.db.Query(eventMediaSelect+` WHERE event_id = ? ORDER BY sort_order, id`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	media := []EventMedia{}
+	index := map[int64]int{}
+	for rows.Next() {
+		m, err := h.scanEventMedia(ctx, rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[m.ID] = len(media)
+		media = append(media, m)
+	}
+	rows.Close()
+
+	query := `
+		SELECT t.media_id, t.lang_code, t.caption
+		FROM event_media_translations t
+		JOIN event_media m ON t.media_id = m.id
+		WHERE m.event_id = ?`
+	args := []interface{}{eventID}
+	if langFilter != "" {
+		query += " AND t.lang_code = ?"
+		args = append(args, langFilter)
+	}
+	rows, err = h.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mediaID int64
+		var t EventMediaTranslation
+		if err := rows.Scan(&mediaID, &t.LangCode, &t.Caption); err != nil {
+			return nil, err
+		}
+		if i, ok := index[mediaID]; ok {
+			media[i].Translations = append(media[i].Translations, t)
+		}
+	}
+	return media, rows.Err()
+}
+
+func (h *Handler) loadEventMediaByID(ctx context.Context, id int64) (EventMedia, error) {
+	m, err := h.scanEventMedia(ctx, h.db.QueryRow(eventMediaSelect+" WHERE id = ?", id))
+	if err != nil {
+		return m, err
+	}
+
+	rows, err := h.db.Query("SELECT lang_code, caption FROM event_media_translations WHERE media_id = ?", id)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t EventMediaTranslation
+		if err := rows.Scan(&t.LangCode, &t.Caption); err != nil {
+			return m, err
+		}
+		m.Translations = append(m.Translations, t)
+	}
+	return m, rows.Err()
+}
+
+func galleryFromMedia(media []EventMedia) EventGallery {
+	gallery := EventGallery{Images: []EventMedia{}, Files: []EventMedia{}}
+	for i := range media {
+		if media[i].Kind == "image" {
+			gallery.Images = append(gallery.Images, media[i])
+			if media[i].IsCover {
+				gallery.Cover = &media[i]
+			}
+		} else {
+			gallery.Files = append(gallery.Files, media[i])
+		}
+	}
+	// Without an explicit cover the first photo is the cover
+	if gallery.Cover == nil && len(gallery.Images) > 0 {
+		gallery.Cover = &gallery.Images[0]
+	}
+	return gallery
+}
+
+// GetPublicEventMedia returns the photo gallery and files of a published event
+func (h *Handler) GetPublicEventMedia(w http.ResponseWriter, r *http.Request) {
+	eventUUID := chi.URLParam(r, "uuid")
+	lang := h.requestLanguage(w, r)
+
+	var eventID int64
+	err := h.db.QueryRow("SELECT id FROM events WHERE uuid = ? AND state = 'published'", eventUUID).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	media, err := h.loadEventMedia(r.Context(), eventID, lang)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, galleryFromMedia(media))
+}
+
+// GetEventMedia returns all media of an event with every caption (admin)
+func (h *Handler) GetEventMedia(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	media, err := h.loadEventMedia(r.Context(), eventID, "")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, galleryFromMedia(media))
+}
+
+// UploadEventMedia adds photos and files to an event. Every "file" part of
+// the multipart form is stored; images join the gallery, pdf/gpx/kml become
+// downloads. New media go to the end of the gallery.
+func (h *Handler) UploadEventMedia(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	var exists int
+	h.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ?", eventID).Scan(&exists)
+	if exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Upload too large or not a multipart form"})
+		return
+	}
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No file provided"})
+		return
+	}
+	if len(headers) > maxMediaFilesPerPost {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("At most %d files per upload", maxMediaFilesPerPost)})
+		return
+	}
+
+	var maxOrder int
+	h.db.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM event_media WHERE event_id = ?", eventID).Scan(&maxOrder)
+
+	created := []EventMedia{}
+	rejected := []map[string]string{}
+	for _, header := range headers {
+		id, uploadErr := h.storeEventMedia(r.Context(), eventID, header, maxOrder+1, uploaderID(r))
+		if uploadErr != nil {
+			rejected = append(rejected, map[string]string{"file": header.Filename, "error": uploadErr.message})
+			continue
+		}
+		maxOrder++
+		if m, err := h.loadEventMediaByID(r.Context(), id); err == nil {
+			created = append(created, m)
+		}
+	}
+
+	if len(created) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "No files were stored", "rejected": rejected})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"media":    created,
+		"rejected": rejected,
+		"message":  fmt.Sprintf("%d file(s) added", len(created)),
+	})
+}
+
+func (h *Handler) storeEventMedia(ctx context.Context, eventID int64, header *multipart.FileHeader, sortOrder int, uploadedBy *int64) (int64, *uploadError) {
+	if header.Size > maxMediaFileSize {
+		return 0, &uploadError{http.StatusBadRequest, "File is larger than 10 MB"}
+	}
+	file, err := header.Open()
+	if err != nil {
+		return 0, &uploadError{http.StatusInternalServerError, "Failed to read file"}
+	}
+	defer file.Close()
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	kind := "file"
+	contentType, isDocument := documentTypes[ext]
+	var filename string
+	var uploadErr *uploadError
+	if isDocument {
+		filename, uploadErr = h.saveDocument(ctx, file, header.Filename)
+	} else {
+		kind = "image"
+		contentType = imageContentTypes[ext]
+		filename, uploadErr = h.saveImage(ctx, file, header.Filename, uploadedBy)
+	}
+	if uploadErr != nil {
+		return 0, uploadErr
+	}
+
+	result, err := h.db.Exec(`
+		INSERT INTO event_media (event_id, kind, filename, original_name, content_type, size_bytes, sort_order)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, eventID, kind, filename, truncateString(filepath.Base(header.Filename), 200), contentType, header.Size, sortOrder)
+	if err != nil {
+		return 0, &uploadError{http.StatusInternalServerError, "Failed to save file"}
+	}
+	id, _ := result.LastInsertId()
+	return id, nil
+}
+
+// UpdateEventMedia sets the captions and cover flag of a photo or file.
+// Body: {"translations": [{"lang_code": "NL", "caption": "..."}], "is_cover": true}
+// Translations replace the existing captions; leaving them out keeps them.
+func (h *Handler) UpdateEventMedia(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid media ID"})
+		return
+	}
+
+	var req struct {
+		Translations *[]EventMediaTranslation `json:"translations"`
+		IsCover      *bool                    `json:"is_cover"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	media, err := h.loadEventMediaByID(r.Context(), id)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Media not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if req.IsCover != nil && *req.IsCover && media.Kind != "image" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Only photos can be the cover"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if req.Translations != nil {
+		if _, err := tx.Exec("DELETE FROM event_media_translations WHERE media_id = ?", id); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update captions"})
+			return
+		}
+		for _, t := range *req.Translations {
+			caption := truncateString(strings.TrimSpace(t.Caption), 500)
+			if caption == "" {
+				continue
+			}
+			_, err := tx.Exec(`
+				INSERT INTO event_media_translations (media_id, lang_code, caption) VALUES (?, ?, ?)
+			`, id, t.LangCode, caption)
+			if err != nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid language: " + t.LangCode})
+				return
+			}
+		}
+	}
+
+	if req.IsCover != nil {
+		// An event has at most one cover photo
+		if *req.IsCover {
+			if _, err := tx.Exec("UPDATE event_media SET is_cover = 0 WHERE event_id = ?", media.EventID); err != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update media"})
+				return
+			}
+		}
+		if _, err := tx.Exec("UPDATE event_media SET is_cover = ? WHERE id = ?", boolToInt(*req.IsCover), id); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update media"})
+			return
+		}
+	}
+
+	tx.Exec("UPDATE event_media SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	if err := tx.Commit(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update media"})
+		return
+	}
+
+	media, err = h.loadEventMediaByID(r.Context(), id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, http.StatusOK, media)
+}
+
+// ReorderEventMedia sets the gallery order of an event.
+// Body: {"ids": [3, 1, 2]}; media left out keep their place after the listed ones.
+func (h *Handler) ReorderEventMedia(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	media, err := h.loadEventMedia(r.Context(), eventID, "")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	listed := map[int64]bool{}
+	order := []int64{}
+	belongs := map[int64]bool{}
+	for _, m := range media {
+		belongs[m.ID] = true
+	}
+	for _, id := range req.IDs {
+		if !belongs[id] {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Media %d does not belong to this event", id)})
+			return
+		}
+		if !listed[id] {
+			listed[id] = true
+			order = append(order, id)
+		}
+	}
+	for _, m := range media {
+		if !listed[m.ID] {
+			order = append(order, m.ID)
+		}
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+	for i, id := range order {
+		if _, err := tx.Exec("UPDATE event_media SET sort_order = ? WHERE id = ?", i, id); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to reorder media"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to reorder media"})
+		return
+	}
+
+	h.GetEventMedia(w, r)
+}
+
+// DeleteEventMedia removes a photo or file and its stored upload
+func (h *Handler) DeleteEventMedia(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid media ID"})
+		return
+	}
+
+	media, err := h.loadEventMediaByID(r.Context(), id)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Media not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM event_media WHERE id = ?", id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete media"})
+		return
+	}
+
+	if media.Kind == "image" {
+		// The photo may also be used elsewhere, such as the event image
+		if !h.imageInUse(media.Filename) {
+			h.removeImage(r.Context(), media.Filename)
+		}
+	} else if err := h.store.Delete(r.Context(), fileKey(media.Filename)); err != nil {
+		log.Printf("Failed to remove file %s: %v", media.Filename, err)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Media deleted"})
+}
+
+// ServeFile serves an event download under its original name
+func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request) {
+	filename := filepath.Base(chi.URLParam(r, "filename"))
+	ext := strings.ToLower(filepath.Ext(filename))
+	contentType, ok := documentTypes[ext]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, err := h.store.Stat(r.Context(), fileKey(filename)); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	downloadName := filename
+	h.db.QueryRow("SELECT original_name FROM event_media WHERE filename = ?", filename).Scan(&downloadName)
+	downloadName = strings.NewReplacer(`"`, "", "\\", "", "\r", "", "\n", "").Replace(downloadName)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", downloadName))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	h.serveStored(w, r, fileKey(filename), contentType, downloadName)
+}
@@ -0,0 +1,1426 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/email"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/payment"
+	"github.com/go-chi/chi/v5"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Registration statuses:
+//   - pending: paid tickets, spots are held until hold_until while the registrant pays
+//   - confirmed: tickets were issued
+//   - waitlisted: the event was full, no spots are held
+//   - expired: the hold ran out before the payment arrived
+//   - cancelled, refunded
+//
+// Only confirmed registrations and pending ones within their hold take up spots.
+
+// registrationHoldTTL is how long a pending registration keeps its spots.
+// It matches the default expiry of a Stripe checkout session.
+const registrationHoldTTL = 24 * time.Hour
+
+// maxAttendeesPerRegistration caps the per-event max_per_registration setting
+const maxAttendeesPerRegistration = 50
+
+// registrationSpotsWhere selects the registrations r of an event that take up spots
+const registrationSpotsWhere = `r.event_id = ? AND (r.status = 'confirmed' OR (r.status = 'pending' AND r.hold_until > ?))`
+
+// EventRegistrationSettings configures built-in registration for an event.
+// Capacity is the number of attendees over all ticket types; nil means unlimited.
+type EventRegistrationSettings struct {
+	Enabled            bool       `json:"enabled"`
+	Capacity           *int       `json:"capacity,omitempty"`
+	WaitlistEnabled    bool       `json:"waitlist_enabled"`
+	MaxPerRegistration int        `json:"max_per_registration"`
+	ClosesAt           *time.Time `json:"closes_at,omitempty"`
+}
+
+// EventTicketType is a kind of ticket for an event. Free events without
+// ticket types hand out untyped free tickets.
+type EventTicketType struct {
+	ID           int64  `json:"id"`
+	EventID      int64  `json:"event_id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	PriceCents   int    `json:"price_cents"`
+	PriceDisplay string `json:"price_display"`
+	Capacity     *int   `json:"capacity,omitempty"`
+	Taken        int    `json:"taken"`
+	Available    *int   `json:"available,omitempty"`
+	SoldOut      bool   `json:"sold_out"`
+	Active       bool   `json:"active"`
+	SortOrder    int    `json:"sort_order"`
+}
+
+// EventRegistrationInfo is the registration state shown on a public event
+type EventRegistrationInfo struct {
+	Open               bool              `json:"open"`
+	ClosesAt           *time.Time        `json:"closes_at,omitempty"`
+	Capacity           *int              `json:"capacity,omitempty"`
+	Available          *int              `json:"available,omitempty"`
+	Full               bool              `json:"full"`
+	WaitlistEnabled    bool              `json:"waitlist_enabled"`
+	MaxPerRegistration int               `json:"max_per_registration"`
+	Currency           string            `json:"currency"`
+	TicketTypes        []EventTicketType `json:"ticket_types"`
+}
+
+// parseEventTime reads an event start or end date. The admin sends RFC3339;
+// older rows may hold a bare local date or time.
+func parseEventTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, pickupLocation); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// formatEventDate renders an event date the way mails show it
+func formatEventDate(s string) string {
+	t, ok := parseEventTime(s)
+	if !ok {
+		return s
+	}
+	return t.In(pickupLocation).Format("02/01/2006 15:04")
+}
+
+// loadRegistrationSettings returns the settings of an event, or the
+// defaults (registration disabled) when none were saved
+func (h *Handler) loadRegistrationSettings(eventID int64) (EventRegistrationSettings, error) {
+	s := EventRegistrationSettings{WaitlistEnabled: true, MaxPerRegistration: 10}
+	var enabled, waitlist int
+	var capacity sql.NullInt64
+	var closesAt sql.NullTime
+	err := h.db.QueryRow(`
+		SELECT enabled, capacity, waitlist_enabled, max_per_registration, closes_at
+		FROM event_registration_settings WHERE event_id = ?
+	`, eventID).Scan(&enabled, &capacity, &waitlist, &s.MaxPerRegistration, &closesAt)
+	if err == sql.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	s.Enabled = enabled == 1
+	s.WaitlistEnabled = waitlist == 1
+	if capacity.Valid {
+		c := int(capacity.Int64)
+		s.Capacity = &c
+	}
+	if closesAt.Valid {
+		s.ClosesAt = &closesAt.Time
+	}
+	return s, nil
+}
+
+// registrationClosed reports whether registration has ended: at closes_at
+// when set, otherwise when the event starts
+func registrationClosed(settings EventRegistrationSettings, startDate string) bool {
+	now := time.Now()
+	if settings.ClosesAt != nil {
+		return !now.Before(*settings.ClosesAt)
+	}
+	if start, ok := parseEventTime(startDate); ok {
+		return !now.Before(start)
+	}
+	return false
+}
+
+// spotUsage counts the attendees holding a spot, in total and per ticket
+// type (0 for untyped tickets)
+type spotUsage struct {
+	Total  int
+	ByType map[int64]int
+}
+
+func (h *Handler) eventSpotUsage(eventID int64) (spotUsage, error) {
+	return loadSpotUsage(h.db, eventID)
+}
+
+// loadSpotUsage counts the spots of an event through q, the database or a
+// transaction
+func loadSpotUsage(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, eventID int64) (spotUsage, error) {
+	u := spotUsage{ByType: map[int64]int{}}
+	rows, err := q.Query(`
+		SELECT COALESCE(a.ticket_type_id, 0), COUNT(*)
+		FROM event_attendees a
+		JOIN event_registrations r ON a.registration_id = r.id
+		WHERE `+registrationSpotsWhere+`
+		GROUP BY 1
+	`, eventID, time.Now().UTC())
+	if err != nil {
+		return u, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var typeID int64
+		var n int
+		if err := rows.Scan(&typeID, &n); err != nil {
+			return u, err
+		}
+		u.ByType[typeID] = n
+		u.Total += n
+	}
+	return u, rows.Err()
+}
+
+// fits reports whether the wanted tickets (count per ticket type) are still available
+func (u spotUsage) fits(settings EventRegistrationSettings, types map[int64]EventTicketType, want map[int64]int) bool {
+	n := 0
+	for typeID, count := range want {
+		n += count
+		if t, ok := types[typeID]; ok && t.Capacity != nil && u.ByType[typeID]+count > *t.Capacity {
+			return false
+		}
+	}
+	return settings.Capacity == nil || u.Total+n <= *settings.Capacity
+}
+
+func scanEventTicketType(rows interface {
+	Scan(dest ...any) error
+}) (EventTicketType, error) {
+	var t EventTicketType
+	var capacity sql.NullInt64
+	var active int
+	err := rows.Scan(&t.ID, &t.EventID, &t.Name, &t.Description, &t.PriceCents, &capacity, &active, &t.SortOrder)
+	if err != nil {
+		return t, err
+	}
+	t.Active = active == 1
+	if capacity.Valid {
+		c := int(capacity.Int64)
+		t.Capacity = &c
+	}
+	return t, nil
+}
+
+// loadTicketTypes returns the ticket types of an event with their availability
+func (h *Handler) loadTicketTypes(eventID int64, activeOnly bool, usage spotUsage, currency string) ([]EventTicketType, error) {
+	query := `
+		SELECT id, event_id, name, description, price_cents, capacity, active, sort_order
+		FROM event_ticket_types WHERE event_id = ?`
+	if activeOnly {
+		query += " AND active = 1"
+	}
+	query += " ORDER BY sort_order, id"
+
+	rows, err := h.db.Query(query, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := []EventTicketType{}
+	for rows.Next() {
+		t, err := scanEventTicketType(rows)
+		if err != nil {
+			return nil, err
+		}
+		t.PriceDisplay = formatPrice(t.PriceCents, currency)
+		t.Taken = usage.ByType[t.ID]
+		if t.Capacity != nil {
+			available := *t.Capacity - t.Taken
+			if available < 0 {
+				available = 0
+			}
+			t.Available = &available
+			t.SoldOut = available == 0
+		}
+		types = append(types, t)
+	}
+	return types, rows.Err()
+}
+
+// ticketTypeMap indexes ticket types by ID
+func ticketTypeMap(types []EventTicketType) map[int64]EventTicketType {
+	m := make(map[int64]EventTicketType, len(types))
+	for _, t := range types {
+		m[t.ID] = t
+	}
+	return m
+}
+
+// eventRegistrationInfo returns the public registration state of an event,
+// or nil when registration isn't enabled
+func (h *Handler) eventRegistrationInfo(eventID int64, startDate string) (*EventRegistrationInfo, error) {
+	settings, err := h.loadRegistrationSettings(eventID)
+	if err != nil || !settings.Enabled {
+		return nil, err
+	}
+	shop, err := h.getShopSettings()
+	if err != nil {
+		return nil, err
+	}
+	usage, err := h.eventSpotUsage(eventID)
+	if err != nil {
+		return nil, err
+	}
+	types, err := h.loadTicketTypes(eventID, true, usage, shop.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &EventRegistrationInfo{
+		Open:               !registrationClosed(settings, startDate),
+		ClosesAt:           settings.ClosesAt,
+		Capacity:           settings.Capacity,
+		WaitlistEnabled:    settings.WaitlistEnabled,
+		MaxPerRegistration: settings.MaxPerRegistration,
+		Currency:           shop.Currency,
+		TicketTypes:        types,
+	}
+	if settings.Capacity != nil {
+		available := *settings.Capacity - usage.Total
+		if available < 0 {
+			available = 0
+		}
+		info.Available = &available
+		info.Full = available == 0
+	}
+	if len(types) > 0 {
+		soldOut := true
+		for _, t := range types {
+			soldOut = soldOut && t.SoldOut
+		}
+		info.Full = info.Full || soldOut
+	}
+	return info, nil
+}
+
+// registrationStatusURL is the frontend page showing a registration and its tickets
+func (h *Handler) registrationStatusURL(token string) string {
+	return fmt.Sprintf("%s/event/registrations/%s", h.cfg.FrontendURL, token)
+}
+
+// ticketURL is what an attendee's QR code encodes. The signature lets the
+// check-in scanner tell a real ticket from a typed-over code.
+func (h *Handler) ticketURL(code string) string {
+	return fmt.Sprintf("%s/event/tickets/%s?sig=%s", h.cfg.FrontendURL, code, h.ticketSignature(code))
+}
+
+// ticketQRURL is the QR image of a ticket, shown in mails and on the registration page
+func (h *Handler) ticketQRURL(code string) string {
+	return fmt.Sprintf("%s/events/tickets/%s/qr.png", h.cfg.APIURL, code)
+}
+
+type attendeeRequest struct {
+	Name         string `json:"name"`
+	TicketTypeID *int64 `json:"ticket_type_id"`
+}
+
+type eventRegistrationRequest struct {
+	Name      string            `json:"name"`
+	Email     string            `json:"email"`
+	LangCode  string            `json:"lang"`
+	Attendees []attendeeRequest `json:"attendees"`
+}
+
+// RegisterForEvent registers one or more attendees for a published event.
+// Free registrations are confirmed immediately; paid ones return a checkout
+// URL and are confirmed by the payment webhook. When the event is full the
+// registration goes on the waitlist if the event has one.
+func (h *Handler) RegisterForEvent(w http.ResponseWriter, r *http.Request) {
+	var req eventRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	req.Name = truncateString(strings.TrimSpace(req.Name), 200)
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Name == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Name is required"})
+		return
+	}
+	if !validateEmail(req.Email) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "A valid email is required"})
+		return
+	}
+	if len(req.Attendees) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one attendee is required"})
+		return
+	}
+	for i := range req.Attendees {
+		req.Attendees[i].Name = truncateString(strings.TrimSpace(req.Attendees[i].Name), 200)
+		if req.Attendees[i].Name == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Every attendee needs a name"})
+			return
+		}
+	}
+	req.LangCode = h.orderLangCode(req.LangCode)
+
+	var eventID int64
+	var startDate string
+	err := h.db.QueryRow(`
+		SELECT id, start_date FROM events WHERE uuid = ? AND state = 'published'
+	`, chi.URLParam(r, "uuid")).Scan(&eventID, &startDate)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	settings, err := h.loadRegistrationSettings(eventID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if !settings.Enabled || registrationClosed(settings, startDate) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Registration is not open for this event"})
+		return
+	}
+	if len(req.Attendees) > settings.MaxPerRegistration {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("At most %d attendees per registration", settings.MaxPerRegistration),
+		})
+		return
+	}
+
+	// Freed spots go to the waitlist before anyone new
+	h.promoteWaitlist(eventID)
+
+	shop, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	types, err := h.loadTicketTypes(eventID, true, spotUsage{}, shop.Currency)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	typesByID := ticketTypeMap(types)
+
+	want := map[int64]int{}
+	prices := make([]int, len(req.Attendees))
+	totalCents := 0
+	for i, a := range req.Attendees {
+		var typeID int64
+		if len(types) > 0 {
+			if a.TicketTypeID == nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Every attendee needs a ticket type"})
+				return
+			}
+			t, ok := typesByID[*a.TicketTypeID]
+			if !ok {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown ticket type"})
+				return
+			}
+			typeID, prices[i] = t.ID, t.PriceCents
+		} else if a.TicketTypeID != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "This event has no ticket types"})
+			return
+		}
+		want[typeID]++
+		totalCents += prices[i]
+	}
+	if totalCents > maxPriceCents {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Total amount exceeds maximum allowed"})
+		return
+	}
+
+	var provider payment.Provider
+	if totalCents > 0 {
+		if h.paymentConfigured(shop) {
+			provider, err = h.paymentProvider(shop.PaymentProvider, shop)
+		}
+		if provider == nil || err != nil {
+			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Online payment is not available"})
+			return
+		}
+	}
+
+	token, err := newOrderToken()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register"})
+		return
+	}
+	defer tx.Rollback()
+
+	// The registration goes in waitlisted first: writing takes the database
+	// write lock, so no other registration can take the spots between the
+	// check and the update, and a waitlisted one holds no spots itself
+	result, err := tx.Exec(`
+		INSERT INTO event_registrations (token, event_id, name, email, lang_code, status, amount_cents)
+		VALUES (?, ?, ?, ?, ?, 'waitlisted', ?)
+	`, token, eventID, req.Name, req.Email, req.LangCode, totalCents)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register"})
+		return
+	}
+	registrationID, _ := result.LastInsertId()
+
+	for i, a := range req.Attendees {
+		code, err := newOrderToken()
+		if err == nil {
+			_, err = tx.Exec(`
+				INSERT INTO event_attendees (registration_id, ticket_type_id, name, price_cents, ticket_code)
+				VALUES (?, ?, ?, ?, ?)
+			`, registrationID, nullablePtrInt64(a.TicketTypeID), a.Name, prices[i], code)
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register"})
+			return
+		}
+	}
+
+	usage, err := loadSpotUsage(tx, eventID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	status := "confirmed"
+	switch {
+	case !usage.fits(settings, typesByID, want):
+		if !settings.WaitlistEnabled {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "Not enough places left"})
+			return
+		}
+		status = "waitlisted"
+	case totalCents > 0:
+		status = "pending"
+	}
+
+	if status != "waitlisted" {
+		now := time.Now().UTC()
+		var holdUntil, confirmedAt interface{}
+		providerName := ""
+		if status == "pending" {
+			holdUntil = now.Add(registrationHoldTTL)
+			providerName = provider.Name()
+		} else {
+			confirmedAt = now
+		}
+		if _, err := tx.Exec(`
+			UPDATE event_registrations SET status = ?, payment_provider = ?, hold_until = ?, confirmed_at = ?
+			WHERE id = ?
+		`, status, providerName, holdUntil, confirmedAt, registrationID); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register"})
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":     registrationID,
+		"token":  token,
+		"status": status,
+	}
+
+	switch status {
+	case "confirmed":
+		h.notifyRegistration(registrationID, "confirmed")
+		response["message"] = "Registration confirmed"
+	case "waitlisted":
+		h.notifyRegistration(registrationID, "waitlisted")
+		response["message"] = "The event is full, you have been added to the waitlist"
+	case "pending":
+		checkout, err := h.startRegistrationCheckout(r.Context(), provider, shop.Currency, registrationID)
+		if err != nil {
+			h.db.Exec(`
+				UPDATE event_registrations SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP WHERE id = ?
+			`, registrationID)
+			msg := "Payment provider checkout creation failed"
+			var providerErr *payment.ProviderError
+			if errors.As(err, &providerErr) {
+				msg = providerErr.Message
+			}
+			respondJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+			return
+		}
+		response["checkout_url"] = checkout.URL
+		response["message"] = "Complete the payment to confirm your registration"
+	}
+
+	respondJSON(w, http.StatusCreated, response)
+}
+
+// startRegistrationCheckout creates a hosted checkout for a pending registration
+func (h *Handler) startRegistrationCheckout(ctx context.Context, provider payment.Provider, currency string, registrationID int64) (*payment.Checkout, error) {
+	var token, buyerEmail, eventTitle string
+	var amountCents int
+	err := h.db.QueryRow(`
+		SELECT r.token, r.email, r.amount_cents, e.title
+		FROM event_registrations r
+		JOIN events e ON r.event_id = e.id
+		WHERE r.id = ?
+	`, registrationID).Scan(&token, &buyerEmail, &amountCents, &eventTitle)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	rows, err := h.db.Query(`
+		SELECT a.name || COALESCE(' (' || t.name || ')', '')
+		FROM event_attendees a
+		LEFT JOIN event_ticket_types t ON a.ticket_type_id = t.id
+		WHERE a.registration_id = ?
+		ORDER BY a.id
+	`, registrationID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	itemName := eventTitle
+	if len(names) > 1 {
+		itemName = fmt.Sprintf("%s (%d tickets)", eventTitle, len(names))
+	}
+
+	checkout, err := provider.CreateCheckout(ctx, payment.CheckoutRequest{
+		RegistrationID:  registrationID,
+		ItemName:        itemName,
+		Description:     truncateString(strings.Join(names, ", "), 500),
+		Quantity:        1,
+		UnitAmountCents: amountCents,
+		Currency:        currency,
+		BuyerEmail:      buyerEmail,
+		SuccessURL:      h.registrationStatusURL(token) + "?payment=success",
+		CancelURL:       h.registrationStatusURL(token) + "?payment=cancelled",
+		WebhookURL:      fmt.Sprintf("%s/shop/webhook/%s", h.cfg.APIURL, provider.Name()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.db.Exec(`
+		UPDATE event_registrations SET payment_provider = ?, stripe_session_id = ?, provider_payment_id = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, provider.Name(), nullableString(checkout.SessionID), nullableString(checkout.PaymentID), registrationID)
+
+	return checkout, nil
+}
+
+// cancelOpenCheckout closes the checkout a registration was sent to before,
+// when its provider can. A payment that still gets through is refunded by
+// applyRegistrationPayment.
+func (h *Handler) cancelOpenCheckout(ctx context.Context, registrationID int64, shop ShopSettings) {
+	var providerName string
+	var previous payment.Checkout
+	err := h.db.QueryRow(`
+		SELECT payment_provider, COALESCE(stripe_session_id, ''), COALESCE(provider_payment_id, '')
+		FROM event_registrations WHERE id = ?
+	`, registrationID).Scan(&providerName, &previous.SessionID, &previous.PaymentID)
+	if err != nil || (previous.SessionID == "" && previous.PaymentID == "") {
+		return
+	}
+
+	provider, err := h.paymentProvider(providerName, shop)
+	if err != nil {
+		return
+	}
+	canceller, ok := provider.(payment.CheckoutCanceller)
+	if !ok {
+		return
+	}
+	if err := canceller.CancelCheckout(ctx, previous); err != nil {
+		log.Printf("Failed to cancel the previous checkout of registration #%d: %v", registrationID, err)
+	}
+}
+
+// registrationTickets returns the tickets of a registration counted per ticket type
+func (h *Handler) registrationTickets(registrationID int64) (map[int64]int, error) {
+	rows, err := h.db.Query(`
+		SELECT COALESCE(ticket_type_id, 0), COUNT(*) FROM event_attendees WHERE registration_id = ? GROUP BY 1
+	`, registrationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	want := map[int64]int{}
+	for rows.Next() {
+		var typeID int64
+		var n int
+		if err := rows.Scan(&typeID, &n); err != nil {
+			return nil, err
+		}
+		want[typeID] = n
+	}
+	return want, rows.Err()
+}
+
+// promoteWaitlist expires lapsed payment holds and then offers the freed
+// spots to waitlisted registrations, oldest first. A registration that
+// doesn't fit is skipped so smaller ones behind it can still move up. Free
+// registrations are confirmed right away; paid ones get a hold and a mail
+// asking them to pay.
+func (h *Handler) promoteWaitlist(eventID int64) {
+	now := time.Now().UTC()
+	h.db.Exec(`
+		UPDATE event_registrations SET status = 'expired', updated_at = CURRENT_TIMESTAMP
+		WHERE event_id = ? AND status = 'pending' AND hold_until <= ?
+	`, eventID, now)
+
+	var startDate string
+	if err := h.db.QueryRow("SELECT start_date FROM events WHERE id = ?", eventID).Scan(&startDate); err != nil {
+		return
+	}
+	settings, err := h.loadRegistrationSettings(eventID)
+	if err != nil || !settings.Enabled || registrationClosed(settings, startDate) {
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, amount_cents FROM event_registrations
+		WHERE event_id = ? AND status = 'waitlisted'
+		ORDER BY id
+	`, eventID)
+	if err != nil {
+		log.Printf("Failed to load waitlist of event #%d: %v", eventID, err)
+		return
+	}
+	type waiting struct {
+		id          int64
+		amountCents int
+	}
+	var waitlist []waiting
+	for rows.Next() {
+		var w waiting
+		if rows.Scan(&w.id, &w.amountCents) == nil {
+			waitlist = append(waitlist, w)
+		}
+	}
+	rows.Close()
+	if len(waitlist) == 0 {
+		return
+	}
+
+	shop, err := h.getShopSettings()
+	if err != nil {
+		return
+	}
+	canPay := h.paymentConfigured(shop)
+	types, err := h.loadTicketTypes(eventID, false, spotUsage{}, shop.Currency)
+	if err != nil {
+		return
+	}
+	typesByID := ticketTypeMap(types)
+
+	for _, reg := range waitlist {
+		if reg.amountCents > 0 && !canPay {
+			continue
+		}
+		kind, err := h.promoteRegistration(reg.id, eventID, reg.amountCents, settings, typesByID, shop.PaymentProvider)
+		if err != nil {
+			log.Printf("Failed to promote registration #%d: %v", reg.id, err)
+			continue
+		}
+		if kind == "" {
+			continue
+		}
+		log.Printf("Registration #%d moved up from the waitlist (%s)", reg.id, kind)
+		h.notifyRegistration(reg.id, kind)
+	}
+}
+
+// promoteRegistration moves one waitlisted registration up when its tickets
+// fit, checking and updating in one transaction. Returns the kind of mail to
+// send, or "" when the registration stays where it is.
+func (h *Handler) promoteRegistration(registrationID, eventID int64, amountCents int, settings EventRegistrationSettings,
+	types map[int64]EventTicketType, providerName string) (string, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Writing first takes the database write lock, as in
+	// applyRegistrationPayment, and tells whether it is still waitlisted
+	result, err := tx.Exec(`
+		UPDATE event_registrations SET updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'waitlisted'
+	`, registrationID)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", nil
+	}
+
+	usage, err := loadSpotUsage(tx, eventID)
+	if err != nil {
+		return "", err
+	}
+	want, err := h.registrationTickets(registrationID)
+	if err != nil {
+		return "", err
+	}
+	if !usage.fits(settings, types, want) {
+		return "", nil
+	}
+
+	now := time.Now().UTC()
+	kind := "confirmed"
+	if amountCents > 0 {
+		kind = "offered"
+		_, err = tx.Exec(`
+			UPDATE event_registrations SET status = 'pending', payment_provider = ?, hold_until = ?
+			WHERE id = ?
+		`, providerName, now.Add(registrationHoldTTL), registrationID)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE event_registrations SET status = 'confirmed', confirmed_at = ? WHERE id = ?
+		`, now, registrationID)
+	}
+	if err != nil {
+		return "", err
+	}
+	return kind, tx.Commit()
+}
+
+// findPaymentRegistration resolves the registration a payment event refers
+// to. Events carrying an order ID belong to the shop. Returns 0 without
+// error when the event is not about a registration.
+func (h *Handler) findPaymentRegistration(event *payment.Event) (int64, error) {
+	if event.Kind == payment.EventIgnored || event.OrderID != 0 {
+		return 0, nil
+	}
+
+	var id int64
+	var err error
+	switch {
+	case event.RegistrationID != 0:
+		err = h.db.QueryRow("SELECT id FROM event_registrations WHERE id = ?", event.RegistrationID).Scan(&id)
+	case event.PaymentID != "":
+		err = h.db.QueryRow(`
+			SELECT id FROM event_registrations WHERE stripe_payment_intent_id = ? OR provider_payment_id = ?
+		`, event.PaymentID, event.PaymentID).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// applyRegistrationPaymentEvent performs the registration transition for a
+// payment event. A failed or expired checkout keeps the hold so the
+// registrant can retry; the hold simply runs out otherwise. Payments are
+// handled by applyRegistrationPayment.
+func (h *Handler) applyRegistrationPaymentEvent(providerName string, registrationID int64, event *payment.Event) error {
+	paymentColumn := paymentIDColumn(providerName)
+
+	var eventID int64
+	var storedPaymentID string
+	if err := h.db.QueryRow(`
+		SELECT event_id, COALESCE(`+paymentColumn+`, '') FROM event_registrations WHERE id = ?
+	`, registrationID).Scan(&eventID, &storedPaymentID); err != nil {
+		return err
+	}
+
+	// Refunding a duplicate payment leaves the registration as it is
+	refund := event.Kind == payment.EventRefunded || event.Kind == payment.EventPartiallyRefunded
+	if refund && event.PaymentID != "" && storedPaymentID != "" && event.PaymentID != storedPaymentID {
+		return nil
+	}
+
+	var err error
+	switch event.Kind {
+	case payment.EventPaid:
+		err = h.applyRegistrationPayment(providerName, registrationID, eventID, event.PaymentID)
+
+	case payment.EventPending:
+		_, err = h.db.Exec(`
+			UPDATE event_registrations SET `+paymentColumn+` = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = 'pending'
+		`, event.PaymentID, registrationID)
+
+	case payment.EventFailed, payment.EventExpired:
+		h.promoteWaitlist(eventID)
+
+	case payment.EventPartiallyRefunded:
+		settings, _ := h.getShopSettings()
+		note := fmt.Sprintf("Partial refund, %s refunded in total", formatPrice(event.AmountRefunded, settings.Currency))
+		_, err = h.db.Exec(`
+			UPDATE event_registrations SET
+				refunded_cents = MAX(refunded_cents, ?),
+				notes = CASE WHEN instr(notes, ?) > 0 THEN notes ELSE TRIM(notes || char(10) || ?, char(10)) END,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, event.AmountRefunded, note, note, registrationID)
+
+	case payment.EventRefunded:
+		var result sql.Result
+		result, err = h.db.Exec(`
+			UPDATE event_registrations SET status = 'refunded', refunded_cents = amount_cents,
+				hold_until = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status != 'refunded'
+		`, registrationID)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n > 0 {
+				h.notifyRegistration(registrationID, "refunded")
+				h.promoteWaitlist(eventID)
+			}
+		}
+
+	case payment.EventDisputed:
+		_, err = h.db.Exec(`
+			UPDATE event_registrations SET notes = TRIM(notes || char(10) || ?, char(10)), updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, fmt.Sprintf("Dispute opened: %s", event.Reason), registrationID)
+	}
+
+	return err
+}
+
+// applyRegistrationPayment confirms a paid registration. A payment that
+// arrives after the hold ran out is still honoured when the event has room
+// for it; when the event is full, or the registrant cancelled in the
+// meantime, the payment is refunded instead.
+func (h *Handler) applyRegistrationPayment(providerName string, registrationID, eventID int64, paymentID string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Writing first takes the database write lock, so no other
+	// registration can take the spots between the check and the update
+	paymentColumn := paymentIDColumn(providerName)
+	if _, err := tx.Exec(`
+		UPDATE event_registrations SET updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, registrationID); err != nil {
+		return err
+	}
+
+	var status, storedPaymentID string
+	var holdUntil sql.NullTime
+	if err := tx.QueryRow(`
+		SELECT status, hold_until, COALESCE(`+paymentColumn+`, '') FROM event_registrations WHERE id = ?
+	`, registrationID).Scan(&status, &holdUntil, &storedPaymentID); err != nil {
+		return err
+	}
+
+	// A second checkout paid as well: the registration keeps the first payment
+	if (status == "confirmed" || status == "refunded") && storedPaymentID != "" && storedPaymentID != paymentID {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return h.refundLatePayment(providerName, registrationID, paymentID, "the registration was already paid", true)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE event_registrations SET `+paymentColumn+` = ? WHERE id = ?
+	`, paymentID, registrationID); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	refundReason := ""
+	switch {
+	case status == "pending" && holdUntil.Valid && holdUntil.Time.After(now):
+		// The hold still keeps the spots
+	case status == "pending" || status == "expired":
+		settings, err := h.loadRegistrationSettings(eventID)
+		if err != nil {
+			return err
+		}
+		usage, err := loadSpotUsage(tx, eventID)
+		if err != nil {
+			return err
+		}
+		types, err := h.loadTicketTypes(eventID, false, usage, "")
+		if err != nil {
+			return err
+		}
+		want, err := h.registrationTickets(registrationID)
+		if err != nil {
+			return err
+		}
+		if !usage.fits(settings, ticketTypeMap(types), want) {
+			refundReason = "the event was full"
+		}
+	case status == "cancelled":
+		refundReason = "the registration was cancelled"
+	default:
+		// Confirmed or refunded with this payment already
+		return tx.Commit()
+	}
+
+	if refundReason != "" {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return h.refundLatePayment(providerName, registrationID, paymentID, refundReason, false)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE event_registrations SET status = 'confirmed', confirmed_at = ?, hold_until = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, now, registrationID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	h.notifyRegistration(registrationID, "confirmed")
+	return nil
+}
+
+// refundLatePayment refunds a payment that can no longer get tickets. A
+// duplicate payment is refunded without touching the registration, which
+// keeps its first payment. When the refund fails the registration is
+// flagged in its notes for the organisers, and the error makes the provider
+// deliver the event again, which retries the refund.
+func (h *Handler) refundLatePayment(providerName string, registrationID int64, paymentID, reason string, duplicate bool) error {
+	settings, err := h.getShopSettings()
+	if err == nil {
+		var provider payment.Provider
+		provider, err = h.paymentProvider(providerName, settings)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			err = provider.Refund(ctx, paymentID, 0, settings.Currency)
+		}
+	}
+	if err != nil {
+		log.Printf("Refund of late payment %s for registration #%d failed: %v", paymentID, registrationID, err)
+		note := fmt.Sprintf("Paid after %s, but the automatic refund failed: refund payment %s manually", reason, paymentID)
+		h.db.Exec(`
+			UPDATE event_registrations SET notes = TRIM(notes || char(10) || ?, char(10)), updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND instr(notes, ?) = 0
+		`, note, registrationID, note)
+		return err
+	}
+
+	if duplicate {
+		note := fmt.Sprintf("Paid after %s, payment %s refunded automatically", reason, paymentID)
+		log.Printf("Duplicate payment %s for registration #%d refunded", paymentID, registrationID)
+		_, err := h.db.Exec(`
+			UPDATE event_registrations SET notes = TRIM(notes || char(10) || ?, char(10)), updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND instr(notes, ?) = 0
+		`, note, registrationID, note)
+		return err
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE event_registrations SET status = 'refunded', refunded_cents = amount_cents, hold_until = NULL,
+			notes = TRIM(notes || char(10) || ?, char(10)), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status != 'refunded'
+	`, fmt.Sprintf("Paid after %s, refunded automatically", reason), registrationID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Late payment %s for registration #%d refunded: %s", paymentID, registrationID, reason)
+		h.notifyRegistration(registrationID, "refunded")
+	}
+	return nil
+}
+
+// loadRegistrationEmail collects what the registration mails show
+func (h *Handler) loadRegistrationEmail(registrationID int64) (email.RegistrationEmail, error) {
+	var m email.RegistrationEmail
+	var token, startDate, currency string
+	var amountCents int
+	err := h.db.QueryRow(`
+		SELECT r.id, r.token, r.lang_code, r.email, r.name, r.amount_cents,
+		       e.title, COALESCE(e.location, ''), e.start_date,
+		       COALESCE((SELECT currency FROM shop_settings WHERE id = 1), 'EUR')
+		FROM event_registrations r
+		JOIN events e ON r.event_id = e.id
+		WHERE r.id = ?
+	`, registrationID).Scan(&m.RegistrationID, &token, &m.LangCode, &m.Email, &m.Name, &amountCents,
+		&m.EventTitle, &m.EventLocation, &startDate, &currency)
+	if err != nil {
+		return m, err
+	}
+
+	m.EventDate = formatEventDate(startDate)
+	m.StatusURL = h.registrationStatusURL(token)
+	if amountCents > 0 {
+		m.AmountDisplay = formatPrice(amountCents, currency)
+	}
+
+	attendees, err := h.loadAttendees(registrationID)
+	if err != nil {
+		return m, err
+	}
+	for _, a := range attendees {
+		m.Tickets = append(m.Tickets, email.TicketEmail{
+			Name:       a.Name,
+			TicketType: a.TicketType,
+			QRURL:      h.ticketQRURL(a.TicketCode),
+		})
+	}
+	return m, nil
+}
+
+// notifyRegistration mails the registrant about a registration change.
+// Kind is confirmed, waitlisted, offered, cancelled or refunded.
+func (h *Handler) notifyRegistration(registrationID int64, kind string) {
+	m, err := h.loadRegistrationEmail(registrationID)
+	if err != nil {
+		log.Printf("Failed to load registration #%d for emails: %v", registrationID, err)
+		return
+	}
+
+	switch kind {
+	case "confirmed":
+		go h.emailService.SendRegistrationConfirmed(m)
+	case "waitlisted":
+		go h.emailService.SendRegistrationWaitlisted(m)
+	case "offered":
+		go h.emailService.SendRegistrationOffered(m)
+	case "cancelled", "refunded":
+		m.Refunded = kind == "refunded"
+		go h.emailService.SendRegistrationCancelled(m)
+	}
+}
+
+// EventAttendee is one person on a registration
+type EventAttendee struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	TicketTypeID *int64     `json:"ticket_type_id,omitempty"`
+	TicketType   string     `json:"ticket_type,omitempty"`
+	PriceCents   int        `json:"price_cents"`
+	TicketCode   string     `json:"ticket_code,omitempty"`
+	QRURL        string     `json:"qr_url,omitempty"`
+	CheckedInAt  *time.Time `json:"checked_in_at,omitempty"`
+	CheckedInBy  string     `json:"checked_in_by,omitempty"`
+}
+
+func (h *Handler) loadAttendees(registrationID int64) ([]EventAttendee, error) {
+	rows, err := h.db.Query(`
+		SELECT a.id, a.name, a.ticket_type_id, COALESCE(t.name, ''), a.price_cents, a.ticket_code,
+		       a.checked_in_at, a.checked_in_by
+		FROM event_attendees a
+		LEFT JOIN event_ticket_types t ON a.ticket_type_id = t.id
+		WHERE a.registration_id = ?
+		ORDER BY a.id
+	`, registrationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attendees := []EventAttendee{}
+	for rows.Next() {
+		var a EventAttendee
+		var typeID sql.NullInt64
+		var checkedInAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Name, &typeID, &a.TicketType, &a.PriceCents, &a.TicketCode,
+			&checkedInAt, &a.CheckedInBy); err != nil {
+			return nil, err
+		}
+		if typeID.Valid {
+			a.TicketTypeID = &typeID.Int64
+		}
+		if checkedInAt.Valid {
+			a.CheckedInAt = &checkedInAt.Time
+		}
+		attendees = append(attendees, a)
+	}
+	return attendees, rows.Err()
+}
+
+// PublicEventRegistration is what a registrant sees of their registration.
+// Ticket codes are only included once the registration is confirmed.
+type PublicEventRegistration struct {
+	Token            string          `json:"token"`
+	Status           string          `json:"status"`
+	EventUUID        string          `json:"event_uuid"`
+	EventTitle       string          `json:"event_title"`
+	EventStartDate   string          `json:"event_start_date"`
+	EventLocation    string          `json:"event_location,omitempty"`
+	Name             string          `json:"name"`
+	AmountCents      int             `json:"amount_cents"`
+	AmountDisplay    string          `json:"amount_display"`
+	HoldUntil        *time.Time      `json:"hold_until,omitempty"`
+	WaitlistPosition int             `json:"waitlist_position,omitempty"`
+	Attendees        []EventAttendee `json:"attendees"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+// GetPublicEventRegistration shows a registrant their registration by its token
+func (h *Handler) GetPublicEventRegistration(w http.ResponseWriter, r *http.Request) {
+	token := strings.ToLower(chi.URLParam(r, "token"))
+	if !orderTokenRegex.MatchString(token) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+
+	var reg PublicEventRegistration
+	var id, eventID int64
+	var holdUntil sql.NullTime
+	var currency string
+	err := h.db.QueryRow(`
+		SELECT r.id, r.token, r.status, e.id, COALESCE(e.uuid, ''), e.title, e.start_date, COALESCE(e.location, ''),
+		       r.name, r.amount_cents, r.hold_until, r.created_at,
+		       COALESCE((SELECT currency FROM shop_settings WHERE id = 1), 'EUR')
+		FROM event_registrations r
+		JOIN events e ON r.event_id = e.id
+		WHERE r.token = ?
+	`, token).Scan(&id, &reg.Token, &reg.Status, &eventID, &reg.EventUUID, &reg.EventTitle, &reg.EventStartDate,
+		&reg.EventLocation, &reg.Name, &reg.AmountCents, &holdUntil, &reg.CreatedAt, &currency)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	reg.AmountDisplay = formatPrice(reg.AmountCents, currency)
+	if reg.Status == "pending" && holdUntil.Valid {
+		reg.HoldUntil = &holdUntil.Time
+	}
+	if reg.Status == "waitlisted" {
+		h.db.QueryRow(`
+			SELECT COUNT(*) FROM event_registrations WHERE event_id = ? AND status = 'waitlisted' AND id <= ?
+		`, eventID, id).Scan(&reg.WaitlistPosition)
+	}
+
+	reg.Attendees, err = h.loadAttendees(id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	for i := range reg.Attendees {
+		reg.Attendees[i].CheckedInBy = ""
+		if reg.Status == "confirmed" {
+			reg.Attendees[i].QRURL = h.ticketQRURL(reg.Attendees[i].TicketCode)
+		} else {
+			reg.Attendees[i].TicketCode = ""
+		}
+	}
+
+	respondJSON(w, http.StatusOK, reg)
+}
+
+// StartEventRegistrationCheckout creates a new checkout for a pending
+// registration, for registrants moved up from the waitlist or retrying a
+// failed payment. It doesn't extend the hold.
+func (h *Handler) StartEventRegistrationCheckout(w http.ResponseWriter, r *http.Request) {
+	token := strings.ToLower(chi.URLParam(r, "token"))
+	if !orderTokenRegex.MatchString(token) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+
+	var id int64
+	var status string
+	var amountCents int
+	var holdUntil sql.NullTime
+	err := h.db.QueryRow(`
+		SELECT id, status, amount_cents, hold_until FROM event_registrations WHERE token = ?
+	`, token).Scan(&id, &status, &amountCents, &holdUntil)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if status != "pending" || amountCents == 0 || !holdUntil.Valid || !time.Now().Before(holdUntil.Time) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "This registration is not awaiting payment"})
+		return
+	}
+
+	shop, err := h.getShopSettings()
+	if err != nil || !h.paymentConfigured(shop) {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Online payment is not available"})
+		return
+	}
+	provider, err := h.paymentProvider(shop.PaymentProvider, shop)
+	if err != nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Online payment is not available"})
+		return
+	}
+
+	h.cancelOpenCheckout(r.Context(), id, shop)
+
+	checkout, err := h.startRegistrationCheckout(r.Context(), provider, shop.Currency, id)
+	if err != nil {
+		msg := "Payment provider checkout creation failed"
+		var providerErr *payment.ProviderError
+		if errors.As(err, &providerErr) {
+			msg = providerErr.Message
+		}
+		respondJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"checkout_url": checkout.URL,
+		"session_id":   checkout.SessionID,
+	})
+}
+
+// CancelOwnEventRegistration lets a registrant give up their registration.
+// Paid tickets that were already issued can only be cancelled (and refunded)
+// by the organisers.
+func (h *Handler) CancelOwnEventRegistration(w http.ResponseWriter, r *http.Request) {
+	token := strings.ToLower(chi.URLParam(r, "token"))
+	if !orderTokenRegex.MatchString(token) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+
+	var id, eventID int64
+	var status string
+	var amountCents int
+	err := h.db.QueryRow(`
+		SELECT id, event_id, status, amount_cents FROM event_registrations WHERE token = ?
+	`, token).Scan(&id, &eventID, &status, &amountCents)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Registration not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	switch {
+	case status == "confirmed" && amountCents > 0:
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Paid registrations can only be cancelled by the organisers, please contact us"})
+		return
+	case status != "confirmed" && status != "pending" && status != "waitlisted":
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Registration cannot be cancelled"})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE event_registrations SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`, id, status)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to cancel registration"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		h.notifyRegistration(id, "cancelled")
+		h.promoteWaitlist(eventID)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Registration cancelled"})
+}
+
+// GetEventTicketQR renders the QR code of a ticket of a confirmed registration
+func (h *Handler) GetEventTicketQR(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToLower(chi.URLParam(r, "code"))
+	if !orderTokenRegex.MatchString(code) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+		return
+	}
+
+	var status string
+	err := h.db.QueryRow(`
+		SELECT r.status FROM event_attendees a
+		JOIN event_registrations r ON a.registration_id = r.id
+		WHERE a.ticket_code = ?
+	`, code).Scan(&status)
+	if err != nil || status != "confirmed" {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+		return
+	}
+
+	png, err := qrcode.Encode(h.ticketURL(code), qrcode.Medium, 384)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate QR code"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(png)
+}
+
+// PublicEventTicket is what the link in a ticket's QR code shows. Valid is
+// false once the registration is no longer confirmed.
+type PublicEventTicket struct {
+	Valid          bool       `json:"valid"`
+	EventUUID      string     `json:"event_uuid"`
+	EventTitle     string     `json:"event_title"`
+	EventStartDate string     `json:"event_start_date"`
+	EventLocation  string     `json:"event_location,omitempty"`
+	Name           string     `json:"name"`
+	TicketType     string     `json:"ticket_type,omitempty"`
+	CheckedInAt    *time.Time `json:"checked_in_at,omitempty"`
+	QRURL          string     `json:"qr_url,omitempty"`
+}
+
+// GetPublicEventTicket shows a ticket by its code and the signature from
+// its QR code
+func (h *Handler) GetPublicEventTicket(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToLower(chi.URLParam(r, "code"))
+	sig := strings.ToLower(r.URL.Query().Get("sig"))
+	if !orderTokenRegex.MatchString(code) || !h.verifyTicketSignature(code, sig) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+		return
+	}
+
+	var ticket PublicEventTicket
+	var status string
+	var checkedInAt sql.NullTime
+	err := h.db.QueryRow(`
+		SELECT r.status, COALESCE(e.uuid, ''), e.title, e.start_date, COALESCE(e.location, ''),
+		       a.name, COALESCE(t.name, ''), a.checked_in_at
+		FROM event_attendees a
+		JOIN event_registrations r ON a.registration_id = r.id
+		JOIN events e ON r.event_id = e.id
+		LEFT JOIN event_ticket_types t ON a.ticket_type_id = t.id
+		WHERE a.ticket_code = ?
+	`, code).Scan(&status, &ticket.EventUUID, &ticket.EventTitle, &ticket.EventStartDate, &ticket.EventLocation,
+		&ticket.Name, &ticket.TicketType, &checkedInAt)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	ticket.Valid = status == "confirmed"
+	if ticket.Valid {
+		ticket.QRURL = h.ticketQRURL(code)
+	}
+	if checkedInAt.Valid {
+		ticket.CheckedInAt = &checkedInAt.Time
+	}
+	respondJSON(w, http.StatusOK, ticket)
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLateRegistrationPayment(t *testing.T) {
+	tests := []struct {
+		name string
+		// status and hold of the paying registration; another confirmed
+		// registration takes one of the capacity spots
+		status    string
+		holdUntil interface{}
+		capacity  int
+		want      string
+		refunded  bool
+	}{
+		{"within the hold", "pending", time.Now().UTC().Add(time.Hour), 2, "confirmed", false},
+		{"after the hold with room left", "expired", nil, 2, "confirmed", false},
+		{"after the hold of a full event", "expired", nil, 1, "refunded", true},
+		{"hold ran out but not yet expired, full", "pending", time.Now().UTC().Add(-time.Hour), 1, "refunded", true},
+		{"after cancelling", "cancelled", nil, 2, "refunded", true},
+		{"already confirmed", "confirmed", nil, 2, "confirmed", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			eventID := exec(t, h, `
+				INSERT INTO events (uuid, title, type, start_date, end_date, state)
+				VALUES ('e1', 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+			`)
+			exec(t, h, "INSERT INTO event_registration_settings (event_id, enabled, capacity) VALUES (?, 1, ?)", eventID, tt.capacity)
+			other := exec(t, h, `
+				INSERT INTO event_registrations (token, event_id, name, email, status, amount_cents, payment_provider)
+				VALUES ('t1', ?, 'Other', 'other@example.com', 'confirmed', 500, 'fake')
+			`, eventID)
+			exec(t, h, "INSERT INTO event_attendees (registration_id, name, price_cents, ticket_code) VALUES (?, 'Other', 500, 'c1')", other)
+			id := exec(t, h, `
+				INSERT INTO event_registrations (token, event_id, name, email, status, amount_cents, payment_provider, hold_until)
+				VALUES ('t2', ?, 'Late', 'late@example.com', ?, 500, 'fake', ?)
+			`, eventID, tt.status, tt.holdUntil)
+			exec(t, h, "INSERT INTO event_attendees (registration_id, name, price_cents, ticket_code) VALUES (?, 'Late', 500, 'c2')", id)
+
+			event := fmt.Sprintf(`{"id":"evt_1","kind":"paid","registration_id":%d,"payment_id":"fake_reg_%d"}`, id, id)
+			if code := deliverWebhook(t, h, event); code != http.StatusOK {
+				t.Fatalf("webhook status = %d, want 200", code)
+			}
+
+			var status string
+			var refundedCents int
+			h.db.QueryRow("SELECT status, refunded_cents FROM event_registrations WHERE id = ?", id).Scan(&status, &refundedCents)
+			if status != tt.want {
+				t.Errorf("status = %q, want %q", status, tt.want)
+			}
+			refunds := h.fakePayments.Refunds
+			if tt.refunded != (len(refunds) == 1) {
+				t.Fatalf("refunds = %+v, want refunded %v", refunds, tt.refunded)
+			}
+			if tt.refunded && (refunds[0].PaymentID != fmt.Sprintf("fake_reg_%d", id) || refundedCents != 500) {
+				t.Errorf("refund = %+v, refunded_cents = %d", refunds[0], refundedCents)
+			}
+		})
+	}
+}
+
+func TestLateRegistrationPaymentRefundFails(t *testing.T) {
+	h := newTestHandler(t)
+	eventID := exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES ('e1', 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+	`)
+	id := exec(t, h, `
+		INSERT INTO event_registrations (token, event_id, name, email, status, amount_cents, payment_provider)
+		VALUES ('t1', ?, 'Late', 'late@example.com', 'cancelled', 500, 'fake')
+	`, eventID)
+	h.fakePayments.Err = fmt.Errorf("provider down")
+
+	// The provider delivers the event again, which retries the refund
+	event := fmt.Sprintf(`{"id":"evt_1","kind":"paid","registration_id":%d,"payment_id":"fake_reg_%d"}`, id, id)
+	for i := 0; i < 2; i++ {
+		if code := deliverWebhook(t, h, event); code != http.StatusInternalServerError {
+			t.Fatalf("webhook status = %d, want 500", code)
+		}
+	}
+
+	var status, notes string
+	h.db.QueryRow("SELECT status, notes FROM event_registrations WHERE id = ?", id).Scan(&status, &notes)
+	want := fmt.Sprintf("Paid after the registration was cancelled, but the automatic refund failed: refund payment fake_reg_%d manually", id)
+	if status != "cancelled" || notes != want {
+		t.Errorf("status, notes = %q, %q; want cancelled, %q", status, notes, want)
+	}
+}
+
+func TestRegisterForEventConcurrentLastSpot(t *testing.T) {
+	h := newTestHandler(t)
+	eventID := exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES ('e1', 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+	`)
+	exec(t, h, "INSERT INTO event_registration_settings (event_id, enabled, capacity, waitlist_enabled) VALUES (?, 1, 1, 0)", eventID)
+
+	const n = 8
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"name":"R%d","email":"r%d@example.com","attendees":[{"name":"R%d"}]}`, i, i, i)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/events/e1/register", strings.NewReader(body))
+			h.RegisterForEvent(w, withURLParams(r, "uuid", "e1"))
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status = %d, want 201 or 409", code)
+		}
+	}
+	var confirmed, registrations int
+	h.db.QueryRow("SELECT COUNT(*) FROM event_registrations WHERE status = 'confirmed'").Scan(&confirmed)
+	h.db.QueryRow("SELECT COUNT(*) FROM event_registrations").Scan(&registrations)
+	if created != 1 || confirmed != 1 || registrations != 1 {
+		t.Errorf("created %d, confirmed %d, stored %d; want one of each", created, confirmed, registrations)
+	}
+}
+
+func TestPromoteWaitlistFillsFreedSpotOnce(t *testing.T) {
+	h := newTestHandler(t)
+	eventID := exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES ('e1', 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+	`)
+	exec(t, h, "INSERT INTO event_registration_settings (event_id, enabled, capacity, waitlist_enabled) VALUES (?, 1, 1, 1)", eventID)
+	for i := 0; i < 3; i++ {
+		id := exec(t, h, `
+			INSERT INTO event_registrations (token, event_id, name, email, status)
+			VALUES (?, ?, 'Waiting', 'w@example.com', 'waitlisted')
+		`, fmt.Sprintf("t%d", i), eventID)
+		exec(t, h, "INSERT INTO event_attendees (registration_id, name, ticket_code) VALUES (?, 'Waiting', ?)", id, fmt.Sprintf("c%d", i))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.promoteWaitlist(eventID)
+		}()
+	}
+	wg.Wait()
+
+	var confirmed int
+	var first string
+	h.db.QueryRow("SELECT COUNT(*) FROM event_registrations WHERE status = 'confirmed'").Scan(&confirmed)
+	h.db.QueryRow("SELECT status FROM event_registrations ORDER BY id LIMIT 1").Scan(&first)
+	if confirmed != 1 || first != "confirmed" {
+		t.Errorf("confirmed %d, oldest %q; want only the oldest confirmed", confirmed, first)
+	}
+}
+
+func TestDuplicateRegistrationPayment(t *testing.T) {
+	h := newTestHandler(t)
+	eventID := exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES ('e1', 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+	`)
+	id := exec(t, h, `
+		INSERT INTO event_registrations (token, event_id, name, email, status, amount_cents, payment_provider, provider_payment_id)
+		VALUES ('t1', ?, 'Paid', 'paid@example.com', 'confirmed', 500, 'fake', 'fake_first')
+	`, eventID)
+
+	for i, event := range []string{
+		// The first payment delivered again changes nothing
+		fmt.Sprintf(`{"id":"evt_1","kind":"paid","registration_id":%d,"payment_id":"fake_first"}`, id),
+		fmt.Sprintf(`{"id":"evt_2","kind":"paid","registration_id":%d,"payment_id":"fake_second"}`, id),
+		// The refund of the second payment leaves the registration alone
+		fmt.Sprintf(`{"id":"evt_3","kind":"refunded","registration_id":%d,"payment_id":"fake_second"}`, id),
+	} {
+		if code := deliverWebhook(t, h, event); code != http.StatusOK {
+			t.Fatalf("webhook %d status = %d, want 200", i+1, code)
+		}
+	}
+
+	refunds := h.fakePayments.Refunds
+	if len(refunds) != 1 || refunds[0].PaymentID != "fake_second" {
+		t.Fatalf("refunds = %+v, want the second payment", refunds)
+	}
+	var status, paymentID, notes string
+	var refundedCents int
+	h.db.QueryRow(`
+		SELECT status, provider_payment_id, refunded_cents, notes FROM event_registrations WHERE id = ?
+	`, id).Scan(&status, &paymentID, &refundedCents, &notes)
+	if status != "confirmed" || paymentID != "fake_first" || refundedCents != 0 {
+		t.Errorf("status %q, payment %q, refunded %d; want confirmed with fake_first", status, paymentID, refundedCents)
+	}
+	if !strings.Contains(notes, "fake_second refunded") {
+		t.Errorf("notes = %q, want the refunded payment mentioned", notes)
+	}
+}
+
+func TestRegistrationCheckoutCancelsPrevious(t *testing.T) {
+	h := newTestHandler(t)
+	exec(t, h, "UPDATE shop_settings SET payment_provider = 'fake'")
+	eventID := exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES ('e1', 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+	`)
+	token := fmt.Sprintf("%032x", 1)
+	id := exec(t, h, `
+		INSERT INTO event_registrations (token, event_id, name, email, status, amount_cents, payment_provider, hold_until)
+		VALUES (?, ?, 'Payer', 'payer@example.com', 'pending', 500, 'fake', ?)
+	`, token, eventID, time.Now().UTC().Add(time.Hour))
+	exec(t, h, "INSERT INTO event_attendees (registration_id, name, price_cents, ticket_code) VALUES (?, 'Payer', 500, 'c1')", id)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/events/registrations/"+token+"/checkout", nil)
+		h.StartEventRegistrationCheckout(w, withURLParams(r, "token", token))
+		if w.Code != http.StatusOK {
+			t.Fatalf("checkout %d: %d %s", i+1, w.Code, w.Body)
+		}
+	}
+
+	cancelled := h.fakePayments.Cancelled
+	if len(h.fakePayments.Checkouts) != 2 || len(cancelled) != 1 || cancelled[0].PaymentID != fmt.Sprintf("fake_reg_%d", id) {
+		t.Errorf("checkouts %d, cancelled %+v; want the first checkout cancelled", len(h.fakePayments.Checkouts), cancelled)
+	}
+}
+
+func TestGetPublicEventTicket(t *testing.T) {
+	h := newTestHandler(t)
+	eventID := exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES ('e1', 'Event', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 12:00:00', 'published')
+	`)
+	id := exec(t, h, `
+		INSERT INTO event_registrations (token, event_id, name, email, status)
+		VALUES ('t1', ?, 'Anna', 'anna@example.com', 'confirmed')
+	`, eventID)
+	code := fmt.Sprintf("%032x", 7)
+	exec(t, h, "INSERT INTO event_attendees (registration_id, name, ticket_code) VALUES (?, 'Anna', ?)", id, code)
+
+	get := func(sig string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/events/tickets/"+code+"?sig="+sig, nil)
+		h.GetPublicEventTicket(w, withURLParams(r, "code", code))
+		return w
+	}
+
+	if w := get(h.ticketSignature(code)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"valid":true`) {
+		t.Errorf("signed: %d %s, want a valid ticket", w.Code, w.Body)
+	}
+	if w := get(strings.Repeat("0", 32)); w.Code != http.StatusNotFound {
+		t.Errorf("wrong signature: %d, want 404", w.Code)
+	}
+
+	exec(t, h, "UPDATE event_registrations SET status = 'cancelled' WHERE id = ?", id)
+	if w := get(h.ticketSignature(code)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"valid":false`) {
+		t.Errorf("cancelled: %d %s, want an invalid ticket", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// EventType is an entry of the managed event type list. Events refer to it
+// by code; gc_type_id is the matching geocaching.com cache type, empty for
+// types that are not published there (club meetings).
+type EventType struct {
+	Code         string                 `json:"code"`
+	Icon         string                 `json:"icon"`
+	GCTypeID     *int64                 `json:"gc_type_id,omitempty"`
+	SortOrder    int                    `json:"sort_order"`
+	Active       bool                   `json:"active"`
+	Builtin      bool                   `json:"builtin"`
+	Translations []EventTypeTranslation `json:"translations"`
+}
+
+type EventTypeTranslation struct {
+	LangCode string `json:"lang_code"`
+	Label    string `json:"label"`
+	// Fallback is set when the label is in a fallback language
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+var eventTypeCodePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{1,29}$`)
+
+// eventTypeActive reports whether code is a known event type and whether it
+// can be picked for events
+func (h *Handler) eventTypeActive(code string) (exists, active bool) {
+	var a int
+	err := h.db.QueryRow("SELECT active FROM event_types WHERE code = ?", code).Scan(&a)
+	if err != nil {
+		return false, false
+	}
+	return true, a == 1
+}
+
+// validateEventType checks the type of a new or updated event. current is
+// the type the event already has; it stays valid after being deactivated.
+func (h *Handler) validateEventType(code, current string) string {
+	exists, active := h.eventTypeActive(code)
+	if !exists {
+		return "Unknown event type: " + code
+	}
+	if !active && code != current {
+		return "Event type is no longer in use: " + code
+	}
+	return ""
+}
+
+func (h *Handler) loadEventTypes(activeOnly bool, langFilter string) ([]EventType, error) {
+	query := "SELECT code, icon, gc_type_id, sort_order, active, builtin FROM event_types"
+	if activeOnly {
+		query += " WHERE active = 1"
+	}
+	rows, err := h.db.Query(query + " ORDER BY sort_order, code")
+	if err != nil {
+		return nil, err
+	}
+	types := []EventType{}
+	index := map[string]int{}
+	for rows.Next() {
+		var t EventType
+		var gcTypeID sql.NullInt64
+		var active, builtin int
+		if err := rows.Scan(&t.Code, &t.Icon, &gcTypeID, &t.SortOrder, &active, &builtin); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if gcTypeID.Valid {
+			t.GCTypeID = &gcTypeID.Int64
+		}
+		t.Active = active == 1
+		t.Builtin = builtin == 1
+		t.Translations = []EventTypeTranslation{}
+		index[t.Code] = len(types)
+		types = append(types, t)
+	}
+	rows.Close()
+
+	rows, err = h.db.Query("SELECT type_code, lang_code, label FROM event_type_translations ORDER BY lang_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		var t EventTypeTranslation
+		if err := rows.Scan(&code, &t.LangCode, &t.Label); err != nil {
+			return nil, err
+		}
+		if i, ok := index[code]; ok {
+			types[i].Translations = append(types[i].Translations, t)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if langFilter == "" {
+		return types, nil
+	}
+
+	// One label per type, from the fallback chain like other content
+	chain := h.translationChain(langFilter)
+	for i, t := range types {
+		langs := make([]string, len(t.Translations))
+		labels := make([]string, len(t.Translations))
+		for j, tr := range t.Translations {
+			langs[j], labels[j] = tr.LangCode, tr.Label
+		}
+		j := pickTranslation(chain, langs, labels)
+		if j < 0 {
+			types[i].Translations = []EventTypeTranslation{}
+			continue
+		}
+		tr := t.Translations[j]
+		tr.Fallback = !strings.EqualFold(tr.LangCode, langFilter)
+		types[i].Translations = []EventTypeTranslation{tr}
+	}
+	return types, nil
+}
+
+// GetEventTypes returns the active event types with their labels
+func (h *Handler) GetEventTypes(w http.ResponseWriter, r *http.Request) {
+	types, err := h.loadEventTypes(true, h.requestLanguage(w, r))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, []EventType{})
+		return
+	}
+	respondJSON(w, http.StatusOK, types)
+}
+
+// GetAdminEventTypes returns all event types with every label
+func (h *Handler) GetAdminEventTypes(w http.ResponseWriter, r *http.Request) {
+	types, err := h.loadEventTypes(false, "")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, []EventType{})
+		return
+	}
+	respondJSON(w, http.StatusOK, types)
+}
+
+func (h *Handler) getEventType(code string) (EventType, bool) {
+	types, err := h.loadEventTypes(false, "")
+	if err != nil {
+		return EventType{}, false
+	}
+	for _, t := range types {
+		if t.Code == code {
+			return t, true
+		}
+	}
+	return EventType{}, false
+}
+
+// saveEventTypeLabels replaces the labels of an event type
+func saveEventTypeLabels(tx *sql.Tx, code string, translations []EventTypeTranslation) string {
+	if _, err := tx.Exec("DELETE FROM event_type_translations WHERE type_code = ?", code); err != nil {
+		return "Failed to save labels"
+	}
+	for _, t := range translations {
+		label := truncateString(strings.TrimSpace(t.Label), 100)
+		if label == "" {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO event_type_translations (type_code, lang_code, label) VALUES (?, ?, ?)
+		`, code, t.LangCode, label)
+		if err != nil {
+			return "Invalid language: " + t.LangCode
+		}
+	}
+	return ""
+}
+
+// CreateEventType adds an event type
+func (h *Handler) CreateEventType(w http.ResponseWriter, r *http.Request) {
+	var req EventType
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	req.Icon = truncateString(strings.TrimSpace(req.Icon), 500)
+	if !eventTypeCodePattern.MatchString(req.Code) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Code must be 2-30 characters: A-Z, 0-9 and _"})
+		return
+	}
+	if req.GCTypeID != nil && *req.GCTypeID <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid geocaching.com type"})
+		return
+	}
+	if exists, _ := h.eventTypeActive(req.Code); exists {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Event type already exists"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO event_types (code, icon, gc_type_id, sort_order, active) VALUES (?, ?, ?, ?, 1)
+	`, req.Code, req.Icon, req.GCTypeID, req.SortOrder)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create event type"})
+		return
+	}
+	if msg := saveEventTypeLabels(tx, req.Code, req.Translations); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create event type"})
+		return
+	}
+
+	created, _ := h.getEventType(req.Code)
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// UpdateEventType changes the icon, order, labels and active flag of an
+// event type. The code itself cannot change since events refer to it.
+func (h *Handler) UpdateEventType(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(chi.URLParam(r, "code"))
+
+	var req struct {
+		Icon         *string                 `json:"icon"`
+		GCTypeID     *int64                  `json:"gc_type_id"`
+		SortOrder    *int                    `json:"sort_order"`
+		Active       *bool                   `json:"active"`
+		Translations *[]EventTypeTranslation `json:"translations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	current, ok := h.getEventType(code)
+	if !ok {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event type not found"})
+		return
+	}
+	if req.Icon != nil {
+		current.Icon = truncateString(strings.TrimSpace(*req.Icon), 500)
+	}
+	if req.GCTypeID != nil {
+		// 0 clears it for types that are not listed on geocaching.com
+		current.GCTypeID = req.GCTypeID
+		if *req.GCTypeID <= 0 {
+			current.GCTypeID = nil
+		}
+	}
+	if req.SortOrder != nil {
+		current.SortOrder = *req.SortOrder
+	}
+	if req.Active != nil {
+		current.Active = *req.Active
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE event_types SET icon = ?, gc_type_id = ?, sort_order = ?, active = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE code = ?
+	`, current.Icon, current.GCTypeID, current.SortOrder, boolToInt(current.Active), code)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update event type"})
+		return
+	}
+	if req.Translations != nil {
+		if msg := saveEventTypeLabels(tx, code, *req.Translations); msg != "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update event type"})
+		return
+	}
+
+	updated, _ := h.getEventType(code)
+	respondJSON(w, http.StatusOK, updated)
+}
+
+// DeleteEventType removes an unused custom event type. Built-in types can
+// only be deactivated: the seed_event_types migration and the label seeding
+// run on every start and would bring a deleted one back.
+func (h *Handler) DeleteEventType(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(chi.URLParam(r, "code"))
+
+	current, ok := h.getEventType(code)
+	if !ok {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event type not found"})
+		return
+	}
+	if current.Builtin {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Built-in event types can only be deactivated"})
+		return
+	}
+
+	var inUse int
+	h.db.QueryRow("SELECT COUNT(*) FROM events WHERE type = ?", code).Scan(&inUse)
+	if inUse > 0 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Event type is used by events; deactivate it instead"})
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM event_types WHERE code = ?", code); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete event type"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Event type deleted"})
+}
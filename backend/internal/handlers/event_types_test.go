@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetEventTypesFallback(t *testing.T) {
+	h := newTestHandler(t)
+	// A custom type labelled in Dutch only, with a blank French label
+	exec(t, h, "INSERT INTO event_types (code, icon, sort_order) VALUES ('PICNIC', '/assets/media/eventtypes/REGULAR.png', 10)")
+	exec(t, h, "INSERT INTO event_type_translations (type_code, lang_code, label) VALUES ('PICNIC', 'NL', 'Picknick'), ('PICNIC', 'FR', ' ')")
+	exec(t, h, "UPDATE event_types SET active = 0 WHERE code = 'GIGA'")
+
+	get := func(query string) map[string][]EventTypeTranslation {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.GetEventTypes(w, httptest.NewRequest("GET", "/event-types"+query, nil))
+		var types []EventType
+		if err := json.NewDecoder(w.Body).Decode(&types); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string][]EventTypeTranslation{}
+		for _, et := range types {
+			labels[et.Code] = et.Translations
+		}
+		return labels
+	}
+
+	fr := get("?lang=fr")
+	if _, ok := fr["GIGA"]; ok {
+		t.Error("an inactive type is listed")
+	}
+	if got := fr["CLUB"]; len(got) != 1 || got[0] != (EventTypeTranslation{LangCode: "FR", Label: "Réunion du club"}) {
+		t.Errorf("CLUB = %+v, want the French label", got)
+	}
+	if got := fr["PICNIC"]; len(got) != 1 || got[0] != (EventTypeTranslation{LangCode: "NL", Label: "Picknick", Fallback: true}) {
+		t.Errorf("PICNIC = %+v, want the Dutch fallback", got)
+	}
+
+	if all := get(""); len(all["CLUB"]) != 4 || len(all["PICNIC"]) != 2 {
+		t.Errorf("without a language: CLUB %+v, PICNIC %+v; want every label", all["CLUB"], all["PICNIC"])
+	}
+}
@@ -6,13 +6,13 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"image"
 	"image/color"
-	"image/png"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/qr"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	qrcode "github.com/skip2/go-qrcode"
@@ -32,25 +32,78 @@ type Event struct {
 	EndDate      string             `json:"end_date"`
 	ImageURL     string             `json:"imageUrl,omitempty"`
 	TicketURL    string             `json:"ticket_purchase_url,omitempty"`
+	GCCode       string             `json:"gc_code,omitempty"` // geocaching.com listing of the event
+	GCURL        string             `json:"gc_url,omitempty"`
 	Translations []EventTranslation `json:"translations,omitempty"`
+	// Registration is set on the public event page when built-in registration is enabled
+	Registration *EventRegistrationInfo `json:"registration,omitempty"`
+	// Pretix is set on the public event page when the event is linked to pretix
+	Pretix *EventPretixInfo `json:"pretix,omitempty"`
+}
+
+var (
+	gcCodePattern = regexp.MustCompile(`^GC[0-9A-Z]{1,7}$`)
+	// gcCodeInLink finds the GC code in a coord.info or geocaching.com listing link
+	gcCodeInLink = regexp.MustCompile(`(?i)(?:coord\.info/|geocaching\.com/geocache/)(GC[0-9A-Z]{1,7})\b`)
+)
+
+func (e *Event) setGCCode(code string) {
+	e.GCCode = code
+	e.GCURL = ""
+	if code != "" {
+		e.GCURL = "https://coord.info/" + code
+	}
+}
+
+// normalizeEventGC validates the GC code of an event, taking it from the
+// geolink when the link points at the geocaching.com listing
+func normalizeEventGC(event *Event) bool {
+	code := strings.ToUpper(strings.TrimSpace(event.GCCode))
+	if code == "" {
+		if m := gcCodeInLink.FindStringSubmatch(event.Geolink); m != nil {
+			code = strings.ToUpper(m[1])
+		}
+	}
+	if code != "" && !gcCodePattern.MatchString(code) {
+		return false
+	}
+	event.setGCCode(code)
+	return true
 }
 
 type EventTranslation struct {
 	LangCode    string `json:"lang_code"`
 	Description string `json:"description"`
+	// Fallback is set when the requested language was missing and this is
+	// the next language of the fallback chain
+	Fallback bool `json:"fallback,omitempty"`
+	// MachineTranslated marks a machine translation draft that an admin
+	// has not confirmed yet; drafts are not shown publicly
+	MachineTranslated bool `json:"machine_translated,omitempty"`
 }
 
 // GetPublicEvents returns all published events
 func (h *Handler) GetPublicEvents(w http.ResponseWriter, r *http.Request) {
-	lang := r.URL.Query().Get("lang")
+	lang := h.requestLanguage(w, r)
 
-	rows, err := h.db.Query(`
+	// ?type=CITO,MEGA limits the list to those event types
+	query := `
 SELECT e.id, COALESCE(e.uuid, ''), e.state, e.on_home, e.title, e.geolink, e.type, e.location, 
-       e.start_date, e.end_date, e.image_url, e.ticket_url
+       e.start_date, e.end_date, e.image_url, e.ticket_url, e.gc_code
 FROM events e
-WHERE e.state = 'published'
-ORDER BY e.start_date DESC
-`)
+WHERE e.state = 'published'`
+	args := []interface{}{}
+	if types := r.URL.Query().Get("type"); types != "" {
+		placeholders := []string{}
+		for _, t := range strings.Split(types, ",") {
+			placeholders = append(placeholders, "?")
+			args = append(args, strings.ToUpper(strings.TrimSpace(t)))
+		}
+		query += " AND e.type IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	query += "\nORDER BY e.start_date DESC"
+
+	rows, err := h.db.Query(query, args...)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, []Event{})
 		return
@@ -60,13 +113,13 @@ ORDER BY e.start_date DESC
 	events := []Event{}
 	for rows.Next() {
 		var event Event
-		var geolink, location, imageURL, ticketURL sql.NullString
+		var geolink, location, imageURL, ticketURL, gcCode sql.NullString
 		var onHome int
 
 		if err := rows.Scan(
 			&event.ID, &event.UUID, &event.State, &onHome, &event.Title,
 			&geolink, &event.Type, &location, &event.StartDate,
-			&event.EndDate, &imageURL, &ticketURL,
+			&event.EndDate, &imageURL, &ticketURL, &gcCode,
 		); err != nil {
 			continue
 		}
@@ -84,9 +137,10 @@ ORDER BY e.start_date DESC
 		if ticketURL.Valid {
 			event.TicketURL = ticketURL.String
 		}
+		event.setGCCode(gcCode.String)
 
 		// Get translations
-		event.Translations = h.getEventTranslations(event.ID, lang)
+		event.Translations = h.getEventTranslations(event.ID, lang, false)
 		events = append(events, event)
 	}
 
@@ -95,11 +149,11 @@ ORDER BY e.start_date DESC
 
 // GetHomeEvents returns events marked for homepage
 func (h *Handler) GetHomeEvents(w http.ResponseWriter, r *http.Request) {
-	lang := r.URL.Query().Get("lang")
+	lang := h.requestLanguage(w, r)
 
 	rows, err := h.db.Query(`
 SELECT e.id, COALESCE(e.uuid, ''), e.state, e.on_home, e.title, e.geolink, e.type, e.location, 
-       e.start_date, e.end_date, e.image_url, e.ticket_url
+       e.start_date, e.end_date, e.image_url, e.ticket_url, e.gc_code
 FROM events e
 WHERE e.state = 'published' AND e.on_home = 1
 ORDER BY e.start_date ASC
@@ -114,13 +168,13 @@ LIMIT 5
 	events := []Event{}
 	for rows.Next() {
 		var event Event
-		var geolink, location, imageURL, ticketURL sql.NullString
+		var geolink, location, imageURL, ticketURL, gcCode sql.NullString
 		var onHome int
 
 		if err := rows.Scan(
 			&event.ID, &event.UUID, &event.State, &onHome, &event.Title,
 			&geolink, &event.Type, &location, &event.StartDate,
-			&event.EndDate, &imageURL, &ticketURL,
+			&event.EndDate, &imageURL, &ticketURL, &gcCode,
 		); err != nil {
 			continue
 		}
@@ -138,8 +192,9 @@ LIMIT 5
 		if ticketURL.Valid {
 			event.TicketURL = ticketURL.String
 		}
+		event.setGCCode(gcCode.String)
 
-		event.Translations = h.getEventTranslations(event.ID, lang)
+		event.Translations = h.getEventTranslations(event.ID, lang, false)
 		events = append(events, event)
 	}
 
@@ -150,7 +205,7 @@ LIMIT 5
 func (h *Handler) GetAdminEvents(w http.ResponseWriter, r *http.Request) {
 	rows, err := h.db.Query(`
 SELECT id, COALESCE(uuid, ''), state, on_home, title, geolink, type, location, 
-       start_date, end_date, image_url, ticket_url
+       start_date, end_date, image_url, ticket_url, gc_code
 FROM events
 ORDER BY created_at DESC
 `)
@@ -163,13 +218,13 @@ ORDER BY created_at DESC
 	events := []Event{}
 	for rows.Next() {
 		var event Event
-		var geolink, location, imageURL, ticketURL sql.NullString
+		var geolink, location, imageURL, ticketURL, gcCode sql.NullString
 		var onHome int
 
 		if err := rows.Scan(
 			&event.ID, &event.UUID, &event.State, &onHome, &event.Title,
 			&geolink, &event.Type, &location, &event.StartDate,
-			&event.EndDate, &imageURL, &ticketURL,
+			&event.EndDate, &imageURL, &ticketURL, &gcCode,
 		); err != nil {
 			continue
 		}
@@ -187,8 +242,9 @@ ORDER BY created_at DESC
 		if ticketURL.Valid {
 			event.TicketURL = ticketURL.String
 		}
+		event.setGCCode(gcCode.String)
 
-		event.Translations = h.getEventTranslations(event.ID, "")
+		event.Translations = h.getEventTranslations(event.ID, "", true)
 		events = append(events, event)
 	}
 
@@ -200,17 +256,17 @@ func (h *Handler) GetEventByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
 	var event Event
-	var geolink, location, imageURL, ticketURL sql.NullString
+	var geolink, location, imageURL, ticketURL, gcCode sql.NullString
 	var onHome int
 
 	err := h.db.QueryRow(`
 SELECT id, COALESCE(uuid, ''), state, on_home, title, geolink, type, location, 
-       start_date, end_date, image_url, ticket_url
+       start_date, end_date, image_url, ticket_url, gc_code
 FROM events WHERE id = ?
 `, id).Scan(
 		&event.ID, &event.UUID, &event.State, &onHome, &event.Title,
 		&geolink, &event.Type, &location, &event.StartDate,
-		&event.EndDate, &imageURL, &ticketURL,
+		&event.EndDate, &imageURL, &ticketURL, &gcCode,
 	)
 
 	if err == sql.ErrNoRows {
@@ -235,15 +291,16 @@ FROM events WHERE id = ?
 	if ticketURL.Valid {
 		event.TicketURL = ticketURL.String
 	}
+	event.setGCCode(gcCode.String)
 
-	event.Translations = h.getEventTranslations(event.ID, "")
+	event.Translations = h.getEventTranslations(event.ID, "", true)
 	respondJSON(w, http.StatusOK, event)
 }
 
 // GetEventByUUID returns a single event by UUID (public)
 func (h *Handler) GetEventByUUID(w http.ResponseWriter, r *http.Request) {
 	eventUUID := chi.URLParam(r, "uuid")
-	lang := r.URL.Query().Get("lang")
+	lang := h.requestLanguage(w, r)
 	preview := r.URL.Query().Get("preview") == "true"
 
 	// Preview mode requires valid JWT authentication
@@ -255,13 +312,13 @@ func (h *Handler) GetEventByUUID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var event Event
-	var geolink, location, imageURL, ticketURL sql.NullString
+	var geolink, location, imageURL, ticketURL, gcCode sql.NullString
 	var onHome int
 
 	// Allow preview of draft events if preview=true query param is set (already auth-checked)
 	query := `
 		SELECT id, COALESCE(uuid, ''), state, on_home, title, geolink, type, location, 
-		       start_date, end_date, image_url, ticket_url
+		       start_date, end_date, image_url, ticket_url, gc_code
 		FROM events WHERE uuid = ?`
 
 	if !preview {
@@ -271,7 +328,7 @@ func (h *Handler) GetEventByUUID(w http.ResponseWriter, r *http.Request) {
 	err := h.db.QueryRow(query, eventUUID).Scan(
 		&event.ID, &event.UUID, &event.State, &onHome, &event.Title,
 		&geolink, &event.Type, &location, &event.StartDate,
-		&event.EndDate, &imageURL, &ticketURL,
+		&event.EndDate, &imageURL, &ticketURL, &gcCode,
 	)
 
 	if err == sql.ErrNoRows {
@@ -296,8 +353,11 @@ func (h *Handler) GetEventByUUID(w http.ResponseWriter, r *http.Request) {
 	if ticketURL.Valid {
 		event.TicketURL = ticketURL.String
 	}
+	event.setGCCode(gcCode.String)
 
-	event.Translations = h.getEventTranslations(event.ID, lang)
+	event.Translations = h.getEventTranslations(event.ID, lang, false)
+	event.Registration, _ = h.eventRegistrationInfo(event.ID, event.StartDate)
+	event.Pretix, _ = h.eventPretixInfo(event.ID)
 	respondJSON(w, http.StatusOK, event)
 }
 
@@ -324,6 +384,19 @@ func (h *Handler) CreateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	event.Type = strings.ToUpper(strings.TrimSpace(event.Type))
+	if event.Type == "" {
+		event.Type = "REGULAR"
+	}
+	if msg := h.validateEventType(event.Type, ""); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	if !normalizeEventGC(&event) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid GC code"})
+		return
+	}
+
 	validEventStates := map[string]bool{"published": true, "draft": true, "archived": true}
 	if !validEventStates[event.State] {
 		event.State = "draft"
@@ -338,10 +411,10 @@ func (h *Handler) CreateEvent(w http.ResponseWriter, r *http.Request) {
 	event.UUID = uuid.New().String()
 
 	result, err := h.db.Exec(`
-INSERT INTO events (uuid, state, on_home, title, geolink, type, location, start_date, end_date, image_url, ticket_url)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+INSERT INTO events (uuid, state, on_home, title, geolink, type, location, start_date, end_date, image_url, ticket_url, gc_code)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `, event.UUID, event.State, onHome, event.Title, event.Geolink, event.Type, event.Location,
-		event.StartDate, event.EndDate, event.ImageURL, event.TicketURL)
+		event.StartDate, event.EndDate, event.ImageURL, event.TicketURL, nullableString(event.GCCode))
 
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create event"})
@@ -353,10 +426,11 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	// Insert translations
 	for _, t := range event.Translations {
 		h.db.Exec(`
-INSERT INTO event_translations (event_id, lang_code, description)
-VALUES (?, ?, ?)
-`, event.ID, t.LangCode, t.Description)
+INSERT INTO event_translations (event_id, lang_code, description, machine_translated)
+VALUES (?, ?, ?, ?)
+`, event.ID, t.LangCode, t.Description, t.MachineTranslated)
 	}
+	h.recordRevision(r, "event", strconv.FormatInt(event.ID, 10), nil)
 
 	respondJSON(w, http.StatusCreated, event)
 }
@@ -382,6 +456,21 @@ func (h *Handler) UpdateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	event.Type = strings.ToUpper(strings.TrimSpace(event.Type))
+	if event.Type == "" {
+		event.Type = "REGULAR"
+	}
+	var currentType string
+	h.db.QueryRow("SELECT type FROM events WHERE id = ?", id).Scan(&currentType)
+	if msg := h.validateEventType(event.Type, currentType); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	if !normalizeEventGC(&event) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid GC code"})
+		return
+	}
+
 	validEventStates := map[string]bool{"published": true, "draft": true, "archived": true}
 	if !validEventStates[event.State] {
 		event.State = "draft"
@@ -392,6 +481,8 @@ func (h *Handler) UpdateEvent(w http.ResponseWriter, r *http.Request) {
 		onHome = 1
 	}
 
+	base := h.takeBaseline("event", id)
+
 	// Generate UUID if not exists
 	var existingUUID sql.NullString
 	h.db.QueryRow("SELECT uuid FROM events WHERE id = ?", id).Scan(&existingUUID)
@@ -408,10 +499,10 @@ func (h *Handler) UpdateEvent(w http.ResponseWriter, r *http.Request) {
 	_, err := h.db.Exec(`
 UPDATE events SET 
 state = ?, on_home = ?, title = ?, geolink = ?, type = ?, location = ?,
-start_date = ?, end_date = ?, image_url = ?, ticket_url = ?, updated_at = CURRENT_TIMESTAMP
+start_date = ?, end_date = ?, image_url = ?, ticket_url = ?, gc_code = ?, updated_at = CURRENT_TIMESTAMP
 WHERE id = ?
 `, event.State, onHome, event.Title, event.Geolink, event.Type, event.Location,
-		event.StartDate, event.EndDate, event.ImageURL, event.TicketURL, id)
+		event.StartDate, event.EndDate, event.ImageURL, event.TicketURL, nullableString(event.GCCode), id)
 
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update event"})
@@ -421,10 +512,11 @@ WHERE id = ?
 	// Update translations
 	for _, t := range event.Translations {
 		h.db.Exec(`
-INSERT OR REPLACE INTO event_translations (event_id, lang_code, description)
-VALUES (?, ?, ?)
-`, id, t.LangCode, t.Description)
+INSERT OR REPLACE INTO event_translations (event_id, lang_code, description, machine_translated)
+VALUES (?, ?, ?, ?)
+`, id, t.LangCode, t.Description, t.MachineTranslated)
 	}
+	h.recordRevision(r, "event", id, base)
 
 	idInt, _ := strconv.ParseInt(id, 10, 64)
 	event.ID = idInt
@@ -435,32 +527,27 @@ VALUES (?, ?, ?)
 func (h *Handler) DeleteEvent(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
+	// The history outlives the event, so it can be restored
+	base := h.takeBaseline("event", id)
 	_, err := h.db.Exec("DELETE FROM events WHERE id = ?", id)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete event"})
 		return
 	}
+	h.recordBaseline("event", id, base)
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Event deleted"})
 }
 
 // Helper to get event translations
-func (h *Handler) getEventTranslations(eventID int64, langFilter string) []EventTranslation {
-	var rows *sql.Rows
-	var err error
-
-	if langFilter != "" {
-		rows, err = h.db.Query(
-			"SELECT lang_code, description FROM event_translations WHERE event_id = ? AND lang_code = ?",
-			eventID, langFilter,
-		)
-	} else {
-		rows, err = h.db.Query(
-			"SELECT lang_code, description FROM event_translations WHERE event_id = ?",
-			eventID,
-		)
-	}
-
+// getEventTranslations returns all translations of an event, or with a
+// langFilter the one in that language or else its first fallback. Machine
+// translation drafts are only included with drafts set.
+func (h *Handler) getEventTranslations(eventID int64, langFilter string, drafts bool) []EventTranslation {
+	rows, err := h.db.Query(
+		"SELECT lang_code, COALESCE(description, ''), machine_translated FROM event_translations WHERE event_id = ? ORDER BY lang_code",
+		eventID,
+	)
 	if err != nil {
 		return []EventTranslation{}
 	}
@@ -469,13 +556,27 @@ func (h *Handler) getEventTranslations(eventID int64, langFilter string) []Event
 	translations := []EventTranslation{}
 	for rows.Next() {
 		var t EventTranslation
-		if err := rows.Scan(&t.LangCode, &t.Description); err != nil {
+		if err := rows.Scan(&t.LangCode, &t.Description, &t.MachineTranslated); err != nil || (t.MachineTranslated && !drafts) {
 			continue
 		}
 		translations = append(translations, t)
 	}
+	if langFilter == "" {
+		return translations
+	}
 
-	return translations
+	langs := make([]string, len(translations))
+	texts := make([]string, len(translations))
+	for i, t := range translations {
+		langs[i], texts[i] = t.LangCode, t.Description
+	}
+	i := pickTranslation(h.translationChain(langFilter), langs, texts)
+	if i < 0 {
+		return []EventTranslation{}
+	}
+	t := translations[i]
+	t.Fallback = !strings.EqualFold(t.LangCode, langFilter)
+	return []EventTranslation{t}
 }
 
 // GetEventQRCodes generates and returns a ZIP with QR codes for an event
@@ -502,55 +603,37 @@ func (h *Handler) GetEventQRCodes(w http.ResponseWriter, r *http.Request) {
 	zipWriter := zip.NewWriter(buf)
 
 	// Generate 4 QR code variants
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
 	variants := []struct {
-		name   string
-		fg     color.Color
-		bg     color.Color
-		transp bool
+		name string
+		fg   color.NRGBA
+		bg   color.NRGBA
 	}{
-		{"qr-black-on-white.png", color.Black, color.White, false},
-		{"qr-white-on-black.png", color.White, color.Black, false},
-		{"qr-black-transparent.png", color.Black, color.Transparent, true},
-		{"qr-white-transparent.png", color.White, color.Transparent, true},
+		{"qr-black-on-white.png", black, white},
+		{"qr-white-on-black.png", white, black},
+		{"qr-black-transparent.png", black, color.NRGBA{}},
+		{"qr-white-transparent.png", white, color.NRGBA{}},
 	}
 
 	for _, v := range variants {
-		qr, err := qrcode.New(eventURL, qrcode.Medium)
+		data, err := qr.PNG(qr.Options{
+			Content:    eventURL,
+			Size:       512,
+			Level:      qrcode.Medium,
+			Foreground: v.fg,
+			Background: v.bg,
+		})
 		if err != nil {
 			continue
 		}
-		qr.DisableBorder = true
-
-		// Generate QR code image with custom colors
-		qrImg := qr.Image(512)
-
-		// Create new image with custom colors
-		bounds := qrImg.Bounds()
-		newImg := image.NewRGBA(bounds)
-
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				pixel := qrImg.At(x, y)
-				r, g, b, _ := pixel.RGBA()
-
-				// Check if pixel is dark (QR code module)
-				isDark := (r+g+b)/3 < 32768
-
-				if isDark {
-					newImg.Set(x, y, v.fg)
-				} else {
-					newImg.Set(x, y, v.bg)
-				}
-			}
-		}
 
 		// Add to ZIP
 		fileWriter, err := zipWriter.Create(v.name)
 		if err != nil {
 			continue
 		}
-
-		png.Encode(fileWriter, newImg)
+		fileWriter.Write(data)
 	}
 
 	zipWriter.Close()
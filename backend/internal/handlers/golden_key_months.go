@@ -251,6 +251,8 @@ func (h *Handler) UpdateGoldenKeyMonth(w http.ResponseWriter, r *http.Request) {
 		foundDateVal = fd.UTC().Format("2006-01-02 15:04:05")
 	}
 
+	monthID := strconv.FormatInt(id, 10)
+	base := h.takeBaseline("golden_key_month", monthID)
 	_, err = h.db.Exec(`
 		UPDATE golden_key_months
 		SET live_date = ?, is_found = ?, finder_name = ?, finder_image = ?, found_date = ?, updated_at = CURRENT_TIMESTAMP
@@ -260,6 +262,7 @@ func (h *Handler) UpdateGoldenKeyMonth(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update month"})
 		return
 	}
+	h.recordRevision(r, "golden_key_month", monthID, base)
 
 	// Return the updated month with hints
 	h.GetAdminGoldenKeyMonthByID(w, r)
@@ -286,6 +289,7 @@ func (h *Handler) AddGoldenKeyHint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	base := h.takeBaseline("golden_key_month", strconv.FormatInt(monthID, 10))
 	var maxOrder int
 	h.db.QueryRow(`SELECT COALESCE(MAX(sort_order), -1) FROM golden_key_hints WHERE month_id = ?`, monthID).Scan(&maxOrder)
 
@@ -299,6 +303,7 @@ func (h *Handler) AddGoldenKeyHint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	hintID, _ := result.LastInsertId()
+	h.recordRevision(r, "golden_key_month", strconv.FormatInt(monthID, 10), base)
 	respondJSON(w, http.StatusCreated, GoldenKeyHint{
 		ID:        hintID,
 		MonthID:   monthID,
@@ -322,6 +327,9 @@ func (h *Handler) UpdateGoldenKeyHint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Hints are part of the revisions of their month
+	monthID := h.hintMonth(hintID)
+	base := h.takeBaseline("golden_key_month", monthID)
 	_, err = h.db.Exec(`
 		UPDATE golden_key_hints SET content = ?, image_url = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
 	`, req.Content, nullableString(req.ImageURL), hintID)
@@ -329,6 +337,7 @@ func (h *Handler) UpdateGoldenKeyHint(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update hint"})
 		return
 	}
+	h.recordRevision(r, "golden_key_month", monthID, base)
 
 	var hint GoldenKeyHint
 	var imgURL sql.NullString
@@ -348,15 +357,27 @@ func (h *Handler) DeleteGoldenKeyHint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	monthID := h.hintMonth(hintID)
+	base := h.takeBaseline("golden_key_month", monthID)
 	if _, err := h.db.Exec(`DELETE FROM golden_key_hints WHERE id = ?`, hintID); err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete hint"})
 		return
 	}
+	h.recordRevision(r, "golden_key_month", monthID, base)
 	respondJSON(w, http.StatusOK, map[string]string{"message": "deleted"})
 }
 
 // --- helpers ---
 
+// hintMonth returns the ID of the month a hint belongs to, "" when unknown
+func (h *Handler) hintMonth(hintID int64) string {
+	var monthID int64
+	if err := h.db.QueryRow(`SELECT month_id FROM golden_key_hints WHERE id = ?`, hintID).Scan(&monthID); err != nil {
+		return ""
+	}
+	return strconv.FormatInt(monthID, 10)
+}
+
 func fetchHints(h *Handler, monthID int64) ([]GoldenKeyHint, error) {
 	rows, err := h.db.Query(`
 		SELECT id, month_id, sort_order, content, image_url
@@ -2,16 +2,27 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"image"
 	"io"
+	"log"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/email"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/imaging"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/media"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/payment"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/translate"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
@@ -30,14 +41,28 @@ type Handler struct {
 	db           *database.DB
 	cfg          *config.Config
 	emailService *email.Service
+	store        storage.Storage
+	fakePayments *payment.Fake
+	// translator proposes machine translations; nil when not configured
+	translator translate.Translator
+	// pretixSyncs holds when a background pretix sync last started, per event
+	pretixSyncs sync.Map
+	// backupJob tracks the backup started from the admin panel
+	backupJob   backupJob
+	qrLogoOnce  sync.Once
+	qrLogoImage image.Image
 }
 
 // New creates a new Handler with all dependencies
-func New(db *database.DB, cfg *config.Config, emailService *email.Service) *Handler {
+func New(db *database.DB, cfg *config.Config, emailService *email.Service, store storage.Storage) *Handler {
 	return &Handler{
 		db:           db,
 		cfg:          cfg,
 		emailService: emailService,
+		store:        store,
+		fakePayments: payment.NewFake(),
+		// Config.Validate has rejected unknown translators
+		translator: func() translate.Translator { t, _ := translate.New(cfg.Translator); return t }(),
 	}
 }
 
@@ -53,50 +78,91 @@ func (h *Handler) UploadImage(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	filename, uploadErr := h.saveImage(r.Context(), file, header.Filename, uploaderID(r))
+	if uploadErr != nil {
+		respondJSON(w, uploadErr.status, map[string]string{"error": uploadErr.message})
+		return
+	}
+
+	// widths lists the ?w= sizes that exist, for srcset
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"filename": filename,
+		"widths":   h.imageWidths(r.Context(), filename),
+	})
+}
+
+// uploadError is a rejected upload; the message is safe to show
+type uploadError struct {
+	status  int
+	message string
+}
+
+// saveImage validates an uploaded image by extension and magic bytes and
+// stores it under a new random name in the images directory, without
+// metadata and together with the variants imaging.Process renders for it.
+// The image is added to the media library.
+func (h *Handler) saveImage(ctx context.Context, file io.Reader, originalName string, uploadedBy *int64) (string, *uploadError) {
 	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(header.Filename))
+	ext := strings.ToLower(filepath.Ext(originalName))
 	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".webp" && ext != ".gif" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid file type. Allowed: jpg, jpeg, png, webp, gif"})
-		return
+		return "", &uploadError{http.StatusBadRequest, "Invalid file type. Allowed: jpg, jpeg, png, webp, gif"}
 	}
 
 	// Read file content for magic byte validation
 	fileContent, err := io.ReadAll(file)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read file"})
-		return
+		return "", &uploadError{http.StatusInternalServerError, "Failed to read file"}
 	}
 
 	// Validate magic bytes
 	if !validateImageMagicBytes(fileContent, ext) {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "File content does not match declared type"})
-		return
+		return "", &uploadError{http.StatusBadRequest, "File content does not match declared type"}
+	}
+
+	processed, err := imaging.Process(fileContent, ext)
+	if err == imaging.ErrTooLarge {
+		return "", &uploadError{http.StatusBadRequest, "Image dimensions are too large"}
+	}
+	if err == imaging.ErrInvalid {
+		return "", &uploadError{http.StatusBadRequest, "Invalid or corrupt image"}
+	}
+	if err != nil {
+		return "", &uploadError{http.StatusInternalServerError, "Failed to process image"}
 	}
 
 	// Generate unique filename
 	filename := fmt.Sprintf("%s%s", uuid.New().String(), ext)
 
-	// Create images directory if it doesn't exist
-	imagesDir := filepath.Join(h.cfg.DataDir, "images")
-	if err := os.MkdirAll(imagesDir, 0755); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create images directory"})
-		return
+	// Variants first, so the image is never served without them
+	for _, v := range processed.Variants {
+		name := imaging.VariantName(filename, v.Width, v.Ext)
+		if err := h.store.Put(ctx, media.ImageKey(name), v.Data, media.ContentTypes[v.Ext]); err != nil {
+			h.removeImage(ctx, filename)
+			return "", &uploadError{http.StatusInternalServerError, "Failed to save image"}
+		}
+	}
+	if err := h.store.Put(ctx, media.ImageKey(filename), processed.Original, media.ContentTypes[ext]); err != nil {
+		h.removeImage(ctx, filename)
+		return "", &uploadError{http.StatusInternalServerError, "Failed to save image"}
 	}
 
-	// Save file
-	dst, err := os.Create(filepath.Join(imagesDir, filename))
+	err = media.Register(h.db, filename, truncateString(filepath.Base(originalName), 200),
+		int64(len(processed.Original)), processed.Width, processed.Height, uploadedBy)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save image"})
-		return
+		h.removeImage(ctx, filename)
+		return "", &uploadError{http.StatusInternalServerError, "Failed to save image"}
 	}
-	defer dst.Close()
 
-	if _, err := dst.Write(fileContent); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save image"})
-		return
-	}
+	return filename, nil
+}
 
-	respondJSON(w, http.StatusOK, map[string]string{"filename": filename})
+// uploaderID is the signed-in user of an upload request, nil when unknown
+func uploaderID(r *http.Request) *int64 {
+	user, ok := getUserFromContext(r)
+	if !ok {
+		return nil
+	}
+	return &user.UserID
 }
 
 // validateImageMagicBytes checks if file content matches the expected image type
@@ -129,7 +195,9 @@ func validateImageMagicBytes(content []byte, ext string) bool {
 	return true
 }
 
-// ServeImage serves uploaded images
+// ServeImage serves uploaded images. ?w= picks the smallest resized
+// variant at least that wide (the original when there is none), and a WebP
+// variant is served instead when the Accept header allows it.
 func (h *Handler) ServeImage(w http.ResponseWriter, r *http.Request) {
 	filename := strings.TrimPrefix(r.URL.Path, "/api/images/")
 	if filename == "" {
@@ -139,18 +207,146 @@ func (h *Handler) ServeImage(w http.ResponseWriter, r *http.Request) {
 
 	// Sanitize filename to prevent directory traversal
 	filename = filepath.Base(filename)
-	imagePath := filepath.Join(h.cfg.DataDir, "images", filename)
 
 	// Check if file exists
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+	names := h.imageFiles(r.Context(), filename)
+	if !names[filename] {
 		http.NotFound(w, r)
 		return
 	}
 
+	width := 0
+	if wParam := r.URL.Query().Get("w"); wParam != "" {
+		requested, err := strconv.Atoi(wParam)
+		if err != nil || requested < 1 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid width"})
+			return
+		}
+		for _, candidate := range widthsIn(filename, names) {
+			if candidate >= requested {
+				width = candidate
+				break
+			}
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	candidates := []string{}
+	if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+		candidates = append(candidates, imaging.VariantName(filename, width, ".webp"))
+	}
+	if width > 0 {
+		// WebP originals have JPEG or PNG variants
+		candidates = append(candidates,
+			imaging.VariantName(filename, width, ext),
+			imaging.VariantName(filename, width, ".jpg"),
+			imaging.VariantName(filename, width, ".png"))
+	}
+	served := filename
+	for _, name := range candidates {
+		if names[name] {
+			served = name
+			break
+		}
+	}
+
 	// Set cache headers
 	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	w.Header().Set("Vary", "Accept")
 
-	http.ServeFile(w, r, imagePath)
+	h.serveStored(w, r, media.ImageKey(served), media.ContentTypes[filepath.Ext(served)], "")
+}
+
+// serveStored sends a stored file, or redirects to a signed URL of the
+// storage backend when STORAGE_REDIRECT is on. A non-empty downloadName
+// is only used for the redirect; direct responses set their own headers.
+func (h *Handler) serveStored(w http.ResponseWriter, r *http.Request, key, contentType, downloadName string) {
+	if h.cfg.Storage.Redirect {
+		expiry := time.Duration(h.cfg.Storage.URLExpiryMinutes) * time.Minute
+		signedURL, err := h.store.SignedURL(r.Context(), key, expiry, downloadName)
+		if err == nil {
+			// Browsers may reuse the redirect while the URL is still valid
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(expiry.Seconds())/2))
+			http.Redirect(w, r, signedURL, http.StatusFound)
+			return
+		}
+	}
+
+	body, obj, err := h.store.Get(r.Context(), key)
+	if err == storage.ErrNotFound {
+		w.Header().Del("Cache-Control")
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		w.Header().Del("Cache-Control")
+		http.Error(w, "Failed to read file", http.StatusBadGateway)
+		return
+	}
+	defer body.Close()
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if rs, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), obj.ModTime, rs)
+		return
+	}
+	if !obj.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", obj.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if obj.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, body)
+}
+
+// imageFiles returns the stored names of an upload and its variants. An
+// empty filename lists every stored image.
+func (h *Handler) imageFiles(ctx context.Context, filename string) map[string]bool {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	names := map[string]bool{}
+	objects, err := h.store.List(ctx, media.ImageKey(base))
+	if err != nil {
+		return names
+	}
+	for _, o := range objects {
+		names[path.Base(o.Key)] = true
+	}
+	return names
+}
+
+// widthsIn returns the widths filename has variants for among names
+func widthsIn(filename string, names map[string]bool) []int {
+	ext := strings.ToLower(filepath.Ext(filename))
+	widths := []int{}
+	for _, width := range imaging.Widths {
+		for _, variantExt := range []string{ext, ".jpg", ".png"} {
+			if names[imaging.VariantName(filename, width, variantExt)] {
+				widths = append(widths, width)
+				break
+			}
+		}
+	}
+	return widths
+}
+
+// imageWidths returns the widths an uploaded image has variants for
+func (h *Handler) imageWidths(ctx context.Context, filename string) []int {
+	return widthsIn(filename, h.imageFiles(ctx, filename))
+}
+
+// removeImage deletes an uploaded image, its variants and its media
+// library entry
+func (h *Handler) removeImage(ctx context.Context, filename string) {
+	filename = filepath.Base(filename)
+	h.db.Exec("DELETE FROM media WHERE filename = ?", filename)
+	if err := media.RemoveFiles(ctx, h.store, filename); err != nil {
+		log.Printf("Failed to remove image %s: %v", filename, err)
+	}
 }
 
 // isAuthenticated checks if request has a valid JWT token (for optional auth on public endpoints)
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/email"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMain(m *testing.M) {
+	// Migrations and skipped mails log a lot
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestHandler returns a Handler for development on a new, migrated and
+// seeded database in a temporary directory, with local storage, without
+// mail and with the fake translator and payment provider
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Env:                 "development",
+		DatabasePath:        filepath.Join(dir, "test.db"),
+		DataDir:             dir,
+		FrontendURL:         "http://site.test",
+		APIURL:              "http://site.test/api",
+		TranslationFallback: []string{"NL", "EN"},
+		Translator:          config.TranslatorConfig{Provider: "fake", SourceLang: "NL"},
+	}
+
+	db, err := database.New(cfg.DatabasePath)
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if err := db.SeedDefaults(); err != nil {
+		t.Fatalf("SeedDefaults: %v", err)
+	}
+
+	store, err := storage.New(cfg.Storage, dir)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	return New(db, cfg, email.New(cfg.SMTP), store)
+}
+
+// exec runs a statement of a test's setup and returns the inserted row ID
+func exec(t *testing.T, h *Handler, query string, args ...interface{}) int64 {
+	t.Helper()
+	result, err := h.db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	id, _ := result.LastInsertId()
+	return id
+}
+
+// withURLParams sets the chi route parameters of r, given as name, value
+// pairs, as the router would
+func withURLParams(r *http.Request, params ...string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for i := 0; i+1 < len(params); i += 2 {
+		rctx.URLParams.Add(params[i], params[i+1])
+	}
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/translate"
+	"github.com/go-chi/chi/v5"
+)
+
+// translatable is translated content that machine translation can fill in:
+// rows of table, one per language, keyed by the key column
+type translatable struct {
+	table  string
+	key    string
+	fields []string
+	// touch also sets updated_at, which the public ETag is based on
+	touch bool
+}
+
+// The kinds are also the entity names of their revisions
+var translatables = map[string]translatable{
+	"event":          {table: "event_translations", key: "event_id", fields: []string{"description"}},
+	"message":        {table: "message_translations", key: "message_id", fields: []string{"title", "content"}},
+	"shop_item":      {table: "shop_item_translations", key: "item_id", fields: []string{"description"}},
+	"static_content": {table: "static_content", key: "property", fields: []string{"content"}, touch: true},
+}
+
+// MachineTranslation is a stored draft, field name to translated text
+type MachineTranslation struct {
+	LangCode string            `json:"lang_code"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// storedTranslation is one language row of a translatable
+type storedTranslation struct {
+	texts             []string
+	machineTranslated bool
+}
+
+func (s storedTranslation) blank() bool {
+	for _, text := range s.texts {
+		if !blankTranslation(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// SuggestTranslations fills in the missing languages of an event, message,
+// shop item or static content property with machine translations. They
+// are stored as drafts, hidden from visitors until an admin confirms or
+// edits them. Human translations are never replaced; earlier drafts are.
+func (h *Handler) SuggestTranslations(w http.ResponseWriter, r *http.Request) {
+	t, ok := translatables[chi.URLParam(r, "kind")]
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown content kind"})
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if h.translator == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Machine translation is not configured"})
+		return
+	}
+
+	var req struct {
+		// Source defaults to TRANSLATION_SOURCE, Languages to every other
+		// active language
+		Source    string   `json:"source"`
+		Languages []string `json:"languages"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+	}
+	source := strings.ToUpper(strings.TrimSpace(req.Source))
+	if source == "" {
+		source = h.cfg.Translator.SourceLang
+	}
+
+	stored, err := h.loadTranslations(t, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if len(stored) == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Content not found"})
+		return
+	}
+	original, ok := stored[source]
+	if !ok || original.machineTranslated || original.blank() {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("There is no %s text to translate from", source)})
+		return
+	}
+
+	active := map[string]bool{}
+	rows, err := h.db.Query("SELECT code FROM languages WHERE active = 1 ORDER BY code")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	targets := []string{}
+	for rows.Next() {
+		var code string
+		if rows.Scan(&code) == nil {
+			active[code] = true
+			if len(req.Languages) == 0 {
+				targets = append(targets, code)
+			}
+		}
+	}
+	rows.Close()
+	for _, code := range req.Languages {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !active[code] {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s is not an active language", code)})
+			return
+		}
+		targets = append(targets, code)
+	}
+
+	kind := chi.URLParam(r, "kind")
+	base := h.takeBaseline(kind, id)
+	drafts := []MachineTranslation{}
+	for _, target := range targets {
+		if existing, ok := stored[target]; target == source || (ok && !existing.machineTranslated && !existing.blank()) {
+			continue
+		}
+		texts, err := translate.Texts(r.Context(), h.translator, original.texts, source, target)
+		if err != nil {
+			log.Printf("Machine translation of %s %s to %s failed: %v", t.table, id, target, err)
+			msg := "Could not reach the translation service"
+			var apiErr *translate.APIError
+			if errors.As(err, &apiErr) {
+				msg = apiErr.Message
+			}
+			respondJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+			return
+		}
+		if err := h.saveDraft(t, id, target, texts); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save translation"})
+			return
+		}
+		draft := MachineTranslation{LangCode: target, Fields: map[string]string{}}
+		for i, field := range t.fields {
+			draft.Fields[field] = texts[i]
+		}
+		drafts = append(drafts, draft)
+	}
+	if len(drafts) > 0 {
+		h.recordRevision(r, kind, id, base)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"translator": h.translator.Name(),
+		"source":     source,
+		"drafts":     drafts,
+	})
+}
+
+// ConfirmTranslation marks a machine translation draft as reviewed, which
+// publishes it. Saving the translation through the regular forms without
+// the machine_translated flag confirms it as well.
+func (h *Handler) ConfirmTranslation(w http.ResponseWriter, r *http.Request) {
+	t, ok := translatables[chi.URLParam(r, "kind")]
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown content kind"})
+		return
+	}
+	kind, id := chi.URLParam(r, "kind"), chi.URLParam(r, "id")
+	base := h.takeBaseline(kind, id)
+	set := "machine_translated = 0"
+	if t.touch {
+		set += ", updated_at = CURRENT_TIMESTAMP"
+	}
+	result, err := h.db.Exec(
+		fmt.Sprintf("UPDATE %s SET %s WHERE %s = ? AND lang_code = ? AND machine_translated = 1", t.table, set, t.key),
+		id, strings.ToUpper(chi.URLParam(r, "lang")),
+	)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to confirm translation"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "No machine translation to confirm"})
+		return
+	}
+	h.recordRevision(r, kind, id, base)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Translation confirmed"})
+}
+
+// loadTranslations returns the stored rows of a translatable by language
+func (h *Handler) loadTranslations(t translatable, id string) (map[string]storedTranslation, error) {
+	columns := make([]string, len(t.fields))
+	for i, field := range t.fields {
+		columns[i] = "COALESCE(" + field + ", '')"
+	}
+	rows, err := h.db.Query(
+		fmt.Sprintf("SELECT lang_code, machine_translated, %s FROM %s WHERE %s = ?", strings.Join(columns, ", "), t.table, t.key),
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := map[string]storedTranslation{}
+	for rows.Next() {
+		var lang string
+		s := storedTranslation{texts: make([]string, len(t.fields))}
+		dest := []interface{}{&lang, &s.machineTranslated}
+		for i := range s.texts {
+			dest = append(dest, &s.texts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		stored[strings.ToUpper(lang)] = s
+	}
+	return stored, rows.Err()
+}
+
+// saveDraft stores a machine translation, keeping the row when it exists
+func (h *Handler) saveDraft(t translatable, id, lang string, texts []string) error {
+	updates := make([]string, len(t.fields))
+	for i, field := range t.fields {
+		updates[i] = field + " = excluded." + field
+	}
+	updates = append(updates, "machine_translated = 1")
+	if t.touch {
+		updates = append(updates, "updated_at = CURRENT_TIMESTAMP")
+	}
+	args := []interface{}{id, lang}
+	for _, text := range texts {
+		args = append(args, text)
+	}
+	_, err := h.db.Exec(fmt.Sprintf(
+		"INSERT INTO %s (%s, lang_code, %s, machine_translated) VALUES (?, ?%s, 1) ON CONFLICT(%s, lang_code) DO UPDATE SET %s",
+		t.table, t.key, strings.Join(t.fields, ", "), strings.Repeat(", ?", len(t.fields)), t.key, strings.Join(updates, ", "),
+	), args...)
+	return err
+}
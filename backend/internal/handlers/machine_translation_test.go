@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/translate"
+)
+
+// newTranslatedMessage adds a message with a Dutch original, a human English
+// translation and an earlier French machine draft; German is missing
+func newTranslatedMessage(t *testing.T, h *Handler) int64 {
+	t.Helper()
+	id := exec(t, h, "INSERT INTO messages (state) VALUES ('published')")
+	exec(t, h, `
+		INSERT INTO message_translations (message_id, lang_code, title, content, machine_translated) VALUES
+			(?, 'NL', 'Nieuwe caches', '{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"Veel "},{"type":"text","marks":[{"type":"bold"}],"text":"plezier"}]}]}', 0),
+			(?, 'EN', 'New caches', 'Have fun', 0),
+			(?, 'FR', '[FR] Oud', '[FR] Oud', 1)
+	`, id, id, id)
+	return id
+}
+
+func suggest(h *Handler, kind, id, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/admin/translations/"+kind+"/"+id+"/suggest", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.SuggestTranslations(w, withURLParams(r, "kind", kind, "id", id))
+	return w
+}
+
+type storedRow struct {
+	title, content string
+	machine        bool
+}
+
+func loadMessageTranslations(t *testing.T, h *Handler, id int64) map[string]storedRow {
+	t.Helper()
+	rows, err := h.db.Query("SELECT lang_code, title, content, machine_translated FROM message_translations WHERE message_id = ?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	stored := map[string]storedRow{}
+	for rows.Next() {
+		var lang string
+		var s storedRow
+		rows.Scan(&lang, &s.title, &s.content, &s.machine)
+		stored[lang] = s
+	}
+	return stored
+}
+
+func TestSuggestTranslations(t *testing.T) {
+	h := newTestHandler(t)
+	fake := h.translator.(*translate.Fake)
+	id := newTranslatedMessage(t, h)
+
+	w := suggest(h, "message", fmt.Sprint(id), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Translator string               `json:"translator"`
+		Source     string               `json:"source"`
+		Drafts     []MachineTranslation `json:"drafts"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Translator != "fake" || resp.Source != "NL" || len(resp.Drafts) != 2 || resp.Drafts[0].LangCode != "DE" || resp.Drafts[1].LangCode != "FR" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// The missing language and the old draft are translated from Dutch,
+	// the human English translation is left alone
+	if len(fake.Requests) != 2 || fake.Requests[0].Target != "DE" || fake.Requests[1].Target != "FR" || fake.Requests[0].Source != "NL" {
+		t.Errorf("requests = %+v", fake.Requests)
+	}
+	stored := loadMessageTranslations(t, h, id)
+	want := storedRow{
+		title:   "[FR] Nieuwe caches",
+		content: `{"content":[{"content":[{"text":"[FR] Veel ","type":"text"},{"marks":[{"type":"bold"}],"text":"[FR] plezier","type":"text"}],"type":"paragraph"}],"type":"doc"}`,
+		machine: true,
+	}
+	if stored["FR"] != want {
+		t.Errorf("FR = %+v\nwant %+v", stored["FR"], want)
+	}
+	if de := stored["DE"]; de.title != "[DE] Nieuwe caches" || !de.machine {
+		t.Errorf("DE = %+v", de)
+	}
+	if en := stored["EN"]; en != (storedRow{"New caches", "Have fun", false}) {
+		t.Errorf("EN = %+v, want it unchanged", en)
+	}
+
+	// Asking again only replaces drafts
+	fake.Requests = nil
+	if w := suggest(h, "message", fmt.Sprint(id), `{"languages":["en","fr"]}`); w.Code != http.StatusOK {
+		t.Fatalf("second suggestion: status = %d: %s", w.Code, w.Body)
+	}
+	if len(fake.Requests) != 1 || fake.Requests[0].Target != "FR" {
+		t.Errorf("second suggestion requests = %+v", fake.Requests)
+	}
+}
+
+func TestSuggestTranslationsStaticContent(t *testing.T) {
+	h := newTestHandler(t)
+	exec(t, h, "DELETE FROM static_content WHERE property = 'test_intro'")
+	exec(t, h, `
+		INSERT INTO static_content (property, lang_code, content, updated_at) VALUES
+			('test_intro', 'NL', 'Welkom', '2000-01-01 00:00:00'),
+			('test_intro', 'EN', '', '2000-01-01 00:00:00')
+	`)
+
+	if w := suggest(h, "static_content", "test_intro", `{"languages":["EN"]}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	// A blank translation counts as missing, and the public ETag changes
+	var content string
+	var machine bool
+	var updated string
+	h.db.QueryRow("SELECT content, machine_translated, updated_at FROM static_content WHERE property = 'test_intro' AND lang_code = 'EN'").
+		Scan(&content, &machine, &updated)
+	if content != "[EN] Welkom" || !machine || strings.HasPrefix(updated, "2000") {
+		t.Errorf("EN = %q, machine %v, updated %s", content, machine, updated)
+	}
+}
+
+func TestSuggestTranslationsErrors(t *testing.T) {
+	h := newTestHandler(t)
+	messageID := newTranslatedMessage(t, h)
+	id := fmt.Sprint(messageID)
+
+	tests := []struct {
+		name     string
+		kind, id string
+		body     string
+		// translatorErr makes the translator fail
+		translatorErr error
+		want          int
+		wantError     string
+	}{
+		{name: "unknown kind", kind: "socials", id: id, want: http.StatusBadRequest},
+		{name: "unknown content", kind: "message", id: "999", want: http.StatusNotFound},
+		{name: "machine translated source", kind: "message", id: id, body: `{"source":"FR"}`, want: http.StatusBadRequest, wantError: "There is no FR text to translate from"},
+		{name: "missing source", kind: "message", id: id, body: `{"source":"DE"}`, want: http.StatusBadRequest},
+		{name: "inactive language", kind: "message", id: id, body: `{"languages":["XX"]}`, want: http.StatusBadRequest, wantError: "XX is not an active language"},
+		{name: "invalid body", kind: "message", id: id, body: `{`, want: http.StatusBadRequest},
+		{
+			name: "translation service error", kind: "message", id: id,
+			translatorErr: &translate.APIError{Service: "deepl", Status: 456, Message: "Quota exceeded"},
+			want:          http.StatusBadGateway, wantError: "Quota exceeded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.translator.(*translate.Fake).Err = tt.translatorErr
+			w := suggest(h, tt.kind, tt.id, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			var resp map[string]string
+			json.NewDecoder(w.Body).Decode(&resp)
+			if tt.wantError != "" && resp["error"] != tt.wantError {
+				t.Errorf("error = %q, want %q", resp["error"], tt.wantError)
+			}
+		})
+	}
+
+	// Nothing was saved by the failed requests
+	if fr := loadMessageTranslations(t, h, messageID)["FR"]; fr.title != "[FR] Oud" {
+		t.Errorf("FR = %+v, want the old draft", fr)
+	}
+
+	h.translator = nil
+	if w := suggest(h, "message", id, ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a translator: status = %d, want 503", w.Code)
+	}
+}
+
+func TestConfirmTranslation(t *testing.T) {
+	h := newTestHandler(t)
+	id := newTranslatedMessage(t, h)
+
+	confirm := func(lang string) int {
+		r := httptest.NewRequest("POST", "/admin/translations/message/"+fmt.Sprint(id)+"/"+lang+"/confirm", nil)
+		w := httptest.NewRecorder()
+		h.ConfirmTranslation(w, withURLParams(r, "kind", "message", "id", fmt.Sprint(id), "lang", lang))
+		return w.Code
+	}
+
+	if code := confirm("fr"); code != http.StatusOK {
+		t.Fatalf("confirm FR: status = %d, want 200", code)
+	}
+	if fr := loadMessageTranslations(t, h, id)["FR"]; fr.machine || fr.title != "[FR] Oud" {
+		t.Errorf("FR after confirming = %+v", fr)
+	}
+	// Only drafts can be confirmed
+	for _, lang := range []string{"FR", "EN", "DE"} {
+		if code := confirm(lang); code != http.StatusNotFound {
+			t.Errorf("confirm %s: status = %d, want 404", lang, code)
+		}
+	}
+}
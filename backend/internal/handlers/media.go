@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/media"
+	"github.com/go-chi/chi/v5"
+)
+
+// MediaItem is an uploaded image in the media library. Usages are looked
+// up from the columns that refer to images, so they are always current.
+type MediaItem struct {
+	ID           int64  `json:"id"`
+	Filename     string `json:"filename"`
+	URL          string `json:"url"`
+	OriginalName string `json:"original_name"`
+	ContentType  string `json:"content_type"`
+	SizeBytes    int64  `json:"size_bytes"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Widths       []int  `json:"widths"`
+	// Missing is set when the file is no longer in storage
+	Missing        bool               `json:"missing"`
+	UploadedBy     *int64             `json:"uploaded_by,omitempty"`
+	UploadedByName string             `json:"uploaded_by_name,omitempty"`
+	CreatedAt      time.Time          `json:"created_at"`
+	Translations   []MediaTranslation `json:"translations"`
+	Usages         []media.Usage      `json:"usages"`
+}
+
+type MediaTranslation struct {
+	LangCode string `json:"lang_code"`
+	AltText  string `json:"alt_text"`
+}
+
+// imageInUse reports whether anything still refers to an upload; it errs
+// on the side of keeping the image when usages cannot be looked up
+func (h *Handler) imageInUse(filename string) bool {
+	usages, err := media.Usages(h.db)
+	if err != nil {
+		return true
+	}
+	return len(usages[media.Key(filename)]) > 0
+}
+
+// loadMediaItems returns library entries, newest first. where filters on
+// the media table (aliased m).
+func (h *Handler) loadMediaItems(ctx context.Context, where string, args ...interface{}) ([]MediaItem, error) {
+	usages, err := media.Usages(h.db)
+	if err != nil {
+		return nil, err
+	}
+	// One listing of the image store serves the widths of every entry
+	stored := h.imageFiles(ctx, "")
+
+	query := `
+		SELECT m.id, m.filename, m.original_name, m.content_type, m.size_bytes, m.width, m.height,
+		       m.uploaded_by, COALESCE(u.name, ''), m.created_at
+		FROM media m LEFT JOIN users u ON u.id = m.uploaded_by`
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := h.db.Query(query+" ORDER BY m.created_at DESC, m.id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	items := []MediaItem{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var m MediaItem
+		var uploadedBy sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.Filename, &m.OriginalName, &m.ContentType, &m.SizeBytes,
+			&m.Width, &m.Height, &uploadedBy, &m.UploadedByName, &m.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if uploadedBy.Valid {
+			m.UploadedBy = &uploadedBy.Int64
+		}
+		m.URL = h.mediaURL("image", m.Filename)
+		m.Widths = widthsIn(m.Filename, stored)
+		m.Missing = !stored[m.Filename]
+		m.Translations = []MediaTranslation{}
+		m.Usages = usages[media.Key(m.Filename)]
+		if m.Usages == nil {
+			m.Usages = []media.Usage{}
+		}
+		index[m.ID] = len(items)
+		items = append(items, m)
+	}
+	rows.Close()
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	rows, err = h.db.Query("SELECT media_id, lang_code, alt_text FROM media_translations ORDER BY lang_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mediaID int64
+		var t MediaTranslation
+		if err := rows.Scan(&mediaID, &t.LangCode, &t.AltText); err != nil {
+			return nil, err
+		}
+		if i, ok := index[mediaID]; ok {
+			items[i].Translations = append(items[i].Translations, t)
+		}
+	}
+	return items, rows.Err()
+}
+
+func (h *Handler) loadMediaItem(ctx context.Context, id int64) (MediaItem, error) {
+	items, err := h.loadMediaItems(ctx, "m.id = ?", id)
+	if err != nil {
+		return MediaItem{}, err
+	}
+	if len(items) == 0 {
+		return MediaItem{}, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// GetMedia lists the media library. ?q= searches the original file name,
+// ?unused=true only returns images nothing refers to.
+func (h *Handler) GetMedia(w http.ResponseWriter, r *http.Request) {
+	// Pick up images uploaded before the library existed
+	if err := media.Sync(r.Context(), h.db, h.store); err != nil {
+		respondJSON(w, http.StatusInternalServerError, []MediaItem{})
+		return
+	}
+
+	where := ""
+	args := []interface{}{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		where = "(m.original_name LIKE ? OR m.filename LIKE ?)"
+		args = append(args, "%"+q+"%", "%"+q+"%")
+	}
+	items, err := h.loadMediaItems(r.Context(), where, args...)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, []MediaItem{})
+		return
+	}
+
+	if r.URL.Query().Get("unused") == "true" {
+		unused := []MediaItem{}
+		for _, m := range items {
+			if len(m.Usages) == 0 {
+				unused = append(unused, m)
+			}
+		}
+		items = unused
+	}
+
+	respondJSON(w, http.StatusOK, items)
+}
+
+// GetMediaItem returns one library entry with its usages
+func (h *Handler) GetMediaItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid media ID"})
+		return
+	}
+
+	item, err := h.loadMediaItem(r.Context(), id)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Media not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, http.StatusOK, item)
+}
+
+// GetPublicMedia returns the dimensions, widths and alt texts of an image,
+// so the site can render it with alt text and a srcset. Anyone can call it,
+// so it only reads the one entry and lists the variants of that file;
+// usages are left to the admin endpoints.
+func (h *Handler) GetPublicMedia(w http.ResponseWriter, r *http.Request) {
+	filename := filepath.Base(chi.URLParam(r, "filename"))
+	var id int64
+	var width, height int
+	err := h.db.QueryRow("SELECT id, width, height FROM media WHERE filename = ?", filename).Scan(&id, &width, &height)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Media not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	query := "SELECT lang_code, alt_text FROM media_translations WHERE media_id = ?"
+	args := []interface{}{id}
+	if lang := h.requestLanguage(w, r); lang != "" {
+		query += " AND lang_code = ?"
+		args = append(args, lang)
+	}
+	rows, err := h.db.Query(query+" ORDER BY lang_code", args...)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	translations := []MediaTranslation{}
+	for rows.Next() {
+		var t MediaTranslation
+		if rows.Scan(&t.LangCode, &t.AltText) == nil {
+			translations = append(translations, t)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"filename":     filename,
+		"url":          h.mediaURL("image", filename),
+		"width":        width,
+		"height":       height,
+		"widths":       h.imageWidths(r.Context(), filename),
+		"translations": translations,
+	})
+}
+
+// UpdateMedia replaces the alt texts of an image
+func (h *Handler) UpdateMedia(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid media ID"})
+		return
+	}
+
+	var req struct {
+		Translations []MediaTranslation `json:"translations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	var exists int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM media WHERE id = ?", id).Scan(&exists); err != nil || exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Media not found"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM media_translations WHERE media_id = ?", id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save alt texts"})
+		return
+	}
+	for _, t := range req.Translations {
+		altText := truncateString(strings.TrimSpace(t.AltText), 500)
+		if altText == "" {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO media_translations (media_id, lang_code, alt_text) VALUES (?, ?, ?)
+		`, id, t.LangCode, altText)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid language: " + t.LangCode})
+			return
+		}
+	}
+	tx.Exec("UPDATE media SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	if err := tx.Commit(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save alt texts"})
+		return
+	}
+
+	item, _ := h.loadMediaItem(r.Context(), id)
+	respondJSON(w, http.StatusOK, item)
+}
+
+// DeleteMedia removes an image that nothing refers to
+func (h *Handler) DeleteMedia(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid media ID"})
+		return
+	}
+
+	item, err := h.loadMediaItem(r.Context(), id)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Media not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if len(item.Usages) > 0 {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":  "Image is still in use",
+			"usages": item.Usages,
+		})
+		return
+	}
+
+	h.removeImage(r.Context(), item.Filename)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Media deleted"})
+}
+
+// CleanupMedia removes the images nothing refers to. It is a dry run
+// unless dry_run is false, and skips images younger than min_age_days
+// (at least one day).
+func (h *Handler) CleanupMedia(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		DryRun     *bool `json:"dry_run"`
+		MinAgeDays int   `json:"min_age_days"`
+	}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+	minAge := time.Duration(req.MinAgeDays) * 24 * time.Hour
+
+	orphans, err := media.Cleanup(r.Context(), h.db, h.store, minAge, dryRun)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to clean up media"})
+		return
+	}
+
+	var bytes int64
+	for _, o := range orphans {
+		bytes += o.SizeBytes
+	}
+	message := fmt.Sprintf("%d unused image(s) removed", len(orphans))
+	if dryRun {
+		message = fmt.Sprintf("%d unused image(s) would be removed", len(orphans))
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"dry_run": dryRun,
+		"images":  orphans,
+		"count":   len(orphans),
+		"bytes":   bytes,
+		"message": message,
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -22,11 +23,16 @@ type MessageTranslation struct {
 	LangCode string `json:"lang_code"`
 	Title    string `json:"title"`
 	Content  string `json:"content"`
+	// Fallback is set when this stands in for the requested language
+	Fallback bool `json:"fallback,omitempty"`
+	// MachineTranslated marks a machine translation draft that an admin
+	// has not confirmed yet; drafts are not shown publicly
+	MachineTranslated bool `json:"machine_translated,omitempty"`
 }
 
 // GetPublicMessages returns all published messages
 func (h *Handler) GetPublicMessages(w http.ResponseWriter, r *http.Request) {
-	lang := r.URL.Query().Get("lang")
+	lang := h.requestLanguage(w, r)
 
 	rows, err := h.db.Query(`
 		SELECT id, state, priority, updated_at
@@ -46,7 +52,7 @@ func (h *Handler) GetPublicMessages(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(&msg.ID, &msg.State, &msg.Priority, &msg.UpdatedAt); err != nil {
 			continue
 		}
-		msg.Translations = h.getMessageTranslations(msg.ID, lang)
+		msg.Translations = h.getMessageTranslations(msg.ID, lang, false)
 		messages = append(messages, msg)
 	}
 
@@ -72,7 +78,7 @@ func (h *Handler) GetAdminMessages(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(&msg.ID, &msg.State, &msg.Priority, &msg.UpdatedAt); err != nil {
 			continue
 		}
-		msg.Translations = h.getMessageTranslations(msg.ID, "")
+		msg.Translations = h.getMessageTranslations(msg.ID, "", true)
 		messages = append(messages, msg)
 	}
 
@@ -98,7 +104,7 @@ func (h *Handler) GetMessageByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	msg.Translations = h.getMessageTranslations(msg.ID, "")
+	msg.Translations = h.getMessageTranslations(msg.ID, "", true)
 	respondJSON(w, http.StatusOK, msg)
 }
 
@@ -135,10 +141,11 @@ func (h *Handler) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	// Insert translations
 	for _, t := range msg.Translations {
 		h.db.Exec(`
-			INSERT INTO message_translations (message_id, lang_code, title, content)
-			VALUES (?, ?, ?, ?)
-		`, msg.ID, t.LangCode, t.Title, t.Content)
+			INSERT INTO message_translations (message_id, lang_code, title, content, machine_translated)
+			VALUES (?, ?, ?, ?, ?)
+		`, msg.ID, t.LangCode, t.Title, t.Content, t.MachineTranslated)
 	}
+	h.recordRevision(r, "message", strconv.FormatInt(msg.ID, 10), nil)
 
 	respondJSON(w, http.StatusCreated, msg)
 }
@@ -159,6 +166,7 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	base := h.takeBaseline("message", id)
 	_, err := h.db.Exec(`
 		UPDATE messages SET state = ?, priority = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
@@ -172,10 +180,11 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	// Update translations
 	for _, t := range msg.Translations {
 		h.db.Exec(`
-			INSERT OR REPLACE INTO message_translations (message_id, lang_code, title, content)
-			VALUES (?, ?, ?, ?)
-		`, id, t.LangCode, t.Title, t.Content)
+			INSERT OR REPLACE INTO message_translations (message_id, lang_code, title, content, machine_translated)
+			VALUES (?, ?, ?, ?, ?)
+		`, id, t.LangCode, t.Title, t.Content, t.MachineTranslated)
 	}
+	h.recordRevision(r, "message", id, base)
 
 	idInt, _ := strconv.ParseInt(id, 10, 64)
 	msg.ID = idInt
@@ -186,32 +195,26 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
+	base := h.takeBaseline("message", id)
 	_, err := h.db.Exec("DELETE FROM messages WHERE id = ?", id)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete message"})
 		return
 	}
+	h.recordBaseline("message", id, base)
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Message deleted"})
 }
 
 // Helper to get message translations
-func (h *Handler) getMessageTranslations(messageID int64, langFilter string) []MessageTranslation {
-	var rows *sql.Rows
-	var err error
-
-	if langFilter != "" {
-		rows, err = h.db.Query(
-			"SELECT lang_code, title, content FROM message_translations WHERE message_id = ? AND lang_code = ?",
-			messageID, langFilter,
-		)
-	} else {
-		rows, err = h.db.Query(
-			"SELECT lang_code, title, content FROM message_translations WHERE message_id = ?",
-			messageID,
-		)
-	}
-
+// getMessageTranslations returns all translations of a message, or with a
+// langFilter the one in that language or else its first fallback. Machine
+// translation drafts are only included with drafts set.
+func (h *Handler) getMessageTranslations(messageID int64, langFilter string, drafts bool) []MessageTranslation {
+	rows, err := h.db.Query(
+		"SELECT lang_code, title, content, machine_translated FROM message_translations WHERE message_id = ? ORDER BY lang_code",
+		messageID,
+	)
 	if err != nil {
 		return []MessageTranslation{}
 	}
@@ -220,11 +223,25 @@ func (h *Handler) getMessageTranslations(messageID int64, langFilter string) []M
 	translations := []MessageTranslation{}
 	for rows.Next() {
 		var t MessageTranslation
-		if err := rows.Scan(&t.LangCode, &t.Title, &t.Content); err != nil {
+		if err := rows.Scan(&t.LangCode, &t.Title, &t.Content, &t.MachineTranslated); err != nil || (t.MachineTranslated && !drafts) {
 			continue
 		}
 		translations = append(translations, t)
 	}
+	if langFilter == "" {
+		return translations
+	}
 
-	return translations
+	langs := make([]string, len(translations))
+	texts := make([]string, len(translations))
+	for i, t := range translations {
+		langs[i], texts[i] = t.LangCode, t.Title+t.Content
+	}
+	i := pickTranslation(h.translationChain(langFilter), langs, texts)
+	if i < 0 {
+		return []MessageTranslation{}
+	}
+	t := translations[i]
+	t.Fallback = !strings.EqualFold(t.LangCode, langFilter)
+	return []MessageTranslation{t}
 }
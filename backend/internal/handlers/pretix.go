@@ -0,0 +1,605 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/pretix"
+	"github.com/go-chi/chi/v5"
+)
+
+// Events can be linked to a pretix event by slug. Quota availability and the
+// organizer's orders are mirrored locally: a sync pulls quotas and every
+// order changed since the last sync, and pretix webhooks keep single orders
+// current in between. Public pages only ever read the local copy.
+
+var pretixSlugRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]{0,49}$`)
+
+// pretixSyncInterval is how old the local copy may get before a public page
+// view triggers a background refresh
+const pretixSyncInterval = 10 * time.Minute
+
+var errPretixNotConfigured = errors.New("pretix is not configured")
+
+// PretixQuota is the synced state of one pretix quota
+type PretixQuota struct {
+	Name            string `json:"name"`
+	Size            *int   `json:"size,omitempty"`
+	Available       bool   `json:"available"`
+	AvailableNumber *int   `json:"available_number,omitempty"`
+	Paid            int    `json:"paid"`
+	Pending         int    `json:"pending"`
+}
+
+// EventPretixInfo is set on the public event page when the event is linked
+// to pretix
+type EventPretixInfo struct {
+	EventSlug   string        `json:"event_slug"`
+	ShopURL     string        `json:"shop_url,omitempty"`
+	Available   bool          `json:"available"`
+	Quotas      []PretixQuota `json:"quotas"`
+	TicketsSold int           `json:"tickets_sold"`
+	SyncedAt    *time.Time    `json:"synced_at,omitempty"`
+}
+
+// EventPretixOverview is the admin dashboard view of a linked event
+type EventPretixOverview struct {
+	EventPretixInfo
+	SyncError      string         `json:"sync_error,omitempty"`
+	TicketsPending int            `json:"tickets_pending"`
+	CheckedIn      int            `json:"checked_in"`
+	PaidCents      int            `json:"paid_cents"`
+	PaidDisplay    string         `json:"paid_display"`
+	Orders         map[string]int `json:"orders"`
+	RecentOrders   []PretixOrder  `json:"recent_orders"`
+}
+
+// PretixOrder is an order mirrored from pretix
+type PretixOrder struct {
+	Code         string     `json:"code"`
+	Status       string     `json:"status"`
+	Email        string     `json:"email"`
+	TotalCents   int        `json:"total_cents"`
+	TotalDisplay string     `json:"total_display"`
+	Positions    int        `json:"positions"`
+	CheckedIn    int        `json:"checked_in"`
+	Testmode     bool       `json:"testmode,omitempty"`
+	OrderedAt    *time.Time `json:"ordered_at,omitempty"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type eventPretixLink struct {
+	EventID        int64
+	Slug           string
+	PublicURL      string
+	Quotas         []PretixQuota
+	Available      bool
+	SyncedAt       sql.NullTime
+	OrdersSyncedAt sql.NullTime
+	SyncError      string
+}
+
+// pretixOrderStatus maps pretix status codes to the words we use elsewhere
+func pretixOrderStatus(code string) string {
+	switch code {
+	case pretix.StatusPending:
+		return "pending"
+	case pretix.StatusPaid:
+		return "paid"
+	case pretix.StatusExpired:
+		return "expired"
+	case pretix.StatusCanceled:
+		return "cancelled"
+	}
+	return code
+}
+
+func (h *Handler) pretixClient() (*pretix.Client, error) {
+	settings, err := h.getShopSettings()
+	if err != nil {
+		return nil, err
+	}
+	if settings.PretixOrganizer == "" || settings.PretixAPIToken == "" {
+		return nil, errPretixNotConfigured
+	}
+	return pretix.New(settings.PretixAPIURL, settings.PretixOrganizer, settings.PretixAPIToken), nil
+}
+
+func (h *Handler) loadEventPretix(eventID int64) (*eventPretixLink, error) {
+	link := &eventPretixLink{EventID: eventID}
+	var quotasJSON string
+	var available int
+	err := h.db.QueryRow(`
+		SELECT event_slug, public_url, quotas, available, synced_at, orders_synced_at, sync_error
+		FROM event_pretix WHERE event_id = ?
+	`, eventID).Scan(&link.Slug, &link.PublicURL, &quotasJSON, &available,
+		&link.SyncedAt, &link.OrdersSyncedAt, &link.SyncError)
+	if err != nil {
+		return nil, err
+	}
+	link.Available = available == 1
+	link.Quotas = []PretixQuota{}
+	json.Unmarshal([]byte(quotasJSON), &link.Quotas)
+	return link, nil
+}
+
+func (h *Handler) pretixTicketsSold(eventID int64) int {
+	var sold int
+	h.db.QueryRow(`
+		SELECT COALESCE(SUM(positions), 0) FROM pretix_orders
+		WHERE event_id = ? AND status = 'paid' AND testmode = 0
+	`, eventID).Scan(&sold)
+	return sold
+}
+
+func (link *eventPretixLink) info(ticketsSold int) EventPretixInfo {
+	info := EventPretixInfo{
+		EventSlug:   link.Slug,
+		ShopURL:     link.PublicURL,
+		Available:   link.Available,
+		Quotas:      link.Quotas,
+		TicketsSold: ticketsSold,
+	}
+	if link.SyncedAt.Valid {
+		info.SyncedAt = &link.SyncedAt.Time
+	}
+	return info
+}
+
+// eventPretixInfo returns the public pretix state of an event, or nil when
+// it is not linked. A stale copy is refreshed in the background.
+func (h *Handler) eventPretixInfo(eventID int64) (*EventPretixInfo, error) {
+	link, err := h.loadEventPretix(eventID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !link.SyncedAt.Valid || time.Since(link.SyncedAt.Time) > pretixSyncInterval {
+		h.refreshPretixInBackground(eventID)
+	}
+
+	info := link.info(h.pretixTicketsSold(eventID))
+	return &info, nil
+}
+
+// refreshPretixInBackground syncs an event unless a sync for it started
+// less than pretixSyncInterval ago, so a pretix outage does not turn every
+// page view into an API call
+func (h *Handler) refreshPretixInBackground(eventID int64) {
+	now := time.Now()
+	if last, ok := h.pretixSyncs.Load(eventID); ok && now.Sub(last.(time.Time)) < pretixSyncInterval {
+		return
+	}
+	h.pretixSyncs.Store(eventID, now)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := h.syncEventPretix(ctx, eventID); err != nil {
+			log.Printf("Pretix sync of event #%d failed: %v", eventID, err)
+		}
+	}()
+}
+
+// syncEventPretix pulls quotas and changed orders of a linked event. Failures
+// are stored on the link so the dashboard can show them.
+func (h *Handler) syncEventPretix(ctx context.Context, eventID int64) error {
+	link, err := h.loadEventPretix(eventID)
+	if err != nil {
+		return err
+	}
+
+	err = h.pullEventPretix(ctx, link)
+	if err != nil {
+		msg := err.Error()
+		if errors.Is(err, errPretixNotConfigured) {
+			msg = "Pretix is not configured"
+		}
+		h.db.Exec(`
+			UPDATE event_pretix SET sync_error = ?, updated_at = CURRENT_TIMESTAMP WHERE event_id = ?
+		`, truncateString(msg, 500), eventID)
+	}
+	return err
+}
+
+func (h *Handler) pullEventPretix(ctx context.Context, link *eventPretixLink) error {
+	client, err := h.pretixClient()
+	if err != nil {
+		return err
+	}
+
+	started := time.Now().UTC()
+
+	ev, err := client.Event(ctx, link.Slug)
+	if err != nil {
+		return err
+	}
+
+	quotas, err := client.Quotas(ctx, link.Slug)
+	if err != nil {
+		return err
+	}
+	synced := make([]PretixQuota, 0, len(quotas))
+	available := false
+	for _, q := range quotas {
+		a, err := client.QuotaAvailability(ctx, link.Slug, q.ID)
+		if err != nil {
+			return err
+		}
+		synced = append(synced, PretixQuota{
+			Name:            q.Name,
+			Size:            a.TotalSize,
+			Available:       a.Available && !q.Closed,
+			AvailableNumber: a.AvailableNumber,
+			Paid:            a.PaidOrders,
+			Pending:         a.PendingOrders,
+		})
+		if a.Available && !q.Closed {
+			available = true
+		}
+	}
+
+	// Overlap the previous window a little so clock skew cannot lose orders
+	var since time.Time
+	if link.OrdersSyncedAt.Valid {
+		since = link.OrdersSyncedAt.Time.Add(-time.Minute)
+	}
+	orders, err := client.Orders(ctx, link.Slug, since)
+	if err != nil {
+		return err
+	}
+
+	quotasJSON, _ := json.Marshal(synced)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, o := range orders {
+		if err := upsertPretixOrder(tx, link.EventID, o); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE event_pretix SET public_url = ?, quotas = ?, available = ?, synced_at = ?,
+			orders_synced_at = ?, sync_error = '', updated_at = CURRENT_TIMESTAMP
+		WHERE event_id = ?
+	`, truncateString(ev.PublicURL, 500), string(quotasJSON), boolToInt(available && ev.Live),
+		time.Now().UTC(), started, link.EventID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func upsertPretixOrder(tx *sql.Tx, eventID int64, o pretix.Order) error {
+	var orderedAt interface{}
+	if !o.Datetime.IsZero() {
+		orderedAt = o.Datetime.UTC()
+	}
+	_, err := tx.Exec(`
+		INSERT INTO pretix_orders (event_id, code, status, email, total_cents, positions, checked_in, testmode, ordered_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(event_id, code) DO UPDATE SET
+			status = excluded.status, email = excluded.email, total_cents = excluded.total_cents,
+			positions = excluded.positions, checked_in = excluded.checked_in, testmode = excluded.testmode,
+			ordered_at = excluded.ordered_at, updated_at = CURRENT_TIMESTAMP
+	`, eventID, o.Code, pretixOrderStatus(o.Status), truncateString(o.Email, 254), o.TotalCents(),
+		len(o.Positions), o.CheckedIn(), boolToInt(o.Testmode), orderedAt)
+	return err
+}
+
+func (h *Handler) eventPretixOverview(eventID int64) (*EventPretixOverview, error) {
+	link, err := h.loadEventPretix(eventID)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := h.getShopSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	overview := &EventPretixOverview{
+		EventPretixInfo: link.info(h.pretixTicketsSold(eventID)),
+		SyncError:       link.SyncError,
+		Orders:          map[string]int{},
+		RecentOrders:    []PretixOrder{},
+	}
+
+	rows, err := h.db.Query(`
+		SELECT status, COUNT(*), COALESCE(SUM(positions), 0), COALESCE(SUM(checked_in), 0), COALESCE(SUM(total_cents), 0)
+		FROM pretix_orders WHERE event_id = ? AND testmode = 0
+		GROUP BY status
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var n, positions, checkedIn, total int
+		if err := rows.Scan(&status, &n, &positions, &checkedIn, &total); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		overview.Orders[status] = n
+		switch status {
+		case "paid":
+			overview.CheckedIn += checkedIn
+			overview.PaidCents += total
+		case "pending":
+			overview.TicketsPending += positions
+		}
+	}
+	rows.Close()
+	overview.PaidDisplay = formatPrice(overview.PaidCents, settings.Currency)
+
+	rows, err = h.db.Query(`
+		SELECT code, status, email, total_cents, positions, checked_in, testmode, ordered_at, updated_at
+		FROM pretix_orders WHERE event_id = ?
+		ORDER BY ordered_at DESC, id DESC LIMIT 100
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o PretixOrder
+		var testmode int
+		var orderedAt sql.NullTime
+		if err := rows.Scan(&o.Code, &o.Status, &o.Email, &o.TotalCents, &o.Positions, &o.CheckedIn,
+			&testmode, &orderedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		o.Testmode = testmode == 1
+		if orderedAt.Valid {
+			o.OrderedAt = &orderedAt.Time
+		}
+		o.TotalDisplay = formatPrice(o.TotalCents, settings.Currency)
+		overview.RecentOrders = append(overview.RecentOrders, o)
+	}
+
+	return overview, nil
+}
+
+// respondPretixError turns a pretix client error into a response
+func respondPretixError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPretixNotConfigured) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Pretix is not configured"})
+		return
+	}
+	msg := "Could not reach pretix"
+	var apiErr *pretix.APIError
+	if errors.As(err, &apiErr) {
+		msg = apiErr.Message
+	}
+	respondJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+}
+
+// GetEventPretix returns the pretix dashboard of a linked event
+func (h *Handler) GetEventPretix(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	overview, err := h.eventPretixOverview(eventID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event is not linked to pretix"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, overview)
+}
+
+// LinkEventPretix links an event to a pretix event slug and runs a first sync
+func (h *Handler) LinkEventPretix(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	var req struct {
+		EventSlug string `json:"event_slug"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	req.EventSlug = strings.TrimSpace(req.EventSlug)
+	if !pretixSlugRegex.MatchString(req.EventSlug) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid pretix event slug"})
+		return
+	}
+
+	var exists int
+	h.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ?", eventID).Scan(&exists)
+	if exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+
+	var otherEventID int64
+	err = h.db.QueryRow("SELECT event_id FROM event_pretix WHERE event_slug = ?", req.EventSlug).Scan(&otherEventID)
+	if err == nil && otherEventID != eventID {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "This pretix event is already linked to another event"})
+		return
+	}
+
+	client, err := h.pretixClient()
+	if err != nil {
+		respondPretixError(w, err)
+		return
+	}
+	if _, err := client.Event(r.Context(), req.EventSlug); err != nil {
+		if pretix.IsNotFound(err) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Pretix event not found"})
+			return
+		}
+		respondPretixError(w, err)
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Orders of a previously linked pretix event no longer apply
+	var currentSlug string
+	tx.QueryRow("SELECT event_slug FROM event_pretix WHERE event_id = ?", eventID).Scan(&currentSlug)
+	if currentSlug != req.EventSlug {
+		if _, err := tx.Exec("DELETE FROM pretix_orders WHERE event_id = ?", eventID); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		_, err = tx.Exec(`
+			INSERT INTO event_pretix (event_id, event_slug) VALUES (?, ?)
+			ON CONFLICT(event_id) DO UPDATE SET
+				event_slug = excluded.event_slug, public_url = '', quotas = '[]', available = 0,
+				synced_at = NULL, orders_synced_at = NULL, sync_error = '', updated_at = CURRENT_TIMESTAMP
+		`, eventID, req.EventSlug)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	if err := h.syncEventPretix(r.Context(), eventID); err != nil {
+		log.Printf("Pretix sync of event #%d failed: %v", eventID, err)
+	}
+	h.GetEventPretix(w, r)
+}
+
+// UnlinkEventPretix removes the pretix link and the mirrored orders
+func (h *Handler) UnlinkEventPretix(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	result, err := h.db.Exec("DELETE FROM event_pretix WHERE event_id = ?", eventID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event is not linked to pretix"})
+		return
+	}
+	h.db.Exec("DELETE FROM pretix_orders WHERE event_id = ?", eventID)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Pretix link removed"})
+}
+
+// SyncEventPretix pulls the latest quotas and orders of a linked event
+func (h *Handler) SyncEventPretix(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid event ID"})
+		return
+	}
+
+	err = h.syncEventPretix(r.Context(), eventID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event is not linked to pretix"})
+		return
+	}
+	if err != nil {
+		respondPretixError(w, err)
+		return
+	}
+	h.GetEventPretix(w, r)
+}
+
+// PretixWebhook receives pretix notifications. The body only names the
+// order, which is fetched back from the API with our token; that is what
+// authenticates the call. Non-2xx makes pretix retry later.
+func (h *Handler) PretixWebhook(w http.ResponseWriter, r *http.Request) {
+	n, err := pretix.ParseNotification(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.pretixClient()
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if n.Organizer != client.Organizer() || !n.IsOrderAction() {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var eventID int64
+	err = h.db.QueryRow("SELECT event_id FROM event_pretix WHERE event_slug = ?", n.Event).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		// Not one of our linked events
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	order, err := client.Order(r.Context(), n.Event, n.Code)
+	if pretix.IsNotFound(err) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := upsertPretixOrder(tx, eventID, *order); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Pretix order %s of event #%d: %s", order.Code, eventID, n.Action)
+
+	// Placed and paid orders change availability; force a quota refresh
+	h.pretixSyncs.Delete(eventID)
+	h.refreshPretixInBackground(eventID)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePretix serves one event of the organizer "brughia", with the orders
+// in orders by code
+type fakePretix struct {
+	mu     sync.Mutex
+	orders map[string]string
+	// queries of the order list requests
+	orderQueries []string
+	fail         bool
+}
+
+func (f *fakePretix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Token tok" || f.fail {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"detail":"Invalid token."}`)
+		return
+	}
+	const event = "/organizers/brughia/events/mega/"
+	switch path := r.URL.Path; {
+	case path == event:
+		fmt.Fprint(w, `{"slug":"mega","live":true,"public_url":"https://pretix.test/brughia/mega/"}`)
+	case path == event+"quotas/":
+		fmt.Fprint(w, `{"next":null,"results":[{"id":1,"name":"Tickets"},{"id":2,"name":"Camping","closed":true}]}`)
+	case path == event+"quotas/1/availability/":
+		fmt.Fprint(w, `{"available":true,"available_number":40,"total_size":100,"paid_orders":55,"pending_orders":5}`)
+	case path == event+"quotas/2/availability/":
+		fmt.Fprint(w, `{"available":true,"available_number":3,"total_size":10,"paid_orders":7}`)
+	case path == event+"orders/":
+		f.orderQueries = append(f.orderQueries, r.URL.RawQuery)
+		results := []string{}
+		for _, order := range f.orders {
+			results = append(results, order)
+		}
+		fmt.Fprintf(w, `{"next":null,"results":[%s]}`, strings.Join(results, ","))
+	case strings.HasPrefix(path, event+"orders/"):
+		order, ok := f.orders[strings.Trim(strings.TrimPrefix(path, event+"orders/"), "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"detail":"Not found."}`)
+			return
+		}
+		fmt.Fprint(w, order)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"detail":"Not found."}`)
+	}
+}
+
+func (f *fakePretix) setOrder(code, status string, positions int, checkedIn int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := []string{}
+	for i := 0; i < positions; i++ {
+		checkins := "[]"
+		if i < checkedIn {
+			checkins = `[{"list":1,"type":"entry"}]`
+		}
+		list = append(list, fmt.Sprintf(`{"id":%d,"price":"10.00","checkins":%s}`, i+1, checkins))
+	}
+	f.orders[code] = fmt.Sprintf(`{"code":%q,"status":%q,"email":"%s@example.com","datetime":"2099-01-01T10:00:00Z","total":"%d.00","positions":[%s]}`,
+		code, status, strings.ToLower(code), 10*positions, strings.Join(list, ","))
+}
+
+// newPretixEvent links a new event to the fake pretix event "mega"
+func newPretixEvent(t *testing.T, h *Handler) (*fakePretix, int64) {
+	t.Helper()
+	f := &fakePretix{orders: map[string]string{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	exec(t, h, "UPDATE shop_settings SET pretix_api_url = ?, pretix_organizer = 'brughia', pretix_api_token = 'tok' WHERE id = 1", server.URL)
+	eventID := exec(t, h, `
+		INSERT INTO events (uuid, title, type, start_date, end_date, state)
+		VALUES ('e1', 'Mega', 'REGULAR', '2099-01-01 10:00:00', '2099-01-01 18:00:00', 'published')
+	`)
+	exec(t, h, "INSERT INTO event_pretix (event_id, event_slug) VALUES (?, 'mega')", eventID)
+	return f, eventID
+}
+
+func pretixOrderStatuses(t *testing.T, h *Handler, eventID int64) map[string]string {
+	t.Helper()
+	rows, err := h.db.Query("SELECT code, status || '/' || positions || '/' || checked_in FROM pretix_orders WHERE event_id = ?", eventID)
+	if err != nil {
+		t.Fatalf("loading orders: %v", err)
+	}
+	defer rows.Close()
+	statuses := map[string]string{}
+	for rows.Next() {
+		var code, status string
+		rows.Scan(&code, &status)
+		statuses[code] = status
+	}
+	return statuses
+}
+
+func TestPretixSync(t *testing.T) {
+	h := newTestHandler(t)
+	f, eventID := newPretixEvent(t, h)
+	f.setOrder("PAID1", "p", 3, 2)
+	f.setOrder("OPEN1", "n", 2, 0)
+	f.setOrder("GONE1", "e", 1, 0)
+
+	if err := h.syncEventPretix(context.Background(), eventID); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	want := map[string]string{"PAID1": "paid/3/2", "OPEN1": "pending/2/0", "GONE1": "expired/1/0"}
+	if got := pretixOrderStatuses(t, h, eventID); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("orders after the first sync = %v, want %v", got, want)
+	}
+
+	overview, err := h.eventPretixOverview(eventID)
+	if err != nil {
+		t.Fatalf("eventPretixOverview: %v", err)
+	}
+	if !overview.Available || overview.ShopURL != "https://pretix.test/brughia/mega/" || overview.SyncedAt == nil || overview.SyncError != "" {
+		t.Errorf("overview = %+v", overview)
+	}
+	// A closed quota is not available, whatever pretix counts
+	if len(overview.Quotas) != 2 || !overview.Quotas[0].Available || *overview.Quotas[0].AvailableNumber != 40 ||
+		overview.Quotas[0].Paid != 55 || overview.Quotas[1].Available {
+		t.Errorf("quotas = %+v", overview.Quotas)
+	}
+	if overview.TicketsSold != 3 || overview.TicketsPending != 2 || overview.CheckedIn != 2 || overview.PaidCents != 3000 {
+		t.Errorf("sold, pending, checked in, paid = %d, %d, %d, %d; want 3, 2, 2, 3000",
+			overview.TicketsSold, overview.TicketsPending, overview.CheckedIn, overview.PaidCents)
+	}
+
+	// The next sync only asks for orders changed since the previous one
+	f.setOrder("OPEN1", "p", 2, 1)
+	f.setOrder("PAID1", "c", 3, 0)
+	if err := h.syncEventPretix(context.Background(), eventID); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if len(f.orderQueries) != 2 || f.orderQueries[0] != "" || !strings.HasPrefix(f.orderQueries[1], "modified_since=") {
+		t.Errorf("order list queries = %q", f.orderQueries)
+	}
+	want = map[string]string{"PAID1": "cancelled/3/0", "OPEN1": "paid/2/1", "GONE1": "expired/1/0"}
+	if got := pretixOrderStatuses(t, h, eventID); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("orders after the second sync = %v, want %v", got, want)
+	}
+	if sold := h.pretixTicketsSold(eventID); sold != 2 {
+		t.Errorf("tickets sold = %d, want 2", sold)
+	}
+}
+
+func TestPretixSyncError(t *testing.T) {
+	h := newTestHandler(t)
+	f, eventID := newPretixEvent(t, h)
+	f.setOrder("PAID1", "p", 1, 0)
+	if err := h.syncEventPretix(context.Background(), eventID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	// A failed sync keeps the last copy and shows why on the dashboard
+	f.mu.Lock()
+	f.fail = true
+	f.mu.Unlock()
+	if err := h.syncEventPretix(context.Background(), eventID); err == nil {
+		t.Fatal("sync against a failing pretix succeeded")
+	}
+	overview, _ := h.eventPretixOverview(eventID)
+	if overview.SyncError != "pretix: Invalid token." || overview.TicketsSold != 1 || len(overview.Quotas) != 2 {
+		t.Errorf("overview after a failed sync = %+v", overview)
+	}
+
+	exec(t, h, "UPDATE shop_settings SET pretix_api_token = '' WHERE id = 1")
+	h.syncEventPretix(context.Background(), eventID)
+	if overview, _ := h.eventPretixOverview(eventID); overview.SyncError != "Pretix is not configured" {
+		t.Errorf("sync error without a token = %q", overview.SyncError)
+	}
+}
+
+func TestPretixWebhook(t *testing.T) {
+	h := newTestHandler(t)
+	f, eventID := newPretixEvent(t, h)
+	f.setOrder("PAID1", "p", 2, 0)
+
+	notify := func(body string) int {
+		w := httptest.NewRecorder()
+		h.PretixWebhook(w, httptest.NewRequest("POST", "/pretix/webhook", strings.NewReader(body)))
+		return w.Code
+	}
+
+	// Only the order code comes from the notification; the order itself is
+	// fetched from pretix
+	if code := notify(`{"organizer":"brughia","event":"mega","code":"PAID1","action":"pretix.event.order.paid","status":"c"}`); code != http.StatusOK {
+		t.Fatalf("webhook status = %d, want 200", code)
+	}
+	if got := pretixOrderStatuses(t, h, eventID); got["PAID1"] != "paid/2/0" {
+		t.Errorf("orders after the webhook = %v", got)
+	}
+
+	// The order changes availability, so quotas are refreshed in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var synced int
+		h.db.QueryRow("SELECT COUNT(*) FROM event_pretix WHERE event_id = ? AND synced_at IS NOT NULL", eventID).Scan(&synced)
+		if synced == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("quotas were not refreshed after the webhook")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"other organizer", `{"organizer":"other","event":"mega","code":"NOPE1","action":"pretix.event.order.paid"}`, http.StatusOK},
+		{"unlinked event", `{"organizer":"brughia","event":"other","code":"NOPE1","action":"pretix.event.order.paid"}`, http.StatusOK},
+		{"unknown order", `{"organizer":"brughia","event":"mega","code":"NOPE1","action":"pretix.event.order.paid"}`, http.StatusOK},
+		{"not about an order", `{"organizer":"brughia","event":"mega","action":"pretix.event.live.activated"}`, http.StatusOK},
+		{"incomplete", `{"organizer":"brughia"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := notify(tt.body); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if got := pretixOrderStatuses(t, h, eventID); len(got) != 1 {
+		t.Errorf("orders = %v, want only PAID1", got)
+	}
+
+	// pretix retries when it cannot be reached back
+	f.mu.Lock()
+	f.fail = true
+	f.mu.Unlock()
+	if code := notify(`{"organizer":"brughia","event":"mega","code":"PAID1","action":"pretix.event.order.paid"}`); code != http.StatusServiceUnavailable {
+		t.Errorf("webhook while pretix fails: status = %d, want 503", code)
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/qr"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	minQRSize     = 64
+	maxQRSize     = 2048
+	defaultQRSize = 512
+	maxQRCaption  = 80
+)
+
+// qrLogo returns the logo for QR codes: QR_LOGO_PATH when set and readable,
+// the built-in club logo otherwise. It is decoded once.
+func (h *Handler) qrLogo() image.Image {
+	h.qrLogoOnce.Do(func() {
+		if path := h.cfg.QRLogoPath; path != "" {
+			data, err := os.ReadFile(path)
+			if err == nil {
+				h.qrLogoImage, err = qr.DecodeLogo(data)
+			}
+			if err == nil {
+				return
+			}
+			log.Printf("Failed to load QR logo %s, using the built-in logo: %v", path, err)
+		}
+		logo, err := qr.DefaultLogo()
+		if err != nil {
+			log.Printf("Failed to decode built-in QR logo: %v", err)
+			return
+		}
+		h.qrLogoImage = logo
+	})
+	return h.qrLogoImage
+}
+
+// qrTarget resolves the public page a QR code links to. Only published
+// content can be linked; there is no free-form URL.
+func (h *Handler) qrTarget(target, id, lang string) (string, error) {
+	switch target {
+	case "event":
+		var exists int
+		err := h.db.QueryRow("SELECT 1 FROM events WHERE uuid = ? AND state = 'published'", id).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s/event/%s", h.cfg.FrontendURL, id), nil
+
+	case "geocache":
+		// Geocaches have no page of their own here; link the official cache page
+		var gcCode string
+		err := h.db.QueryRow("SELECT gc_code FROM geocaches WHERE id = ? OR gc_code = ? COLLATE NOCASE", id, id).Scan(&gcCode)
+		if err != nil {
+			return "", err
+		}
+		return "https://coord.info/" + strings.ToUpper(gcCode), nil
+
+	case "golden-key-month":
+		var monthID int64
+		err := h.db.QueryRow("SELECT id FROM golden_key_months WHERE id = ?", id).Scan(&monthID)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s/golden-key/%d", h.cfg.FrontendURL, monthID), nil
+
+	case "shop-item":
+		var itemID int64
+		err := h.db.QueryRow("SELECT id FROM shop_items WHERE id = ? AND active = 1", id).Scan(&itemID)
+		if err != nil {
+			return "", err
+		}
+		// The shop page path is translated, like the navigation
+		if lang == "" {
+			lang = "NL"
+		}
+		var shopPath string
+		err = h.db.QueryRow("SELECT content FROM static_content WHERE property = 'NavShop' AND lang_code = ?", lang).Scan(&shopPath)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s/%s/%s?item=%d", h.cfg.FrontendURL, strings.ToLower(lang), shopPath, itemID), nil
+	}
+	return "", errUnknownQRTarget
+}
+
+var errUnknownQRTarget = errors.New("unknown QR target")
+
+// GetQRCode renders a QR code linking to a public page.
+//
+// Targets: event (uuid), geocache (id or GC code), golden-key-month (id) and
+// shop-item (id, translated with ?lang=). Query parameters:
+//
+//	format   png (default), svg or pdf
+//	size     64-2048, pixels for png/svg and points for pdf (default 512)
+//	level    error correction L, M (default), Q or H; a logo raises it to Q
+//	fg, bg   colours as rrggbb; bg may be "transparent" (default 000000/ffffff)
+//	logo     true to place the club logo in the centre
+//	caption  text under the code, svg and pdf only
+//	border   false to drop the quiet zone
+func (h *Handler) GetQRCode(w http.ResponseWriter, r *http.Request) {
+	target := chi.URLParam(r, "target")
+	id := chi.URLParam(r, "id")
+	q := r.URL.Query()
+
+	content, err := h.qrTarget(target, id, strings.ToUpper(q.Get("lang")))
+	if err == errUnknownQRTarget {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown QR target"})
+		return
+	}
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Target not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	opts := qr.Options{
+		Content:   content,
+		Size:      defaultQRSize,
+		QuietZone: q.Get("border") != "false",
+		Caption:   strings.TrimSpace(q.Get("caption")),
+	}
+
+	if s := q.Get("size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < minQRSize || size > maxQRSize {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Size must be between %d and %d", minQRSize, maxQRSize)})
+			return
+		}
+		opts.Size = size
+	}
+
+	level, ok := qr.ParseLevel(q.Get("level"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Level must be L, M, Q or H"})
+		return
+	}
+	opts.Level = level
+
+	fg, bg := q.Get("fg"), q.Get("bg")
+	if fg == "" {
+		fg = "000000"
+	}
+	if bg == "" {
+		bg = "ffffff"
+	}
+	var fgOK, bgOK bool
+	opts.Foreground, fgOK = qr.ParseColor(fg)
+	opts.Background, bgOK = qr.ParseColor(bg)
+	if !fgOK || !bgOK || opts.Foreground.A == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Colours must be rrggbb; only the background may be transparent"})
+		return
+	}
+
+	if len([]rune(opts.Caption)) > maxQRCaption {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Caption must be at most %d characters", maxQRCaption)})
+		return
+	}
+
+	if q.Get("logo") == "true" {
+		opts.Logo = h.qrLogo()
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "png"
+	}
+
+	var data []byte
+	var contentType string
+	switch format {
+	case "png":
+		data, err = qr.PNG(opts)
+		contentType = "image/png"
+	case "svg":
+		data, err = qr.SVG(opts)
+		contentType = "image/svg+xml"
+	case "pdf":
+		data, err = qr.PDF(opts)
+		contentType = "application/pdf"
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Format must be png, svg or pdf"})
+		return
+	}
+	if errors.Is(err, qr.ErrCaptionNeedsVector) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Captions are only available for SVG and PDF output"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate QR code"})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"qr-%s-%s.%s\"", target, id, format))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(data)
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/media"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/revisions"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// recordRevision saves the state of an entity after a change by the
+// request's user, after base, the state takeBaseline found before it. A
+// failure is only logged, the change itself succeeded.
+func (h *Handler) recordRevision(r *http.Request, entity, id string, base *revisions.Snapshot) {
+	h.recordBaseline(entity, id, base)
+	var author *revisions.Author
+	if user, ok := getUserFromContext(r); ok {
+		author = &revisions.Author{ID: user.UserID, Name: user.Email}
+	}
+	if _, err := revisions.Record(h.db, entity, id, author, ""); err != nil && err != revisions.ErrNotFound {
+		log.Printf("Failed to record revision of %s %s: %v", entity, id, err)
+	}
+}
+
+// takeBaseline snapshots an entity before a change, or returns nil when
+// it has no rows yet
+func (h *Handler) takeBaseline(entity, id string) *revisions.Snapshot {
+	s, err := revisions.Take(h.db, entity, id)
+	if err != nil && err != revisions.ErrNotFound {
+		log.Printf("Failed to snapshot %s %s: %v", entity, id, err)
+	}
+	return s
+}
+
+// recordBaseline saves base, the state of an entity before a change that
+// succeeded, when it is not the latest revision, such as content from
+// before the history or from a bundle import, so the change can be rolled
+// back. A failed change leaves the history alone.
+func (h *Handler) recordBaseline(entity, id string, base *revisions.Snapshot) {
+	if base == nil {
+		return
+	}
+	if _, err := revisions.RecordSnapshot(h.db, entity, id, base, nil, ""); err != nil {
+		log.Printf("Failed to record revision of %s %s: %v", entity, id, err)
+	}
+}
+
+// GetRevisions lists the revisions of ?entity= (event, message, shop_item,
+// static_content or golden_key_month) with ?id=, newest first
+func (h *Handler) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	entity, id := r.URL.Query().Get("entity"), r.URL.Query().Get("id")
+	if !revisions.Known(entity) || id == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "entity and id are required"})
+		return
+	}
+	list, err := revisions.List(h.db, entity, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, []revisions.Revision{})
+		return
+	}
+	respondJSON(w, http.StatusOK, list)
+}
+
+// GetRevision returns a revision with its snapshot
+func (h *Handler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	rev, ok := h.loadRevision(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, rev)
+}
+
+// GetRevisionDiff lists the fields a revision changed compared to the one
+// before it, or to ?against= another revision ID or "current", the state
+// the entity has now
+func (h *Handler) GetRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	rev, ok := h.loadRevision(w, r)
+	if !ok {
+		return
+	}
+
+	var before *revisions.Snapshot
+	against := r.URL.Query().Get("against")
+	switch against {
+	case "":
+		prev, err := revisions.Previous(h.db, rev)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		if prev != nil {
+			before = prev.Data
+			against = strconv.FormatInt(prev.ID, 10)
+		}
+	case "current":
+		current, err := revisions.Take(h.db, rev.Entity, rev.EntityID)
+		if err != nil && err != revisions.ErrNotFound {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		// Compared to now, the revision is what a restore would bring back
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"revision": rev.ID,
+			"against":  against,
+			"changes":  revisions.Diff(current, rev.Data),
+		})
+		return
+	default:
+		otherID, err := strconv.ParseInt(against, 10, 64)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "against must be a revision ID or current"})
+			return
+		}
+		other, err := revisions.Get(h.db, otherID)
+		if err == revisions.ErrNotFound || (err == nil && (other.Entity != rev.Entity || other.EntityID != rev.EntityID)) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No such revision of the same content"})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		before = other.Data
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"revision": rev.ID,
+		"against":  against,
+		"changes":  revisions.Diff(before, rev.Data),
+	})
+}
+
+// RestoreRevision rolls the content back to a revision, re-creating it
+// when it was deleted. The restore is itself a new revision. Event photos
+// and files whose upload was removed since are left out and listed under
+// missing_media.
+func (h *Handler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	rev, ok := h.loadRevision(w, r)
+	if !ok {
+		return
+	}
+	if rev.Entity == "event" && rev.Data.Row != nil {
+		// events.type has no foreign key to catch this
+		eventType := fmt.Sprint(rev.Data.Row["type"])
+		if exists, _ := h.eventTypeActive(eventType); !exists {
+			respondJSON(w, http.StatusConflict, map[string]string{
+				"error": fmt.Sprintf("The event type %s of this revision was deleted; add it again to restore the revision", eventType),
+			})
+			return
+		}
+	}
+	missing := h.dropMissingMedia(r.Context(), rev.Data)
+
+	base := h.takeBaseline(rev.Entity, rev.EntityID)
+	if err := revisions.Restore(h.db, rev); err != nil {
+		log.Printf("Restoring revision #%d failed: %v", rev.ID, err)
+		if errors.Is(err, revisions.ErrConflict) {
+			respondJSON(w, http.StatusConflict, map[string]string{
+				"error": "This revision refers to content removed since, such as a language; add it again to restore the revision",
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to restore revision"})
+		return
+	}
+	h.recordBaseline(rev.Entity, rev.EntityID, base)
+
+	var author *revisions.Author
+	if user, ok := getUserFromContext(r); ok {
+		author = &revisions.Author{ID: user.UserID, Name: user.Email}
+	}
+	newID, err := revisions.Record(h.db, rev.Entity, rev.EntityID, author, fmt.Sprintf("Restored revision #%d", rev.ID))
+	if err != nil {
+		log.Printf("Failed to record revision of %s %s: %v", rev.Entity, rev.EntityID, err)
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       "Revision restored",
+		"revision":      newID,
+		"missing_media": missing,
+	})
+}
+
+// dropMissingMedia takes the event photos and files whose upload no longer
+// exists out of a snapshot, with their captions, and returns their names
+func (h *Handler) dropMissingMedia(ctx context.Context, s *revisions.Snapshot) []string {
+	missing := []string{}
+	rows, ok := s.Children["event_media"]
+	if !ok {
+		return missing
+	}
+	kept, dropped := []map[string]interface{}{}, map[string]bool{}
+	for _, row := range rows {
+		filename := fmt.Sprint(row["filename"])
+		key := fileKey(filename)
+		if row["kind"] == "image" {
+			key = media.ImageKey(filename)
+		}
+		if _, err := h.store.Stat(ctx, key); err == storage.ErrNotFound {
+			dropped[fmt.Sprint(row["id"])] = true
+			missing = append(missing, fmt.Sprint(row["original_name"]))
+			continue
+		}
+		kept = append(kept, row)
+	}
+	s.Children["event_media"] = kept
+	captions := []map[string]interface{}{}
+	for _, row := range s.Children["event_media_translations"] {
+		if !dropped[fmt.Sprint(row["media_id"])] {
+			captions = append(captions, row)
+		}
+	}
+	if _, ok := s.Children["event_media_translations"]; ok {
+		s.Children["event_media_translations"] = captions
+	}
+	return missing
+}
+
+func (h *Handler) loadRevision(w http.ResponseWriter, r *http.Request) (*revisions.Revision, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid revision ID"})
+		return nil, false
+	}
+	rev, err := revisions.Get(h.db, id)
+	if err == revisions.ErrNotFound {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Revision not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load revision #%d: %v", id, err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return nil, false
+	}
+	return rev, true
+}
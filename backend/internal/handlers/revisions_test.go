@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/media"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/revisions"
+)
+
+// recordRevisionOf saves the current state of an entity and returns the
+// revision ID
+func recordRevisionOf(t *testing.T, h *Handler, entity, id string) string {
+	t.Helper()
+	revisionID, err := revisions.Record(h.db, entity, id, nil, "")
+	if err != nil {
+		t.Fatalf("Record %s %s: %v", entity, id, err)
+	}
+	return strconv.FormatInt(revisionID, 10)
+}
+
+func restoreRevision(h *Handler, revisionID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/admin/revisions/"+revisionID+"/restore", nil)
+	h.RestoreRevision(w, withURLParams(r, "id", revisionID))
+	return w
+}
+
+func newEventWithType(t *testing.T, h *Handler, eventType string) string {
+	t.Helper()
+	id := exec(t, h, `INSERT INTO events (uuid, title, type, start_date, end_date)
+		VALUES ('uuid-1', 'Picknick', ?, '2026-06-01 10:00:00', '2026-06-01 12:00:00')`, eventType)
+	return strconv.FormatInt(id, 10)
+}
+
+func TestRestoreRevisionConflicts(t *testing.T) {
+	h := newTestHandler(t)
+
+	exec(t, h, "INSERT INTO event_types (code, icon, sort_order) VALUES ('PICNIC', '', 10)")
+	eventID := newEventWithType(t, h, "PICNIC")
+	eventRevision := recordRevisionOf(t, h, "event", eventID)
+	exec(t, h, "DELETE FROM events WHERE id = ?", eventID)
+	exec(t, h, "DELETE FROM event_types WHERE code = 'PICNIC'")
+
+	w := restoreRevision(h, eventRevision)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "PICNIC") {
+		t.Errorf("deleted event type: %d %s, want 409 naming the type", w.Code, w.Body)
+	}
+
+	exec(t, h, "INSERT INTO languages (code, name, flag_url, active) VALUES ('IT', 'Italiano', '', 1)")
+	messageID := strconv.FormatInt(exec(t, h, "INSERT INTO messages (state, priority) VALUES ('published', 0)"), 10)
+	exec(t, h, "INSERT INTO message_translations (message_id, lang_code, title, content) VALUES (?, 'IT', 'Ciao', '')", messageID)
+	messageRevision := recordRevisionOf(t, h, "message", messageID)
+	exec(t, h, "DELETE FROM languages WHERE code = 'IT'")
+
+	if w := restoreRevision(h, messageRevision); w.Code != http.StatusConflict {
+		t.Errorf("removed language: %d %s, want 409", w.Code, w.Body)
+	}
+}
+
+func TestRestoreRevisionMissingMedia(t *testing.T) {
+	h := newTestHandler(t)
+	eventID := newEventWithType(t, h, "REGULAR")
+	exec(t, h, "INSERT INTO event_media (event_id, kind, filename, original_name) VALUES (?, 'image', 'kept.jpg', 'vijver.jpg')", eventID)
+	removed := exec(t, h, "INSERT INTO event_media (event_id, kind, filename, original_name) VALUES (?, 'file', 'gone.pdf', 'route.pdf')", eventID)
+	exec(t, h, "INSERT INTO event_media_translations (media_id, lang_code, caption) VALUES (?, 'NL', 'Route')", removed)
+	if err := h.store.Put(context.Background(), media.ImageKey("kept.jpg"), []byte("jpeg"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	revisionID := recordRevisionOf(t, h, "event", eventID)
+	exec(t, h, "DELETE FROM events WHERE id = ?", eventID)
+
+	w := restoreRevision(h, revisionID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		MissingMedia []string `json:"missing_media"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.MissingMedia) != 1 || resp.MissingMedia[0] != "route.pdf" {
+		t.Errorf("missing_media = %v, want [route.pdf]", resp.MissingMedia)
+	}
+
+	var filenames []string
+	rows, err := h.db.Query("SELECT filename FROM event_media WHERE event_id = ?", eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f string
+		rows.Scan(&f)
+		filenames = append(filenames, f)
+	}
+	if len(filenames) != 1 || filenames[0] != "kept.jpg" {
+		t.Errorf("restored media = %v, want [kept.jpg]", filenames)
+	}
+}
+
+func TestFailedUpdateKeepsHistory(t *testing.T) {
+	h := newTestHandler(t)
+	messageID := strconv.FormatInt(exec(t, h, "INSERT INTO messages (state, priority) VALUES ('draft', 0)"), 10)
+	exec(t, h, `CREATE TRIGGER refuse_update BEFORE UPDATE ON messages BEGIN SELECT RAISE(ABORT, 'refused'); END`)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("PUT", "/admin/messages/"+messageID, strings.NewReader(`{"state": "published", "priority": 1}`))
+	h.UpdateMessage(w, withURLParams(r, "id", messageID))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("update: %d %s, want 500", w.Code, w.Body)
+	}
+
+	var count int
+	h.db.QueryRow("SELECT COUNT(*) FROM revisions WHERE entity = 'message' AND entity_id = ?", messageID).Scan(&count)
+	if count != 0 {
+		t.Errorf("%d revisions after a failed update, want none", count)
+	}
+}
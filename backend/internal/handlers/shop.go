@@ -4,24 +4,43 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/pretix"
 	"github.com/go-chi/chi/v5"
 )
 
 type ShopSettings struct {
+	PaymentProvider      string `json:"payment_provider"`
 	StripeSecretKey      string `json:"stripe_secret_key"`
 	StripePublishableKey string `json:"stripe_publishable_key"`
 	StripeWebhookSecret  string `json:"stripe_webhook_secret"`
+	MollieAPIKey         string `json:"mollie_api_key"`
 	PretixWidgetURL      string `json:"pretix_widget_url"`
+	PretixAPIURL         string `json:"pretix_api_url"`
+	PretixOrganizer      string `json:"pretix_organizer"`
+	PretixAPIToken       string `json:"pretix_api_token"`
 	Currency             string `json:"currency"`
+
+	InvoiceOrgName          string  `json:"invoice_org_name"`
+	InvoiceOrgAddress       string  `json:"invoice_org_address"`
+	InvoiceEnterpriseNumber string  `json:"invoice_enterprise_number"`
+	InvoiceVATNumber        string  `json:"invoice_vat_number"`
+	InvoiceVATRate          float64 `json:"invoice_vat_rate"`
+	InvoiceVATNote          string  `json:"invoice_vat_note"`
 }
 
 type ShopItemTranslation struct {
 	LangCode    string `json:"lang_code"`
 	Description string `json:"description"`
+	// Fallback is set when this stands in for the requested language
+	Fallback bool `json:"fallback,omitempty"`
+	// MachineTranslated marks a machine translation draft that an admin
+	// has not confirmed yet; drafts are not shown publicly
+	MachineTranslated bool `json:"machine_translated,omitempty"`
 }
 
 type ShopItem struct {
@@ -30,6 +49,7 @@ type ShopItem struct {
 	Description       string                `json:"description"`
 	PriceCents        int                   `json:"price_cents"`
 	PriceDisplay      string                `json:"price_display"`
+	MemberPriceCents  *int                  `json:"member_price_cents,omitempty"`
 	ImageURL          string                `json:"image_url,omitempty"`
 	StockQuantity     *int                  `json:"stock_quantity,omitempty"`
 	AllowPickup       bool                  `json:"allow_pickup"`
@@ -43,25 +63,38 @@ type ShopItem struct {
 }
 
 type ShopOrder struct {
-	ID                  int64  `json:"id"`
-	ItemID              int64  `json:"item_id"`
-	ItemTitle           string `json:"item_title"`
-	StripeSessionID     string `json:"stripe_session_id,omitempty"`
-	StripePaymentIntent string `json:"stripe_payment_intent_id,omitempty"`
-	BuyerEmail          string `json:"buyer_email"`
-	Quantity            int    `json:"quantity"`
-	AmountCents         int    `json:"amount_cents"`
-	AmountDisplay       string `json:"amount_display"`
-	FulfillmentType     string `json:"fulfillment_type"`
-	ShippingName        string `json:"shipping_name,omitempty"`
-	ShippingAddress     string `json:"shipping_address,omitempty"`
-	ShippingCity        string `json:"shipping_city,omitempty"`
-	ShippingPostalCode  string `json:"shipping_postal_code,omitempty"`
-	ShippingCountry     string `json:"shipping_country,omitempty"`
-	Status              string `json:"status"`
-	Notes               string `json:"notes,omitempty"`
-	CreatedAt           string `json:"created_at"`
-	UpdatedAt           string `json:"updated_at"`
+	ID                  int64   `json:"id"`
+	ItemID              int64   `json:"item_id"`
+	ItemTitle           string  `json:"item_title"`
+	StripeSessionID     string  `json:"stripe_session_id,omitempty"`
+	StripePaymentIntent string  `json:"stripe_payment_intent_id,omitempty"`
+	PaymentProvider     string  `json:"payment_provider"`
+	ProviderPaymentID   string  `json:"provider_payment_id,omitempty"`
+	BuyerEmail          string  `json:"buyer_email"`
+	LangCode            string  `json:"lang_code"`
+	Quantity            int     `json:"quantity"`
+	AmountCents         int     `json:"amount_cents"`
+	RefundedCents       int     `json:"refunded_cents"`
+	DiscountCode        string  `json:"discount_code,omitempty"`
+	DiscountCents       int     `json:"discount_cents,omitempty"`
+	Token               string  `json:"token"`
+	PickupSlotID        int64   `json:"pickup_slot_id,omitempty"`
+	PickedUpAt          *string `json:"picked_up_at,omitempty"`
+	AmountDisplay       string  `json:"amount_display"`
+	FulfillmentType     string  `json:"fulfillment_type"`
+	ShippingName        string  `json:"shipping_name,omitempty"`
+	ShippingAddress     string  `json:"shipping_address,omitempty"`
+	ShippingCity        string  `json:"shipping_city,omitempty"`
+	ShippingPostalCode  string  `json:"shipping_postal_code,omitempty"`
+	ShippingCountry     string  `json:"shipping_country,omitempty"`
+	Status              string  `json:"status"`
+	Carrier             string  `json:"carrier,omitempty"`
+	TrackingNumber      string  `json:"tracking_number,omitempty"`
+	TrackingURL         string  `json:"tracking_url,omitempty"`
+	ShippedAt           *string `json:"shipped_at,omitempty"`
+	Notes               string  `json:"notes,omitempty"`
+	CreatedAt           string  `json:"created_at"`
+	UpdatedAt           string  `json:"updated_at"`
 }
 
 func formatPrice(cents int, currency string) string {
@@ -74,8 +107,15 @@ func formatPrice(cents int, currency string) string {
 
 func (h *Handler) getShopSettings() (ShopSettings, error) {
 	var s ShopSettings
-	err := h.db.QueryRow(`SELECT stripe_secret_key, stripe_publishable_key, stripe_webhook_secret, pretix_widget_url, currency FROM shop_settings WHERE id = 1`).
-		Scan(&s.StripeSecretKey, &s.StripePublishableKey, &s.StripeWebhookSecret, &s.PretixWidgetURL, &s.Currency)
+	err := h.db.QueryRow(`
+		SELECT payment_provider, stripe_secret_key, stripe_publishable_key, stripe_webhook_secret, mollie_api_key,
+		       pretix_widget_url, pretix_api_url, pretix_organizer, pretix_api_token,
+		       currency, invoice_org_name, invoice_org_address, invoice_enterprise_number,
+		       invoice_vat_number, invoice_vat_rate, invoice_vat_note
+		FROM shop_settings WHERE id = 1
+	`).Scan(&s.PaymentProvider, &s.StripeSecretKey, &s.StripePublishableKey, &s.StripeWebhookSecret, &s.MollieAPIKey,
+		&s.PretixWidgetURL, &s.PretixAPIURL, &s.PretixOrganizer, &s.PretixAPIToken, &s.Currency, &s.InvoiceOrgName, &s.InvoiceOrgAddress, &s.InvoiceEnterpriseNumber,
+		&s.InvoiceVATNumber, &s.InvoiceVATRate, &s.InvoiceVATNote)
 	if err != nil {
 		return s, err
 	}
@@ -87,14 +127,14 @@ func scanShopItem(rows interface {
 }) (ShopItem, error) {
 	var item ShopItem
 	var imageURL sql.NullString
-	var stockQty sql.NullInt64
+	var stockQty, memberPrice sql.NullInt64
 	var allowPickup, allowShipping, autoConfirm, active int
 	var shippingRegionsJSON string
 
 	err := rows.Scan(
 		&item.ID, &item.Title, &item.Description, &item.PriceCents,
 		&imageURL, &stockQty, &allowPickup, &item.PickupLabel,
-		&allowShipping, &shippingRegionsJSON, &autoConfirm, &active, &item.SortOrder,
+		&allowShipping, &shippingRegionsJSON, &autoConfirm, &active, &item.SortOrder, &memberPrice,
 	)
 	if err != nil {
 		return item, err
@@ -107,6 +147,10 @@ func scanShopItem(rows interface {
 		q := int(stockQty.Int64)
 		item.StockQuantity = &q
 	}
+	if memberPrice.Valid {
+		p := int(memberPrice.Int64)
+		item.MemberPriceCents = &p
+	}
 	if shippingRegionsJSON != "" && shippingRegionsJSON != "[]" {
 		json.Unmarshal([]byte(shippingRegionsJSON), &item.ShippingCountries)
 	}
@@ -121,6 +165,7 @@ func (h *Handler) GetPublicShopSettings(w http.ResponseWriter, r *http.Request)
 	s, err := h.getShopSettings()
 	if err != nil {
 		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"payment_provider":       "stripe",
 			"stripe_publishable_key": "",
 			"pretix_widget_url":      "",
 			"currency":               "EUR",
@@ -129,6 +174,7 @@ func (h *Handler) GetPublicShopSettings(w http.ResponseWriter, r *http.Request)
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"payment_provider":       s.PaymentProvider,
 		"stripe_publishable_key": s.StripePublishableKey,
 		"pretix_widget_url":      s.PretixWidgetURL,
 		"currency":               s.Currency,
@@ -154,19 +200,73 @@ func (h *Handler) UpdateShopSettings(w http.ResponseWriter, r *http.Request) {
 	req.StripeSecretKey = strings.TrimSpace(req.StripeSecretKey)
 	req.StripePublishableKey = strings.TrimSpace(req.StripePublishableKey)
 	req.StripeWebhookSecret = strings.TrimSpace(req.StripeWebhookSecret)
+	req.MollieAPIKey = strings.TrimSpace(req.MollieAPIKey)
 	req.PretixWidgetURL = truncateString(strings.TrimSpace(req.PretixWidgetURL), 500)
+	req.PretixAPIURL = strings.TrimSuffix(strings.TrimSpace(req.PretixAPIURL), "/")
+	req.PretixOrganizer = strings.TrimSpace(req.PretixOrganizer)
+	req.PretixAPIToken = strings.TrimSpace(req.PretixAPIToken)
+	req.PaymentProvider = strings.TrimSpace(req.PaymentProvider)
+	req.InvoiceOrgName = truncateString(strings.TrimSpace(req.InvoiceOrgName), 200)
+	req.InvoiceOrgAddress = truncateString(strings.TrimSpace(req.InvoiceOrgAddress), 500)
+	req.InvoiceEnterpriseNumber = truncateString(strings.TrimSpace(req.InvoiceEnterpriseNumber), 50)
+	req.InvoiceVATNumber = truncateString(strings.TrimSpace(req.InvoiceVATNumber), 50)
+	req.InvoiceVATNote = truncateString(strings.TrimSpace(req.InvoiceVATNote), 500)
+
+	if req.PaymentProvider == "" {
+		req.PaymentProvider = "stripe"
+	}
+	validProviders := map[string]bool{"stripe": true, "mollie": true, "fake": h.cfg.IsDevelopment()}
+	if !validProviders[req.PaymentProvider] {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid payment provider"})
+		return
+	}
 
 	if len(req.StripeSecretKey) > 200 || len(req.StripePublishableKey) > 200 || len(req.StripeWebhookSecret) > 200 {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Stripe key values are too long"})
 		return
 	}
+	if len(req.MollieAPIKey) > 200 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Mollie API key is too long"})
+		return
+	}
+	if req.PretixAPIURL == "" {
+		req.PretixAPIURL = pretix.DefaultAPIURL
+	}
+	if len(req.PretixAPIURL) > 500 || !(strings.HasPrefix(req.PretixAPIURL, "https://") || strings.HasPrefix(req.PretixAPIURL, "http://")) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid pretix API URL"})
+		return
+	}
+	if req.PretixOrganizer != "" && !pretixSlugRegex.MatchString(req.PretixOrganizer) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid pretix organizer"})
+		return
+	}
+	if len(req.PretixAPIToken) > 200 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Pretix API token is too long"})
+		return
+	}
+	if req.InvoiceOrgName == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invoice organisation name is required"})
+		return
+	}
+	if req.InvoiceVATRate < 0 || req.InvoiceVATRate > 100 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid VAT rate"})
+		return
+	}
 
 	_, err := h.db.Exec(`
 		UPDATE shop_settings SET
-			stripe_secret_key = ?, stripe_publishable_key = ?, stripe_webhook_secret = ?,
-			pretix_widget_url = ?, currency = ?, updated_at = CURRENT_TIMESTAMP
+			payment_provider = ?, stripe_secret_key = ?, stripe_publishable_key = ?, stripe_webhook_secret = ?,
+			mollie_api_key = ?, pretix_widget_url = ?, pretix_api_url = ?, pretix_organizer = ?,
+			pretix_api_token = ?, currency = ?,
+			invoice_org_name = ?, invoice_org_address = ?, invoice_enterprise_number = ?,
+			invoice_vat_number = ?, invoice_vat_rate = ?, invoice_vat_note = ?,
+			updated_at = CURRENT_TIMESTAMP
 		WHERE id = 1
-	`, req.StripeSecretKey, req.StripePublishableKey, req.StripeWebhookSecret, req.PretixWidgetURL, "EUR")
+	`, req.PaymentProvider, req.StripeSecretKey, req.StripePublishableKey, req.StripeWebhookSecret,
+		req.MollieAPIKey, req.PretixWidgetURL, req.PretixAPIURL, req.PretixOrganizer,
+		req.PretixAPIToken, "EUR",
+		req.InvoiceOrgName, req.InvoiceOrgAddress, req.InvoiceEnterpriseNumber,
+		req.InvoiceVATNumber, req.InvoiceVATRate, req.InvoiceVATNote)
 
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update shop settings"})
@@ -178,12 +278,12 @@ func (h *Handler) UpdateShopSettings(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) GetPublicShopItems(w http.ResponseWriter, r *http.Request) {
 	settings, _ := h.getShopSettings()
-	lang := r.URL.Query().Get("lang")
+	lang := h.requestLanguage(w, r)
 
 	rows, err := h.db.Query(`
 		SELECT id, title, description, price_cents, image_url, stock_quantity,
 		       allow_pickup, pickup_label, allow_shipping, shipping_regions,
-		       auto_confirm, active, sort_order
+		       auto_confirm, active, sort_order, member_price_cents
 		FROM shop_items WHERE active = 1 ORDER BY sort_order, id
 	`)
 	if err != nil {
@@ -200,7 +300,7 @@ func (h *Handler) GetPublicShopItems(w http.ResponseWriter, r *http.Request) {
 		}
 		item.PriceDisplay = formatPrice(item.PriceCents, settings.Currency)
 		if lang != "" {
-			item.Translations = h.getShopItemTranslations(item.ID, lang)
+			item.Translations = h.getShopItemTranslations(item.ID, lang, false)
 			for _, t := range item.Translations {
 				if t.Description != "" {
 					item.Description = t.Description
@@ -220,7 +320,7 @@ func (h *Handler) GetAdminShopItems(w http.ResponseWriter, r *http.Request) {
 	rows, err := h.db.Query(`
 		SELECT id, title, description, price_cents, image_url, stock_quantity,
 		       allow_pickup, pickup_label, allow_shipping, shipping_regions,
-		       auto_confirm, active, sort_order
+		       auto_confirm, active, sort_order, member_price_cents
 		FROM shop_items ORDER BY sort_order, id
 	`)
 	if err != nil {
@@ -236,7 +336,7 @@ func (h *Handler) GetAdminShopItems(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		item.PriceDisplay = formatPrice(item.PriceCents, settings.Currency)
-		item.Translations = h.getShopItemTranslations(item.ID, "")
+		item.Translations = h.getShopItemTranslations(item.ID, "", true)
 		items = append(items, item)
 	}
 
@@ -250,7 +350,7 @@ func (h *Handler) GetShopItemByID(w http.ResponseWriter, r *http.Request) {
 	item, err := scanShopItem(h.db.QueryRow(`
 		SELECT id, title, description, price_cents, image_url, stock_quantity,
 		       allow_pickup, pickup_label, allow_shipping, shipping_regions,
-		       auto_confirm, active, sort_order
+		       auto_confirm, active, sort_order, member_price_cents
 		FROM shop_items WHERE id = ?
 	`, id))
 
@@ -264,7 +364,7 @@ func (h *Handler) GetShopItemByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	item.PriceDisplay = formatPrice(item.PriceCents, settings.Currency)
-	item.Translations = h.getShopItemTranslations(item.ID, "")
+	item.Translations = h.getShopItemTranslations(item.ID, "", true)
 	respondJSON(w, http.StatusOK, item)
 }
 
@@ -319,6 +419,10 @@ func (h *Handler) CreateShopItem(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Stock must be between 0 and 999999"})
 		return
 	}
+	if item.MemberPriceCents != nil && (!validatePriceCents(*item.MemberPriceCents) || *item.MemberPriceCents > item.PriceCents) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Member price must be positive and not above the regular price"})
+		return
+	}
 	if !item.AllowPickup && !item.AllowShipping {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one fulfillment option (pickup or shipping) is required"})
 		return
@@ -331,11 +435,11 @@ func (h *Handler) CreateShopItem(w http.ResponseWriter, r *http.Request) {
 	result, err := h.db.Exec(`
 		INSERT INTO shop_items (title, description, price_cents, image_url, stock_quantity,
 		                        allow_pickup, pickup_label, allow_shipping, shipping_regions,
-		                        auto_confirm, active, sort_order)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		                        auto_confirm, active, sort_order, member_price_cents)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, item.Title, item.Description, item.PriceCents, item.ImageURL, nullableInt(item.StockQuantity),
 		boolToInt(item.AllowPickup), item.PickupLabel, boolToInt(item.AllowShipping), shippingCountriesJSON,
-		boolToInt(item.AutoConfirm), boolToInt(item.Active), item.SortOrder)
+		boolToInt(item.AutoConfirm), boolToInt(item.Active), item.SortOrder, nullableInt(item.MemberPriceCents))
 
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create item"})
@@ -344,6 +448,7 @@ func (h *Handler) CreateShopItem(w http.ResponseWriter, r *http.Request) {
 
 	item.ID, _ = result.LastInsertId()
 	h.saveShopItemTranslations(item.ID, item.Translations)
+	h.recordRevision(r, "shop_item", strconv.FormatInt(item.ID, 10), nil)
 	settings, _ := h.getShopSettings()
 	item.PriceDisplay = formatPrice(item.PriceCents, settings.Currency)
 	respondJSON(w, http.StatusCreated, item)
@@ -402,6 +507,10 @@ func (h *Handler) UpdateShopItem(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Stock must be between 0 and 999999"})
 		return
 	}
+	if item.MemberPriceCents != nil && (!validatePriceCents(*item.MemberPriceCents) || *item.MemberPriceCents > item.PriceCents) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Member price must be positive and not above the regular price"})
+		return
+	}
 	if !item.AllowPickup && !item.AllowShipping {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one fulfillment option (pickup or shipping) is required"})
 		return
@@ -411,15 +520,16 @@ func (h *Handler) UpdateShopItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	base := h.takeBaseline("shop_item", id)
 	_, err := h.db.Exec(`
 		UPDATE shop_items SET
 			title = ?, description = ?, price_cents = ?, image_url = ?, stock_quantity = ?,
 			allow_pickup = ?, pickup_label = ?, allow_shipping = ?, shipping_regions = ?,
-			auto_confirm = ?, active = ?, sort_order = ?, updated_at = CURRENT_TIMESTAMP
+			auto_confirm = ?, active = ?, sort_order = ?, member_price_cents = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`, item.Title, item.Description, item.PriceCents, item.ImageURL, nullableInt(item.StockQuantity),
 		boolToInt(item.AllowPickup), item.PickupLabel, boolToInt(item.AllowShipping), shippingCountriesJSON,
-		boolToInt(item.AutoConfirm), boolToInt(item.Active), item.SortOrder, id)
+		boolToInt(item.AutoConfirm), boolToInt(item.Active), item.SortOrder, nullableInt(item.MemberPriceCents), id)
 
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update item"})
@@ -429,6 +539,7 @@ func (h *Handler) UpdateShopItem(w http.ResponseWriter, r *http.Request) {
 	idInt, _ := strconv.ParseInt(id, 10, 64)
 	item.ID = idInt
 	h.saveShopItemTranslations(item.ID, item.Translations)
+	h.recordRevision(r, "shop_item", id, base)
 	settings, _ := h.getShopSettings()
 	item.PriceDisplay = formatPrice(item.PriceCents, settings.Currency)
 	respondJSON(w, http.StatusOK, item)
@@ -437,11 +548,13 @@ func (h *Handler) UpdateShopItem(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) DeleteShopItem(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
+	base := h.takeBaseline("shop_item", id)
 	_, err := h.db.Exec("DELETE FROM shop_items WHERE id = ?", id)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete item"})
 		return
 	}
+	h.recordBaseline("shop_item", id, base)
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Item deleted"})
 }
@@ -455,10 +568,11 @@ func (h *Handler) GetAdminShopOrders(w http.ResponseWriter, r *http.Request) {
 
 	if status != "" {
 		rows, err = h.db.Query(`
-			SELECT o.id, o.item_id, COALESCE(i.title, '(deleted)'), o.stripe_session_id,
-			       o.stripe_payment_intent_id, o.buyer_email, o.quantity, o.amount_cents,
+			SELECT o.id, o.item_id, COALESCE(i.title, '(deleted)'), COALESCE(o.stripe_session_id, ''),
+			       COALESCE(o.stripe_payment_intent_id, ''), o.payment_provider, COALESCE(o.provider_payment_id, ''), o.buyer_email, o.lang_code, o.quantity, o.amount_cents, o.refunded_cents, o.discount_code, o.discount_cents, COALESCE(o.token, ''),
+			       COALESCE(o.pickup_slot_id, 0), o.picked_up_at, o.carrier, o.shipped_at,
 			       o.fulfillment_type, o.shipping_name, o.shipping_address, o.shipping_city,
-			       o.shipping_postal_code, o.shipping_country, o.status, o.notes,
+			       o.shipping_postal_code, o.shipping_country, o.status, o.tracking_number, o.notes,
 			       o.created_at, o.updated_at
 			FROM shop_orders o
 			LEFT JOIN shop_items i ON o.item_id = i.id
@@ -467,10 +581,11 @@ func (h *Handler) GetAdminShopOrders(w http.ResponseWriter, r *http.Request) {
 		`, status)
 	} else {
 		rows, err = h.db.Query(`
-			SELECT o.id, o.item_id, COALESCE(i.title, '(deleted)'), o.stripe_session_id,
-			       o.stripe_payment_intent_id, o.buyer_email, o.quantity, o.amount_cents,
+			SELECT o.id, o.item_id, COALESCE(i.title, '(deleted)'), COALESCE(o.stripe_session_id, ''),
+			       COALESCE(o.stripe_payment_intent_id, ''), o.payment_provider, COALESCE(o.provider_payment_id, ''), o.buyer_email, o.lang_code, o.quantity, o.amount_cents, o.refunded_cents, o.discount_code, o.discount_cents, COALESCE(o.token, ''),
+			       COALESCE(o.pickup_slot_id, 0), o.picked_up_at, o.carrier, o.shipped_at,
 			       o.fulfillment_type, o.shipping_name, o.shipping_address, o.shipping_city,
-			       o.shipping_postal_code, o.shipping_country, o.status, o.notes,
+			       o.shipping_postal_code, o.shipping_country, o.status, o.tracking_number, o.notes,
 			       o.created_at, o.updated_at
 			FROM shop_orders o
 			LEFT JOIN shop_items i ON o.item_id = i.id
@@ -479,9 +594,11 @@ func (h *Handler) GetAdminShopOrders(w http.ResponseWriter, r *http.Request) {
 					WHEN 'pending' THEN 1
 					WHEN 'paid' THEN 2
 					WHEN 'confirmed' THEN 3
-					WHEN 'shipped' THEN 4
-					WHEN 'fulfilled' THEN 5
-					ELSE 6
+					WHEN 'ready_for_pickup' THEN 4
+					WHEN 'shipped' THEN 5
+					WHEN 'fulfilled' THEN 6
+					WHEN 'completed' THEN 7
+					ELSE 8
 				END,
 				o.created_at DESC
 		`)
@@ -498,13 +615,14 @@ func (h *Handler) GetAdminShopOrders(w http.ResponseWriter, r *http.Request) {
 		var o ShopOrder
 		if err := rows.Scan(
 			&o.ID, &o.ItemID, &o.ItemTitle, &o.StripeSessionID, &o.StripePaymentIntent,
-			&o.BuyerEmail, &o.Quantity, &o.AmountCents, &o.FulfillmentType,
+			&o.PaymentProvider, &o.ProviderPaymentID, &o.BuyerEmail, &o.LangCode, &o.Quantity, &o.AmountCents, &o.RefundedCents, &o.DiscountCode, &o.DiscountCents, &o.Token, &o.PickupSlotID, &o.PickedUpAt, &o.Carrier, &o.ShippedAt, &o.FulfillmentType,
 			&o.ShippingName, &o.ShippingAddress, &o.ShippingCity, &o.ShippingPostalCode,
-			&o.ShippingCountry, &o.Status, &o.Notes, &o.CreatedAt, &o.UpdatedAt,
+			&o.ShippingCountry, &o.Status, &o.TrackingNumber, &o.Notes, &o.CreatedAt, &o.UpdatedAt,
 		); err != nil {
 			continue
 		}
 		o.AmountDisplay = formatPrice(o.AmountCents, settings.Currency)
+		o.TrackingURL = trackingURL(o.Carrier, o.TrackingNumber, o.ShippingPostalCode, o.ShippingCountry)
 		orders = append(orders, o)
 	}
 
@@ -517,19 +635,20 @@ func (h *Handler) GetShopOrderByID(w http.ResponseWriter, r *http.Request) {
 
 	var o ShopOrder
 	err := h.db.QueryRow(`
-		SELECT o.id, o.item_id, COALESCE(i.title, '(deleted)'), o.stripe_session_id,
-		       o.stripe_payment_intent_id, o.buyer_email, o.quantity, o.amount_cents,
+		SELECT o.id, o.item_id, COALESCE(i.title, '(deleted)'), COALESCE(o.stripe_session_id, ''),
+		       COALESCE(o.stripe_payment_intent_id, ''), o.payment_provider, COALESCE(o.provider_payment_id, ''), o.buyer_email, o.lang_code, o.quantity, o.amount_cents, o.refunded_cents, o.discount_code, o.discount_cents, COALESCE(o.token, ''),
+			       COALESCE(o.pickup_slot_id, 0), o.picked_up_at, o.carrier, o.shipped_at,
 		       o.fulfillment_type, o.shipping_name, o.shipping_address, o.shipping_city,
-		       o.shipping_postal_code, o.shipping_country, o.status, o.notes,
+		       o.shipping_postal_code, o.shipping_country, o.status, o.tracking_number, o.notes,
 		       o.created_at, o.updated_at
 		FROM shop_orders o
 		LEFT JOIN shop_items i ON o.item_id = i.id
 		WHERE o.id = ?
 	`, id).Scan(
 		&o.ID, &o.ItemID, &o.ItemTitle, &o.StripeSessionID, &o.StripePaymentIntent,
-		&o.BuyerEmail, &o.Quantity, &o.AmountCents, &o.FulfillmentType,
+		&o.PaymentProvider, &o.ProviderPaymentID, &o.BuyerEmail, &o.LangCode, &o.Quantity, &o.AmountCents, &o.RefundedCents, &o.DiscountCode, &o.DiscountCents, &o.Token, &o.PickupSlotID, &o.PickedUpAt, &o.Carrier, &o.ShippedAt, &o.FulfillmentType,
 		&o.ShippingName, &o.ShippingAddress, &o.ShippingCity, &o.ShippingPostalCode,
-		&o.ShippingCountry, &o.Status, &o.Notes, &o.CreatedAt, &o.UpdatedAt,
+		&o.ShippingCountry, &o.Status, &o.TrackingNumber, &o.Notes, &o.CreatedAt, &o.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -542,6 +661,7 @@ func (h *Handler) GetShopOrderByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	o.AmountDisplay = formatPrice(o.AmountCents, settings.Currency)
+	o.TrackingURL = trackingURL(o.Carrier, o.TrackingNumber, o.ShippingPostalCode, o.ShippingCountry)
 	respondJSON(w, http.StatusOK, o)
 }
 
@@ -549,8 +669,10 @@ func (h *Handler) UpdateShopOrderStatus(w http.ResponseWriter, r *http.Request)
 	id := chi.URLParam(r, "id")
 
 	var update struct {
-		Status string `json:"status"`
-		Notes  string `json:"notes"`
+		Status         string `json:"status"`
+		Notes          string `json:"notes"`
+		TrackingNumber string `json:"tracking_number"`
+		Carrier        string `json:"carrier"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
@@ -559,26 +681,62 @@ func (h *Handler) UpdateShopOrderStatus(w http.ResponseWriter, r *http.Request)
 
 	update.Status = strings.TrimSpace(update.Status)
 	update.Notes = truncateString(strings.TrimSpace(update.Notes), maxStringLength)
+	update.TrackingNumber = truncateString(strings.TrimSpace(update.TrackingNumber), 100)
+	carrier, ok := normalizeCarrier(update.Carrier)
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown carrier"})
+		return
+	}
 
 	validStatuses := map[string]bool{
-		"pending": true, "paid": true, "confirmed": true,
-		"shipped": true, "fulfilled": true, "cancelled": true,
+		"pending": true, "paid": true, "confirmed": true, "ready_for_pickup": true,
+		"shipped": true, "fulfilled": true, "completed": true, "cancelled": true,
+		"refunded": true, "disputed": true,
 	}
 	if !validStatuses[update.Status] {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid status"})
 		return
 	}
 
-	_, err := h.db.Exec(`
-		UPDATE shop_orders SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP
+	var orderID int64
+	var oldStatus string
+	err := h.db.QueryRow("SELECT id, status FROM shop_orders WHERE id = ?", id).Scan(&orderID, &oldStatus)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	_, err = h.db.Exec(`
+		UPDATE shop_orders SET status = ?, notes = ?,
+			tracking_number = CASE WHEN ? != '' THEN ? ELSE tracking_number END,
+			carrier = CASE WHEN ? != '' THEN ? ELSE carrier END,
+			picked_up_at = CASE WHEN ? = 'completed' THEN COALESCE(picked_up_at, CURRENT_TIMESTAMP) ELSE picked_up_at END,
+			shipped_at = CASE WHEN ? = 'shipped' THEN COALESCE(shipped_at, CURRENT_TIMESTAMP) ELSE shipped_at END,
+			refunded_cents = CASE WHEN ? = 'refunded' THEN amount_cents ELSE refunded_cents END,
+			updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
-	`, update.Status, update.Notes, id)
+	`, update.Status, update.Notes, update.TrackingNumber, update.TrackingNumber, carrier, carrier,
+		update.Status, update.Status, update.Status, orderID)
 
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update order"})
 		return
 	}
 
+	// An order marked paid by hand gets its invoice like a paid checkout
+	if !invoiceableStatuses[oldStatus] && invoiceableStatuses[update.Status] {
+		if _, err := h.issueInvoice(orderID); err != nil {
+			log.Printf("Failed to issue invoice for order #%d: %v", orderID, err)
+		}
+	}
+	if update.Status != oldStatus {
+		h.notifyOrderStatus(orderID, update.Status)
+	}
+
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Order updated"})
 }
 
@@ -596,16 +754,18 @@ func nullableInt(v *int) interface{} {
 	return *v
 }
 
-func (h *Handler) getShopItemTranslations(itemID int64, langFilter string) []ShopItemTranslation {
-	var rows *sql.Rows
-	var err error
-
-	if langFilter != "" {
-		rows, err = h.db.Query("SELECT lang_code, description FROM shop_item_translations WHERE item_id = ? AND lang_code = ?", itemID, langFilter)
-	} else {
-		rows, err = h.db.Query("SELECT lang_code, description FROM shop_item_translations WHERE item_id = ?", itemID)
+func nullableInt64(v int64) interface{} {
+	if v == 0 {
+		return nil
 	}
+	return v
+}
 
+// getShopItemTranslations returns all translations of an item, or with a
+// langFilter the one in that language or else its first fallback. Machine
+// translation drafts are only included with drafts set.
+func (h *Handler) getShopItemTranslations(itemID int64, langFilter string, drafts bool) []ShopItemTranslation {
+	rows, err := h.db.Query("SELECT lang_code, description, machine_translated FROM shop_item_translations WHERE item_id = ? ORDER BY lang_code", itemID)
 	if err != nil {
 		return []ShopItemTranslation{}
 	}
@@ -614,18 +774,33 @@ func (h *Handler) getShopItemTranslations(itemID int64, langFilter string) []Sho
 	translations := []ShopItemTranslation{}
 	for rows.Next() {
 		var t ShopItemTranslation
-		if err := rows.Scan(&t.LangCode, &t.Description); err != nil {
+		if err := rows.Scan(&t.LangCode, &t.Description, &t.MachineTranslated); err != nil || (t.MachineTranslated && !drafts) {
 			continue
 		}
 		translations = append(translations, t)
 	}
-	return translations
+	if langFilter == "" {
+		return translations
+	}
+
+	langs := make([]string, len(translations))
+	texts := make([]string, len(translations))
+	for i, t := range translations {
+		langs[i], texts[i] = t.LangCode, t.Description
+	}
+	i := pickTranslation(h.translationChain(langFilter), langs, texts)
+	if i < 0 {
+		return []ShopItemTranslation{}
+	}
+	t := translations[i]
+	t.Fallback = !strings.EqualFold(t.LangCode, langFilter)
+	return []ShopItemTranslation{t}
 }
 
 func (h *Handler) saveShopItemTranslations(itemID int64, translations []ShopItemTranslation) {
 	for _, t := range translations {
 		desc := truncateString(strings.TrimSpace(t.Description), maxStringLength)
-		h.db.Exec(`INSERT OR REPLACE INTO shop_item_translations (item_id, lang_code, description) VALUES (?, ?, ?)`,
-			itemID, t.LangCode, desc)
+		h.db.Exec(`INSERT OR REPLACE INTO shop_item_translations (item_id, lang_code, description, machine_translated) VALUES (?, ?, ?, ?)`,
+			itemID, t.LangCode, desc, t.MachineTranslated)
 	}
 }
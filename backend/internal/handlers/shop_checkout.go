@@ -0,0 +1,633 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/payment"
+	"github.com/go-chi/chi/v5"
+)
+
+type createCheckoutRequest struct {
+	ItemID          int64  `json:"item_id"`
+	Quantity        int    `json:"quantity"`
+	FulfillmentType string `json:"fulfillment_type"`
+	BuyerEmail      string `json:"buyer_email"`
+	ShippingName    string `json:"shipping_name"`
+	ShippingAddress string `json:"shipping_address"`
+	ShippingCity    string `json:"shipping_city"`
+	ShippingPostal  string `json:"shipping_postal_code"`
+	ShippingCountry string `json:"shipping_country"`
+	LangCode        string `json:"lang"`
+	DiscountCode    string `json:"discount_code"`
+	PickupSlotID    *int64 `json:"pickup_slot_id"`
+}
+
+func (h *Handler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request) {
+	var req createCheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if req.ItemID == 0 || !validateQuantity(req.Quantity) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid item or quantity (max 999)"})
+		return
+	}
+
+	if req.FulfillmentType != "pickup" && req.FulfillmentType != "shipping" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid fulfillment type"})
+		return
+	}
+
+	if !validateEmail(req.BuyerEmail) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "A valid email is required"})
+		return
+	}
+
+	if req.FulfillmentType == "shipping" {
+		if !validateCountryCode(req.ShippingCountry) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid or unsupported shipping country"})
+			return
+		}
+		req.ShippingName = truncateString(strings.TrimSpace(req.ShippingName), 200)
+		req.ShippingAddress = truncateString(strings.TrimSpace(req.ShippingAddress), 500)
+		req.ShippingCity = truncateString(strings.TrimSpace(req.ShippingCity), 100)
+		req.ShippingPostal = truncateString(strings.TrimSpace(req.ShippingPostal), 20)
+		if req.ShippingName == "" || req.ShippingAddress == "" || req.ShippingCity == "" || req.ShippingPostal == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "All shipping fields are required"})
+			return
+		}
+	}
+
+	req.LangCode = h.orderLangCode(req.LangCode)
+
+	settings, err := h.getShopSettings()
+	if err != nil || !h.paymentConfigured(settings) {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Shop is not configured"})
+		return
+	}
+	provider, err := h.paymentProvider(settings.PaymentProvider, settings)
+	if err != nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Shop is not configured"})
+		return
+	}
+
+	item, err := scanShopItem(h.db.QueryRow(`
+		SELECT id, title, description, price_cents, image_url, stock_quantity,
+		       allow_pickup, pickup_label, allow_shipping, shipping_regions,
+		       auto_confirm, active, sort_order, member_price_cents
+		FROM shop_items WHERE id = ? AND active = 1
+	`, req.ItemID))
+
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Item not found or inactive"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	if req.FulfillmentType == "pickup" && !item.AllowPickup {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Pickup not available for this item"})
+		return
+	}
+	if req.FulfillmentType == "shipping" && !item.AllowShipping {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Shipping not available for this item"})
+		return
+	}
+
+	var pickupSlotID interface{}
+	if req.FulfillmentType == "pickup" {
+		if req.PickupSlotID != nil {
+			msg, err := h.checkPickupSlot(*req.PickupSlotID)
+			if err != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+				return
+			}
+			if msg != "" {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+				return
+			}
+			pickupSlotID = *req.PickupSlotID
+		} else if h.hasUpcomingPickupSlots() {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Please choose a pickup time slot"})
+			return
+		}
+	}
+
+	if req.FulfillmentType == "shipping" {
+		countryAllowed := false
+		for _, c := range item.ShippingCountries {
+			if strings.EqualFold(c, req.ShippingCountry) {
+				countryAllowed = true
+				break
+			}
+		}
+		if !countryAllowed {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Shipping to this country is not available for this item"})
+			return
+		}
+	}
+
+	if item.StockQuantity != nil {
+		remaining := *item.StockQuantity
+		if remaining < req.Quantity {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Insufficient stock"})
+			return
+		}
+	}
+
+	totalCents := item.PriceCents * req.Quantity
+	if totalCents > maxPriceCents || totalCents < item.PriceCents {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Total amount exceeds maximum allowed"})
+		return
+	}
+
+	var discountCodeID interface{}
+	var discountCode string
+	var discountCents int
+	if strings.TrimSpace(req.DiscountCode) != "" {
+		d, discount, err := h.applyDiscountCode(req.DiscountCode, item, req.Quantity)
+		if msg, ok := err.(discountError); ok {
+			respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": string(msg)})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		discountCodeID, discountCode, discountCents = d.ID, d.Code, discount
+	}
+
+	token, err := newOrderToken()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create order"})
+		return
+	}
+
+	// The code's use and the pickup slot's place are taken by the insert
+	// itself, so two checkouts can't both get the last one
+	result, err := h.db.Exec(`
+		INSERT INTO shop_orders (token, item_id, buyer_email, quantity, amount_cents,
+		                         fulfillment_type, shipping_name, shipping_address,
+		                         shipping_city, shipping_postal_code, shipping_country,
+		                         status, payment_provider, lang_code,
+		                         discount_code_id, discount_code, discount_cents, pickup_slot_id)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?, ?, ?, ?, ?
+		WHERE (? IS NULL OR EXISTS (
+			SELECT 1 FROM shop_discount_codes d
+			WHERE d.id = ? AND (d.max_uses IS NULL OR `+discountUses+` < d.max_uses)
+		))
+		AND (? IS NULL OR EXISTS (
+			SELECT 1 FROM shop_pickup_slots s
+			WHERE s.id = ? AND (s.capacity IS NULL OR `+pickupBookedSQL+` < s.capacity)
+		))
+	`, token, req.ItemID, req.BuyerEmail, req.Quantity, totalCents-discountCents,
+		req.FulfillmentType, req.ShippingName, req.ShippingAddress,
+		req.ShippingCity, req.ShippingPostal, req.ShippingCountry, provider.Name(), req.LangCode,
+		discountCodeID, discountCode, discountCents, pickupSlotID,
+		discountCodeID, discountCodeID, pickupSlotID, pickupSlotID)
+
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create order"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Either the slot filled up or the code ran out since the checks above
+		if pickupSlotID != nil {
+			if msg, err := h.checkPickupSlot(*req.PickupSlotID); err == nil && msg != "" {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+				return
+			}
+		}
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": string(errDiscountUsedUp)})
+		return
+	}
+
+	orderID, _ := result.LastInsertId()
+
+	frontendURL := h.cfg.FrontendURL
+	checkout, err := provider.CreateCheckout(r.Context(), payment.CheckoutRequest{
+		OrderID:         orderID,
+		ItemID:          req.ItemID,
+		ItemName:        item.Title,
+		Description:     item.Description,
+		ImageURL:        item.ImageURL,
+		Quantity:        req.Quantity,
+		UnitAmountCents: item.PriceCents,
+		DiscountCents:   discountCents,
+		DiscountCode:    discountCode,
+		Currency:        settings.Currency,
+		BuyerEmail:      req.BuyerEmail,
+		FulfillmentType: req.FulfillmentType,
+		SuccessURL:      fmt.Sprintf("%s/shop/success?order=%d", frontendURL, orderID),
+		CancelURL:       fmt.Sprintf("%s/shop/cancel?order=%d", frontendURL, orderID),
+		WebhookURL:      fmt.Sprintf("%s/shop/webhook/%s", h.cfg.APIURL, provider.Name()),
+	})
+	if err != nil {
+		h.db.Exec("UPDATE shop_orders SET status = 'cancelled' WHERE id = ?", orderID)
+		msg := "Payment provider checkout creation failed"
+		var providerErr *payment.ProviderError
+		if errors.As(err, &providerErr) {
+			msg = providerErr.Message
+		}
+		respondJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+		return
+	}
+
+	h.db.Exec("UPDATE shop_orders SET stripe_session_id = ?, provider_payment_id = ? WHERE id = ?",
+		nullableString(checkout.SessionID), nullableString(checkout.PaymentID), orderID)
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"checkout_url": checkout.URL,
+		"session_id":   checkout.SessionID,
+		"order_id":     orderID,
+		"order_token":  token,
+	})
+}
+
+// paymentConfigured reports whether the selected provider has its credentials
+func (h *Handler) paymentConfigured(settings ShopSettings) bool {
+	switch settings.PaymentProvider {
+	case "stripe":
+		return settings.StripeSecretKey != ""
+	case "mollie":
+		return settings.MollieAPIKey != ""
+	case "fake":
+		return h.cfg.IsDevelopment()
+	}
+	return false
+}
+
+// paymentProvider builds the named provider from the stored credentials.
+// Webhooks use the provider named in their URL rather than the selected one,
+// so late refunds keep working after switching providers.
+func (h *Handler) paymentProvider(name string, settings ShopSettings) (payment.Provider, error) {
+	switch name {
+	case "stripe":
+		return payment.NewStripe(settings.StripeSecretKey, settings.StripeWebhookSecret), nil
+	case "mollie":
+		return payment.NewMollie(settings.MollieAPIKey), nil
+	case "fake":
+		// Unsigned webhooks: never reachable outside development
+		if h.cfg.IsDevelopment() {
+			return h.fakePayments, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", name)
+}
+
+// StripeWebhook serves the original /shop/webhook endpoint that existing
+// Stripe dashboards point at.
+func (h *Handler) StripeWebhook(w http.ResponseWriter, r *http.Request) {
+	h.handlePaymentWebhook(w, r, "stripe")
+}
+
+// PaymentWebhook receives webhooks at /shop/webhook/{provider}
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	h.handlePaymentWebhook(w, r, chi.URLParam(r, "provider"))
+}
+
+func (h *Handler) handlePaymentWebhook(w http.ResponseWriter, r *http.Request, providerName string) {
+	settings, err := h.getShopSettings()
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	provider, err := h.paymentProvider(providerName, settings)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	event, err := provider.ParseWebhook(r)
+	if errors.Is(err, payment.ErrInvalidSignature) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// Not configured yet or provider unreachable: let the provider retry
+		// instead of dropping the event
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// Idempotency: providers deliver at-least-once, so skip events we already handled
+	var existingStatus string
+	err = h.db.QueryRow("SELECT status FROM payment_events WHERE id = ?", event.ID).Scan(&existingStatus)
+	if err != nil && err != sql.ErrNoRows {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if existingStatus == "processed" || existingStatus == "ignored" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	_, err = h.db.Exec(`
+		INSERT INTO payment_events (id, provider, type, payload, status, attempts)
+		VALUES (?, ?, ?, ?, 'received', 0)
+		ON CONFLICT(id) DO NOTHING
+	`, event.ID, provider.Name(), event.Type, string(event.Payload))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.handlePaymentEvent(provider.Name(), event); err != nil {
+		// Non-2xx makes the provider retry the delivery later
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePaymentEvent applies a verified event to its order or event
+// registration and records the outcome in payment_events. A returned error
+// means the event should be retried.
+func (h *Handler) handlePaymentEvent(providerName string, event *payment.Event) error {
+	var orderID int64
+	handled := true
+	registrationID, err := h.findPaymentRegistration(event)
+	if err == nil {
+		if registrationID != 0 {
+			err = h.applyRegistrationPaymentEvent(providerName, registrationID, event)
+		} else {
+			orderID, handled, err = h.applyPaymentEvent(providerName, event)
+		}
+	}
+
+	status := "processed"
+	errMsg := ""
+	if err != nil {
+		status = "failed"
+		errMsg = err.Error()
+	} else if !handled {
+		status = "ignored"
+	}
+
+	h.db.Exec(`
+		UPDATE payment_events SET
+			status = ?, error = ?, order_id = ?, registration_id = ?, attempts = attempts + 1,
+			processed_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, status, errMsg, nullableInt64(orderID), nullableInt64(registrationID), event.ID)
+
+	return err
+}
+
+// applyPaymentEvent performs the order transition for an event. It reports
+// handled=false for events we don't act on and for unknown orders.
+func (h *Handler) applyPaymentEvent(providerName string, event *payment.Event) (orderID int64, handled bool, err error) {
+	if event.Kind == payment.EventIgnored {
+		return 0, false, nil
+	}
+
+	orderID, err = h.findPaymentOrder(event)
+	if err != nil || orderID == 0 {
+		return 0, false, err
+	}
+
+	paymentColumn := paymentIDColumn(providerName)
+
+	switch event.Kind {
+	case payment.EventPaid:
+		err = h.markOrderPaid(orderID, providerName, event.PaymentID)
+
+	case payment.EventPending:
+		_, err = h.db.Exec(`
+			UPDATE shop_orders SET `+paymentColumn+` = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = 'pending'
+		`, event.PaymentID, orderID)
+
+	case payment.EventFailed, payment.EventExpired:
+		_, err = h.db.Exec(`
+			UPDATE shop_orders SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = 'pending'
+		`, orderID)
+
+	case payment.EventPartiallyRefunded:
+		// Keep the status, leave a trace for the admin. The amount is the
+		// total refunded so far, a redelivered event adds no second note.
+		settings, _ := h.getShopSettings()
+		note := fmt.Sprintf("Partial refund, %s refunded in total", formatPrice(event.AmountRefunded, settings.Currency))
+		_, err = h.db.Exec(`
+			UPDATE shop_orders SET
+				refunded_cents = MAX(refunded_cents, ?),
+				notes = CASE WHEN instr(notes, ?) > 0 THEN notes ELSE TRIM(notes || char(10) || ?, char(10)) END,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, event.AmountRefunded, note, note, orderID)
+
+	case payment.EventRefunded:
+		var result sql.Result
+		result, err = h.db.Exec(`
+			UPDATE shop_orders SET status = 'refunded', refunded_cents = amount_cents, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status != 'refunded'
+		`, orderID)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n > 0 {
+				h.notifyOrderStatus(orderID, "refunded")
+			}
+		}
+
+	case payment.EventDisputed:
+		_, err = h.db.Exec(`
+			UPDATE shop_orders SET status = 'disputed', notes = TRIM(notes || char(10) || ?, char(10)), updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, fmt.Sprintf("Dispute opened: %s", event.Reason), orderID)
+	}
+
+	return orderID, true, err
+}
+
+// findPaymentOrder resolves the order an event refers to, first via the
+// order_id metadata we set at checkout, then via the payment reference.
+// Returns 0 without error when no matching order exists.
+func (h *Handler) findPaymentOrder(event *payment.Event) (int64, error) {
+	var id int64
+	if event.OrderID != 0 {
+		err := h.db.QueryRow("SELECT id FROM shop_orders WHERE id = ?", event.OrderID).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+
+	if event.PaymentID == "" {
+		return 0, nil
+	}
+
+	err := h.db.QueryRow(`
+		SELECT id FROM shop_orders WHERE stripe_payment_intent_id = ? OR provider_payment_id = ?
+	`, event.PaymentID, event.PaymentID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// paymentIDColumn is where an order keeps its provider payment reference.
+// Stripe predates the provider abstraction and keeps its own column.
+func paymentIDColumn(providerName string) string {
+	if providerName == "stripe" {
+		return "stripe_payment_intent_id"
+	}
+	return "provider_payment_id"
+}
+
+// markOrderPaid moves a pending order to paid (or confirmed for auto-confirm
+// items, which also reserves stock).
+func (h *Handler) markOrderPaid(orderID int64, providerName, paymentID string) error {
+	var itemID int64
+	var autoConfirm int
+	var qty int
+	err := h.db.QueryRow(`
+		SELECT o.item_id, COALESCE(i.auto_confirm, 0), o.quantity
+		FROM shop_orders o
+		LEFT JOIN shop_items i ON o.item_id = i.id
+		WHERE o.id = ?
+	`, orderID).Scan(&itemID, &autoConfirm, &qty)
+	if err != nil {
+		return err
+	}
+
+	newStatus := "paid"
+	if autoConfirm == 1 {
+		newStatus = "confirmed"
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE shop_orders SET
+			status = ?, `+paymentIDColumn(providerName)+` = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'pending'
+	`, newStatus, paymentID, orderID)
+	if err != nil {
+		return err
+	}
+
+	// Only touch stock and send mails when this call actually performed the transition
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	if autoConfirm == 1 {
+		h.db.Exec(`
+			UPDATE shop_items SET stock_quantity = stock_quantity - ?
+			WHERE id = ? AND stock_quantity IS NOT NULL
+		`, qty, itemID)
+	}
+
+	h.notifyOrderPaid(orderID)
+
+	return nil
+}
+
+// RefundShopOrder refunds an order through the provider that took the payment.
+// An empty body or amount_cents of 0 refunds the full order.
+func (h *Handler) RefundShopOrder(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		AmountCents int `json:"amount_cents"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	var orderID int64
+	var providerName, status string
+	var amountCents, refundedCents int
+	var paymentID sql.NullString
+	err := h.db.QueryRow(`
+		SELECT id, payment_provider, status, amount_cents, refunded_cents,
+		       CASE WHEN payment_provider = 'stripe' THEN stripe_payment_intent_id ELSE provider_payment_id END
+		FROM shop_orders WHERE id = ?
+	`, id).Scan(&orderID, &providerName, &status, &amountCents, &refundedCents, &paymentID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	refundable := map[string]bool{
+		"paid": true, "confirmed": true, "ready_for_pickup": true, "shipped": true, "fulfilled": true,
+		"completed": true,
+	}
+	if !refundable[status] || !paymentID.Valid || paymentID.String == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Order has no captured payment to refund"})
+		return
+	}
+
+	settings, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch shop settings"})
+		return
+	}
+
+	// Earlier partial refunds count against the order total
+	remaining := amountCents - refundedCents
+	if remaining <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Order has been refunded in full already"})
+		return
+	}
+	if req.AmountCents < 0 || req.AmountCents > remaining {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Refund amount exceeds what is left to refund (%s)", formatPrice(remaining, settings.Currency)),
+		})
+		return
+	}
+	provider, err := h.paymentProvider(providerName, settings)
+	if err != nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Payment provider for this order is not available"})
+		return
+	}
+
+	if err := provider.Refund(r.Context(), paymentID.String, req.AmountCents, settings.Currency); err != nil {
+		msg := "Refund failed"
+		var providerErr *payment.ProviderError
+		if errors.As(err, &providerErr) {
+			msg = providerErr.Message
+		}
+		respondJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+		return
+	}
+
+	// The provider's webhook confirms the refund as well; applying it here
+	// keeps the admin view accurate if that webhook is delayed. The note
+	// for a partial refund is left to the webhook.
+	if req.AmountCents == 0 || req.AmountCents == remaining {
+		result, err := h.db.Exec(`
+			UPDATE shop_orders SET status = 'refunded', refunded_cents = amount_cents, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status != 'refunded'
+		`, orderID)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n > 0 {
+				h.notifyOrderStatus(orderID, "refunded")
+			}
+		}
+	} else {
+		h.db.Exec(`
+			UPDATE shop_orders SET refunded_cents = MIN(amount_cents, refunded_cents + ?), updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, req.AmountCents, orderID)
+	}
+
+	h.GetShopOrderByID(w, r)
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/payment"
+)
+
+// deliverWebhook posts a fake provider event like the provider would
+func deliverWebhook(t *testing.T, h *Handler, body string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.handlePaymentWebhook(w, httptest.NewRequest("POST", "/shop/webhook/fake", strings.NewReader(body)), "fake")
+	return w.Code
+}
+
+// newTestOrder adds an item with stock and a pending order for quantity of it
+func newTestOrder(t *testing.T, h *Handler, autoConfirm bool, stock, quantity int) (itemID, orderID int64) {
+	t.Helper()
+	itemID = exec(t, h, `
+		INSERT INTO shop_items (title, price_cents, stock_quantity, allow_pickup, auto_confirm, active)
+		VALUES ('Geocoin', 1000, ?, 1, ?, 1)
+	`, stock, autoConfirm)
+	orderID = exec(t, h, `
+		INSERT INTO shop_orders (item_id, buyer_email, quantity, amount_cents, payment_provider, token)
+		VALUES (?, 'buyer@example.com', ?, ?, 'fake', ?)
+	`, itemID, quantity, 1000*quantity, fmt.Sprintf("%032x", itemID))
+	return itemID, orderID
+}
+
+type orderState struct {
+	status        string
+	paymentID     string
+	refundedCents int
+	stock         int
+	eventStatus   string
+}
+
+func loadOrderState(t *testing.T, h *Handler, itemID, orderID int64, eventID string) orderState {
+	t.Helper()
+	var s orderState
+	var paymentID sql.NullString
+	if err := h.db.QueryRow(`
+		SELECT o.status, o.provider_payment_id, o.refunded_cents, i.stock_quantity
+		FROM shop_orders o JOIN shop_items i ON i.id = o.item_id WHERE o.id = ?
+	`, orderID).Scan(&s.status, &paymentID, &s.refundedCents, &s.stock); err != nil {
+		t.Fatalf("loading order: %v", err)
+	}
+	s.paymentID = paymentID.String
+	h.db.QueryRow("SELECT status FROM payment_events WHERE id = ?", eventID).Scan(&s.eventStatus)
+	return s
+}
+
+func TestPaymentEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		autoConfirm bool
+		// setup runs before the event, on the pending order
+		setup string
+		// event is delivered with ORDER replaced by the order ID
+		event string
+		want  orderState
+	}{
+		{
+			name:  "paid",
+			event: `{"id":"evt_1","kind":"paid","order_id":ORDER,"payment_id":"fake_pay_1"}`,
+			want:  orderState{status: "paid", paymentID: "fake_pay_1", stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:        "paid for an item that confirms itself",
+			autoConfirm: true,
+			event:       `{"id":"evt_1","kind":"paid","order_id":ORDER,"payment_id":"fake_pay_1"}`,
+			want:        orderState{status: "confirmed", paymentID: "fake_pay_1", stock: 3, eventStatus: "processed"},
+		},
+		{
+			name:  "paid after the order was cancelled",
+			setup: "UPDATE shop_orders SET status = 'cancelled'",
+			event: `{"id":"evt_1","kind":"paid","order_id":ORDER,"payment_id":"fake_pay_1"}`,
+			want:  orderState{status: "cancelled", stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "pending",
+			event: `{"id":"evt_1","kind":"pending","order_id":ORDER,"payment_id":"fake_pay_1"}`,
+			want:  orderState{status: "pending", paymentID: "fake_pay_1", stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "expired",
+			event: `{"id":"evt_1","kind":"expired","order_id":ORDER}`,
+			want:  orderState{status: "cancelled", stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "failed",
+			event: `{"id":"evt_1","kind":"failed","order_id":ORDER}`,
+			want:  orderState{status: "cancelled", stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "expired after payment",
+			setup: "UPDATE shop_orders SET status = 'paid'",
+			event: `{"id":"evt_1","kind":"expired","order_id":ORDER}`,
+			want:  orderState{status: "paid", stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "refunded",
+			setup: "UPDATE shop_orders SET status = 'paid', provider_payment_id = 'fake_pay_1'",
+			event: `{"id":"evt_1","kind":"refunded","payment_id":"fake_pay_1"}`,
+			want:  orderState{status: "refunded", paymentID: "fake_pay_1", refundedCents: 2000, stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "partially refunded",
+			setup: "UPDATE shop_orders SET status = 'paid', provider_payment_id = 'fake_pay_1'",
+			event: `{"id":"evt_1","kind":"partially_refunded","order_id":ORDER,"amount_refunded":500}`,
+			want:  orderState{status: "paid", paymentID: "fake_pay_1", refundedCents: 500, stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "disputed",
+			setup: "UPDATE shop_orders SET status = 'paid'",
+			event: `{"id":"evt_1","kind":"disputed","order_id":ORDER,"reason":"fraudulent"}`,
+			want:  orderState{status: "disputed", stock: 5, eventStatus: "processed"},
+		},
+		{
+			name:  "unknown order",
+			event: `{"id":"evt_1","kind":"paid","order_id":999,"payment_id":"fake_pay_99"}`,
+			want:  orderState{status: "pending", stock: 5, eventStatus: "ignored"},
+		},
+		{
+			name:  "ignored kind",
+			event: `{"id":"evt_1","kind":"ignored","order_id":ORDER}`,
+			want:  orderState{status: "pending", stock: 5, eventStatus: "ignored"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			itemID, orderID := newTestOrder(t, h, tt.autoConfirm, 5, 2)
+			if tt.setup != "" {
+				exec(t, h, tt.setup)
+			}
+
+			if code := deliverWebhook(t, h, strings.ReplaceAll(tt.event, "ORDER", fmt.Sprint(orderID))); code != http.StatusOK {
+				t.Fatalf("webhook status = %d, want 200", code)
+			}
+			if got := loadOrderState(t, h, itemID, orderID, "evt_1"); got != tt.want {
+				t.Errorf("after the event: %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentEventDuplicate(t *testing.T) {
+	h := newTestHandler(t)
+	itemID, orderID := newTestOrder(t, h, true, 5, 2)
+	event := fmt.Sprintf(`{"id":"evt_1","kind":"paid","order_id":%d,"payment_id":"fake_pay_1"}`, orderID)
+
+	for i := 0; i < 3; i++ {
+		if code := deliverWebhook(t, h, event); code != http.StatusOK {
+			t.Fatalf("delivery %d: status = %d, want 200", i+1, code)
+		}
+	}
+
+	// Stock is taken once, however often the event arrives
+	want := orderState{status: "confirmed", paymentID: "fake_pay_1", stock: 3, eventStatus: "processed"}
+	if got := loadOrderState(t, h, itemID, orderID, "evt_1"); got != want {
+		t.Errorf("after three deliveries: %+v, want %+v", got, want)
+	}
+	var attempts int
+	h.db.QueryRow("SELECT attempts FROM payment_events WHERE id = 'evt_1'").Scan(&attempts)
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestPaymentEventRetryAfterFailure(t *testing.T) {
+	h := newTestHandler(t)
+	itemID, orderID := newTestOrder(t, h, false, 5, 1)
+	event := fmt.Sprintf(`{"id":"evt_1","kind":"paid","order_id":%d,"payment_id":"fake_pay_1"}`, orderID)
+
+	// A failed event is stored as such and applied when it is delivered again
+	exec(t, h, `
+		INSERT INTO payment_events (id, provider, type, payload, status, attempts)
+		VALUES ('evt_1', 'fake', 'fake.paid', ?, 'failed', 1)
+	`, event)
+	if code := deliverWebhook(t, h, event); code != http.StatusOK {
+		t.Fatalf("webhook status = %d, want 200", code)
+	}
+	if got := loadOrderState(t, h, itemID, orderID, "evt_1"); got.status != "paid" || got.eventStatus != "processed" {
+		t.Errorf("after the retry: %+v", got)
+	}
+}
+
+func TestPaymentWebhookInvalid(t *testing.T) {
+	h := newTestHandler(t)
+	if code := deliverWebhook(t, h, `{"kind":"paid"}`); code != http.StatusServiceUnavailable {
+		t.Errorf("event without ID: status = %d, want 503", code)
+	}
+
+	w := httptest.NewRecorder()
+	h.handlePaymentWebhook(w, httptest.NewRequest("POST", "/shop/webhook/paypal", strings.NewReader("{}")), "paypal")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown provider: status = %d, want 404", w.Code)
+	}
+
+	// Unsigned fake events are never accepted outside development
+	h.cfg.Env = "production"
+	w = httptest.NewRecorder()
+	h.handlePaymentWebhook(w, httptest.NewRequest("POST", "/shop/webhook/fake", strings.NewReader(`{"id":"evt_1","kind":"paid"}`)), "fake")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("fake provider in production: status = %d, want 404", w.Code)
+	}
+}
+
+func TestPaymentEventNotes(t *testing.T) {
+	h := newTestHandler(t)
+	_, orderID := newTestOrder(t, h, false, 5, 1)
+	exec(t, h, "UPDATE shop_orders SET status = 'paid' WHERE id = ?", orderID)
+
+	deliverWebhook(t, h, fmt.Sprintf(`{"id":"evt_1","kind":"partially_refunded","order_id":%d,"amount_refunded":250}`, orderID))
+	deliverWebhook(t, h, fmt.Sprintf(`{"id":"evt_2","kind":"disputed","order_id":%d,"reason":"fraudulent"}`, orderID))
+	// The same refund reported by a second event type adds no second note
+	deliverWebhook(t, h, fmt.Sprintf(`{"id":"evt_3","kind":"partially_refunded","order_id":%d,"amount_refunded":250}`, orderID))
+
+	var notes string
+	h.db.QueryRow("SELECT notes FROM shop_orders WHERE id = ?", orderID).Scan(&notes)
+	if want := "Partial refund, € 2.50 refunded in total\nDispute opened: fraudulent"; notes != want {
+		t.Errorf("notes = %q, want %q", notes, want)
+	}
+}
+
+func TestRefundShopOrderPartial(t *testing.T) {
+	h := newTestHandler(t)
+	exec(t, h, "UPDATE shop_settings SET payment_provider = 'fake'")
+	itemID, orderID := newTestOrder(t, h, false, 5, 1)
+	exec(t, h, "UPDATE shop_orders SET status = 'paid', provider_payment_id = 'fake_pay_1', refunded_cents = 600 WHERE id = ?", orderID)
+
+	refund := func(amount int) int {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(fmt.Sprintf(`{"amount_cents":%d}`, amount)))
+		w := httptest.NewRecorder()
+		h.RefundShopOrder(w, withURLParams(r, "id", fmt.Sprint(orderID)))
+		return w.Code
+	}
+
+	// € 6 of the € 10 was refunded before, € 5 more is too much
+	if code := refund(500); code != http.StatusBadRequest {
+		t.Errorf("refund over what is left: status = %d, want 400", code)
+	}
+	if len(h.fakePayments.Refunds) != 0 {
+		t.Fatalf("refunds = %+v, want none", h.fakePayments.Refunds)
+	}
+
+	if code := refund(250); code != http.StatusOK {
+		t.Fatalf("partial refund: status = %d, want 200", code)
+	}
+	deliverWebhook(t, h, fmt.Sprintf(`{"id":"evt_1","kind":"partially_refunded","order_id":%d,"amount_refunded":850}`, orderID))
+	var notes string
+	h.db.QueryRow("SELECT notes FROM shop_orders WHERE id = ?", orderID).Scan(&notes)
+	if want := "Partial refund, € 8.50 refunded in total"; notes != want {
+		t.Errorf("notes = %q, want %q", notes, want)
+	}
+	if got := loadOrderState(t, h, itemID, orderID, ""); got.status != "paid" || got.refundedCents != 850 {
+		t.Errorf("after the partial refund: %+v", got)
+	}
+
+	// Refunding exactly what is left refunds the order in full
+	if code := refund(150); code != http.StatusOK {
+		t.Fatalf("refund of the rest: status = %d, want 200", code)
+	}
+	if got := loadOrderState(t, h, itemID, orderID, ""); got.status != "refunded" || got.refundedCents != 1000 {
+		t.Errorf("after refunding the rest: %+v", got)
+	}
+}
+
+func TestApplyPaymentEventByPaymentID(t *testing.T) {
+	h := newTestHandler(t)
+	_, orderID := newTestOrder(t, h, false, 5, 1)
+	exec(t, h, "UPDATE shop_orders SET provider_payment_id = 'tr_1' WHERE id = ?", orderID)
+
+	// Mollie refunds only carry the payment, not our order ID
+	got, handled, err := h.applyPaymentEvent("mollie", &payment.Event{ID: "tr_1:paid", Kind: payment.EventPaid, PaymentID: "tr_1"})
+	if err != nil || !handled || got != orderID {
+		t.Fatalf("applyPaymentEvent = %d, %v, %v; want %d, true, nil", got, handled, err, orderID)
+	}
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DiscountCode is a promotion code entered at checkout. Kinds:
+//   - percentage: Value percent off the order total, at most 99 because
+//     an order has to leave something to pay
+//   - fixed: Value cents off the order total
+//   - member: the item's member price instead of the regular price
+type DiscountCode struct {
+	ID            int64      `json:"id"`
+	Code          string     `json:"code"`
+	Description   string     `json:"description"`
+	Kind          string     `json:"kind"`
+	Value         int        `json:"value"`
+	ItemID        *int64     `json:"item_id,omitempty"`
+	MaxUses       *int       `json:"max_uses,omitempty"`
+	ValidFrom     *time.Time `json:"valid_from,omitempty"`
+	ValidUntil    *time.Time `json:"valid_until,omitempty"`
+	Active        bool       `json:"active"`
+	Uses          int        `json:"uses"`
+	DiscountCents int        `json:"discount_total_cents"`
+	CreatedAt     string     `json:"created_at"`
+}
+
+type discountCodeRequest struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Kind        string `json:"kind"`
+	Value       int    `json:"value"`
+	ItemID      *int64 `json:"item_id"`
+	MaxUses     *int   `json:"max_uses"`
+	ValidFrom   string `json:"valid_from"`
+	ValidUntil  string `json:"valid_until"`
+	Active      bool   `json:"active"`
+}
+
+// discountError is a checkout-facing reason a code can't be used
+type discountError string
+
+func (e discountError) Error() string { return string(e) }
+
+const (
+	errDiscountInvalid  discountError = "Invalid discount code"
+	errDiscountNotYet   discountError = "Discount code is not valid yet"
+	errDiscountExpired  discountError = "Discount code has expired"
+	errDiscountUsedUp   discountError = "Discount code has been fully used"
+	errDiscountNotItem  discountError = "Discount code does not apply to this item"
+	errDiscountTooLarge discountError = "Discount exceeds the order total"
+)
+
+// discountUses counts the uses of code d: every order that isn't cancelled,
+// so pending checkouts hold their use until they are paid or expire
+const discountUses = `(SELECT COUNT(*) FROM shop_orders o WHERE o.discount_code_id = d.id AND o.status != 'cancelled')`
+
+const discountCodeSelect = `
+	SELECT d.id, d.code, d.description, d.kind, d.value, d.item_id, d.max_uses,
+	       d.valid_from, d.valid_until, d.active, d.created_at,
+	       ` + discountUses + `,
+	       (SELECT COALESCE(SUM(o.discount_cents), 0) FROM shop_orders o
+	        WHERE o.discount_code_id = d.id AND o.status NOT IN ('pending', 'cancelled'))
+	FROM shop_discount_codes d`
+
+func scanDiscountCode(rows interface {
+	Scan(dest ...any) error
+}) (DiscountCode, error) {
+	var d DiscountCode
+	var itemID, maxUses sql.NullInt64
+	var validFrom, validUntil sql.NullTime
+	var active int
+	var createdAt time.Time
+
+	err := rows.Scan(&d.ID, &d.Code, &d.Description, &d.Kind, &d.Value, &itemID, &maxUses,
+		&validFrom, &validUntil, &active, &createdAt, &d.Uses, &d.DiscountCents)
+	if err != nil {
+		return d, err
+	}
+
+	if itemID.Valid {
+		d.ItemID = &itemID.Int64
+	}
+	if maxUses.Valid {
+		m := int(maxUses.Int64)
+		d.MaxUses = &m
+	}
+	if validFrom.Valid {
+		d.ValidFrom = &validFrom.Time
+	}
+	if validUntil.Valid {
+		d.ValidUntil = &validUntil.Time
+	}
+	d.Active = active == 1
+	d.CreatedAt = createdAt.Format(time.RFC3339)
+	return d, nil
+}
+
+// applyDiscountCode validates a code for an item and quantity and returns
+// the code with the discount in cents. Errors are discountErrors unless the
+// database failed.
+func (h *Handler) applyDiscountCode(code string, item ShopItem, quantity int) (DiscountCode, int, error) {
+	d, err := scanDiscountCode(h.db.QueryRow(discountCodeSelect+" WHERE d.code = ?", strings.TrimSpace(code)))
+	if err == sql.ErrNoRows {
+		return d, 0, errDiscountInvalid
+	}
+	if err != nil {
+		return d, 0, err
+	}
+
+	now := time.Now()
+	switch {
+	case !d.Active:
+		return d, 0, errDiscountInvalid
+	case d.ValidFrom != nil && now.Before(*d.ValidFrom):
+		return d, 0, errDiscountNotYet
+	case d.ValidUntil != nil && !now.Before(*d.ValidUntil):
+		return d, 0, errDiscountExpired
+	case d.ItemID != nil && *d.ItemID != item.ID:
+		return d, 0, errDiscountNotItem
+	case d.MaxUses != nil && d.Uses >= *d.MaxUses:
+		return d, 0, errDiscountUsedUp
+	}
+
+	total := item.PriceCents * quantity
+	discount := 0
+	switch d.Kind {
+	case "percentage":
+		discount = total * d.Value / 100
+	case "fixed":
+		discount = d.Value
+	case "member":
+		if item.MemberPriceCents == nil {
+			return d, 0, errDiscountNotItem
+		}
+		discount = (item.PriceCents - *item.MemberPriceCents) * quantity
+	}
+
+	if discount >= total {
+		return d, 0, errDiscountTooLarge
+	}
+	return d, discount, nil
+}
+
+// CheckDiscountCode lets the checkout page preview a code before paying
+func (h *Handler) CheckDiscountCode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code     string `json:"code"`
+		ItemID   int64  `json:"item_id"`
+		Quantity int    `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if !validateQuantity(req.Quantity) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid item or quantity (max 999)"})
+		return
+	}
+
+	item, err := scanShopItem(h.db.QueryRow(`
+		SELECT id, title, description, price_cents, image_url, stock_quantity,
+		       allow_pickup, pickup_label, allow_shipping, shipping_regions,
+		       auto_confirm, active, sort_order, member_price_cents
+		FROM shop_items WHERE id = ? AND active = 1
+	`, req.ItemID))
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Item not found or inactive"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	d, discount, err := h.applyDiscountCode(req.Code, item, req.Quantity)
+	if msg, ok := err.(discountError); ok {
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": string(msg)})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	settings, _ := h.getShopSettings()
+	total := item.PriceCents*req.Quantity - discount
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"code":             d.Code,
+		"description":      d.Description,
+		"discount_cents":   discount,
+		"discount_display": formatPrice(discount, settings.Currency),
+		"total_cents":      total,
+		"total_display":    formatPrice(total, settings.Currency),
+	})
+}
+
+// GetDiscountCodes lists all discount codes with their usage
+func (h *Handler) GetDiscountCodes(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(discountCodeSelect + " ORDER BY d.created_at DESC")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, []DiscountCode{})
+		return
+	}
+	defer rows.Close()
+
+	codes := []DiscountCode{}
+	for rows.Next() {
+		d, err := scanDiscountCode(rows)
+		if err != nil {
+			continue
+		}
+		codes = append(codes, d)
+	}
+
+	respondJSON(w, http.StatusOK, codes)
+}
+
+func (h *Handler) GetDiscountCodeByID(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	d, err := scanDiscountCode(h.db.QueryRow(discountCodeSelect+" WHERE d.id = ?", id))
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Discount code not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, d)
+}
+
+// parseDiscountDate accepts RFC 3339 or YYYY-MM-DD. A bare date as end of
+// the validity window means "through that day".
+func parseDiscountDate(s string, endOfDay bool) (interface{}, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return nil, false
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true
+}
+
+// validateDiscountCodeRequest normalises req and returns an error message
+func validateDiscountCodeRequest(req *discountCodeRequest) string {
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	req.Description = truncateString(strings.TrimSpace(req.Description), 500)
+
+	if !validateDiscountCode(req.Code) {
+		return "Code must be 3-40 characters: letters, digits, - or _"
+	}
+	switch req.Kind {
+	case "percentage":
+		if req.Value < 1 || req.Value > 99 {
+			return "Percentage must be between 1 and 99"
+		}
+	case "fixed":
+		if !validatePriceCents(req.Value) {
+			return "Amount must be between 0.01 and 1000000.00"
+		}
+	case "member":
+		req.Value = 0
+	default:
+		return "Kind must be percentage, fixed or member"
+	}
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		return "Maximum uses must be at least 1"
+	}
+	return ""
+}
+
+func (h *Handler) saveDiscountCode(w http.ResponseWriter, r *http.Request, id string) {
+	var req discountCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if msg := validateDiscountCodeRequest(&req); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	validFrom, ok1 := parseDiscountDate(req.ValidFrom, false)
+	validUntil, ok2 := parseDiscountDate(req.ValidUntil, true)
+	if !ok1 || !ok2 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Dates must be formatted YYYY-MM-DD or RFC 3339"})
+		return
+	}
+
+	var itemID interface{}
+	if req.ItemID != nil {
+		var exists int
+		h.db.QueryRow("SELECT COUNT(*) FROM shop_items WHERE id = ?", *req.ItemID).Scan(&exists)
+		if exists == 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Item not found"})
+			return
+		}
+		itemID = *req.ItemID
+	}
+
+	status := http.StatusOK
+	var err error
+	if id == "" {
+		status = http.StatusCreated
+		var result sql.Result
+		result, err = h.db.Exec(`
+			INSERT INTO shop_discount_codes (code, description, kind, value, item_id, max_uses, valid_from, valid_until, active)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, req.Code, req.Description, req.Kind, req.Value, itemID, nullableInt(req.MaxUses),
+			validFrom, validUntil, boolToInt(req.Active))
+		if err == nil {
+			newID, _ := result.LastInsertId()
+			id = strconv.FormatInt(newID, 10)
+		}
+	} else {
+		var result sql.Result
+		result, err = h.db.Exec(`
+			UPDATE shop_discount_codes SET
+				code = ?, description = ?, kind = ?, value = ?, item_id = ?, max_uses = ?,
+				valid_from = ?, valid_until = ?, active = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, req.Code, req.Description, req.Kind, req.Value, itemID, nullableInt(req.MaxUses),
+			validFrom, validUntil, boolToInt(req.Active), id)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n == 0 {
+				respondJSON(w, http.StatusNotFound, map[string]string{"error": "Discount code not found"})
+				return
+			}
+		}
+	}
+
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "A discount code with this code already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save discount code"})
+		return
+	}
+
+	d, err := scanDiscountCode(h.db.QueryRow(discountCodeSelect+" WHERE d.id = ?", id))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, status, d)
+}
+
+func (h *Handler) CreateDiscountCode(w http.ResponseWriter, r *http.Request) {
+	h.saveDiscountCode(w, r, "")
+}
+
+func (h *Handler) UpdateDiscountCode(w http.ResponseWriter, r *http.Request) {
+	h.saveDiscountCode(w, r, chi.URLParam(r, "id"))
+}
+
+// DeleteDiscountCode removes a code. Orders keep the code text and amount
+// they were placed with.
+func (h *Handler) DeleteDiscountCode(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	_, err := h.db.Exec("DELETE FROM shop_discount_codes WHERE id = ?", id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete discount code"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Discount code deleted"})
+}
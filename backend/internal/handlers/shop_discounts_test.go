@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// checkout starts a checkout of one item with a discount code
+func checkout(h *Handler, itemID int64, code string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"item_id":%d,"quantity":1,"fulfillment_type":"pickup","buyer_email":"buyer@example.com","discount_code":%q}`, itemID, code)
+	w := httptest.NewRecorder()
+	h.CreateCheckoutSession(w, httptest.NewRequest("POST", "/shop/checkout", strings.NewReader(body)))
+	return w
+}
+
+func TestDiscountCodeMaxUses(t *testing.T) {
+	h := newTestHandler(t)
+	exec(t, h, "UPDATE shop_settings SET payment_provider = 'fake' WHERE id = 1")
+	itemID := exec(t, h, "INSERT INTO shop_items (title, price_cents, allow_pickup, active) VALUES ('Geocoin', 1000, 1, 1)")
+	exec(t, h, "INSERT INTO shop_discount_codes (code, kind, value, max_uses, active) VALUES ('ONCE', 'percentage', 10, 1, 1)")
+
+	// Of checkouts at the same time for the last use, one gets it
+	var wg sync.WaitGroup
+	codes := make([]int, 16)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = checkout(h, itemID, "ONCE").Code
+		}(i)
+	}
+	wg.Wait()
+
+	var orders int
+	h.db.QueryRow("SELECT COUNT(*) FROM shop_orders WHERE discount_code = 'ONCE'").Scan(&orders)
+	if orders != 1 {
+		t.Errorf("%d orders with the code (responses %v), want 1", orders, codes)
+	}
+
+	if w := checkout(h, itemID, "ONCE"); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), string(errDiscountUsedUp)) {
+		t.Errorf("used up code: status = %d: %s", w.Code, w.Body)
+	}
+
+	// A cancelled checkout gives the use back
+	exec(t, h, "UPDATE shop_orders SET status = 'cancelled' WHERE discount_code = 'ONCE'")
+	if w := checkout(h, itemID, "ONCE"); w.Code != http.StatusOK {
+		t.Errorf("after cancelling: status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestValidateDiscountCodeRequest(t *testing.T) {
+	tests := []struct {
+		kind  string
+		value int
+		valid bool
+	}{
+		{"percentage", 1, true},
+		{"percentage", 99, true},
+		// Nothing would be left to pay
+		{"percentage", 100, false},
+		{"percentage", 0, false},
+		{"fixed", 500, true},
+		{"fixed", 0, false},
+		{"member", 0, true},
+		{"gift", 10, false},
+	}
+	for _, tt := range tests {
+		req := discountCodeRequest{Code: "summer-25", Kind: tt.kind, Value: tt.value}
+		if msg := validateDiscountCodeRequest(&req); (msg == "") != tt.valid {
+			t.Errorf("%s %d: %q, want valid %v", tt.kind, tt.value, msg, tt.valid)
+		}
+	}
+}
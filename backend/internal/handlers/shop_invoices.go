@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/invoice"
+	"github.com/go-chi/chi/v5"
+)
+
+// invoiceLinkTTL is how long the download link in the confirmation mail works
+const invoiceLinkTTL = 365 * 24 * time.Hour
+
+// invoiceableStatuses are the order statuses for which an invoice may be issued
+var invoiceableStatuses = map[string]bool{
+	"paid": true, "confirmed": true, "ready_for_pickup": true, "shipped": true, "fulfilled": true,
+	"completed": true,
+}
+
+// errNotInvoiceable is returned when issuing an invoice for an order that
+// hasn't been paid
+var errNotInvoiceable = errors.New("order cannot be invoiced")
+
+// issueInvoice returns the invoice of an order, issuing it with the next
+// number of the current year if it doesn't exist yet. Issuing is idempotent.
+// Invoices are issued when an order is paid; reading one never issues it.
+func (h *Handler) issueInvoice(orderID int64) (invoice.Invoice, error) {
+	inv, err := h.loadInvoice(orderID)
+	if err != sql.ErrNoRows {
+		return inv, err
+	}
+
+	inv, status, err := h.buildInvoice(orderID)
+	if err != nil {
+		return inv, err
+	}
+	if !invoiceableStatuses[status] {
+		return inv, fmt.Errorf("order #%d is %s: %w", orderID, status, errNotInvoiceable)
+	}
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return inv, err
+	}
+
+	// Allocating the sequence and inserting in one statement keeps concurrent
+	// issues from taking the same number.
+	year := time.Now().Year()
+	_, err = h.db.Exec(`
+		INSERT INTO shop_invoices (order_id, year, sequence, number, data)
+		SELECT ?, ?, COALESCE(MAX(sequence), 0) + 1, printf('%d-%04d', ?, COALESCE(MAX(sequence), 0) + 1), ?
+		FROM shop_invoices WHERE year = ?
+		ON CONFLICT(order_id) DO NOTHING
+	`, orderID, year, year, string(data), year)
+	if err != nil {
+		return inv, err
+	}
+
+	return h.loadInvoice(orderID)
+}
+
+// loadInvoice reads an issued invoice; sql.ErrNoRows if there is none
+func (h *Handler) loadInvoice(orderID int64) (invoice.Invoice, error) {
+	var inv invoice.Invoice
+	var data string
+	err := h.db.QueryRow(`
+		SELECT number, data, issued_at FROM shop_invoices WHERE order_id = ?
+	`, orderID).Scan(&inv.Number, &data, &inv.IssuedAt)
+	if err != nil {
+		return inv, err
+	}
+	if err := json.Unmarshal([]byte(data), &inv); err != nil {
+		return inv, err
+	}
+	return inv, nil
+}
+
+// buildInvoice snapshots the order and the current invoice settings
+func (h *Handler) buildInvoice(orderID int64) (invoice.Invoice, string, error) {
+	var inv invoice.Invoice
+	var status, itemTitle, paymentRef string
+	var quantity, amountCents, discountCents int
+	var discountCode, langCode string
+	var shippingName, shippingAddress, shippingCity, shippingPostal, shippingCountry string
+
+	err := h.db.QueryRow(`
+		SELECT o.id, o.status, o.buyer_email, COALESCE(i.title, ''), o.quantity, o.amount_cents,
+		       o.payment_provider,
+		       COALESCE(CASE WHEN o.payment_provider = 'stripe' THEN o.stripe_payment_intent_id ELSE o.provider_payment_id END, ''),
+		       o.shipping_name, o.shipping_address, o.shipping_city, o.shipping_postal_code, o.shipping_country,
+		       o.discount_code, o.discount_cents, o.lang_code
+		FROM shop_orders o
+		LEFT JOIN shop_items i ON o.item_id = i.id
+		WHERE o.id = ?
+	`, orderID).Scan(&inv.OrderID, &status, &inv.BuyerEmail, &itemTitle, &quantity, &amountCents,
+		&inv.PaymentProvider, &paymentRef,
+		&shippingName, &shippingAddress, &shippingCity, &shippingPostal, &shippingCountry,
+		&discountCode, &discountCents, &langCode)
+	if err != nil {
+		return inv, "", err
+	}
+
+	settings, err := h.getShopSettings()
+	if err != nil {
+		return inv, "", err
+	}
+
+	inv.Seller = invoice.Seller{
+		Name:             settings.InvoiceOrgName,
+		Address:          settings.InvoiceOrgAddress,
+		EnterpriseNumber: settings.InvoiceEnterpriseNumber,
+		VATNumber:        settings.InvoiceVATNumber,
+		Email:            h.cfg.SMTP.NotificationEmail,
+	}
+	inv.Currency = settings.Currency
+	inv.VATRate = settings.InvoiceVATRate
+	inv.VATNote = settings.InvoiceVATNote
+	inv.PaymentRef = paymentRef
+
+	inv.BuyerName = shippingName
+	if shippingAddress != "" {
+		inv.BuyerAddress = strings.Join([]string{
+			shippingAddress,
+			strings.TrimSpace(shippingPostal + " " + shippingCity),
+			shippingCountry,
+		}, "\n")
+	}
+
+	// amount_cents is authoritative; the unit price is derived from it so
+	// the invoice always adds up to what was charged.
+	grossCents := amountCents + discountCents
+	unitCents := grossCents
+	if quantity > 0 && grossCents%quantity == 0 {
+		unitCents = grossCents / quantity
+	} else {
+		itemTitle = fmt.Sprintf("%s (x%d)", itemTitle, quantity)
+		quantity = 1
+	}
+	inv.Lines = []invoice.Line{{Description: itemTitle, Quantity: quantity, UnitCents: unitCents}}
+	if discountCents > 0 {
+		inv.Lines = append(inv.Lines, invoice.Line{
+			Description: fmt.Sprintf("%s (%s)", invoice.DiscountLabel(langCode), discountCode),
+			Quantity:    1,
+			UnitCents:   -discountCents,
+		})
+	}
+
+	return inv, status, nil
+}
+
+// renderOrderInvoice issues (if needed) and renders the invoice of an order
+func (h *Handler) renderOrderInvoice(orderID int64) (invoice.Invoice, []byte, error) {
+	inv, err := h.issueInvoice(orderID)
+	if err != nil {
+		return inv, nil, err
+	}
+	data, err := invoice.Render(inv)
+	return inv, data, err
+}
+
+// GetShopOrderInvoice serves the invoice PDF of an order to admins
+func (h *Handler) GetShopOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid order ID"})
+		return
+	}
+	h.serveInvoice(w, orderID)
+}
+
+// IssueShopOrderInvoice issues the invoice of a paid order that doesn't have
+// one yet, such as orders paid before invoices were issued
+func (h *Handler) IssueShopOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid order ID"})
+		return
+	}
+
+	inv, err := h.issueInvoice(orderID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+	if errors.Is(err, errNotInvoiceable) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Only paid orders can be invoiced"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to issue invoice for order #%d: %v", orderID, err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to issue invoice"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"number": inv.Number, "issued_at": inv.IssuedAt})
+}
+
+// GetPublicShopOrderInvoice serves the invoice PDF to the buyer through the
+// signed link from the confirmation mail.
+func (h *Handler) GetPublicShopOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid order ID"})
+		return
+	}
+
+	expires, _ := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if !h.verifyInvoiceSignature(orderID, expires, r.URL.Query().Get("sig")) {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "Invalid or expired link"})
+		return
+	}
+	h.serveInvoice(w, orderID)
+}
+
+// serveInvoice renders an issued invoice
+func (h *Handler) serveInvoice(w http.ResponseWriter, orderID int64) {
+	inv, err := h.loadInvoice(orderID)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Invoice not found"})
+		return
+	}
+	var data []byte
+	if err == nil {
+		data, err = invoice.Render(inv)
+	}
+	if err != nil {
+		log.Printf("Failed to render invoice of order #%d: %v", orderID, err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to render invoice"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, invoiceFilename(inv)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(data)
+}
+
+func invoiceFilename(inv invoice.Invoice) string {
+	return "factuur-" + inv.Number + ".pdf"
+}
+
+// invoiceURL builds the signed buyer download link for an order's invoice
+func (h *Handler) invoiceURL(orderID int64) string {
+	expires := time.Now().Add(invoiceLinkTTL).Unix()
+	return fmt.Sprintf("%s/shop/orders/%d/invoice.pdf?expires=%d&sig=%s",
+		h.cfg.APIURL, orderID, expires, h.invoiceSignature(orderID, expires))
+}
+
+func (h *Handler) invoiceSignature(orderID, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(h.cfg.JWT.Secret))
+	fmt.Fprintf(mac, "invoice:%d:%d", orderID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handler) verifyInvoiceSignature(orderID, expires int64, sig string) bool {
+	if expires == 0 || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(h.invoiceSignature(orderID, expires)))
+}
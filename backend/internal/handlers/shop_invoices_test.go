@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getInvoice(h *Handler, orderID int64) *httptest.ResponseRecorder {
+	id := fmt.Sprint(orderID)
+	w := httptest.NewRecorder()
+	h.GetShopOrderInvoice(w, withURLParams(httptest.NewRequest("GET", "/admin/shop/orders/"+id+"/invoice.pdf", nil), "id", id))
+	return w
+}
+
+func issueInvoice(h *Handler, orderID int64) *httptest.ResponseRecorder {
+	id := fmt.Sprint(orderID)
+	w := httptest.NewRecorder()
+	h.IssueShopOrderInvoice(w, withURLParams(httptest.NewRequest("POST", "/admin/shop/orders/"+id+"/invoice", nil), "id", id))
+	return w
+}
+
+func invoiceCount(h *Handler) int {
+	var n int
+	h.db.QueryRow("SELECT COUNT(*) FROM shop_invoices").Scan(&n)
+	return n
+}
+
+func TestInvoiceIssuedOnPayment(t *testing.T) {
+	h := newTestHandler(t)
+	_, orderID := newTestOrder(t, h, false, 5, 2)
+	exec(t, h, "UPDATE shop_orders SET lang_code = 'EN', discount_code = 'SUMMER', discount_cents = 300, amount_cents = 1700 WHERE id = ?", orderID)
+
+	// Looking at an unpaid order's invoice doesn't issue one
+	if w := getInvoice(h, orderID); w.Code != http.StatusNotFound {
+		t.Errorf("before payment: status = %d, want 404", w.Code)
+	}
+	if invoiceCount(h) != 0 {
+		t.Fatal("GET issued an invoice")
+	}
+
+	event := fmt.Sprintf(`{"id":"evt_1","kind":"paid","order_id":%d,"payment_id":"fake_pay_1"}`, orderID)
+	if code := deliverWebhook(t, h, event); code != http.StatusOK {
+		t.Fatalf("webhook status = %d", code)
+	}
+	inv, err := h.loadInvoice(orderID)
+	if err != nil {
+		t.Fatalf("no invoice after payment: %v", err)
+	}
+	if want := fmt.Sprintf("%d-0001", time.Now().Year()); inv.Number != want {
+		t.Errorf("number = %s, want %s", inv.Number, want)
+	}
+	// The discount line is in the buyer's language
+	if len(inv.Lines) != 2 || inv.Lines[1].Description != "Discount (SUMMER)" || inv.TotalCents() != 1700 {
+		t.Errorf("lines = %+v", inv.Lines)
+	}
+
+	w := getInvoice(h, orderID)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/pdf" {
+		t.Errorf("after payment: status = %d, type %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if invoiceCount(h) != 1 {
+		t.Errorf("%d invoices, want 1", invoiceCount(h))
+	}
+}
+
+func TestIssueShopOrderInvoice(t *testing.T) {
+	h := newTestHandler(t)
+	_, pending := newTestOrder(t, h, false, 5, 1)
+	_, paid := newTestOrder(t, h, false, 5, 1)
+	// Paid before invoices were issued
+	exec(t, h, "UPDATE shop_orders SET status = 'completed' WHERE id = ?", paid)
+
+	if w := issueInvoice(h, pending); w.Code != http.StatusConflict {
+		t.Errorf("pending order: status = %d, want 409", w.Code)
+	}
+	if w := issueInvoice(h, 999); w.Code != http.StatusNotFound {
+		t.Errorf("unknown order: status = %d, want 404", w.Code)
+	}
+
+	var numbers []string
+	for i := 0; i < 2; i++ {
+		w := issueInvoice(h, paid)
+		if w.Code != http.StatusOK {
+			t.Fatalf("issue %d: status = %d: %s", i+1, w.Code, w.Body)
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		numbers = append(numbers, fmt.Sprint(resp["number"]))
+	}
+	// Issuing again returns the same invoice
+	if numbers[0] != numbers[1] || !strings.HasSuffix(numbers[0], "-0001") {
+		t.Errorf("numbers = %v", numbers)
+	}
+}
+
+func TestInvoiceIssuedWhenMarkedPaid(t *testing.T) {
+	h := newTestHandler(t)
+	_, orderID := newTestOrder(t, h, false, 5, 1)
+
+	update := func(status string) {
+		t.Helper()
+		id := fmt.Sprint(orderID)
+		r := httptest.NewRequest("PUT", "/admin/shop/orders/"+id+"/status", strings.NewReader(`{"status":"`+status+`"}`))
+		w := httptest.NewRecorder()
+		h.UpdateShopOrderStatus(w, withURLParams(r, "id", id))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %s: %d %s", status, w.Code, w.Body)
+		}
+	}
+
+	update("cancelled")
+	if invoiceCount(h) != 0 {
+		t.Error("a cancelled order was invoiced")
+	}
+	update("paid")
+	update("completed")
+	if invoiceCount(h) != 1 {
+		t.Errorf("%d invoices, want 1", invoiceCount(h))
+	}
+}
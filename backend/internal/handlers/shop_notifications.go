@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/email"
+)
+
+// orderLangCode normalises the language a buyer checked out in, falling
+// back to NL for unknown or inactive languages.
+func (h *Handler) orderLangCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return "NL"
+	}
+
+	var exists int
+	err := h.db.QueryRow("SELECT COUNT(*) FROM languages WHERE code = ? AND active = 1", code).Scan(&exists)
+	if err != nil || exists == 0 {
+		return "NL"
+	}
+	return code
+}
+
+// loadOrderEmail collects the order details shown in buyer and admin mails
+func (h *Handler) loadOrderEmail(orderID int64) (email.OrderEmail, error) {
+	var o email.OrderEmail
+	var amountCents int
+	var currency, token, carrier, pickupPoint, pickupAddress string
+	var slotStart, slotEnd sql.NullTime
+
+	err := h.db.QueryRow(`
+		SELECT o.id, o.token, o.lang_code, o.buyer_email, COALESCE(i.title, ''), o.quantity, o.amount_cents,
+		       o.fulfillment_type, COALESCE(i.pickup_label, ''), o.shipping_name, o.shipping_address,
+		       o.shipping_city, o.shipping_postal_code, o.shipping_country, o.carrier, o.tracking_number,
+		       COALESCE(p.name, ''), COALESCE(p.address, ''), s.starts_at, s.ends_at,
+		       COALESCE((SELECT currency FROM shop_settings WHERE id = 1), 'EUR')
+		FROM shop_orders o
+		LEFT JOIN shop_items i ON o.item_id = i.id
+		LEFT JOIN shop_pickup_slots s ON o.pickup_slot_id = s.id
+		LEFT JOIN shop_pickup_points p ON s.pickup_point_id = p.id
+		WHERE o.id = ?
+	`, orderID).Scan(&o.OrderID, &token, &o.LangCode, &o.BuyerEmail, &o.ItemTitle, &o.Quantity, &amountCents,
+		&o.FulfillmentType, &o.PickupLabel, &o.ShippingName, &o.ShippingAddress,
+		&o.ShippingCity, &o.ShippingPostalCode, &o.ShippingCountry, &carrier, &o.TrackingNumber,
+		&pickupPoint, &pickupAddress, &slotStart, &slotEnd, &currency)
+	if err != nil {
+		return o, err
+	}
+
+	o.AmountDisplay = formatPrice(amountCents, currency)
+	o.StatusURL = h.orderStatusURL(token)
+	if carrier != "" {
+		o.Carrier = carrierName(carrier)
+	}
+	o.TrackingURL = trackingURL(carrier, o.TrackingNumber, o.ShippingPostalCode, o.ShippingCountry)
+	if pickupPoint != "" {
+		o.PickupLabel = pickupPoint
+		if pickupAddress != "" {
+			o.PickupLabel += ", " + pickupAddress
+		}
+	}
+	if slotStart.Valid && slotEnd.Valid {
+		o.PickupTime = formatPickupSlot(slotStart.Time, slotEnd.Time)
+	}
+	if o.FulfillmentType == "pickup" {
+		o.PickupQRURL = h.pickupQRURL(token)
+	}
+	return o, nil
+}
+
+// notifyOrderPaid issues the invoice and sends the buyer confirmation (with
+// the invoice attached) and the admin notification.
+func (h *Handler) notifyOrderPaid(orderID int64) {
+	o, err := h.loadOrderEmail(orderID)
+	if err != nil {
+		log.Printf("Failed to load order #%d for emails: %v", orderID, err)
+		return
+	}
+
+	if inv, data, err := h.renderOrderInvoice(orderID); err != nil {
+		log.Printf("Failed to issue invoice for order #%d: %v", orderID, err)
+	} else {
+		o.InvoiceURL = h.invoiceURL(orderID)
+		o.Attachments = []email.Attachment{{
+			Filename:    invoiceFilename(inv),
+			ContentType: "application/pdf",
+			Data:        data,
+		}}
+	}
+
+	go h.emailService.SendOrderConfirmation(o)
+	go h.emailService.SendNewOrderNotification(o)
+}
+
+// notifyOrderStatus mails the buyer about status changes they care about.
+// Other statuses are admin bookkeeping and don't trigger a mail.
+func (h *Handler) notifyOrderStatus(orderID int64, status string) {
+	var send func(email.OrderEmail)
+	switch status {
+	case "ready_for_pickup":
+		send = h.emailService.SendOrderReadyForPickup
+	case "shipped":
+		send = h.emailService.SendOrderShipped
+	case "refunded":
+		send = h.emailService.SendOrderRefunded
+	default:
+		return
+	}
+
+	o, err := h.loadOrderEmail(orderID)
+	if err != nil {
+		log.Printf("Failed to load order #%d for emails: %v", orderID, err)
+		return
+	}
+
+	go send(o)
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// orderLookupLinkTTL is how long the "all my orders" link in the lookup mail works
+const orderLookupLinkTTL = 24 * time.Hour
+
+var orderTokenRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// newOrderToken returns the unguessable token buyers use to view an order
+func newOrderToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// orderStatusURL is the frontend page showing a single order
+func (h *Handler) orderStatusURL(token string) string {
+	return fmt.Sprintf("%s/shop/orders/%s", h.cfg.FrontendURL, token)
+}
+
+// PublicShopOrder is what a buyer sees of their order. Admin-only fields
+// (notes, payment references, full address) are left out.
+type PublicShopOrder struct {
+	OrderID         int64      `json:"order_id"`
+	Token           string     `json:"token"`
+	Status          string     `json:"status"`
+	ItemTitle       string     `json:"item_title"`
+	ItemImageURL    string     `json:"item_image_url,omitempty"`
+	Quantity        int        `json:"quantity"`
+	AmountCents     int        `json:"amount_cents"`
+	AmountDisplay   string     `json:"amount_display"`
+	DiscountCode    string     `json:"discount_code,omitempty"`
+	RefundedCents   int        `json:"refunded_cents,omitempty"`
+	RefundedDisplay string     `json:"refunded_display,omitempty"`
+	FulfillmentType string     `json:"fulfillment_type"`
+	PickupLabel     string     `json:"pickup_label,omitempty"`
+	PickupAddress   string     `json:"pickup_address,omitempty"`
+	PickupStartsAt  *time.Time `json:"pickup_starts_at,omitempty"`
+	PickupEndsAt    *time.Time `json:"pickup_ends_at,omitempty"`
+	PickupQRURL     string     `json:"pickup_qr_url,omitempty"`
+	PickedUpAt      *time.Time `json:"picked_up_at,omitempty"`
+	ShippingName    string     `json:"shipping_name,omitempty"`
+	ShippingCity    string     `json:"shipping_city,omitempty"`
+	ShippingCountry string     `json:"shipping_country,omitempty"`
+	Carrier         string     `json:"carrier,omitempty"`
+	TrackingNumber  string     `json:"tracking_number,omitempty"`
+	TrackingURL     string     `json:"tracking_url,omitempty"`
+	ShippedAt       *time.Time `json:"shipped_at,omitempty"`
+	InvoiceURL      string     `json:"invoice_url,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+const publicShopOrderSelect = `
+	SELECT o.id, o.token, o.status, COALESCE(i.title, ''), COALESCE(i.image_url, ''), o.quantity,
+	       o.amount_cents, o.discount_code, o.refunded_cents, o.fulfillment_type,
+	       COALESCE(p.name, i.pickup_label, ''), COALESCE(p.address, ''), s.starts_at, s.ends_at, o.picked_up_at,
+	       o.shipping_name, o.shipping_city, o.shipping_postal_code, o.shipping_country,
+	       o.carrier, o.tracking_number, o.shipped_at, o.created_at, o.updated_at,
+	       (SELECT COUNT(*) FROM shop_invoices inv WHERE inv.order_id = o.id)
+	FROM shop_orders o
+	LEFT JOIN shop_items i ON o.item_id = i.id
+	LEFT JOIN shop_pickup_slots s ON o.pickup_slot_id = s.id
+	LEFT JOIN shop_pickup_points p ON s.pickup_point_id = p.id`
+
+func (h *Handler) scanPublicShopOrder(rows interface {
+	Scan(dest ...any) error
+}, currency string) (PublicShopOrder, error) {
+	var o PublicShopOrder
+	var invoices int
+	var slotStart, slotEnd, pickedUp, shippedAt sql.NullTime
+	var carrier, postal string
+	err := rows.Scan(&o.OrderID, &o.Token, &o.Status, &o.ItemTitle, &o.ItemImageURL, &o.Quantity,
+		&o.AmountCents, &o.DiscountCode, &o.RefundedCents, &o.FulfillmentType,
+		&o.PickupLabel, &o.PickupAddress, &slotStart, &slotEnd, &pickedUp,
+		&o.ShippingName, &o.ShippingCity, &postal, &o.ShippingCountry,
+		&carrier, &o.TrackingNumber, &shippedAt, &o.CreatedAt, &o.UpdatedAt, &invoices)
+	if err != nil {
+		return o, err
+	}
+
+	o.AmountDisplay = formatPrice(o.AmountCents, currency)
+	if o.RefundedCents > 0 {
+		o.RefundedDisplay = formatPrice(o.RefundedCents, currency)
+	}
+	if o.FulfillmentType == "pickup" {
+		o.ShippingName, o.ShippingCity, o.ShippingCountry = "", "", ""
+		o.PickupQRURL = h.pickupQRURL(o.Token)
+		if slotStart.Valid && slotEnd.Valid {
+			o.PickupStartsAt, o.PickupEndsAt = &slotStart.Time, &slotEnd.Time
+		}
+		if pickedUp.Valid {
+			o.PickedUpAt = &pickedUp.Time
+		}
+	} else {
+		o.PickupLabel, o.PickupAddress = "", ""
+		if carrier != "" {
+			o.Carrier = carrierName(carrier)
+		}
+		o.TrackingURL = trackingURL(carrier, o.TrackingNumber, postal, o.ShippingCountry)
+		if shippedAt.Valid {
+			o.ShippedAt = &shippedAt.Time
+		}
+	}
+	if invoices > 0 {
+		o.InvoiceURL = h.invoiceURL(o.OrderID)
+	}
+	return o, nil
+}
+
+// GetPublicShopOrder shows a buyer the status of one order by its token
+func (h *Handler) GetPublicShopOrder(w http.ResponseWriter, r *http.Request) {
+	token := strings.ToLower(chi.URLParam(r, "token"))
+	if !orderTokenRegex.MatchString(token) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+
+	settings, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	row := h.db.QueryRow(publicShopOrderSelect+" WHERE o.token = ?", token)
+	order, err := h.scanPublicShopOrder(row, settings.Currency)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, order)
+}
+
+type orderLookupRequest struct {
+	Email    string `json:"email"`
+	LangCode string `json:"lang"`
+}
+
+// RequestShopOrderLookup mails a link listing every order of an address.
+// The response is the same whether or not orders exist, so the endpoint
+// can't be used to find out who bought something.
+func (h *Handler) RequestShopOrderLookup(w http.ResponseWriter, r *http.Request) {
+	var req orderLookupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	address := strings.ToLower(strings.TrimSpace(req.Email))
+	if !validateEmail(address) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "A valid email is required"})
+		return
+	}
+
+	var count int
+	err := h.db.QueryRow(`
+		SELECT COUNT(*) FROM shop_orders WHERE buyer_email = ? COLLATE NOCASE AND status != 'pending'
+	`, address).Scan(&count)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	if count > 0 {
+		go h.emailService.SendOrderLookup(address, h.orderLangCode(req.LangCode), h.orderLookupURL(address))
+	} else {
+		log.Printf("Order lookup requested for an address without orders")
+	}
+
+	respondJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If there are orders for this address, a link to them has been mailed",
+	})
+}
+
+// GetShopOrderLookup lists the orders of the address in a signed lookup link
+func (h *Handler) GetShopOrderLookup(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	address := strings.ToLower(strings.TrimSpace(q.Get("email")))
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if !h.verifyOrderLookupSignature(address, expires, q.Get("sig")) {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "Invalid or expired link"})
+		return
+	}
+
+	settings, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	rows, err := h.db.Query(publicShopOrderSelect+`
+		WHERE o.buyer_email = ? COLLATE NOCASE AND o.status != 'pending'
+		ORDER BY o.created_at DESC
+		LIMIT 100
+	`, address)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	orders := []PublicShopOrder{}
+	for rows.Next() {
+		o, err := h.scanPublicShopOrder(rows, settings.Currency)
+		if err != nil {
+			continue
+		}
+		orders = append(orders, o)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"email":  address,
+		"orders": orders,
+	})
+}
+
+func (h *Handler) orderLookupURL(address string) string {
+	expires := time.Now().Add(orderLookupLinkTTL).Unix()
+	return fmt.Sprintf("%s/shop/orders?email=%s&expires=%d&sig=%s",
+		h.cfg.FrontendURL, url.QueryEscape(address), expires, h.orderLookupSignature(address, expires))
+}
+
+func (h *Handler) orderLookupSignature(address string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(h.cfg.JWT.Secret))
+	fmt.Fprintf(mac, "order-lookup:%s:%d", address, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handler) verifyOrderLookupSignature(address string, expires int64, sig string) bool {
+	if address == "" || expires == 0 || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(h.orderLookupSignature(address, expires)))
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PaymentEventLog is a stored webhook delivery from any payment provider
+type PaymentEventLog struct {
+	ID             string          `json:"id"`
+	Provider       string          `json:"provider"`
+	Type           string          `json:"type"`
+	Status         string          `json:"status"`
+	Error          string          `json:"error,omitempty"`
+	OrderID        *int64          `json:"order_id,omitempty"`
+	RegistrationID *int64          `json:"registration_id,omitempty"`
+	Attempts       int             `json:"attempts"`
+	ReceivedAt     string          `json:"received_at"`
+	ProcessedAt    *string         `json:"processed_at,omitempty"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+}
+
+func scanPaymentEventLog(rows interface {
+	Scan(dest ...any) error
+}, withPayload bool) (PaymentEventLog, error) {
+	var e PaymentEventLog
+	var orderID, registrationID sql.NullInt64
+	var processedAt sql.NullString
+	var payload string
+
+	err := rows.Scan(&e.ID, &e.Provider, &e.Type, &e.Status, &e.Error, &orderID, &registrationID, &e.Attempts,
+		&e.ReceivedAt, &processedAt, &payload)
+	if err != nil {
+		return e, err
+	}
+
+	if orderID.Valid {
+		id := orderID.Int64
+		e.OrderID = &id
+	}
+	if registrationID.Valid {
+		id := registrationID.Int64
+		e.RegistrationID = &id
+	}
+	if processedAt.Valid {
+		e.ProcessedAt = &processedAt.String
+	}
+	if withPayload {
+		e.Payload = json.RawMessage(payload)
+	}
+	return e, nil
+}
+
+// GetPaymentEvents lists stored webhook events, newest first.
+// Optional filters: ?status=, ?provider=, ?type=, ?order_id=, ?registration_id=,
+// ?limit= (default 100, max 500)
+func (h *Handler) GetPaymentEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	query := `
+		SELECT id, provider, type, status, error, order_id, registration_id, attempts, received_at, processed_at, payload
+		FROM payment_events WHERE 1 = 1`
+	args := []interface{}{}
+
+	if status := q.Get("status"); status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	if provider := q.Get("provider"); provider != "" {
+		query += " AND provider = ?"
+		args = append(args, provider)
+	}
+	if eventType := q.Get("type"); eventType != "" {
+		query += " AND type = ?"
+		args = append(args, eventType)
+	}
+	if orderID := q.Get("order_id"); orderID != "" {
+		query += " AND order_id = ?"
+		args = append(args, orderID)
+	}
+	if registrationID := q.Get("registration_id"); registrationID != "" {
+		query += " AND registration_id = ?"
+		args = append(args, registrationID)
+	}
+	query += " ORDER BY received_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, []PaymentEventLog{})
+		return
+	}
+	defer rows.Close()
+
+	events := []PaymentEventLog{}
+	for rows.Next() {
+		e, err := scanPaymentEventLog(rows, false)
+		if err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// GetPaymentEventByID returns a stored webhook event including its raw payload
+func (h *Handler) GetPaymentEventByID(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	e, err := scanPaymentEventLog(h.db.QueryRow(`
+		SELECT id, provider, type, status, error, order_id, registration_id, attempts, received_at, processed_at, payload
+		FROM payment_events WHERE id = ?
+	`, id), true)
+
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, e)
+}
+
+// ReplayPaymentEvent re-applies a stored event. The payload was verified when
+// it was received, so it is trusted here.
+func (h *Handler) ReplayPaymentEvent(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var providerName, payload string
+	err := h.db.QueryRow("SELECT provider, payload FROM payment_events WHERE id = ?", id).Scan(&providerName, &payload)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Event not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	settings, err := h.getShopSettings()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch shop settings"})
+		return
+	}
+	provider, err := h.paymentProvider(providerName, settings)
+	if err != nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Payment provider for this event is not available"})
+		return
+	}
+
+	event, err := provider.DecodeEvent([]byte(payload))
+	if err != nil {
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Stored payload could not be decoded"})
+		return
+	}
+
+	// Errors are recorded on the event row; return the row either way
+	h.handlePaymentEvent(provider.Name(), event)
+
+	h.GetPaymentEventByID(w, r)
+}
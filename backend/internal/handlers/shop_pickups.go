@@ -0,0 +1,729 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/xlsx"
+	"github.com/go-chi/chi/v5"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// PickupPoint is a place buyers can collect pickup orders, often one of our events
+type PickupPoint struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Address     string       `json:"address"`
+	Description string       `json:"description"`
+	EventID     *int64       `json:"event_id,omitempty"`
+	EventTitle  string       `json:"event_title,omitempty"`
+	Active      bool         `json:"active"`
+	SortOrder   int          `json:"sort_order"`
+	Slots       []PickupSlot `json:"slots"`
+}
+
+// PickupSlot is a time window at a pickup point. Capacity is the number of
+// orders it can take; nil means unlimited.
+type PickupSlot struct {
+	ID            int64     `json:"id"`
+	PickupPointID int64     `json:"pickup_point_id"`
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+	Capacity      *int      `json:"capacity,omitempty"`
+	Booked        int       `json:"booked"`
+	Available     *int      `json:"available,omitempty"`
+	Full          bool      `json:"full"`
+}
+
+type pickupPointRequest struct {
+	Name        string `json:"name"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+	EventID     *int64 `json:"event_id"`
+	Active      bool   `json:"active"`
+	SortOrder   int    `json:"sort_order"`
+}
+
+type pickupSlotRequest struct {
+	StartsAt string `json:"starts_at"`
+	EndsAt   string `json:"ends_at"`
+	Capacity *int   `json:"capacity"`
+}
+
+// pickupLocation is the timezone slot times are shown in
+var pickupLocation = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Brussels")
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}()
+
+// formatPickupSlot renders a slot as "02/01/2006 10:00 - 12:00" in local time
+func formatPickupSlot(start, end time.Time) string {
+	start, end = start.In(pickupLocation), end.In(pickupLocation)
+	if start.Format("2006-01-02") == end.Format("2006-01-02") {
+		return start.Format("02/01/2006 15:04") + " - " + end.Format("15:04")
+	}
+	return start.Format("02/01/2006 15:04") + " - " + end.Format("02/01/2006 15:04")
+}
+
+// pickupBookedSQL counts the orders holding a place in slot s. Pending
+// orders count too, and checkout checks it in the order's INSERT, so a slot
+// can't be oversold during checkout.
+const pickupBookedSQL = `(SELECT COUNT(*) FROM shop_orders o
+	WHERE o.pickup_slot_id = s.id AND o.status NOT IN ('cancelled', 'refunded'))`
+
+const pickupSlotSelect = `
+	SELECT s.id, s.pickup_point_id, s.starts_at, s.ends_at, s.capacity, ` + pickupBookedSQL + `
+	FROM shop_pickup_slots s`
+
+func scanPickupSlot(rows interface {
+	Scan(dest ...any) error
+}) (PickupSlot, error) {
+	var s PickupSlot
+	var capacity sql.NullInt64
+	if err := rows.Scan(&s.ID, &s.PickupPointID, &s.StartsAt, &s.EndsAt, &capacity, &s.Booked); err != nil {
+		return s, err
+	}
+	s.setCapacity(capacity)
+	return s, nil
+}
+
+// setCapacity fills in the capacity and what is left of it
+func (s *PickupSlot) setCapacity(capacity sql.NullInt64) {
+	if !capacity.Valid {
+		return
+	}
+	c := int(capacity.Int64)
+	available := c - s.Booked
+	if available < 0 {
+		available = 0
+	}
+	s.Capacity = &c
+	s.Available = &available
+	s.Full = available == 0
+}
+
+// loadPickupSlots returns the slots of a point, only future ones if upcoming is set
+func (h *Handler) loadPickupSlots(pointID int64, upcoming bool) ([]PickupSlot, error) {
+	query := pickupSlotSelect + " WHERE s.pickup_point_id = ?"
+	if upcoming {
+		query += " AND s.starts_at > ?"
+	}
+	query += " ORDER BY s.starts_at"
+
+	args := []interface{}{pointID}
+	if upcoming {
+		args = append(args, time.Now().UTC())
+	}
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := []PickupSlot{}
+	for rows.Next() {
+		s, err := scanPickupSlot(rows)
+		if err != nil {
+			continue
+		}
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+const pickupPointSelect = `
+	SELECT p.id, p.name, p.address, p.description, p.event_id, COALESCE(e.title, ''), p.active, p.sort_order
+	FROM shop_pickup_points p
+	LEFT JOIN events e ON p.event_id = e.id`
+
+func scanPickupPoint(rows interface {
+	Scan(dest ...any) error
+}) (PickupPoint, error) {
+	var p PickupPoint
+	var eventID sql.NullInt64
+	var active int
+	err := rows.Scan(&p.ID, &p.Name, &p.Address, &p.Description, &eventID, &p.EventTitle, &active, &p.SortOrder)
+	if err != nil {
+		return p, err
+	}
+	if eventID.Valid {
+		p.EventID = &eventID.Int64
+	}
+	p.Active = active == 1
+	return p, nil
+}
+
+// loadPickupPoints returns pickup points with their slots. The public view
+// only lists active points that still have upcoming slots.
+func (h *Handler) loadPickupPoints(public bool) ([]PickupPoint, error) {
+	query := pickupPointSelect
+	if public {
+		query += " WHERE p.active = 1"
+	}
+	query += " ORDER BY p.sort_order, p.name"
+
+	rows, err := h.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	var points []PickupPoint
+	for rows.Next() {
+		p, err := scanPickupPoint(rows)
+		if err != nil {
+			continue
+		}
+		points = append(points, p)
+	}
+	rows.Close()
+
+	result := []PickupPoint{}
+	for _, p := range points {
+		slots, err := h.loadPickupSlots(p.ID, public)
+		if err != nil {
+			return nil, err
+		}
+		if public && len(slots) == 0 {
+			continue
+		}
+		p.Slots = slots
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// hasUpcomingPickupSlots reports whether buyers must pick a slot at checkout
+func (h *Handler) hasUpcomingPickupSlots() bool {
+	var count int
+	h.db.QueryRow(`
+		SELECT COUNT(*) FROM shop_pickup_slots s
+		JOIN shop_pickup_points p ON s.pickup_point_id = p.id
+		WHERE p.active = 1 AND s.starts_at > ?
+	`, time.Now().UTC()).Scan(&count)
+	return count > 0
+}
+
+// checkPickupSlot validates a slot chosen at checkout. The returned message
+// is meant for the buyer; it is empty when the slot can be booked.
+func (h *Handler) checkPickupSlot(slotID int64) (string, error) {
+	var active int
+	slot, err := scanPickupSlot(h.db.QueryRow(pickupSlotSelect+" WHERE s.id = ?", slotID))
+	if err == sql.ErrNoRows {
+		return "Invalid pickup time slot", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := h.db.QueryRow("SELECT active FROM shop_pickup_points WHERE id = ?", slot.PickupPointID).Scan(&active); err != nil {
+		return "", err
+	}
+
+	switch {
+	case active == 0:
+		return "Invalid pickup time slot", nil
+	case !slot.StartsAt.After(time.Now()):
+		return "This pickup time slot has already started", nil
+	case slot.Full:
+		return "This pickup time slot is full", nil
+	}
+	return "", nil
+}
+
+// GetPublicPickupPoints lists where and when pickup orders can be collected
+func (h *Handler) GetPublicPickupPoints(w http.ResponseWriter, r *http.Request) {
+	points, err := h.loadPickupPoints(true)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, http.StatusOK, points)
+}
+
+func (h *Handler) GetPickupPoints(w http.ResponseWriter, r *http.Request) {
+	points, err := h.loadPickupPoints(false)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, http.StatusOK, points)
+}
+
+func (h *Handler) GetPickupPointByID(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	p, err := scanPickupPoint(h.db.QueryRow(pickupPointSelect+" WHERE p.id = ?", id))
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Pickup point not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	if p.Slots, err = h.loadPickupSlots(p.ID, false); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	respondJSON(w, http.StatusOK, p)
+}
+
+func (h *Handler) validatePickupPointRequest(req *pickupPointRequest) string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Address = truncateString(strings.TrimSpace(req.Address), 500)
+	req.Description = truncateString(strings.TrimSpace(req.Description), maxStringLength)
+	if req.Name == "" || len(req.Name) > 200 {
+		return "Name is required (max 200 characters)"
+	}
+	if req.EventID != nil {
+		var exists int
+		h.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ?", *req.EventID).Scan(&exists)
+		if exists == 0 {
+			return "Event not found"
+		}
+	}
+	return ""
+}
+
+func (h *Handler) CreatePickupPoint(w http.ResponseWriter, r *http.Request) {
+	var req pickupPointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if msg := h.validatePickupPointRequest(&req); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		INSERT INTO shop_pickup_points (name, address, description, event_id, active, sort_order)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, req.Name, req.Address, req.Description, nullablePtrInt64(req.EventID), boolToInt(req.Active), req.SortOrder)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create pickup point"})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	respondJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "message": "Pickup point created"})
+}
+
+func (h *Handler) UpdatePickupPoint(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req pickupPointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if msg := h.validatePickupPointRequest(&req); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE shop_pickup_points
+		SET name = ?, address = ?, description = ?, event_id = ?, active = ?, sort_order = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, req.Name, req.Address, req.Description, nullablePtrInt64(req.EventID), boolToInt(req.Active), req.SortOrder, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update pickup point"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Pickup point not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Pickup point updated"})
+}
+
+// DeletePickupPoint refuses to remove points that still have orders booked,
+// deactivate those instead.
+func (h *Handler) DeletePickupPoint(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var booked int
+	h.db.QueryRow(`
+		SELECT COUNT(*) FROM shop_orders o
+		JOIN shop_pickup_slots s ON o.pickup_slot_id = s.id
+		WHERE s.pickup_point_id = ? AND o.status NOT IN ('cancelled', 'refunded')
+	`, id).Scan(&booked)
+	if booked > 0 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Pickup point has orders, deactivate it instead"})
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM shop_pickup_points WHERE id = ?", id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete pickup point"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Pickup point deleted"})
+}
+
+// parseSlotRequest validates slot times (RFC3339) and capacity, returning UTC times
+func parseSlotRequest(req pickupSlotRequest) (time.Time, time.Time, string) {
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(req.StartsAt))
+	if err != nil {
+		return start, start, "Start and end times must be RFC3339 timestamps"
+	}
+	end, err := time.Parse(time.RFC3339, strings.TrimSpace(req.EndsAt))
+	if err != nil {
+		return start, end, "Start and end times must be RFC3339 timestamps"
+	}
+	if !end.After(start) {
+		return start, end, "End time must be after start time"
+	}
+	if req.Capacity != nil && (*req.Capacity < 1 || *req.Capacity > 10000) {
+		return start, end, "Capacity must be between 1 and 10000"
+	}
+	return start.UTC(), end.UTC(), ""
+}
+
+func (h *Handler) CreatePickupSlot(w http.ResponseWriter, r *http.Request) {
+	pointID := chi.URLParam(r, "id")
+
+	var req pickupSlotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	start, end, msg := parseSlotRequest(req)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	var exists int
+	h.db.QueryRow("SELECT COUNT(*) FROM shop_pickup_points WHERE id = ?", pointID).Scan(&exists)
+	if exists == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Pickup point not found"})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		INSERT INTO shop_pickup_slots (pickup_point_id, starts_at, ends_at, capacity)
+		VALUES (?, ?, ?, ?)
+	`, pointID, start, end, nullableInt(req.Capacity))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create time slot"})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	respondJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "message": "Time slot created"})
+}
+
+func (h *Handler) UpdatePickupSlot(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req pickupSlotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	start, end, msg := parseSlotRequest(req)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE shop_pickup_slots SET starts_at = ?, ends_at = ?, capacity = ? WHERE id = ?
+	`, start, end, nullableInt(req.Capacity), id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update time slot"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Time slot not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Time slot updated"})
+}
+
+func (h *Handler) DeletePickupSlot(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var booked int
+	h.db.QueryRow(`
+		SELECT COUNT(*) FROM shop_orders WHERE pickup_slot_id = ? AND status NOT IN ('cancelled', 'refunded')
+	`, id).Scan(&booked)
+	if booked > 0 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Time slot has orders and cannot be deleted"})
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM shop_pickup_slots WHERE id = ?", id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete time slot"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Time slot deleted"})
+}
+
+// PickupListOrder is one line on the volunteers' pickup list
+type PickupListOrder struct {
+	OrderID    int64   `json:"order_id"`
+	BuyerEmail string  `json:"buyer_email"`
+	ItemTitle  string  `json:"item_title"`
+	Quantity   int     `json:"quantity"`
+	Status     string  `json:"status"`
+	Notes      string  `json:"notes,omitempty"`
+	PickedUpAt *string `json:"picked_up_at,omitempty"`
+}
+
+// PickupListSlot groups the orders to hand out in one time slot
+type PickupListSlot struct {
+	PickupSlot
+	PointName string            `json:"point_name"`
+	Label     string            `json:"label"`
+	Orders    []PickupListOrder `json:"orders"`
+}
+
+// pickupListStatuses are the orders volunteers should expect at the desk
+const pickupListStatuses = `'paid', 'confirmed', 'ready_for_pickup', 'completed'`
+
+// GetPickupList lists the orders per slot for a pickup point and/or day,
+// as JSON or as a printable CSV/XLSX for volunteers.
+// Query: point_id, date (YYYY-MM-DD, local time), format (json|csv|xlsx)
+func (h *Handler) GetPickupList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if v := q.Get("point_id"); v != "" {
+		pointID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid pickup point"})
+			return
+		}
+		where = append(where, "s.pickup_point_id = ?")
+		args = append(args, pointID)
+	}
+	if v := q.Get("date"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, pickupLocation)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Dates must be formatted YYYY-MM-DD"})
+			return
+		}
+		where = append(where, "s.starts_at >= ? AND s.starts_at < ?")
+		args = append(args, day.UTC(), day.AddDate(0, 0, 1).UTC())
+	}
+
+	rows, err := h.db.Query(`
+		SELECT s.id, s.pickup_point_id, s.starts_at, s.ends_at, s.capacity, `+pickupBookedSQL+`, p.name
+		FROM shop_pickup_slots s
+		JOIN shop_pickup_points p ON s.pickup_point_id = p.id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY s.starts_at, p.sort_order, p.name
+	`, args...)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	slots := []PickupListSlot{}
+	for rows.Next() {
+		var s PickupListSlot
+		var capacity sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.PickupPointID, &s.StartsAt, &s.EndsAt, &capacity, &s.Booked, &s.PointName); err != nil {
+			continue
+		}
+		s.setCapacity(capacity)
+		s.Label = formatPickupSlot(s.StartsAt, s.EndsAt)
+		slots = append(slots, s)
+	}
+	rows.Close()
+
+	for i := range slots {
+		slots[i].Orders, err = h.loadPickupListOrders(slots[i].ID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+	}
+
+	format := q.Get("format")
+	if format != "csv" && format != "xlsx" {
+		respondJSON(w, http.StatusOK, slots)
+		return
+	}
+
+	sheet := xlsx.Sheet{
+		Name:    "Pickups",
+		Headers: []string{"Pickup point", "Time slot", "Order", "Buyer email", "Item", "Quantity", "Status", "Picked up", "Notes"},
+	}
+	for _, s := range slots {
+		for _, o := range s.Orders {
+			pickedUp := ""
+			if o.PickedUpAt != nil {
+				pickedUp = "x"
+			}
+			sheet.Rows = append(sheet.Rows, []interface{}{
+				s.PointName, s.Label, o.OrderID, o.BuyerEmail, o.ItemTitle, o.Quantity, o.Status, pickedUp, o.Notes,
+			})
+		}
+	}
+	filename := "pickups"
+	if d := q.Get("date"); d != "" {
+		filename += "-" + d
+	}
+	respondTable(w, format, filename, []xlsx.Sheet{sheet})
+}
+
+func (h *Handler) loadPickupListOrders(slotID int64) ([]PickupListOrder, error) {
+	rows, err := h.db.Query(`
+		SELECT o.id, o.buyer_email, COALESCE(i.title, '(deleted)'), o.quantity, o.status, o.notes, o.picked_up_at
+		FROM shop_orders o
+		LEFT JOIN shop_items i ON o.item_id = i.id
+		WHERE o.pickup_slot_id = ? AND o.status IN (`+pickupListStatuses+`)
+		ORDER BY o.buyer_email, o.id
+	`, slotID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []PickupListOrder{}
+	for rows.Next() {
+		var o PickupListOrder
+		if err := rows.Scan(&o.OrderID, &o.BuyerEmail, &o.ItemTitle, &o.Quantity, &o.Status, &o.Notes, &o.PickedUpAt); err != nil {
+			continue
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// pickupQRURL is the image buyers show at the pickup desk
+func (h *Handler) pickupQRURL(token string) string {
+	return fmt.Sprintf("%s/shop/orders/%s/pickup-qr.png", h.cfg.APIURL, token)
+}
+
+// GetOrderPickupQR renders the QR code of an order's status page. Scanning
+// it at the desk hands the order over; see ScanPickup.
+func (h *Handler) GetOrderPickupQR(w http.ResponseWriter, r *http.Request) {
+	token := strings.ToLower(chi.URLParam(r, "token"))
+	if !orderTokenRegex.MatchString(token) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+
+	var fulfillment string
+	err := h.db.QueryRow("SELECT fulfillment_type FROM shop_orders WHERE token = ?", token).Scan(&fulfillment)
+	if err != nil || fulfillment != "pickup" {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+
+	png, err := qrcode.Encode(h.orderStatusURL(token), qrcode.Medium, 384)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate QR code"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(png)
+}
+
+var orderTokenInText = regexp.MustCompile(`[0-9a-fA-F]{32}`)
+
+// handOverStatuses are the statuses an order may be in when it's picked up
+var handOverStatuses = map[string]bool{"paid": true, "confirmed": true, "ready_for_pickup": true}
+
+// ScanPickup marks a pickup order completed from its scanned QR code. The
+// code may be the full status URL or just the token.
+func (h *Handler) ScanPickup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	token := strings.ToLower(orderTokenInText.FindString(req.Code))
+	if token == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Not an order QR code"})
+		return
+	}
+
+	var order PickupListOrder
+	var fulfillment, slotLabel string
+	var startsAt, endsAt sql.NullTime
+	err := h.db.QueryRow(`
+		SELECT o.id, o.buyer_email, COALESCE(i.title, '(deleted)'), o.quantity, o.status, o.notes,
+		       o.picked_up_at, o.fulfillment_type, COALESCE(p.name, ''), s.starts_at, s.ends_at
+		FROM shop_orders o
+		LEFT JOIN shop_items i ON o.item_id = i.id
+		LEFT JOIN shop_pickup_slots s ON o.pickup_slot_id = s.id
+		LEFT JOIN shop_pickup_points p ON s.pickup_point_id = p.id
+		WHERE o.token = ?
+	`, token).Scan(&order.OrderID, &order.BuyerEmail, &order.ItemTitle, &order.Quantity, &order.Status,
+		&order.Notes, &order.PickedUpAt, &fulfillment, &slotLabel, &startsAt, &endsAt)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if startsAt.Valid && endsAt.Valid {
+		slotLabel += " " + formatPickupSlot(startsAt.Time, endsAt.Time)
+	}
+
+	if fulfillment != "pickup" {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{"error": "Order is not a pickup order", "order": order})
+		return
+	}
+	if order.Status == "completed" {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{"error": "Order was already picked up", "order": order})
+		return
+	}
+	if !handOverStatuses[order.Status] {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{
+			"error": fmt.Sprintf("Order is %s and cannot be handed over", order.Status),
+			"order": order,
+		})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE shop_orders SET status = 'completed', picked_up_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status IN ('paid', 'confirmed', 'ready_for_pickup')
+	`, time.Now().UTC(), order.OrderID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update order"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondJSON(w, http.StatusConflict, map[string]interface{}{"error": "Order was already picked up", "order": order})
+		return
+	}
+
+	order.Status = "completed"
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Order handed over",
+		"order":   order,
+		"slot":    strings.TrimSpace(slotLabel),
+	})
+}
+
+func nullablePtrInt64(v *int64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPickupSlotCapacity(t *testing.T) {
+	h := newTestHandler(t)
+	exec(t, h, "UPDATE shop_settings SET payment_provider = 'fake' WHERE id = 1")
+	itemID := exec(t, h, "INSERT INTO shop_items (title, price_cents, allow_pickup, active) VALUES ('Geocoin', 1000, 1, 1)")
+	pointID := exec(t, h, "INSERT INTO shop_pickup_points (name) VALUES ('Clubhuis')")
+	starts := time.Now().UTC().Add(48 * time.Hour)
+	slotID := exec(t, h, "INSERT INTO shop_pickup_slots (pickup_point_id, starts_at, ends_at, capacity) VALUES (?, ?, ?, 1)",
+		pointID, starts, starts.Add(time.Hour))
+
+	pickup := func() *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"item_id":%d,"quantity":1,"fulfillment_type":"pickup","buyer_email":"buyer@example.com","pickup_slot_id":%d}`, itemID, slotID)
+		w := httptest.NewRecorder()
+		h.CreateCheckoutSession(w, httptest.NewRequest("POST", "/shop/checkout", strings.NewReader(body)))
+		return w
+	}
+
+	// Of checkouts at the same time for the last place, one gets it
+	var wg sync.WaitGroup
+	codes := make([]int, 16)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = pickup().Code
+		}(i)
+	}
+	wg.Wait()
+
+	var orders int
+	h.db.QueryRow("SELECT COUNT(*) FROM shop_orders WHERE pickup_slot_id = ?", slotID).Scan(&orders)
+	if orders != 1 {
+		t.Errorf("%d orders in the slot (responses %v), want 1", orders, codes)
+	}
+
+	if w := pickup(); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "full") {
+		t.Errorf("full slot: status = %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,722 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/payment"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/xlsx"
+)
+
+// revenueStatuses are orders whose payment was captured. Refunds are
+// tracked separately through refunded_cents.
+var revenueStatuses = []string{
+	"paid", "confirmed", "ready_for_pickup", "shipped", "fulfilled", "completed", "refunded", "disputed",
+}
+
+// feeLookupsPerRequest caps provider API calls made by one fee report.
+// They run feeLookupWorkers at a time and stop after feeLookupTimeout, so
+// the report stays well within the request timeout; what is left is
+// fetched by the next report.
+const (
+	feeLookupsPerRequest = 50
+	feeLookupWorkers     = 5
+	feeLookupTimeout     = 10 * time.Second
+)
+
+// reportFilter narrows reports to orders created in a date range with
+// given statuses. Dates are inclusive and formatted YYYY-MM-DD.
+type reportFilter struct {
+	From     string
+	To       string
+	Statuses []string
+}
+
+// parseReportFilter reads ?from=, ?to= and ?status= (comma separated).
+// Without statuses, defaultStatuses is used (nil means all).
+func parseReportFilter(r *http.Request, defaultStatuses []string) (reportFilter, error) {
+	q := r.URL.Query()
+	f := reportFilter{From: q.Get("from"), To: q.Get("to"), Statuses: defaultStatuses}
+
+	for _, d := range []string{f.From, f.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return f, fmt.Errorf("invalid date %q", d)
+		}
+	}
+
+	if status := q.Get("status"); status != "" {
+		f.Statuses = nil
+		for _, s := range strings.Split(status, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				f.Statuses = append(f.Statuses, s)
+			}
+		}
+	}
+	return f, nil
+}
+
+// where returns an SQL condition on the shop_orders alias "o"
+func (f reportFilter) where() (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	args := []interface{}{}
+
+	if f.From != "" {
+		conds = append(conds, "o.created_at >= ?")
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		conds = append(conds, "o.created_at < date(?, '+1 day')")
+		args = append(args, f.To)
+	}
+	if len(f.Statuses) > 0 {
+		conds = append(conds, "o.status IN (?"+strings.Repeat(", ?", len(f.Statuses)-1)+")")
+		for _, s := range f.Statuses {
+			args = append(args, s)
+		}
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func (f reportFilter) filenameSuffix() string {
+	suffix := ""
+	if f.From != "" {
+		suffix += "-from-" + f.From
+	}
+	if f.To != "" {
+		suffix += "-to-" + f.To
+	}
+	return suffix
+}
+
+// centsToAmount converts cents to a decimal for spreadsheets
+func centsToAmount(cents int) float64 {
+	return float64(cents) / 100
+}
+
+// csvText keeps spreadsheets from running text as a formula: buyer emails,
+// addresses and discount codes are typed in by customers
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// respondTable writes sheets as CSV or XLSX depending on ?format=. CSV
+// output puts multiple sheets below each other, each under its name.
+func respondTable(w http.ResponseWriter, format, filename string, sheets []xlsx.Sheet) {
+	switch format {
+	case "xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		if err := xlsx.Write(w, sheets); err != nil {
+			log.Printf("Failed to write %s.xlsx: %v", filename, err)
+		}
+
+	default:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		// BOM so Excel detects UTF-8
+		w.Write([]byte("\xef\xbb\xbf"))
+
+		cw := csv.NewWriter(w)
+		for i, sheet := range sheets {
+			if len(sheets) > 1 {
+				if i > 0 {
+					cw.Write([]string{})
+				}
+				cw.Write([]string{sheet.Name})
+			}
+			cw.Write(sheet.Headers)
+			for _, row := range sheet.Rows {
+				record := make([]string, len(row))
+				for j, v := range row {
+					switch n := v.(type) {
+					case nil:
+					case float64:
+						record[j] = strconv.FormatFloat(n, 'f', 2, 64)
+					case string:
+						record[j] = csvText(n)
+					default:
+						record[j] = fmt.Sprint(v)
+					}
+				}
+				cw.Write(record)
+			}
+		}
+		cw.Flush()
+	}
+}
+
+// ExportShopOrders exports orders as CSV (default) or XLSX.
+// Optional filters: ?from=, ?to= (YYYY-MM-DD, inclusive), ?status= (comma separated), ?format=csv|xlsx
+func (h *Handler) ExportShopOrders(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseReportFilter(r, nil)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Dates must be formatted YYYY-MM-DD"})
+		return
+	}
+
+	where, args := filter.where()
+	rows, err := h.db.Query(`
+		SELECT o.id, o.created_at, o.status, COALESCE(i.title, '(deleted)'), o.quantity, o.amount_cents,
+		       o.refunded_cents, o.discount_code, o.discount_cents, o.payment_provider,
+		       COALESCE(CASE WHEN o.payment_provider = 'stripe' THEN o.stripe_payment_intent_id ELSE o.provider_payment_id END, ''),
+		       COALESCE(inv.number, ''), o.buyer_email, o.lang_code, o.fulfillment_type,
+		       o.shipping_name, o.shipping_address, o.shipping_postal_code, o.shipping_city, o.shipping_country,
+		       o.carrier, o.tracking_number, o.shipped_at, o.notes
+		FROM shop_orders o
+		LEFT JOIN shop_items i ON o.item_id = i.id
+		LEFT JOIN shop_invoices inv ON inv.order_id = o.id
+		WHERE `+where+`
+		ORDER BY o.created_at, o.id
+	`, args...)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	sheet := xlsx.Sheet{
+		Name: "Orders",
+		Headers: []string{
+			"Order", "Date", "Status", "Item", "Quantity", "Amount", "Refunded", "Discount code", "Discount",
+			"Provider", "Payment ID",
+			"Invoice", "Buyer email", "Language", "Fulfillment", "Name", "Address", "Postal code", "City",
+			"Country", "Carrier", "Tracking number", "Shipped at", "Notes",
+		},
+	}
+
+	for rows.Next() {
+		var id int64
+		var createdAt time.Time
+		var status, title, discountCode, provider, paymentID, invoiceNumber, buyerEmail, lang, fulfillment string
+		var name, address, postal, city, country, carrier, tracking, notes string
+		var shippedAt sql.NullTime
+		var quantity, amount, refunded, discount int
+		if err := rows.Scan(&id, &createdAt, &status, &title, &quantity, &amount, &refunded, &discountCode, &discount, &provider,
+			&paymentID, &invoiceNumber, &buyerEmail, &lang, &fulfillment,
+			&name, &address, &postal, &city, &country, &carrier, &tracking, &shippedAt, &notes); err != nil {
+			continue
+		}
+		shipped := ""
+		if shippedAt.Valid {
+			shipped = shippedAt.Time.Format("2006-01-02 15:04")
+		}
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			id, createdAt.Format("2006-01-02 15:04"), status, title, quantity, centsToAmount(amount),
+			centsToAmount(refunded), discountCode, centsToAmount(discount), provider, paymentID, invoiceNumber, buyerEmail, lang, fulfillment,
+			name, address, postal, city, country, carrierName(carrier), tracking, shipped, notes,
+		})
+	}
+
+	respondTable(w, r.URL.Query().Get("format"), "orders"+filter.filenameSuffix(), []xlsx.Sheet{sheet})
+}
+
+// RevenueLine is a revenue aggregate for one item or one month
+type RevenueLine struct {
+	Key           string `json:"key"`
+	Label         string `json:"label"`
+	Orders        int    `json:"orders"`
+	Quantity      int    `json:"quantity"`
+	GrossCents    int    `json:"gross_cents"`
+	DiscountCents int    `json:"discount_cents"`
+	RefundedCents int    `json:"refunded_cents"`
+	NetCents      int    `json:"net_cents"`
+}
+
+// RevenueReport summarises captured payments
+type RevenueReport struct {
+	Currency string        `json:"currency"`
+	From     string        `json:"from,omitempty"`
+	To       string        `json:"to,omitempty"`
+	Totals   RevenueLine   `json:"totals"`
+	PerItem  []RevenueLine `json:"per_item"`
+	PerMonth []RevenueLine `json:"per_month"`
+}
+
+func (h *Handler) buildRevenueReport(filter reportFilter) (RevenueReport, error) {
+	settings, _ := h.getShopSettings()
+	report := RevenueReport{
+		Currency: settings.Currency,
+		From:     filter.From,
+		To:       filter.To,
+		Totals:   RevenueLine{Key: "total", Label: "Total"},
+		PerItem:  []RevenueLine{},
+		PerMonth: []RevenueLine{},
+	}
+
+	where, args := filter.where()
+	groups := []struct {
+		keyExpr   string
+		labelExpr string
+		order     string
+		dest      *[]RevenueLine
+	}{
+		{"CAST(o.item_id AS TEXT)", "COALESCE(i.title, '(deleted)')", "SUM(o.amount_cents) DESC", &report.PerItem},
+		{"strftime('%Y-%m', o.created_at)", "strftime('%Y-%m', o.created_at)", "1", &report.PerMonth},
+	}
+
+	for _, g := range groups {
+		rows, err := h.db.Query(`
+			SELECT `+g.keyExpr+`, `+g.labelExpr+`, COUNT(*), SUM(o.quantity),
+			       SUM(o.amount_cents + o.discount_cents), SUM(o.discount_cents), SUM(o.refunded_cents)
+			FROM shop_orders o
+			LEFT JOIN shop_items i ON o.item_id = i.id
+			WHERE `+where+`
+			GROUP BY 1
+			ORDER BY `+g.order, args...)
+		if err != nil {
+			return report, err
+		}
+		for rows.Next() {
+			var l RevenueLine
+			if err := rows.Scan(&l.Key, &l.Label, &l.Orders, &l.Quantity, &l.GrossCents, &l.DiscountCents, &l.RefundedCents); err != nil {
+				rows.Close()
+				return report, err
+			}
+			// Gross is the list price, amount_cents is what was paid after the discount
+			l.NetCents = l.GrossCents - l.DiscountCents - l.RefundedCents
+			*g.dest = append(*g.dest, l)
+		}
+		rows.Close()
+	}
+
+	for _, l := range report.PerMonth {
+		report.Totals.Orders += l.Orders
+		report.Totals.Quantity += l.Quantity
+		report.Totals.GrossCents += l.GrossCents
+		report.Totals.DiscountCents += l.DiscountCents
+		report.Totals.RefundedCents += l.RefundedCents
+		report.Totals.NetCents += l.NetCents
+	}
+	return report, nil
+}
+
+func revenueSheet(name, keyHeader string, lines []RevenueLine, totals *RevenueLine) xlsx.Sheet {
+	sheet := xlsx.Sheet{
+		Name:    name,
+		Headers: []string{keyHeader, "Orders", "Quantity", "Gross", "Discounts", "Refunded", "Net"},
+	}
+	if totals != nil {
+		lines = append(lines, *totals)
+	}
+	for _, l := range lines {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			l.Label, l.Orders, l.Quantity,
+			centsToAmount(l.GrossCents), centsToAmount(l.DiscountCents), centsToAmount(l.RefundedCents),
+			centsToAmount(l.NetCents),
+		})
+	}
+	return sheet
+}
+
+// GetShopRevenueReport returns revenue per item and per month.
+// Optional: ?from=, ?to=, ?status= (defaults to all captured payments), ?format=json|csv|xlsx
+func (h *Handler) GetShopRevenueReport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseReportFilter(r, revenueStatuses)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Dates must be formatted YYYY-MM-DD"})
+		return
+	}
+
+	report, err := h.buildRevenueReport(filter)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to build report"})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "json" {
+		respondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	respondTable(w, format, "revenue"+filter.filenameSuffix(), []xlsx.Sheet{
+		revenueSheet("Per item", "Item", report.PerItem, &report.Totals),
+		revenueSheet("Per month", "Month", report.PerMonth, &report.Totals),
+	})
+}
+
+// FeeLine reconciles one captured payment with what the provider reports
+type FeeLine struct {
+	OrderID             int64  `json:"order_id"`
+	CreatedAt           string `json:"created_at"`
+	Provider            string `json:"provider"`
+	PaymentID           string `json:"payment_id"`
+	AmountCents         int    `json:"amount_cents"`
+	ProviderAmountCents *int   `json:"provider_amount_cents"`
+	FeeCents            *int   `json:"fee_cents"`
+	NetCents            *int   `json:"net_cents"`
+	// Status is "ok", "mismatch" (provider captured a different amount)
+	// or "unavailable" (fees not known yet)
+	Status string `json:"status"`
+}
+
+// FeeReport lists payment provider fees for captured orders. FeeCents and
+// NetCents only cover orders whose fees are known.
+type FeeReport struct {
+	Currency    string    `json:"currency"`
+	From        string    `json:"from,omitempty"`
+	To          string    `json:"to,omitempty"`
+	AmountCents int       `json:"amount_cents"`
+	FeeCents    int       `json:"fee_cents"`
+	NetCents    int       `json:"net_cents"`
+	Mismatches  int       `json:"mismatches"`
+	Unavailable int       `json:"unavailable"`
+	Orders      []FeeLine `json:"orders"`
+}
+
+// syncPaymentFees fetches fees for captured orders in the filter that don't
+// have them yet. Fees are final once known, so they are stored on the order.
+func (h *Handler) syncPaymentFees(ctx context.Context, filter reportFilter) {
+	settings, err := h.getShopSettings()
+	if err != nil {
+		return
+	}
+
+	where, args := filter.where()
+	rows, err := h.db.Query(`
+		SELECT o.id, o.payment_provider,
+		       COALESCE(CASE WHEN o.payment_provider = 'stripe' THEN o.stripe_payment_intent_id ELSE o.provider_payment_id END, '')
+		FROM shop_orders o
+		WHERE `+where+` AND o.payment_fee_cents IS NULL
+		ORDER BY o.created_at
+		LIMIT ?
+	`, append(args, feeLookupsPerRequest)...)
+	if err != nil {
+		return
+	}
+
+	type pending struct {
+		orderID   int64
+		provider  string
+		paymentID string
+	}
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.orderID, &p.provider, &p.paymentID); err == nil && p.paymentID != "" {
+			todo = append(todo, p)
+		}
+	}
+	rows.Close()
+
+	// Providers are built up front, the lookups share them
+	type lookup struct {
+		pending
+		reporter payment.FeeReporter
+	}
+	var lookups []lookup
+	providers := map[string]payment.Provider{}
+	for _, p := range todo {
+		provider, ok := providers[p.provider]
+		if !ok {
+			provider, _ = h.paymentProvider(p.provider, settings)
+			providers[p.provider] = provider
+		}
+		if reporter, ok := provider.(payment.FeeReporter); ok {
+			lookups = append(lookups, lookup{p, reporter})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, feeLookupTimeout)
+	defer cancel()
+
+	type result struct {
+		orderID int64
+		fees    *payment.Fees
+	}
+	jobs := make(chan lookup)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < feeLookupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range jobs {
+				fees, err := l.reporter.PaymentFees(ctx, l.paymentID)
+				if err != nil {
+					log.Printf("Failed to fetch fees for order #%d: %v", l.orderID, err)
+					continue
+				}
+				results <- result{l.orderID, fees}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, l := range lookups {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case jobs <- l:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// One writer, SQLite does not like concurrent writes
+	for res := range results {
+		h.db.Exec(`
+			UPDATE shop_orders SET payment_gross_cents = ?, payment_fee_cents = ?, payment_net_cents = ?
+			WHERE id = ?
+		`, res.fees.AmountCents, res.fees.FeeCents, res.fees.NetCents, res.orderID)
+	}
+}
+
+func (h *Handler) buildFeeReport(filter reportFilter) (FeeReport, error) {
+	settings, _ := h.getShopSettings()
+	report := FeeReport{Currency: settings.Currency, From: filter.From, To: filter.To, Orders: []FeeLine{}}
+
+	where, args := filter.where()
+	rows, err := h.db.Query(`
+		SELECT o.id, o.created_at, o.payment_provider,
+		       COALESCE(CASE WHEN o.payment_provider = 'stripe' THEN o.stripe_payment_intent_id ELSE o.provider_payment_id END, ''),
+		       o.amount_cents, o.payment_gross_cents, o.payment_fee_cents, o.payment_net_cents
+		FROM shop_orders o
+		WHERE `+where+`
+		ORDER BY o.created_at, o.id
+	`, args...)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l FeeLine
+		var createdAt time.Time
+		var gross, fee, net sql.NullInt64
+		if err := rows.Scan(&l.OrderID, &createdAt, &l.Provider, &l.PaymentID, &l.AmountCents, &gross, &fee, &net); err != nil {
+			return report, err
+		}
+		l.CreatedAt = createdAt.Format("2006-01-02 15:04")
+		report.AmountCents += l.AmountCents
+
+		if !fee.Valid {
+			l.Status = "unavailable"
+			report.Unavailable++
+			report.Orders = append(report.Orders, l)
+			continue
+		}
+
+		g, f, n := int(gross.Int64), int(fee.Int64), int(net.Int64)
+		l.ProviderAmountCents, l.FeeCents, l.NetCents = &g, &f, &n
+		report.FeeCents += f
+		report.NetCents += n
+
+		l.Status = "ok"
+		if g != l.AmountCents {
+			l.Status = "mismatch"
+			report.Mismatches++
+		}
+		report.Orders = append(report.Orders, l)
+	}
+	return report, nil
+}
+
+func feeSheet(report FeeReport) xlsx.Sheet {
+	sheet := xlsx.Sheet{
+		Name:    "Fees",
+		Headers: []string{"Order", "Date", "Provider", "Payment ID", "Amount", "Provider amount", "Fee", "Net", "Status"},
+	}
+	optional := func(v *int) interface{} {
+		if v == nil {
+			return nil
+		}
+		return centsToAmount(*v)
+	}
+	for _, l := range report.Orders {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			l.OrderID, l.CreatedAt, l.Provider, l.PaymentID, centsToAmount(l.AmountCents),
+			optional(l.ProviderAmountCents), optional(l.FeeCents), optional(l.NetCents), l.Status,
+		})
+	}
+	sheet.Rows = append(sheet.Rows, []interface{}{
+		"Total", nil, nil, nil, centsToAmount(report.AmountCents), nil,
+		centsToAmount(report.FeeCents), centsToAmount(report.NetCents), nil,
+	})
+	return sheet
+}
+
+// GetShopFeeReport reconciles captured orders against the fees the payment
+// provider reports, fetching missing fees first (at most 50 per request).
+// Optional: ?from=, ?to=, ?status=, ?format=json|csv|xlsx
+func (h *Handler) GetShopFeeReport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseReportFilter(r, revenueStatuses)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Dates must be formatted YYYY-MM-DD"})
+		return
+	}
+
+	h.syncPaymentFees(r.Context(), filter)
+
+	report, err := h.buildFeeReport(filter)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to build report"})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "json" {
+		respondJSON(w, http.StatusOK, report)
+		return
+	}
+	respondTable(w, format, "fees"+filter.filenameSuffix(), []xlsx.Sheet{feeSheet(report)})
+}
+
+// InvoiceLine is an issued invoice in the yearly report
+type InvoiceLine struct {
+	Number        string `json:"number"`
+	IssuedAt      string `json:"issued_at"`
+	OrderID       int64  `json:"order_id"`
+	BuyerEmail    string `json:"buyer_email"`
+	Status        string `json:"status"`
+	TotalCents    int    `json:"total_cents"`
+	VATCents      int    `json:"vat_cents"`
+	RefundedCents int    `json:"refunded_cents"`
+}
+
+// YearlyReport bundles everything the accountant needs for one year
+type YearlyReport struct {
+	Year     int           `json:"year"`
+	Revenue  RevenueReport `json:"revenue"`
+	Fees     FeeReport     `json:"fees"`
+	Invoices []InvoiceLine `json:"invoices"`
+}
+
+func (h *Handler) buildInvoiceLines(year int) ([]InvoiceLine, error) {
+	rows, err := h.db.Query(`
+		SELECT inv.number, inv.issued_at, inv.order_id, o.buyer_email, o.status, o.refunded_cents
+		FROM shop_invoices inv
+		JOIN shop_orders o ON o.id = inv.order_id
+		WHERE inv.year = ?
+		ORDER BY inv.sequence
+	`, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []InvoiceLine{}
+	for rows.Next() {
+		var l InvoiceLine
+		var issuedAt time.Time
+		if err := rows.Scan(&l.Number, &issuedAt, &l.OrderID, &l.BuyerEmail, &l.Status, &l.RefundedCents); err != nil {
+			return nil, err
+		}
+		l.IssuedAt = issuedAt.Format("2006-01-02")
+		lines = append(lines, l)
+	}
+	rows.Close()
+
+	// Totals come from the stored snapshot, which is what was printed
+	for i := range lines {
+		inv, err := h.loadInvoice(lines[i].OrderID)
+		if err != nil {
+			return nil, err
+		}
+		lines[i].TotalCents = inv.TotalCents()
+		lines[i].VATCents = inv.VATCents()
+	}
+	return lines, nil
+}
+
+// GetShopYearlyReport returns invoices, revenue and fees for ?year= (default
+// the current year) as JSON or, with ?format=xlsx|csv, as a multi-sheet file.
+func (h *Handler) GetShopYearlyReport(w http.ResponseWriter, r *http.Request) {
+	year := time.Now().Year()
+	if y := r.URL.Query().Get("year"); y != "" {
+		parsed, err := strconv.Atoi(y)
+		if err != nil || parsed < 2000 || parsed > 9999 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid year"})
+			return
+		}
+		year = parsed
+	}
+
+	filter := reportFilter{
+		From:     fmt.Sprintf("%d-01-01", year),
+		To:       fmt.Sprintf("%d-12-31", year),
+		Statuses: revenueStatuses,
+	}
+
+	h.syncPaymentFees(r.Context(), filter)
+
+	revenue, err := h.buildRevenueReport(filter)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to build report"})
+		return
+	}
+	fees, err := h.buildFeeReport(filter)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to build report"})
+		return
+	}
+	invoices, err := h.buildInvoiceLines(year)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to build report"})
+		return
+	}
+
+	report := YearlyReport{Year: year, Revenue: revenue, Fees: fees, Invoices: invoices}
+
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "json" {
+		respondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	summary := xlsx.Sheet{
+		Name:    "Summary",
+		Headers: []string{"", "Amount"},
+		Rows: [][]interface{}{
+			{"Year", year},
+			{"Currency", revenue.Currency},
+			{"Orders", revenue.Totals.Orders},
+			{"Invoices issued", len(invoices)},
+			{"Gross revenue", centsToAmount(revenue.Totals.GrossCents)},
+			{"Discounts given", centsToAmount(revenue.Totals.DiscountCents)},
+			{"Refunded", centsToAmount(revenue.Totals.RefundedCents)},
+			{"Net revenue", centsToAmount(revenue.Totals.NetCents)},
+			{"Payment fees", centsToAmount(fees.FeeCents)},
+			{"Orders without known fees", fees.Unavailable},
+		},
+	}
+
+	invoiceSheet := xlsx.Sheet{
+		Name:    "Invoices",
+		Headers: []string{"Number", "Date", "Order", "Buyer email", "Status", "Total", "VAT", "Excl. VAT", "Refunded"},
+	}
+	for _, l := range invoices {
+		invoiceSheet.Rows = append(invoiceSheet.Rows, []interface{}{
+			l.Number, l.IssuedAt, l.OrderID, l.BuyerEmail, l.Status, centsToAmount(l.TotalCents),
+			centsToAmount(l.VATCents), centsToAmount(l.TotalCents - l.VATCents), centsToAmount(l.RefundedCents),
+		})
+	}
+
+	respondTable(w, format, fmt.Sprintf("shop-report-%d", year), []xlsx.Sheet{
+		summary,
+		invoiceSheet,
+		revenueSheet("Per month", "Month", revenue.PerMonth, &revenue.Totals),
+		revenueSheet("Per item", "Item", revenue.PerItem, &revenue.Totals),
+		feeSheet(fees),
+	})
+}
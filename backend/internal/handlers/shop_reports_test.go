@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/payment"
+)
+
+func TestExportShopOrdersCSV(t *testing.T) {
+	h := newTestHandler(t)
+	itemID := exec(t, h, "INSERT INTO shop_items (title, price_cents, allow_pickup, active) VALUES ('Geocoin', 1000, 1, 1)")
+	exec(t, h, `
+		INSERT INTO shop_orders (item_id, buyer_email, quantity, amount_cents, payment_provider, token, status,
+		                         fulfillment_type, shipping_name, shipping_address, shipping_city, notes)
+		VALUES (?, 'buyer@example.com', 1, 1000, 'fake', 'tok', 'paid', 'shipping',
+		        '=HYPERLINK("http://evil.test")', '+32 Markt 1', '@SUM(A1)', '-1+1')
+	`, itemID)
+
+	w := httptest.NewRecorder()
+	h.ExportShopOrders(w, httptest.NewRequest("GET", "/admin/shop/orders/export?format=csv", nil))
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\ufeff"))).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("records = %q, %v", records, err)
+	}
+	row := map[string]string{}
+	for i, header := range records[0] {
+		row[header] = records[1][i]
+	}
+
+	// Text that a spreadsheet would run is quoted, numbers are left alone
+	want := map[string]string{
+		"Name":        `'=HYPERLINK("http://evil.test")`,
+		"Address":     "'+32 Markt 1",
+		"City":        "'@SUM(A1)",
+		"Notes":       "'-1+1",
+		"Buyer email": "buyer@example.com",
+		"Amount":      "10.00",
+	}
+	for header, v := range want {
+		if row[header] != v {
+			t.Errorf("%s = %q, want %q", header, row[header], v)
+		}
+	}
+}
+
+func TestSyncPaymentFees(t *testing.T) {
+	h := newTestHandler(t)
+	itemID := exec(t, h, "INSERT INTO shop_items (title, price_cents, allow_pickup, active) VALUES ('Geocoin', 1000, 1, 1)")
+
+	const orders = 12
+	for i := 0; i < orders; i++ {
+		id := exec(t, h, `
+			INSERT INTO shop_orders (item_id, buyer_email, quantity, amount_cents, payment_provider, token, status)
+			VALUES (?, 'buyer@example.com', 1, 1000, 'fake', ?, 'paid')
+		`, itemID, fmt.Sprintf("tok%d", i))
+		exec(t, h, "UPDATE shop_orders SET provider_payment_id = ? WHERE id = ?", fmt.Sprintf("fake_pay_%d", id), id)
+		// The last payment is unknown to the provider
+		if i < orders-1 {
+			h.fakePayments.Checkouts = append(h.fakePayments.Checkouts, payment.CheckoutRequest{OrderID: id, Quantity: 1, UnitAmountCents: 1000})
+		}
+	}
+
+	h.syncPaymentFees(context.Background(), reportFilter{Statuses: revenueStatuses})
+
+	var withFees, fee int
+	h.db.QueryRow("SELECT COUNT(*), MAX(payment_fee_cents) FROM shop_orders WHERE payment_fee_cents IS NOT NULL").Scan(&withFees, &fee)
+	if withFees != orders-1 || fee != 25+14 {
+		t.Errorf("%d orders with fees (fee %d), want %d with 39", withFees, fee, orders-1)
+	}
+
+	// A cancelled request stops the lookups
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exec(t, h, "UPDATE shop_orders SET payment_fee_cents = NULL")
+	h.syncPaymentFees(ctx, reportFilter{Statuses: revenueStatuses})
+	h.db.QueryRow("SELECT COUNT(*) FROM shop_orders WHERE payment_fee_cents IS NOT NULL").Scan(&withFees)
+	if withFees != 0 {
+		t.Errorf("%d fees were fetched for a cancelled request", withFees)
+	}
+}
+
+func TestRevenueReport(t *testing.T) {
+	h := newTestHandler(t)
+	itemID := exec(t, h, "INSERT INTO shop_items (title, price_cents, allow_pickup, active) VALUES ('Geocoin', 1000, 1, 1)")
+	// € 10 with € 2 off, € 1.50 of it refunded
+	exec(t, h, `
+		INSERT INTO shop_orders (item_id, buyer_email, quantity, amount_cents, discount_cents, refunded_cents, payment_provider, token, status)
+		VALUES (?, 'buyer@example.com', 1, 800, 200, 150, 'fake', 'tok1', 'paid')
+	`, itemID)
+	orderID := exec(t, h, `
+		INSERT INTO shop_orders (item_id, buyer_email, quantity, amount_cents, payment_provider, token, status)
+		VALUES (?, 'buyer@example.com', 1, 1000, 'fake', 'tok2', 'paid')
+	`, itemID)
+
+	// Refunded outside the shop and marked so by hand
+	id := fmt.Sprint(orderID)
+	r := httptest.NewRequest("PUT", "/admin/shop/orders/"+id+"/status", strings.NewReader(`{"status":"refunded"}`))
+	w := httptest.NewRecorder()
+	h.UpdateShopOrderStatus(w, withURLParams(r, "id", id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("marking refunded: %d %s", w.Code, w.Body)
+	}
+
+	report, err := h.buildRevenueReport(reportFilter{Statuses: revenueStatuses})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RevenueLine{
+		Key: "total", Label: "Total", Orders: 2, Quantity: 2,
+		GrossCents: 2000, DiscountCents: 200, RefundedCents: 1150, NetCents: 650,
+	}
+	if report.Totals != want {
+		t.Errorf("totals = %+v, want %+v", report.Totals, want)
+	}
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/labels"
+)
+
+// Carrier is a parcel service orders can be shipped with. TrackingURL may
+// use {code}, {postal} and {country}; it is empty when the carrier has no
+// public tracking page.
+type Carrier struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	TrackingURL string `json:"tracking_url,omitempty"`
+}
+
+var carriers = map[string]Carrier{
+	"bpost":  {"bpost", "bpost", "https://track.bpost.cloud/btr/web/#/search?itemCode={code}&postalCode={postal}"},
+	"postnl": {"postnl", "PostNL", "https://jouw.postnl.nl/track-and-trace/{code}-{country}-{postal}"},
+	"dhl":    {"dhl", "DHL", "https://www.dhl.com/be-nl/home/tracking.html?tracking-id={code}"},
+	"dpd":    {"dpd", "DPD", "https://tracking.dpd.de/status/nl_BE/parcel/{code}"},
+	"gls":    {"gls", "GLS", "https://gls-group.com/BE/nl/pakket-volgen?match={code}"},
+	"ups":    {"ups", "UPS", "https://www.ups.com/track?tracknum={code}"},
+	"other":  {"other", "Other", ""},
+}
+
+// shippableStatuses are the statuses an order can be marked shipped from
+var shippableStatuses = map[string]bool{"paid": true, "confirmed": true}
+
+// trackingURL builds the carrier's tracking link, or "" when there is none
+func trackingURL(carrier, trackingNumber, postal, country string) string {
+	c, ok := carriers[carrier]
+	if !ok || c.TrackingURL == "" || trackingNumber == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{code}", url.PathEscape(trackingNumber),
+		"{postal}", url.QueryEscape(strings.ReplaceAll(postal, " ", "")),
+		"{country}", url.PathEscape(strings.ToUpper(country)),
+	).Replace(c.TrackingURL)
+}
+
+// carrierName returns the display name of a carrier code
+func carrierName(code string) string {
+	if c, ok := carriers[code]; ok {
+		return c.Name
+	}
+	return code
+}
+
+// normalizeCarrier lowercases a carrier code and reports whether it is known.
+// An empty code is valid and means "not set".
+func normalizeCarrier(code string) (string, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return "", true
+	}
+	_, ok := carriers[code]
+	return code, ok
+}
+
+func (h *Handler) GetCarriers(w http.ResponseWriter, r *http.Request) {
+	list := make([]Carrier, 0, len(carriers))
+	for _, c := range carriers {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		// "other" goes last
+		if list[i].Code == "other" || list[j].Code == "other" {
+			return list[j].Code == "other" && list[i].Code != "other"
+		}
+		return list[i].Name < list[j].Name
+	})
+	respondJSON(w, http.StatusOK, list)
+}
+
+type shipOrderRequest struct {
+	ID             int64  `json:"id"`
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"tracking_number"`
+}
+
+// shipOrderResult reports what happened to one order of a bulk update
+type shipOrderResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"` // shipped, updated or skipped
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkShipShopOrders marks several shipping orders as shipped at once.
+// Body: {"carrier": "bpost", "orders": [{"id": 1, "tracking_number": "..."}], "notify": true}
+// The top-level carrier applies to orders that don't name their own. Orders
+// that were already shipped only get their carrier and tracking updated.
+func (h *Handler) BulkShipShopOrders(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Carrier string             `json:"carrier"`
+		Orders  []shipOrderRequest `json:"orders"`
+		Notify  *bool              `json:"notify"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if len(req.Orders) == 0 || len(req.Orders) > 500 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Between 1 and 500 orders are required"})
+		return
+	}
+	defaultCarrier, ok := normalizeCarrier(req.Carrier)
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown carrier"})
+		return
+	}
+	notify := req.Notify == nil || *req.Notify
+
+	results := make([]shipOrderResult, 0, len(req.Orders))
+	shipped := 0
+	for _, o := range req.Orders {
+		res := shipOrderResult{ID: o.ID}
+
+		carrier, ok := normalizeCarrier(o.Carrier)
+		if carrier == "" {
+			carrier = defaultCarrier
+		}
+		tracking := truncateString(strings.TrimSpace(o.TrackingNumber), 100)
+
+		var status, fulfillment string
+		err := h.db.QueryRow("SELECT status, fulfillment_type FROM shop_orders WHERE id = ?", o.ID).Scan(&status, &fulfillment)
+		switch {
+		case !ok:
+			res.Status, res.Error = "skipped", "Unknown carrier"
+		case err != nil:
+			res.Status, res.Error = "skipped", "Order not found"
+		case fulfillment != "shipping":
+			res.Status, res.Error = "skipped", "Order is not a shipping order"
+		case status == "shipped":
+			_, err = h.db.Exec(`
+				UPDATE shop_orders SET
+					carrier = CASE WHEN ? != '' THEN ? ELSE carrier END,
+					tracking_number = CASE WHEN ? != '' THEN ? ELSE tracking_number END,
+					updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, carrier, carrier, tracking, tracking, o.ID)
+			res.Status = "updated"
+		case !shippableStatuses[status]:
+			res.Status, res.Error = "skipped", fmt.Sprintf("Order is %s", status)
+		default:
+			var result sql.Result
+			result, err = h.db.Exec(`
+				UPDATE shop_orders SET status = 'shipped', carrier = ?, tracking_number = ?,
+					shipped_at = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND status IN ('paid', 'confirmed')
+			`, carrier, tracking, time.Now().UTC(), o.ID)
+			if err == nil {
+				if n, _ := result.RowsAffected(); n == 0 {
+					res.Status, res.Error = "skipped", "Order changed while updating"
+					break
+				}
+				res.Status = "shipped"
+				shipped++
+				if notify {
+					h.notifyOrderStatus(o.ID, "shipped")
+				}
+			}
+		}
+		if err != nil && res.Error == "" {
+			res.Status, res.Error = "skipped", "Failed to update order"
+		}
+		results = append(results, res)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"shipped": shipped,
+		"results": results,
+	})
+}
+
+// GetShippingLabels renders address labels for shipping orders as a PDF of
+// A4 label sheets. Query:
+//   - ids: comma separated order IDs; defaults to all paid/confirmed shipping orders
+//   - layout: sheet layout (3x8, 3x7, 2x7, 2x4), default 3x8
+//   - skip: number of labels already used on the first sheet
+//   - sender: set to 0 to leave out the return address
+func (h *Handler) GetShippingLabels(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	layoutName := q.Get("layout")
+	if layoutName == "" {
+		layoutName = labels.DefaultLayout
+	}
+	layout, ok := labels.Layouts[layoutName]
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown label layout"})
+		return
+	}
+	skip, _ := strconv.Atoi(q.Get("skip"))
+
+	where := "o.fulfillment_type = 'shipping' AND o.status IN ('paid', 'confirmed')"
+	var args []interface{}
+	if v := strings.TrimSpace(q.Get("ids")); v != "" {
+		var placeholders []string
+		for _, part := range strings.Split(v, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid order ID"})
+				return
+			}
+			placeholders = append(placeholders, "?")
+			args = append(args, id)
+		}
+		if len(placeholders) > 500 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Too many orders"})
+			return
+		}
+		where = "o.fulfillment_type = 'shipping' AND o.id IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	rows, err := h.db.Query(`
+		SELECT o.id, o.shipping_name, o.shipping_address, o.shipping_postal_code, o.shipping_city, o.shipping_country
+		FROM shop_orders o
+		WHERE `+where+`
+		ORDER BY o.created_at, o.id
+	`, args...)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	var list []labels.Label
+	for rows.Next() {
+		var id int64
+		var l labels.Label
+		if err := rows.Scan(&id, &l.Name, &l.Address, &l.PostalCode, &l.City, &l.Country); err != nil {
+			continue
+		}
+		l.Reference = fmt.Sprintf("#%d", id)
+		list = append(list, l)
+	}
+	if len(list) == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "No orders to print labels for"})
+		return
+	}
+
+	sender := ""
+	if q.Get("sender") != "0" {
+		if settings, err := h.getShopSettings(); err == nil {
+			sender = strings.Join(strings.Fields(strings.ReplaceAll(settings.InvoiceOrgName+", "+settings.InvoiceOrgAddress, "\n", ", ")), " ")
+			sender = strings.Trim(sender, ", ")
+		}
+	}
+
+	data, err := labels.Render(layout, list, skip, sender)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to render labels"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="labels-%s.pdf"`, time.Now().Format("2006-01-02")))
+	w.Write(data)
+}
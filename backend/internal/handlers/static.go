@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -25,6 +26,10 @@ type StaticContent struct {
 type StaticContentTranslation struct {
 	LangCode string `json:"lang_code"`
 	Content  string `json:"content"`
+	// Fallback is set when this stands in for the requested language
+	Fallback bool `json:"fallback,omitempty"`
+	// MachineTranslated marks an unconfirmed machine translation draft
+	MachineTranslated bool `json:"machine_translated,omitempty"`
 }
 
 // Social represents a social media link
@@ -70,20 +75,35 @@ func (h *Handler) GetLanguages(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, languages)
 }
 
-// GetStaticContent returns all static content/translations (public)
+// GetStaticContent returns all static content/translations (public). With
+// ?lang= each property has one translation: that language or its first
+// fallback. Machine translation drafts are left out.
 func (h *Handler) GetStaticContent(w http.ResponseWriter, r *http.Request) {
-	// Get the latest update time for ETag
+	h.writeStaticContent(w, r, false)
+}
+
+// writeStaticContent writes the static content grouped by property,
+// including machine translation drafts when drafts is set. The admin view
+// with drafts only filters on an explicit ?lang=.
+func (h *Handler) writeStaticContent(w http.ResponseWriter, r *http.Request, drafts bool) {
+	lang := strings.ToUpper(r.URL.Query().Get("lang"))
+	if !drafts {
+		lang = h.requestLanguage(w, r)
+	}
+
+	// Get the latest update time for ETag; the language is part of it as
+	// it can come from Accept-Language on the same URL
 	var lastUpdate time.Time
 	h.db.QueryRow("SELECT MAX(updated_at) FROM static_content").Scan(&lastUpdate)
 
-	etag := fmt.Sprintf(`"%x"`, md5.Sum([]byte(lastUpdate.String())))
+	etag := fmt.Sprintf(`"%x"`, md5.Sum([]byte(lastUpdate.String()+lang)))
 	if match := r.Header.Get("If-None-Match"); match == etag {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	w.Header().Set("ETag", etag)
 
-	rows, err := h.db.Query("SELECT property, lang_code, content FROM static_content ORDER BY property")
+	rows, err := h.db.Query("SELECT property, lang_code, content, machine_translated FROM static_content ORDER BY property")
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, []StaticContent{})
 		return
@@ -94,7 +114,8 @@ func (h *Handler) GetStaticContent(w http.ResponseWriter, r *http.Request) {
 	contentMap := make(map[string]*StaticContent)
 	for rows.Next() {
 		var property, langCode, content string
-		if err := rows.Scan(&property, &langCode, &content); err != nil {
+		var machineTranslated bool
+		if err := rows.Scan(&property, &langCode, &content, &machineTranslated); err != nil || (machineTranslated && !drafts) {
 			continue
 		}
 
@@ -105,14 +126,30 @@ func (h *Handler) GetStaticContent(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		contentMap[property].Contents = append(contentMap[property].Contents, StaticContentTranslation{
-			LangCode: langCode,
-			Content:  content,
+			LangCode:          langCode,
+			Content:           content,
+			MachineTranslated: machineTranslated,
 		})
 	}
 
 	// Convert map to slice
+	chain := h.translationChain(lang)
 	result := make([]StaticContent, 0, len(contentMap))
 	for _, v := range contentMap {
+		if lang != "" {
+			langs := make([]string, len(v.Contents))
+			texts := make([]string, len(v.Contents))
+			for i, c := range v.Contents {
+				langs[i], texts[i] = c.LangCode, c.Content
+			}
+			picked := []StaticContentTranslation{}
+			if i := pickTranslation(chain, langs, texts); i >= 0 {
+				c := v.Contents[i]
+				c.Fallback = !strings.EqualFold(c.LangCode, lang)
+				picked = append(picked, c)
+			}
+			v.Contents = picked
+		}
 		result = append(result, *v)
 	}
 
@@ -249,29 +286,32 @@ func (h *Handler) DeleteLanguage(w http.ResponseWriter, r *http.Request) {
 
 // Admin handlers for static content
 func (h *Handler) GetAdminStaticContent(w http.ResponseWriter, r *http.Request) {
-	// Same as public but might include additional metadata in future
-	h.GetStaticContent(w, r)
+	// Same as public but with the machine translation drafts
+	h.writeStaticContent(w, r, true)
 }
 
 func (h *Handler) CreateStaticContent(w http.ResponseWriter, r *http.Request) {
 	var content struct {
-		Property string `json:"property"`
-		LangCode string `json:"lang_code"`
-		Content  string `json:"content"`
+		Property          string `json:"property"`
+		LangCode          string `json:"lang_code"`
+		Content           string `json:"content"`
+		MachineTranslated bool   `json:"machine_translated,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
 
+	base := h.takeBaseline("static_content", content.Property)
 	_, err := h.db.Exec(
-		"INSERT INTO static_content (property, lang_code, content) VALUES (?, ?, ?)",
-		content.Property, content.LangCode, content.Content,
+		"INSERT INTO static_content (property, lang_code, content, machine_translated) VALUES (?, ?, ?, ?)",
+		content.Property, content.LangCode, content.Content, content.MachineTranslated,
 	)
 	if err != nil {
 		respondJSON(w, http.StatusConflict, map[string]string{"error": "Content already exists for this property/language"})
 		return
 	}
+	h.recordRevision(r, "static_content", content.Property, base)
 
 	respondJSON(w, http.StatusCreated, content)
 }
@@ -283,22 +323,26 @@ func (h *Handler) UpdateStaticContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var content struct {
-		LangCode string `json:"lang_code"`
-		Content  string `json:"content"`
+		LangCode          string `json:"lang_code"`
+		Content           string `json:"content"`
+		MachineTranslated bool   `json:"machine_translated,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
 
+	base := h.takeBaseline("static_content", property)
+	// Saving a draft without the flag confirms it
 	_, err := h.db.Exec(
-		"UPDATE static_content SET content = ?, updated_at = CURRENT_TIMESTAMP WHERE property = ? AND lang_code = ?",
-		content.Content, property, content.LangCode,
+		"UPDATE static_content SET content = ?, machine_translated = ?, updated_at = CURRENT_TIMESTAMP WHERE property = ? AND lang_code = ?",
+		content.Content, content.MachineTranslated, property, content.LangCode,
 	)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update content"})
 		return
 	}
+	h.recordRevision(r, "static_content", property, base)
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Content updated"})
 }
@@ -309,12 +353,14 @@ func (h *Handler) DeleteStaticContent(w http.ResponseWriter, r *http.Request) {
 		property = getPathParam(r, "property")
 	}
 
-	// Delete all translations for this property
+	// Delete all translations for this property, after keeping them
+	base := h.takeBaseline("static_content", property)
 	_, err := h.db.Exec("DELETE FROM static_content WHERE property = ?", property)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete content"})
 		return
 	}
+	h.recordBaseline("static_content", property, base)
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Content deleted"})
 }
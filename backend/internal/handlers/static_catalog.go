@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/catalog"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/revisions"
+)
+
+// maxCatalogSize limits the size of an uploaded translation catalog
+const maxCatalogSize = 10 << 20
+
+// Actions reported per key of an imported catalog
+const (
+	catalogCreated   = "created"
+	catalogUpdated   = "updated"
+	catalogUnchanged = "unchanged"
+	catalogSkipped   = "skipped"
+	catalogEmpty     = "empty"
+	catalogFuzzy     = "fuzzy"
+	catalogUnknown   = "unknown"
+	catalogDuplicate = "duplicate"
+)
+
+// CatalogChange is one key of an imported catalog and what the import does
+// with it
+type CatalogChange struct {
+	Property string `json:"property"`
+	Action   string `json:"action"`
+	Current  string `json:"current,omitempty"`
+	Imported string `json:"imported,omitempty"`
+	// Conflict is set when the key already has a different human
+	// translation; the policy or resolve decides which one stays
+	Conflict bool `json:"conflict,omitempty"`
+	// SourceChanged is set when the source text in the file is not the
+	// current one, so the translation may be outdated
+	SourceChanged bool `json:"source_changed,omitempty"`
+}
+
+// staticText is a stored static content translation
+type staticText struct {
+	content           string
+	machineTranslated bool
+}
+
+// ExportStaticContent downloads the static content of ?lang= as a PO,
+// XLIFF or CSV catalog (?format=, default po) for translators, with the
+// text of ?source= (default TRANSLATION_SOURCE) to translate from. Machine
+// translation drafts are marked fuzzy.
+func (h *Handler) ExportStaticContent(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, ok := catalog.ParseFormat(query.Get("format"))
+	if query.Get("format") == "" {
+		format, ok = catalog.FormatPO, true
+	}
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Format must be po, xliff or csv"})
+		return
+	}
+	lang := strings.ToUpper(query.Get("lang"))
+	source := strings.ToUpper(query.Get("source"))
+	if source == "" {
+		source = h.cfg.Translator.SourceLang
+	}
+	for _, code := range []string{lang, source} {
+		if !h.languageExists(code) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown language: %s", code)})
+			return
+		}
+	}
+
+	texts, err := h.loadStaticTexts()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	properties := make([]string, 0, len(texts))
+	for property := range texts {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+
+	c := &catalog.Catalog{SourceLang: source, TargetLang: lang}
+	for _, property := range properties {
+		target := texts[property][lang]
+		c.Entries = append(c.Entries, catalog.Entry{
+			Key:    property,
+			Source: texts[property][source].content,
+			Target: target.content,
+			Fuzzy:  target.machineTranslated && target.content != "",
+		})
+	}
+
+	filename := fmt.Sprintf("static-content-%s.%s", strings.ToLower(lang), format.Extension())
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := catalog.Encode(w, format, c); err != nil {
+		log.Printf("Failed to write %s: %v", filename, err)
+	}
+}
+
+// ImportStaticContent imports a catalog uploaded as "file", made by
+// ExportStaticContent or a translation tool. The language comes from ?lang=
+// or the file, the format from ?format= or the file name. Keys with a
+// different human translation are conflicts, kept (?policy=skip, the
+// default) or overwritten (?policy=overwrite); the "resolve" form field
+// can decide per key, as a JSON object of key to "skip" or "overwrite".
+// Unknown keys, empty and fuzzy translations are never imported. It is a
+// dry run that only reports the changes unless ?dry_run=false.
+func (h *Handler) ImportStaticContent(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	policy := query.Get("policy")
+	if policy == "" {
+		policy = "skip"
+	}
+	if policy != "skip" && policy != "overwrite" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Policy must be skip or overwrite"})
+		return
+	}
+	dryRun := query.Get("dry_run") != "false"
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCatalogSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No catalog file provided"})
+		return
+	}
+	defer file.Close()
+
+	format, ok := catalog.ParseFormat(query.Get("format"))
+	if query.Get("format") == "" {
+		format, ok = catalog.FormatOf(header.Filename)
+	}
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Format must be po, xliff or csv"})
+		return
+	}
+
+	resolve := map[string]string{}
+	if value := r.FormValue("resolve"); value != "" {
+		if err := json.Unmarshal([]byte(value), &resolve); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid resolve field"})
+			return
+		}
+		for property, decision := range resolve {
+			if decision != "skip" && decision != "overwrite" {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Resolve %s must be skip or overwrite", property)})
+				return
+			}
+		}
+	}
+
+	c, err := catalog.Decode(file, format)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	lang := strings.ToUpper(query.Get("lang"))
+	switch {
+	case lang == "" && c.TargetLang == "":
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "The file names no language, pass ?lang="})
+		return
+	case lang == "":
+		lang = c.TargetLang
+	case c.TargetLang != "" && c.TargetLang != lang:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("The file is for %s, not %s", c.TargetLang, lang)})
+		return
+	}
+	if !h.languageExists(lang) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown language: %s", lang)})
+		return
+	}
+
+	texts, err := h.loadStaticTexts()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	changes := []CatalogChange{}
+	counts := map[string]int{}
+	conflicts := 0
+	seen := map[string]bool{}
+	for _, e := range c.Entries {
+		change := CatalogChange{Property: e.Key, Imported: e.Target}
+		current, known := texts[e.Key][lang]
+		change.Current = current.content
+		if texts[e.Key] != nil && c.SourceLang != "" && e.Source != "" {
+			change.SourceChanged = e.Source != texts[e.Key][c.SourceLang].content
+		}
+
+		switch {
+		case seen[e.Key]:
+			change.Action = catalogDuplicate
+		case texts[e.Key] == nil:
+			change.Action = catalogUnknown
+		case blankTranslation(e.Target):
+			change.Action = catalogEmpty
+		case e.Fuzzy:
+			change.Action = catalogFuzzy
+		case !known || blankTranslation(current.content):
+			change.Action = catalogCreated
+		case current.content == e.Target && current.machineTranslated && format.MarksReview():
+			// The draft was exported fuzzy and comes back reviewed: it is
+			// confirmed as it is
+			change.Action = catalogUpdated
+		case current.content == e.Target:
+			// Drafts stay drafts in CSV, which can't say they were reviewed
+			change.Action = catalogUnchanged
+		case current.machineTranslated:
+			// A reviewed translation replaces the machine draft
+			change.Action = catalogUpdated
+		default:
+			change.Conflict = true
+			conflicts++
+			decision := policy
+			if d, ok := resolve[e.Key]; ok {
+				decision = d
+			}
+			change.Action = catalogSkipped
+			if decision == "overwrite" {
+				change.Action = catalogUpdated
+			}
+		}
+		seen[e.Key] = true
+		counts[change.Action]++
+		if change.Action != catalogUnchanged {
+			changes = append(changes, change)
+		}
+	}
+
+	if !dryRun {
+		bases := map[string]*revisions.Snapshot{}
+		for _, change := range changes {
+			if change.Action == catalogCreated || change.Action == catalogUpdated {
+				bases[change.Property] = h.takeBaseline("static_content", change.Property)
+			}
+		}
+		if err := h.applyStaticChanges(lang, changes); err != nil {
+			log.Printf("Static content import failed: %v", err)
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Import failed, nothing was changed"})
+			return
+		}
+		for _, change := range changes {
+			if change.Action == catalogCreated || change.Action == catalogUpdated {
+				h.recordRevision(r, "static_content", change.Property, bases[change.Property])
+			}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"lang":      lang,
+		"format":    format,
+		"dry_run":   dryRun,
+		"policy":    policy,
+		"counts":    counts,
+		"conflicts": conflicts,
+		"changes":   changes,
+	})
+}
+
+// applyStaticChanges saves the created and updated keys of an import in
+// one transaction, as confirmed human translations
+func (h *Handler) applyStaticChanges(lang string, changes []CatalogChange) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, change := range changes {
+		if change.Action != catalogCreated && change.Action != catalogUpdated {
+			continue
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO static_content (property, lang_code, content, machine_translated) VALUES (?, ?, ?, 0)
+			ON CONFLICT(property, lang_code) DO UPDATE SET
+				content = excluded.content, machine_translated = 0, updated_at = CURRENT_TIMESTAMP
+		`, change.Property, lang, change.Imported); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadStaticTexts returns the static content by property and language
+func (h *Handler) loadStaticTexts() (map[string]map[string]staticText, error) {
+	rows, err := h.db.Query("SELECT property, lang_code, COALESCE(content, ''), machine_translated FROM static_content")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	texts := map[string]map[string]staticText{}
+	for rows.Next() {
+		var property, lang string
+		var t staticText
+		if err := rows.Scan(&property, &lang, &t.content, &t.machineTranslated); err != nil {
+			return nil, err
+		}
+		if texts[property] == nil {
+			texts[property] = map[string]staticText{}
+		}
+		texts[property][strings.ToUpper(lang)] = t
+	}
+	return texts, rows.Err()
+}
+
+// languageExists reports whether code is in the languages table, active
+// or not
+func (h *Handler) languageExists(code string) bool {
+	var n int
+	return h.db.QueryRow("SELECT 1 FROM languages WHERE code = ?", code).Scan(&n) == nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/catalog"
+)
+
+// importCatalog uploads c as a file in format f for real (dry_run=false)
+func importCatalog(t *testing.T, h *Handler, f catalog.Format, c *catalog.Catalog) map[string]int {
+	t.Helper()
+	var file bytes.Buffer
+	if err := catalog.Encode(&file, f, c); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "static."+f.Extension())
+	part.Write(file.Bytes())
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/admin/static/import?dry_run=false&lang=FR&format="+string(f), &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.ImportStaticContent(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import: status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Counts map[string]int `json:"counts"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp.Counts
+}
+
+func TestImportStaticContentConfirmsReviewedDrafts(t *testing.T) {
+	tests := []struct {
+		format catalog.Format
+		fuzzy  bool
+		// wantMachine is the flag of the draft after the import
+		wantMachine bool
+		wantAction  string
+	}{
+		{catalog.FormatPO, false, false, catalogUpdated},
+		{catalog.FormatXLIFF, false, false, catalogUpdated},
+		{catalog.FormatPO, true, true, catalogFuzzy},
+		// CSV can't tell a reviewed draft from one sent back as it was
+		{catalog.FormatCSV, false, true, catalogUnchanged},
+	}
+	for _, tt := range tests {
+		name := string(tt.format)
+		if tt.fuzzy {
+			name += " fuzzy"
+		}
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t)
+			exec(t, h, "DELETE FROM static_content WHERE property = 'test_intro'")
+			exec(t, h, `
+				INSERT INTO static_content (property, lang_code, content, machine_translated) VALUES
+					('test_intro', 'NL', 'Welkom', 0),
+					('test_intro', 'FR', 'Bienvenue', 1)
+			`)
+
+			counts := importCatalog(t, h, tt.format, &catalog.Catalog{
+				SourceLang: "NL", TargetLang: "FR",
+				Entries: []catalog.Entry{{Key: "test_intro", Source: "Welkom", Target: "Bienvenue", Fuzzy: tt.fuzzy}},
+			})
+			if counts[tt.wantAction] != 1 {
+				t.Errorf("counts = %v, want one %s", counts, tt.wantAction)
+			}
+
+			var content string
+			var machine bool
+			h.db.QueryRow("SELECT content, machine_translated FROM static_content WHERE property = 'test_intro' AND lang_code = 'FR'").
+				Scan(&content, &machine)
+			if content != "Bienvenue" || machine != tt.wantMachine {
+				t.Errorf("FR = %q, machine %v; want machine %v", content, machine, tt.wantMachine)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// requestLanguage returns the language a public request is answered in:
+// the ?lang= parameter, or else the best match of the Accept-Language
+// header among the active languages. When no active language matches the
+// header the first active fallback language is used. It returns "" for
+// ?lang=all, an empty ?lang= or a request without either, which get every
+// translation. The chosen language is sent back as Content-Language.
+func (h *Handler) requestLanguage(w http.ResponseWriter, r *http.Request) string {
+	active := h.activeLanguages()
+
+	var lang string
+	if values, ok := r.URL.Query()["lang"]; ok {
+		lang = strings.ToUpper(strings.TrimSpace(values[0]))
+		if lang == "ALL" {
+			lang = ""
+		}
+	} else if header := r.Header.Get("Accept-Language"); header != "" {
+		lang = negotiateLanguage(header, active)
+		if lang == "" {
+			for _, fallback := range h.cfg.TranslationFallback {
+				if active[fallback] {
+					lang = fallback
+					break
+				}
+			}
+		}
+	}
+
+	if active[lang] {
+		w.Header().Set("Content-Language", strings.ToLower(lang))
+	}
+	return lang
+}
+
+// activeLanguages returns the codes of the active languages
+func (h *Handler) activeLanguages() map[string]bool {
+	active := map[string]bool{}
+	rows, err := h.db.Query("SELECT code FROM languages WHERE active = 1")
+	if err != nil {
+		return active
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		if rows.Scan(&code) == nil {
+			active[strings.ToUpper(code)] = true
+		}
+	}
+	return active
+}
+
+// negotiateLanguage picks the active language a browser prefers from an
+// Accept-Language header such as "nl-BE,nl;q=0.9,en;q=0.8". Regions are
+// ignored since languages are stored without them. It returns "" when no
+// active language is accepted.
+func negotiateLanguage(header string, active map[string]bool) string {
+	type accepted struct {
+		lang string
+		q    float64
+	}
+	preferences := []accepted{}
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+		if primary == "" || q <= 0 {
+			continue
+		}
+		preferences = append(preferences, accepted{strings.ToUpper(primary), q})
+	}
+	sort.SliceStable(preferences, func(i, j int) bool { return preferences[i].q > preferences[j].q })
+
+	for _, p := range preferences {
+		if active[p.lang] {
+			return p.lang
+		}
+	}
+	return ""
+}
+
+// translationChain returns the languages tried for a ?lang= request: the
+// language itself, then the configured fallbacks
+func (h *Handler) translationChain(lang string) []string {
+	lang = strings.ToUpper(strings.TrimSpace(lang))
+	chain := []string{lang}
+	for _, fallback := range h.cfg.TranslationFallback {
+		if fallback != lang {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// pickTranslation returns the index of the translation to show: the first
+// language of the chain that has a non-blank text. langs and texts hold
+// the language and text of each stored translation. It returns -1 when no
+// language of the chain is translated.
+func pickTranslation(chain, langs, texts []string) int {
+	for _, lang := range chain {
+		for i := range langs {
+			if strings.EqualFold(langs[i], lang) && !blankTranslation(texts[i]) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// blankTranslation reports whether a translated text is empty. The admin
+// forms save a row for every language, and the rich text editor stores an
+// empty document as JSON without any text node.
+func blankTranslation(text string) bool {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return true
+	}
+	return strings.HasPrefix(text, `{"type":"doc"`) && !strings.Contains(text, `"text"`)
+}
+
+// TranslationGap is an entity without a usable translation in a language
+type TranslationGap struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	// Draft is set when an unconfirmed machine translation exists
+	Draft bool `json:"draft,omitempty"`
+}
+
+// translationSources are the translated entities checked by the report.
+// entities returns (id, label) of every entity that should be translated,
+// translations its (id, lang_code, text, machine_translated) rows.
+var translationSources = []struct {
+	kind         string
+	entities     string
+	translations string
+}{
+	{
+		"event",
+		"SELECT CAST(id AS TEXT), title FROM events WHERE state != 'archived'",
+		"SELECT CAST(event_id AS TEXT), lang_code, COALESCE(description, ''), machine_translated FROM event_translations",
+	},
+	{
+		"message",
+		`SELECT CAST(m.id AS TEXT), COALESCE((SELECT t.title FROM message_translations t
+		        WHERE t.message_id = m.id AND COALESCE(t.title, '') != '' ORDER BY t.lang_code LIMIT 1), '#' || m.id)
+		 FROM messages m WHERE m.state != 'archived'`,
+		`SELECT CAST(message_id AS TEXT), lang_code, COALESCE(title, '') || COALESCE(content, ''), machine_translated
+		 FROM message_translations`,
+	},
+	{
+		"shop_item",
+		"SELECT CAST(id AS TEXT), title FROM shop_items",
+		"SELECT CAST(item_id AS TEXT), lang_code, description, machine_translated FROM shop_item_translations",
+	},
+	{
+		"event_type",
+		"SELECT code, code FROM event_types WHERE active = 1",
+		"SELECT type_code, lang_code, label, 0 FROM event_type_translations",
+	},
+	{
+		"static_content",
+		"SELECT DISTINCT property, property FROM static_content",
+		"SELECT property, lang_code, COALESCE(content, ''), machine_translated FROM static_content",
+	},
+}
+
+// GetMissingTranslations lists, per active language, every entity that has
+// no translation in it. Visitors then see the fallback language instead.
+// Unconfirmed machine translations count as missing.
+// ?lang= limits the report to one language.
+func (h *Handler) GetMissingTranslations(w http.ResponseWriter, r *http.Request) {
+	langFilter := strings.ToUpper(r.URL.Query().Get("lang"))
+
+	rows, err := h.db.Query("SELECT code FROM languages WHERE active = 1 ORDER BY code")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	languages := []string{}
+	for rows.Next() {
+		var code string
+		if rows.Scan(&code) == nil && (langFilter == "" || code == langFilter) {
+			languages = append(languages, code)
+		}
+	}
+	rows.Close()
+
+	missing := map[string][]TranslationGap{}
+	counts := map[string]map[string]int{}
+	for _, lang := range languages {
+		missing[lang] = []TranslationGap{}
+		counts[lang] = map[string]int{}
+	}
+
+	for _, source := range translationSources {
+		// translated[id][lang] is set for every usable translation,
+		// drafts[id][lang] for every machine translation draft
+		translated := map[string]map[string]bool{}
+		drafts := map[string]map[string]bool{}
+		rows, err := h.db.Query(source.translations)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		for rows.Next() {
+			var id, lang, text string
+			var machineTranslated bool
+			if rows.Scan(&id, &lang, &text, &machineTranslated) != nil || blankTranslation(text) {
+				continue
+			}
+			set := translated
+			if machineTranslated {
+				set = drafts
+			}
+			if set[id] == nil {
+				set[id] = map[string]bool{}
+			}
+			set[id][strings.ToUpper(lang)] = true
+		}
+		rows.Close()
+
+		rows, err = h.db.Query(source.entities)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		for rows.Next() {
+			var id, label string
+			if rows.Scan(&id, &label) != nil {
+				continue
+			}
+			for _, lang := range languages {
+				if !translated[id][lang] {
+					missing[lang] = append(missing[lang], TranslationGap{
+						Kind: source.kind, ID: id, Label: label, Draft: drafts[id][lang],
+					})
+					counts[lang][source.kind]++
+				}
+			}
+		}
+		rows.Close()
+	}
+
+	for _, gaps := range missing {
+		sort.SliceStable(gaps, func(i, j int) bool {
+			if gaps[i].Kind != gaps[j].Kind {
+				return gaps[i].Kind < gaps[j].Kind
+			}
+			a, errA := strconv.Atoi(gaps[i].ID)
+			b, errB := strconv.Atoi(gaps[j].ID)
+			if errA == nil && errB == nil {
+				return a < b
+			}
+			return gaps[i].ID < gaps[j].ID
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"languages": languages,
+		"fallback":  h.cfg.TranslationFallback,
+		"missing":   missing,
+		"counts":    counts,
+	})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	active := map[string]bool{"NL": true, "EN": true, "FR": true}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"nl", "NL"},
+		{"en-GB", "EN"},
+		{"nl-BE,nl;q=0.9,en;q=0.8", "NL"},
+		// The order of the header doesn't matter, the q-values do
+		{"en;q=0.5,fr;q=0.8", "FR"},
+		{"de,fr;q=0.3", "FR"},
+		{"FR-be", "FR"},
+		// q=0 means "not this one"
+		{"nl;q=0,en;q=0.1", "EN"},
+		{"nl;q=0", ""},
+		{"de,es", ""},
+		{"*", ""},
+		// Broken entries are skipped, not fatal
+		{"nl;q=abc,en", "EN"},
+		{" , ;q=1, fr ", "FR"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateLanguage(tt.header, active); got != tt.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	h := newTestHandler(t)
+	exec(t, h, "UPDATE languages SET active = 0 WHERE code = 'DE'")
+
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		want           string
+		wantHeader     string
+	}{
+		{"lang parameter", "?lang=fr", "en", "FR", "fr"},
+		{"lang parameter wins over the header", "?lang=EN", "nl", "EN", "en"},
+		{"every language", "?lang=all", "nl", "", ""},
+		{"empty lang parameter", "?lang=", "nl", "", ""},
+		{"inactive lang parameter", "?lang=de", "", "DE", ""},
+		{"header", "", "fr-BE,fr;q=0.9,en;q=0.8", "FR", "fr"},
+		{"header with an inactive language first", "", "de-DE,en;q=0.5", "EN", "en"},
+		{"no accepted language falls back", "", "es,it;q=0.8", "NL", "nl"},
+		{"no language at all", "", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/messages"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			if got := h.requestLanguage(w, r); got != tt.want {
+				t.Errorf("language = %q, want %q", got, tt.want)
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.wantHeader {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantHeader)
+			}
+		})
+	}
+
+	// The fallback skips languages that were switched off
+	h.cfg.TranslationFallback = []string{"DE", "EN"}
+	r := httptest.NewRequest("GET", "/messages", nil)
+	r.Header.Set("Accept-Language", "es")
+	if got := h.requestLanguage(httptest.NewRecorder(), r); got != "EN" {
+		t.Errorf("with DE inactive: language = %q, want EN", got)
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/mail"
+	"regexp"
 	"strings"
 )
 
@@ -61,3 +62,9 @@ var validCountryCodes = map[string]bool{
 func validateCountryCode(code string) bool {
 	return validCountryCodes[strings.ToUpper(code)]
 }
+
+var discountCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,40}$`)
+
+func validateDiscountCode(code string) bool {
+	return discountCodePattern.MatchString(code)
+}
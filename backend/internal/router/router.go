@@ -9,12 +9,13 @@ import (
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/handlers"
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/middleware"
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/email"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 )
 
-func New(db *database.DB, cfg *config.Config, emailService *email.Service) http.Handler {
+func New(db *database.DB, cfg *config.Config, emailService *email.Service, store storage.Storage) http.Handler {
 	r := chi.NewRouter()
 
 	// Global middleware
@@ -27,14 +28,14 @@ func New(db *database.DB, cfg *config.Config, emailService *email.Service) http.
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   cfg.CORSOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Checkin-Key"},
 		ExposedHeaders:   []string{"Link", "X-Total-Count"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	// Initialize handlers
-	h := handlers.New(db, cfg, emailService)
+	h := handlers.New(db, cfg, emailService, store)
 
 	// Initialize login rate limiter (5 attempts per 15 minutes per IP)
 	loginLimiter := middleware.NewRateLimiter(5, 15*time.Minute)
@@ -54,8 +55,20 @@ func New(db *database.DB, cfg *config.Config, emailService *email.Service) http.
 		r.With(middleware.CacheControl()).Get("/static", h.GetStaticContent)
 		r.With(middleware.CacheControl()).Get("/socials", h.GetSocials)
 		r.With(middleware.CacheControl()).Get("/events", h.GetPublicEvents)
+		r.With(middleware.CacheControl()).Get("/event-types", h.GetEventTypes)
 		r.With(middleware.CacheControl()).Get("/events/{uuid}", h.GetEventByUUID)
 		r.Get("/events/{uuid}/qr-codes", h.GetEventQRCodes)
+		r.With(middleware.CacheControl()).Get("/events/{uuid}/media", h.GetPublicEventMedia)
+		r.With(chiMiddleware.Throttle(10)).Get("/qr/{target}/{id}", h.GetQRCode)
+		r.With(chiMiddleware.Throttle(10)).Post("/events/{uuid}/register", h.RegisterForEvent)
+		r.Get("/events/registrations/{token}", h.GetPublicEventRegistration)
+		r.Post("/events/registrations/{token}/checkout", h.StartEventRegistrationCheckout)
+		r.Post("/events/registrations/{token}/cancel", h.CancelOwnEventRegistration)
+		r.Get("/events/tickets/{code}", h.GetPublicEventTicket)
+		r.Get("/events/tickets/{code}/qr.png", h.GetEventTicketQR)
+		// Door check-in by volunteers, authorized by an X-Checkin-Key header
+		r.Post("/events/check-in", h.VolunteerCheckIn)
+		r.Get("/events/check-in", h.GetVolunteerCheckIns)
 		r.With(middleware.CacheControl()).Get("/home_events", h.GetHomeEvents)
 		r.With(middleware.CacheControl()).Get("/messages", h.GetPublicMessages)
 		r.With(middleware.CacheControl()).Get("/geocaches", h.GetPublicGeocaches)
@@ -67,12 +80,24 @@ func New(db *database.DB, cfg *config.Config, emailService *email.Service) http.
 		r.With(middleware.CacheControl()).Get("/shop/settings", h.GetPublicShopSettings)
 		r.With(middleware.CacheControl()).Get("/shop/items", h.GetPublicShopItems)
 		r.Post("/shop/checkout", h.CreateCheckoutSession)
-
-		// Stripe webhook (no auth, signature-verified in handler)
+		r.With(chiMiddleware.Throttle(10)).Post("/shop/discount-codes/check", h.CheckDiscountCode)
+		r.Get("/shop/orders/{id}/invoice.pdf", h.GetPublicShopOrderInvoice) // signed link from the confirmation mail
+		r.With(chiMiddleware.Throttle(10)).Post("/shop/orders/lookup", h.RequestShopOrderLookup)
+		r.Get("/shop/orders/lookup", h.GetShopOrderLookup) // signed link from the lookup mail
+		r.Get("/shop/orders/{token}", h.GetPublicShopOrder)
+		r.Get("/shop/orders/{token}/pickup-qr.png", h.GetOrderPickupQR)
+		r.Get("/shop/pickup-points", h.GetPublicPickupPoints)
+
+		// Payment webhooks (no auth, verified by the provider implementation)
 		r.Post("/shop/webhook", h.StripeWebhook)
+		r.Post("/shop/webhook/{provider}", h.PaymentWebhook)
+		// Pretix webhook (no auth, the order is fetched back from the pretix API)
+		r.Post("/events/pretix/webhook", h.PretixWebhook)
 
-		// Serve uploaded images (public, cached)
+		// Serve uploaded images, their alt texts and event downloads (public, cached)
 		r.Get("/images/*", h.ServeImage)
+		r.Get("/files/{filename}", h.ServeFile)
+		r.With(middleware.CacheControl()).Get("/media/{filename}", h.GetPublicMedia)
 
 		// Contact form (no caching, rate limited more strictly)
 		r.With(chiMiddleware.Throttle(10)).Post("/contact", h.SubmitContactForm)
@@ -102,6 +127,43 @@ func New(db *database.DB, cfg *config.Config, emailService *email.Service) http.
 			r.Put("/events/{id}", h.UpdateEvent)
 			r.Delete("/events/{id}", h.DeleteEvent)
 
+			// Event registration, ticket types and attendees
+			r.Get("/events/{id}/registration", h.GetEventRegistration)
+			r.Put("/events/{id}/registration", h.UpdateEventRegistration)
+			r.Post("/events/{id}/ticket-types", h.CreateEventTicketType)
+			r.Put("/events/ticket-types/{id}", h.UpdateEventTicketType)
+			r.Delete("/events/ticket-types/{id}", h.DeleteEventTicketType)
+			r.Get("/events/{id}/attendees", h.GetEventAttendees)
+			r.Get("/events/registrations/{id}", h.GetEventRegistrationByID)
+			r.Post("/events/registrations/{id}/cancel", h.CancelEventRegistration)
+			r.Post("/events/registrations/{id}/promote", h.PromoteEventRegistration)
+			r.Post("/events/{id}/check-in", h.CheckInEventTicket)
+			r.Get("/events/{id}/check-in", h.GetEventCheckIns)
+			r.Delete("/events/attendees/{id}/check-in", h.UndoEventCheckIn)
+			r.Get("/events/{id}/checkin-keys", h.GetEventCheckinKeys)
+			r.Post("/events/{id}/checkin-keys", h.CreateEventCheckinKey)
+			r.Delete("/events/checkin-keys/{id}", h.DeleteEventCheckinKey)
+			r.Get("/events/{id}/pretix", h.GetEventPretix)
+			r.Put("/events/{id}/pretix", h.LinkEventPretix)
+			r.Delete("/events/{id}/pretix", h.UnlinkEventPretix)
+			r.Post("/events/{id}/pretix/sync", h.SyncEventPretix)
+			r.Get("/events/{id}/media", h.GetEventMedia)
+			r.Post("/events/{id}/media", h.UploadEventMedia)
+			r.Put("/events/{id}/media/order", h.ReorderEventMedia)
+			r.Put("/events/media/{id}", h.UpdateEventMedia)
+			r.Delete("/events/media/{id}", h.DeleteEventMedia)
+
+			// Media library
+			r.Get("/media", h.GetMedia)
+			r.Post("/media/cleanup", h.CleanupMedia)
+			r.Get("/media/{id}", h.GetMediaItem)
+			r.Put("/media/{id}", h.UpdateMedia)
+			r.Delete("/media/{id}", h.DeleteMedia)
+			r.Get("/event-types", h.GetAdminEventTypes)
+			r.Post("/event-types", h.CreateEventType)
+			r.Put("/event-types/{code}", h.UpdateEventType)
+			r.Delete("/event-types/{code}", h.DeleteEventType)
+
 			// Geocaches CRUD
 			r.Get("/geocaches", h.GetAdminGeocaches)
 			r.Get("/geocaches/{id}", h.GetGeocacheByID)
@@ -125,9 +187,19 @@ func New(db *database.DB, cfg *config.Config, emailService *email.Service) http.
 			// Static content / translations CRUD
 			r.Get("/static", h.GetAdminStaticContent)
 			r.Post("/static", h.CreateStaticContent)
+			// Bulk translation as PO, XLIFF or CSV catalogs
+			r.Get("/static/export", h.ExportStaticContent)
+			r.Post("/static/import", h.ImportStaticContent)
 			r.Put("/static/{property}", h.UpdateStaticContent)
 			r.Delete("/static/{property}", h.DeleteStaticContent)
 
+			// Translations missing per active language
+			r.Get("/translations/missing", h.GetMissingTranslations)
+
+			// Machine translation drafts, confirmed by an admin
+			r.Post("/translations/{kind}/{id}/suggest", h.SuggestTranslations)
+			r.Post("/translations/{kind}/{id}/{lang}/confirm", h.ConfirmTranslation)
+
 			// Socials CRUD
 			r.Get("/socials", h.GetAdminSocials)
 			r.Post("/socials", h.CreateSocial)
@@ -165,16 +237,73 @@ func New(db *database.DB, cfg *config.Config, emailService *email.Service) http.
 			r.Put("/shop/items/{id}", h.UpdateShopItem)
 			r.Delete("/shop/items/{id}", h.DeleteShopItem)
 
+			// Shop discount codes CRUD
+			r.Get("/shop/discount-codes", h.GetDiscountCodes)
+			r.Get("/shop/discount-codes/{id}", h.GetDiscountCodeByID)
+			r.Post("/shop/discount-codes", h.CreateDiscountCode)
+			r.Put("/shop/discount-codes/{id}", h.UpdateDiscountCode)
+			r.Delete("/shop/discount-codes/{id}", h.DeleteDiscountCode)
+
+			// Shop pickup points, time slots and hand-over
+			r.Get("/shop/pickup-points", h.GetPickupPoints)
+			r.Get("/shop/pickup-points/{id}", h.GetPickupPointByID)
+			r.Post("/shop/pickup-points", h.CreatePickupPoint)
+			r.Put("/shop/pickup-points/{id}", h.UpdatePickupPoint)
+			r.Delete("/shop/pickup-points/{id}", h.DeletePickupPoint)
+			r.Post("/shop/pickup-points/{id}/slots", h.CreatePickupSlot)
+			r.Put("/shop/pickup-slots/{id}", h.UpdatePickupSlot)
+			r.Delete("/shop/pickup-slots/{id}", h.DeletePickupSlot)
+			r.Get("/shop/pickups", h.GetPickupList)
+			r.Post("/shop/pickups/scan", h.ScanPickup)
+
 			// Shop orders
 			r.Get("/shop/orders", h.GetAdminShopOrders)
+			r.Get("/shop/orders/export", h.ExportShopOrders)
+			r.Get("/shop/orders/labels.pdf", h.GetShippingLabels)
+			r.Post("/shop/orders/ship", h.BulkShipShopOrders)
+			r.Get("/shop/carriers", h.GetCarriers)
 			r.Get("/shop/orders/{id}", h.GetShopOrderByID)
 			r.Put("/shop/orders/{id}/status", h.UpdateShopOrderStatus)
+			r.Post("/shop/orders/{id}/refund", h.RefundShopOrder)
+			r.Get("/shop/orders/{id}/invoice.pdf", h.GetShopOrderInvoice)
+			r.Post("/shop/orders/{id}/invoice", h.IssueShopOrderInvoice)
+
+			// Shop accounting reports
+			r.Get("/shop/reports/revenue", h.GetShopRevenueReport)
+			r.Get("/shop/reports/fees", h.GetShopFeeReport)
+			r.Get("/shop/reports/yearly", h.GetShopYearlyReport)
+
+			// Payment webhook event log
+			r.Get("/shop/payment-events", h.GetPaymentEvents)
+			r.Get("/shop/payment-events/{id}", h.GetPaymentEventByID)
+			r.Post("/shop/payment-events/{id}/replay", h.ReplayPaymentEvent)
+			// Former names, from when Stripe was the only provider
+			r.Get("/shop/stripe-events", h.GetPaymentEvents)
+			r.Get("/shop/stripe-events/{id}", h.GetPaymentEventByID)
+			r.Post("/shop/stripe-events/{id}/replay", h.ReplayPaymentEvent)
 
 			// User management
 			r.Get("/users", h.GetUsers)
 			r.Post("/users", h.CreateUser)
 			r.Delete("/users/{id}", h.DeleteUser)
 			r.Post("/users/{id}/resend-invitation", h.ResendInvitation)
+
+			// Revision history of content, with diff and restore
+			r.Get("/revisions", h.GetRevisions)
+			r.Get("/revisions/{id}", h.GetRevision)
+			r.Get("/revisions/{id}/diff", h.GetRevisionDiff)
+			r.Post("/revisions/{id}/restore", h.RestoreRevision)
+
+			// Content export/import between environments
+			r.Get("/export", h.ExportBundle)
+			r.Post("/import", h.ImportBundle)
+
+			// Backups of the database and uploads
+			r.Get("/backups", h.GetBackups)
+			r.Post("/backups", h.CreateBackup)
+			r.Get("/backups/status", h.GetBackupStatus)
+			r.Get("/backups/{name}", h.DownloadBackup)
+			r.Delete("/backups/{name}", h.DeleteBackup)
 		})
 	})
 
@@ -0,0 +1,338 @@
+// Package backup writes the database and the uploaded files into one
+// archive and restores such archives.
+//
+// An archive is a gzipped tar holding database.db (an online copy made
+// with the SQLite backup API, so it is consistent while the site is in
+// use), the images/ and files/ of the storage backend and, last,
+// manifest.json with the SHA-256 of every other entry.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	manifestName  = "manifest.json"
+	databaseName  = "database.db"
+	formatVersion = 1
+)
+
+// NamePattern matches the file names of archives made by Create. A second
+// archive within the same second gets a sequence number.
+var NamePattern = regexp.MustCompile(`^backup-(\d{8}-\d{6})(?:-(\d+))?\.tar\.gz$`)
+
+// ErrInvalidArchive is returned for archives that fail validation
+var ErrInvalidArchive = errors.New("backup: invalid archive")
+
+// running serializes backups from the scheduler and the admin panel
+var running sync.Mutex
+
+// Info describes a stored archive
+type Info struct {
+	Name      string    `json:"name"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Manifest is the table of contents of an archive
+type Manifest struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	Files     map[string]string `json:"files"`
+}
+
+// Create writes a new archive to dir and returns it
+func Create(ctx context.Context, db *database.DB, store storage.Storage, dir string) (*Info, error) {
+	running.Lock()
+	defer running.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+
+	snapshot, err := os.CreateTemp(dir, ".snapshot-*.db")
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Close()
+	defer os.Remove(snapshot.Name())
+	if err := copyDatabase(ctx, db, snapshot.Name()); err != nil {
+		return nil, fmt.Errorf("database copy failed: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".backup-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	manifest := Manifest{Version: formatVersion, CreatedAt: now, Files: map[string]string{}}
+
+	err = func() error {
+		f, err := os.Open(snapshot.Name())
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return addEntry(tw, manifest.Files, databaseName, info.Size(), now, f)
+	}()
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	for _, prefix := range storage.Prefixes {
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			tmp.Close()
+			return nil, fmt.Errorf("listing %s failed: %w", prefix, err)
+		}
+		for _, o := range objects {
+			body, obj, err := store.Get(ctx, o.Key)
+			if err == storage.ErrNotFound {
+				continue // removed since the listing
+			}
+			if err != nil {
+				tmp.Close()
+				return nil, fmt.Errorf("reading %s failed: %w", o.Key, err)
+			}
+			err = addEntry(tw, manifest.Files, o.Key, obj.Size, o.ModTime, body)
+			body.Close()
+			if err != nil {
+				tmp.Close()
+				return nil, err
+			}
+		}
+	}
+
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	err = tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0600, Size: int64(len(data)), ModTime: now})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := publish(tmp.Name(), dir, "backup-"+now.Format("20060102-150405"))
+	if err != nil {
+		return nil, err
+	}
+	return Stat(dir, name)
+}
+
+// publish gives the finished archive at tmpPath its name in dir: base, or
+// base with a sequence number when that is taken. A hard link fails instead
+// of replacing an existing file, also when another process (the CLI next to
+// the server) publishes an archive at the same moment.
+func publish(tmpPath, dir, base string) (string, error) {
+	for seq := 1; seq <= 100; seq++ {
+		name := base + ".tar.gz"
+		if seq > 1 {
+			name = fmt.Sprintf("%s-%d.tar.gz", base, seq)
+		}
+		err := os.Link(tmpPath, filepath.Join(dir, name))
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("backup: no free archive name for %s", base)
+}
+
+func addEntry(tw *tar.Writer, hashes map[string]string, name string, size int64, modTime time.Time, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(tw, io.TeeReader(r, hash)); err != nil {
+		return fmt.Errorf("writing %s failed: %w", name, err)
+	}
+	hashes[name] = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// copyDatabase copies the live database to dest with the SQLite online
+// backup API. Writers are not blocked, the WAL is included.
+func copyDatabase(ctx context.Context, db *database.DB, dest string) error {
+	destDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			destSQLite, ok1 := destDriver.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("not a SQLite connection")
+			}
+			b, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// Stat returns the archive called name in dir
+func Stat(dir, name string) (*Info, error) {
+	if !NamePattern.MatchString(name) {
+		return nil, os.ErrNotExist
+	}
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return &Info{Name: name, SizeBytes: info.Size(), CreatedAt: info.ModTime().UTC()}, nil
+}
+
+// List returns the archives in dir, newest first
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := []Info{}
+	for _, e := range entries {
+		if info, err := Stat(dir, e.Name()); err == nil {
+			backups = append(backups, *info)
+		}
+	}
+	// The name holds the creation time and the sequence number, so it
+	// sorts chronologically
+	sort.Slice(backups, func(i, j int) bool {
+		ti, si := archiveOrder(backups[i].Name)
+		tj, sj := archiveOrder(backups[j].Name)
+		if ti != tj {
+			return ti > tj
+		}
+		return si > sj
+	})
+	return backups, nil
+}
+
+// archiveOrder returns the creation time and sequence number in an archive
+// name
+func archiveOrder(name string) (string, int) {
+	m := NamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0
+	}
+	seq := 1
+	if m[2] != "" {
+		seq, _ = strconv.Atoi(m[2])
+	}
+	return m[1], seq
+}
+
+// Rotate deletes all but the newest keep archives
+func Rotate(dir string, keep int) error {
+	if keep < 1 {
+		return nil
+	}
+	backups, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(dir, backups[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartScheduler makes a backup every intervalHours and keeps the newest
+// keep archives. It stops when ctx is cancelled.
+func StartScheduler(ctx context.Context, db *database.DB, store storage.Storage, dir string, intervalHours, keep int) {
+	if intervalHours <= 0 {
+		log.Println("Backup scheduler disabled (BACKUP_INTERVAL_HOURS is 0)")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(intervalHours) * time.Hour)
+	defer ticker.Stop()
+	log.Printf("Backup scheduler started (every %d hours, keeping %d backups in %s)", intervalHours, keep, dir)
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := Create(ctx, db, store, dir)
+			if err != nil {
+				log.Printf("Backup failed: %v", err)
+				continue
+			}
+			log.Printf("Backup written: %s (%d bytes)", info.Name, info.SizeBytes)
+			if err := Rotate(dir, keep); err != nil {
+				log.Printf("Backup rotation failed: %v", err)
+			}
+		case <-ctx.Done():
+			log.Println("Backup scheduler stopped")
+			return
+		}
+	}
+}
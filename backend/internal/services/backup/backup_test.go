@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+)
+
+func TestCreateKeepsEveryArchive(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "geocaching.db"))
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	defer db.Close()
+	backups := filepath.Join(dir, "backups")
+
+	// Usually within the same second
+	names := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		info, err := Create(context.Background(), db, storage.NewLocal(filepath.Join(dir, "storage")), backups)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		names[info.Name] = true
+	}
+	list, err := List(backups)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(names) != 3 || len(list) != 3 {
+		t.Errorf("created %v, listed %+v; want 3 archives", names, list)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	base := "backup-20260101-120000"
+
+	for i, want := range []string{base + ".tar.gz", base + "-2.tar.gz", base + "-3.tar.gz"} {
+		tmp := filepath.Join(dir, ".backup-tmp")
+		if err := os.WriteFile(tmp, []byte{byte(i)}, 0600); err != nil {
+			t.Fatal(err)
+		}
+		name, err := publish(tmp, dir, base)
+		if err != nil || name != want {
+			t.Fatalf("publish #%d = %q, %v; want %q", i+1, name, err, want)
+		}
+		os.Remove(tmp)
+	}
+
+	// The first archive was not replaced
+	if data, _ := os.ReadFile(filepath.Join(dir, base+".tar.gz")); len(data) != 1 || data[0] != 0 {
+		t.Errorf("first archive holds %v, want [0]", data)
+	}
+}
+
+func TestListOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"backup-20260101-120000.tar.gz",
+		"backup-20260101-120000-2.tar.gz",
+		"backup-20260101-120000-10.tar.gz",
+		"backup-20260101-120001.tar.gz",
+		"backup-20260101-120000-x.tar.gz",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := List(dir)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := []string{}
+	for _, info := range list {
+		got = append(got, info.Name)
+	}
+	want := []string{
+		"backup-20260101-120001.tar.gz",
+		"backup-20260101-120000-10.tar.gz",
+		"backup-20260101-120000-2.tar.gz",
+		"backup-20260101-120000.tar.gz",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+}
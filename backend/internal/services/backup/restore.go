@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+)
+
+// requiredTables must exist in a restored database
+var requiredTables = []string{"users", "languages", "events", "static_content"}
+
+// Restore validates an archive and then puts its files back into storage
+// and swaps its database in at dbPath. The current database is kept next
+// to it with a .before-restore-<time> suffix. The server must be stopped:
+// while it runs it holds the database lock and Restore returns
+// database.ErrInUse.
+func Restore(ctx context.Context, archivePath, dbPath string, store storage.Storage) (*Manifest, error) {
+	release, err := database.Lock(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	workDir, err := os.MkdirTemp(filepath.Dir(dbPath), ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	manifest, err := extract(archivePath, workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files first: if that fails halfway the old database is still in place
+	// and the extra files are only orphans
+	for name := range manifest.Files {
+		if name == databaseName {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(workDir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Put(ctx, name, data, mime.TypeByExtension(path.Ext(name))); err != nil {
+			return nil, fmt.Errorf("restoring %s failed: %w", name, err)
+		}
+	}
+
+	suffix := ".before-restore-" + time.Now().UTC().Format("20060102-150405")
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+suffix); err != nil {
+			return nil, err
+		}
+	}
+	// The WAL belongs to the old database
+	for _, ext := range []string{"-wal", "-shm"} {
+		if _, err := os.Stat(dbPath + ext); err == nil {
+			if err := os.Rename(dbPath+ext, dbPath+suffix+ext); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := os.Rename(filepath.Join(workDir, databaseName), dbPath); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Validate checks an archive without restoring it
+func Validate(archivePath string) (*Manifest, error) {
+	workDir, err := os.MkdirTemp("", "backup-check-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	return extract(archivePath, workDir)
+}
+
+// extract unpacks an archive into dir and checks it against its manifest
+// and the database for integrity
+func extract(archivePath, dir string) (*Manifest, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a gzip file", ErrInvalidArchive)
+	}
+	tr := tar.NewReader(gz)
+
+	hashes := map[string]string{}
+	var manifest *Manifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, header.Name)
+		}
+		if _, seen := hashes[header.Name]; seen || (header.Name == manifestName && manifest != nil) {
+			return nil, fmt.Errorf("%w: duplicate entry %s", ErrInvalidArchive, header.Name)
+		}
+
+		if header.Name == manifestName {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(io.LimitReader(tr, 64<<20)).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("%w: unreadable manifest", ErrInvalidArchive)
+			}
+			continue
+		}
+		if !validEntryName(header.Name) {
+			return nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, header.Name)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(io.MultiWriter(out, hash), tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, header.Name, err)
+		}
+		hashes[header.Name] = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: no manifest", ErrInvalidArchive)
+	}
+	if manifest.Version != formatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, manifest.Version)
+	}
+	if _, ok := manifest.Files[databaseName]; !ok {
+		return nil, fmt.Errorf("%w: no database", ErrInvalidArchive)
+	}
+	for name, want := range manifest.Files {
+		got, ok := hashes[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is missing", ErrInvalidArchive, name)
+		}
+		if got != want {
+			return nil, fmt.Errorf("%w: %s is corrupt", ErrInvalidArchive, name)
+		}
+	}
+	for name := range hashes {
+		if _, ok := manifest.Files[name]; !ok {
+			return nil, fmt.Errorf("%w: %s is not in the manifest", ErrInvalidArchive, name)
+		}
+	}
+
+	if err := checkDatabase(filepath.Join(dir, databaseName)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return manifest, nil
+}
+
+// validEntryName accepts the database and files directly below the
+// backed-up storage directories
+func validEntryName(name string) bool {
+	if name == databaseName {
+		return true
+	}
+	for _, prefix := range storage.Prefixes {
+		base := strings.TrimPrefix(name, prefix)
+		if base != name && base != "" && !strings.ContainsAny(base, `/\`) && base != "." && base != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDatabase runs SQLite's integrity check and looks for the core
+// tables, so an unrelated SQLite file is not swapped in
+func checkDatabase(dbPath string) error {
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("database unreadable: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database integrity check failed: %s", result)
+	}
+	for _, table := range requiredTables {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+		if n == 0 {
+			return fmt.Errorf("database has no %s table", table)
+		}
+	}
+	return nil
+}
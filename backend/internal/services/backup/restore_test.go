@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+)
+
+func TestRestoreRefusesWhileInUse(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "geocaching.db")
+
+	release, err := database.Lock(dbPath)
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	_, err = Restore(context.Background(), filepath.Join(dir, "missing.tar.gz"), dbPath, storage.NewLocal(dir))
+	if err != database.ErrInUse {
+		t.Errorf("Restore while locked: error = %v, want ErrInUse", err)
+	}
+
+	// Once the server is gone the archive itself is looked at
+	release()
+	_, err = Restore(context.Background(), filepath.Join(dir, "missing.tar.gz"), dbPath, storage.NewLocal(dir))
+	if err == nil || err == database.ErrInUse {
+		t.Errorf("Restore after release: error = %v, want the missing archive", err)
+	}
+}
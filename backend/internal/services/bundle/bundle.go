@@ -0,0 +1,519 @@
+// Package bundle exports site content to a JSON bundle and imports such
+// bundles, to move content between environments (staging to production).
+//
+// Rows are matched on a key that is the same in every environment (an
+// event's UUID, a language code, a month number, ...) rather than on their
+// IDs, which differ. Translations, hints and other child rows travel inside
+// their parent, so their references are remapped by construction.
+package bundle
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+)
+
+// FormatVersion is the version written to and accepted in bundles
+const FormatVersion = 1
+
+// Sections are the parts of a bundle, in import order
+var Sections = []string{
+	"languages", "event_types", "events", "messages", "static_content",
+	"socials", "golden_key", "shop_items",
+}
+
+// Bundle is the exported content. Sections that were not exported are
+// left out.
+type Bundle struct {
+	Version         int              `json:"version"`
+	ExportedAt      time.Time        `json:"exported_at"`
+	Languages       []Language       `json:"languages,omitempty"`
+	EventTypes      []EventType      `json:"event_types,omitempty"`
+	Events          []Event          `json:"events,omitempty"`
+	Messages        []Message        `json:"messages,omitempty"`
+	StaticContent   []StaticContent  `json:"static_content,omitempty"`
+	Socials         []Social         `json:"socials,omitempty"`
+	GoldenKeyMonths []GoldenKeyMonth `json:"golden_key_months,omitempty"`
+	ShopItems       []ShopItem       `json:"shop_items,omitempty"`
+}
+
+type Language struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	FlagURL string `json:"flag_url"`
+	Active  bool   `json:"active"`
+}
+
+type EventType struct {
+	Code         string             `json:"code"`
+	Icon         string             `json:"icon"`
+	GCTypeID     *int64             `json:"gc_type_id"`
+	SortOrder    int                `json:"sort_order"`
+	Active       bool               `json:"active"`
+	Translations []LabelTranslation `json:"translations"`
+}
+
+type LabelTranslation struct {
+	LangCode string `json:"lang_code"`
+	Label    string `json:"label"`
+}
+
+type Event struct {
+	ID           int64                    `json:"id"`
+	UUID         string                   `json:"uuid"`
+	State        string                   `json:"state"`
+	OnHome       bool                     `json:"on_home"`
+	Title        string                   `json:"title"`
+	Geolink      string                   `json:"geolink"`
+	Type         string                   `json:"type"`
+	Location     string                   `json:"location"`
+	StartDate    string                   `json:"start_date"`
+	EndDate      string                   `json:"end_date"`
+	ImageURL     string                   `json:"image_url"`
+	TicketURL    string                   `json:"ticket_url"`
+	GCCode       string                   `json:"gc_code"`
+	Translations []DescriptionTranslation `json:"translations"`
+}
+
+type DescriptionTranslation struct {
+	LangCode    string `json:"lang_code"`
+	Description string `json:"description"`
+}
+
+type Message struct {
+	ID           int64                `json:"id"`
+	State        string               `json:"state"`
+	Priority     int                  `json:"priority"`
+	Translations []MessageTranslation `json:"translations"`
+}
+
+type MessageTranslation struct {
+	LangCode string `json:"lang_code"`
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+}
+
+type StaticContent struct {
+	Property string `json:"property"`
+	LangCode string `json:"lang_code"`
+	Content  string `json:"content"`
+}
+
+type Social struct {
+	ID        int64  `json:"id"`
+	Platform  string `json:"platform"`
+	URL       string `json:"url"`
+	Icon      string `json:"icon"`
+	Active    bool   `json:"active"`
+	SortOrder int    `json:"sort_order"`
+}
+
+type GoldenKeyMonth struct {
+	ID          int64           `json:"id"`
+	MonthNumber int             `json:"month_number"`
+	MonthName   string          `json:"month_name"`
+	LiveDate    string          `json:"live_date"`
+	IsFound     bool            `json:"is_found"`
+	FinderName  string          `json:"finder_name"`
+	FinderImage string          `json:"finder_image"`
+	FoundDate   string          `json:"found_date"`
+	Hints       []GoldenKeyHint `json:"hints"`
+}
+
+type GoldenKeyHint struct {
+	SortOrder int    `json:"sort_order"`
+	Content   string `json:"content"`
+	ImageURL  string `json:"image_url"`
+}
+
+type ShopItem struct {
+	ID               int64                    `json:"id"`
+	Title            string                   `json:"title"`
+	Description      string                   `json:"description"`
+	PriceCents       int64                    `json:"price_cents"`
+	MemberPriceCents *int64                   `json:"member_price_cents"`
+	ImageURL         string                   `json:"image_url"`
+	StockQuantity    *int64                   `json:"stock_quantity"`
+	AllowPickup      bool                     `json:"allow_pickup"`
+	PickupLabel      string                   `json:"pickup_label"`
+	AllowShipping    bool                     `json:"allow_shipping"`
+	ShippingRegions  string                   `json:"shipping_regions"`
+	AutoConfirm      bool                     `json:"auto_confirm"`
+	Active           bool                     `json:"active"`
+	SortOrder        int                      `json:"sort_order"`
+	Translations     []DescriptionTranslation `json:"translations"`
+}
+
+// ParseSections turns a comma-separated list into a section set; an empty
+// list selects every section
+func ParseSections(list string) (map[string]bool, error) {
+	selected := map[string]bool{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !validSection(s) {
+			return nil, fmt.Errorf("unknown section %q", s)
+		}
+		selected[s] = true
+	}
+	if len(selected) == 0 {
+		for _, s := range Sections {
+			selected[s] = true
+		}
+	}
+	return selected, nil
+}
+
+func validSection(name string) bool {
+	for _, s := range Sections {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Export reads the selected sections from the database
+func Export(db *database.DB, sections map[string]bool) (*Bundle, error) {
+	b := &Bundle{Version: FormatVersion, ExportedAt: time.Now().UTC()}
+	var err error
+	if sections["languages"] && err == nil {
+		b.Languages, err = exportLanguages(db)
+	}
+	if sections["event_types"] && err == nil {
+		b.EventTypes, err = exportEventTypes(db)
+	}
+	if sections["events"] && err == nil {
+		b.Events, err = exportEvents(db)
+	}
+	if sections["messages"] && err == nil {
+		b.Messages, err = exportMessages(db)
+	}
+	if sections["static_content"] && err == nil {
+		b.StaticContent, err = exportStaticContent(db)
+	}
+	if sections["socials"] && err == nil {
+		b.Socials, err = exportSocials(db)
+	}
+	if sections["golden_key"] && err == nil {
+		b.GoldenKeyMonths, err = exportGoldenKeyMonths(db)
+	}
+	if sections["shop_items"] && err == nil {
+		b.ShopItems, err = exportShopItems(db)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func exportLanguages(db *database.DB) ([]Language, error) {
+	rows, err := db.Query("SELECT code, name, COALESCE(flag_url, ''), active FROM languages ORDER BY code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	languages := []Language{}
+	for rows.Next() {
+		var l Language
+		if err := rows.Scan(&l.Code, &l.Name, &l.FlagURL, &l.Active); err != nil {
+			return nil, err
+		}
+		languages = append(languages, l)
+	}
+	return languages, rows.Err()
+}
+
+func exportEventTypes(db *database.DB) ([]EventType, error) {
+	rows, err := db.Query("SELECT code, icon, gc_type_id, sort_order, active FROM event_types ORDER BY sort_order, code")
+	if err != nil {
+		return nil, err
+	}
+	types := []EventType{}
+	index := map[string]int{}
+	for rows.Next() {
+		var t EventType
+		var gcTypeID sql.NullInt64
+		if err := rows.Scan(&t.Code, &t.Icon, &gcTypeID, &t.SortOrder, &t.Active); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if gcTypeID.Valid {
+			t.GCTypeID = &gcTypeID.Int64
+		}
+		t.Translations = []LabelTranslation{}
+		index[t.Code] = len(types)
+		types = append(types, t)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT type_code, lang_code, label FROM event_type_translations ORDER BY lang_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		var t LabelTranslation
+		if err := rows.Scan(&code, &t.LangCode, &t.Label); err != nil {
+			return nil, err
+		}
+		if i, ok := index[code]; ok {
+			types[i].Translations = append(types[i].Translations, t)
+		}
+	}
+	return types, rows.Err()
+}
+
+func exportEvents(db *database.DB) ([]Event, error) {
+	rows, err := db.Query(`
+		SELECT id, uuid, state, on_home, title, COALESCE(geolink, ''), type, COALESCE(location, ''),
+		       start_date, end_date, COALESCE(image_url, ''), COALESCE(ticket_url, ''), COALESCE(gc_code, '')
+		FROM events ORDER BY start_date, id`)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.UUID, &e.State, &e.OnHome, &e.Title, &e.Geolink, &e.Type, &e.Location,
+			&e.StartDate, &e.EndDate, &e.ImageURL, &e.TicketURL, &e.GCCode); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e.Translations = []DescriptionTranslation{}
+		index[e.ID] = len(events)
+		events = append(events, e)
+	}
+	rows.Close()
+
+	// Unconfirmed machine translations stay behind: an import would make
+	// them look human
+	rows, err = db.Query("SELECT event_id, lang_code, COALESCE(description, '') FROM event_translations WHERE machine_translated = 0 ORDER BY lang_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID int64
+		var t DescriptionTranslation
+		if err := rows.Scan(&eventID, &t.LangCode, &t.Description); err != nil {
+			return nil, err
+		}
+		if i, ok := index[eventID]; ok {
+			events[i].Translations = append(events[i].Translations, t)
+		}
+	}
+	return events, rows.Err()
+}
+
+func exportMessages(db *database.DB) ([]Message, error) {
+	rows, err := db.Query("SELECT id, state, priority FROM messages ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	messages := []Message{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.State, &m.Priority); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.Translations = []MessageTranslation{}
+		index[m.ID] = len(messages)
+		messages = append(messages, m)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT message_id, lang_code, COALESCE(title, ''), COALESCE(content, '')
+		FROM message_translations WHERE machine_translated = 0 ORDER BY lang_code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageID int64
+		var t MessageTranslation
+		if err := rows.Scan(&messageID, &t.LangCode, &t.Title, &t.Content); err != nil {
+			return nil, err
+		}
+		if i, ok := index[messageID]; ok {
+			messages[i].Translations = append(messages[i].Translations, t)
+		}
+	}
+	return messages, rows.Err()
+}
+
+func exportStaticContent(db *database.DB) ([]StaticContent, error) {
+	rows, err := db.Query("SELECT property, lang_code, COALESCE(content, '') FROM static_content WHERE machine_translated = 0 ORDER BY property, lang_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	content := []StaticContent{}
+	for rows.Next() {
+		var c StaticContent
+		if err := rows.Scan(&c.Property, &c.LangCode, &c.Content); err != nil {
+			return nil, err
+		}
+		content = append(content, c)
+	}
+	return content, rows.Err()
+}
+
+func exportSocials(db *database.DB) ([]Social, error) {
+	rows, err := db.Query("SELECT id, platform, url, COALESCE(icon, ''), active, sort_order FROM socials ORDER BY sort_order, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	socials := []Social{}
+	for rows.Next() {
+		var s Social
+		if err := rows.Scan(&s.ID, &s.Platform, &s.URL, &s.Icon, &s.Active, &s.SortOrder); err != nil {
+			return nil, err
+		}
+		socials = append(socials, s)
+	}
+	return socials, rows.Err()
+}
+
+func exportGoldenKeyMonths(db *database.DB) ([]GoldenKeyMonth, error) {
+	rows, err := db.Query(`
+		SELECT id, month_number, month_name, live_date, is_found, COALESCE(finder_name, ''),
+		       COALESCE(finder_image, ''), COALESCE(found_date, '')
+		FROM golden_key_months ORDER BY month_number`)
+	if err != nil {
+		return nil, err
+	}
+	months := []GoldenKeyMonth{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var m GoldenKeyMonth
+		if err := rows.Scan(&m.ID, &m.MonthNumber, &m.MonthName, &m.LiveDate, &m.IsFound, &m.FinderName,
+			&m.FinderImage, &m.FoundDate); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.Hints = []GoldenKeyHint{}
+		index[m.ID] = len(months)
+		months = append(months, m)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT month_id, sort_order, content, COALESCE(image_url, '') FROM golden_key_hints ORDER BY sort_order, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var monthID int64
+		var h GoldenKeyHint
+		if err := rows.Scan(&monthID, &h.SortOrder, &h.Content, &h.ImageURL); err != nil {
+			return nil, err
+		}
+		if i, ok := index[monthID]; ok {
+			months[i].Hints = append(months[i].Hints, h)
+		}
+	}
+	return months, rows.Err()
+}
+
+func exportShopItems(db *database.DB) ([]ShopItem, error) {
+	rows, err := db.Query(`
+		SELECT id, title, description, price_cents, member_price_cents, COALESCE(image_url, ''), stock_quantity,
+		       allow_pickup, pickup_label, allow_shipping, shipping_regions, auto_confirm, active, sort_order
+		FROM shop_items ORDER BY sort_order, id`)
+	if err != nil {
+		return nil, err
+	}
+	items := []ShopItem{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var item ShopItem
+		var memberPrice, stock sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Title, &item.Description, &item.PriceCents, &memberPrice, &item.ImageURL,
+			&stock, &item.AllowPickup, &item.PickupLabel, &item.AllowShipping, &item.ShippingRegions,
+			&item.AutoConfirm, &item.Active, &item.SortOrder); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if memberPrice.Valid {
+			item.MemberPriceCents = &memberPrice.Int64
+		}
+		if stock.Valid {
+			item.StockQuantity = &stock.Int64
+		}
+		item.Translations = []DescriptionTranslation{}
+		index[item.ID] = len(items)
+		items = append(items, item)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT item_id, lang_code, description FROM shop_item_translations WHERE machine_translated = 0 ORDER BY lang_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var itemID int64
+		var t DescriptionTranslation
+		if err := rows.Scan(&itemID, &t.LangCode, &t.Description); err != nil {
+			return nil, err
+		}
+		if i, ok := index[itemID]; ok {
+			items[i].Translations = append(items[i].Translations, t)
+		}
+	}
+	return items, rows.Err()
+}
+
+// imageFields returns every value in the bundle that can refer to an
+// uploaded image
+func (b *Bundle) imageFields() []string {
+	values := []string{}
+	for _, l := range b.Languages {
+		values = append(values, l.FlagURL)
+	}
+	for _, t := range b.EventTypes {
+		values = append(values, t.Icon)
+	}
+	for _, e := range b.Events {
+		values = append(values, e.ImageURL)
+		for _, t := range e.Translations {
+			values = append(values, t.Description)
+		}
+	}
+	for _, m := range b.Messages {
+		for _, t := range m.Translations {
+			values = append(values, t.Content)
+		}
+	}
+	for _, c := range b.StaticContent {
+		values = append(values, c.Content)
+	}
+	for _, s := range b.Socials {
+		values = append(values, s.Icon)
+	}
+	for _, m := range b.GoldenKeyMonths {
+		values = append(values, m.FinderImage)
+		for _, h := range m.Hints {
+			values = append(values, h.ImageURL, h.Content)
+		}
+	}
+	for _, item := range b.ShopItems {
+		values = append(values, item.ImageURL, item.Description)
+		for _, t := range item.Translations {
+			values = append(values, t.Description)
+		}
+	}
+	return values
+}
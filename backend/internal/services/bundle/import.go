@@ -0,0 +1,666 @@
+package bundle
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/media"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+)
+
+// Policy decides what happens to bundle rows that already exist
+type Policy string
+
+const (
+	PolicySkip      Policy = "skip"
+	PolicyOverwrite Policy = "overwrite"
+)
+
+// Actions reported per row
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionSkipped = "skipped"
+	ActionInvalid = "invalid"
+)
+
+var validStates = map[string]bool{"published": true, "draft": true, "archived": true}
+
+// Options control an import
+type Options struct {
+	Policy Policy
+	// DryRun imports into a transaction that is rolled back, so the report
+	// shows what would happen
+	DryRun bool
+	// Sections limits the import to these sections; nil imports all
+	Sections map[string]bool
+}
+
+// Report describes what an import did, or would do on a dry run
+type Report struct {
+	DryRun   bool                      `json:"dry_run"`
+	Policy   Policy                    `json:"policy"`
+	Sections map[string]*SectionReport `json:"sections"`
+	// IDMap maps the IDs in the bundle to the IDs in this database, per
+	// section
+	IDMap map[string]map[string]int64 `json:"id_map"`
+	// MissingImages are uploads the content refers to that are not in
+	// storage here; copy them over separately
+	MissingImages []string `json:"missing_images"`
+	Warnings      []string `json:"warnings"`
+}
+
+type SectionReport struct {
+	Created int          `json:"created"`
+	Updated int          `json:"updated"`
+	Skipped int          `json:"skipped"`
+	Invalid int          `json:"invalid"`
+	Items   []ItemResult `json:"items"`
+}
+
+type ItemResult struct {
+	// Key identifies the row in both environments
+	Key      string `json:"key"`
+	Action   string `json:"action"`
+	SourceID int64  `json:"source_id,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type importer struct {
+	tx        *sql.Tx
+	policy    Policy
+	report    *Report
+	languages map[string]bool
+}
+
+// Import writes a bundle into the database. Rows are matched on their key;
+// existing rows are left alone or overwritten according to the policy.
+// Everything happens in one transaction, so a failed import changes nothing.
+func Import(ctx context.Context, db *database.DB, store storage.Storage, b *Bundle, opts Options) (*Report, error) {
+	if b.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if opts.Policy != PolicySkip && opts.Policy != PolicyOverwrite {
+		return nil, fmt.Errorf("unknown conflict policy %q", opts.Policy)
+	}
+	selected := func(section string) bool {
+		return opts.Sections == nil || opts.Sections[section]
+	}
+
+	report := &Report{
+		DryRun:        opts.DryRun,
+		Policy:        opts.Policy,
+		Sections:      map[string]*SectionReport{},
+		IDMap:         map[string]map[string]int64{},
+		MissingImages: []string{},
+		Warnings:      []string{},
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	im := &importer{tx: tx, policy: opts.Policy, report: report}
+
+	steps := []struct {
+		section string
+		present bool
+		run     func() error
+	}{
+		{"languages", b.Languages != nil, func() error { return im.importLanguages(b.Languages) }},
+		{"event_types", b.EventTypes != nil, func() error { return im.importEventTypes(b.EventTypes) }},
+		{"events", b.Events != nil, func() error { return im.importEvents(b.Events) }},
+		{"messages", b.Messages != nil, func() error { return im.importMessages(b.Messages) }},
+		{"static_content", b.StaticContent != nil, func() error { return im.importStaticContent(b.StaticContent) }},
+		{"socials", b.Socials != nil, func() error { return im.importSocials(b.Socials) }},
+		{"golden_key", b.GoldenKeyMonths != nil, func() error { return im.importGoldenKeyMonths(b.GoldenKeyMonths) }},
+		{"shop_items", b.ShopItems != nil, func() error { return im.importShopItems(b.ShopItems) }},
+	}
+	for _, step := range steps {
+		if !step.present || !selected(step.section) {
+			continue
+		}
+		// Translations may use languages imported in an earlier step
+		if err := im.loadLanguages(); err != nil {
+			return nil, err
+		}
+		report.Sections[step.section] = &SectionReport{Items: []ItemResult{}}
+		if err := step.run(); err != nil {
+			return nil, fmt.Errorf("%s: %w", step.section, err)
+		}
+	}
+
+	if err := missingImages(ctx, store, b, report); err != nil {
+		report.Warnings = append(report.Warnings, "Could not check images: "+err.Error())
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// record adds a row result to the report of section
+func (im *importer) record(section string, result ItemResult) {
+	sr := im.report.Sections[section]
+	switch result.Action {
+	case ActionCreated:
+		sr.Created++
+	case ActionUpdated:
+		sr.Updated++
+	case ActionSkipped:
+		sr.Skipped++
+	case ActionInvalid:
+		sr.Invalid++
+	}
+	sr.Items = append(sr.Items, result)
+	if result.SourceID != 0 && result.ID != 0 {
+		if im.report.IDMap[section] == nil {
+			im.report.IDMap[section] = map[string]int64{}
+		}
+		im.report.IDMap[section][strconv.FormatInt(result.SourceID, 10)] = result.ID
+	}
+}
+
+// resolve picks the action for a row that may exist already
+func (im *importer) resolve(exists bool) string {
+	if !exists {
+		return ActionCreated
+	}
+	if im.policy == PolicyOverwrite {
+		return ActionUpdated
+	}
+	return ActionSkipped
+}
+
+func (im *importer) loadLanguages() error {
+	rows, err := im.tx.Query("SELECT code FROM languages")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	im.languages = map[string]bool{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return err
+		}
+		im.languages[code] = true
+	}
+	return rows.Err()
+}
+
+// knownLanguage reports whether a translation can be stored, warning
+// about the ones that are dropped
+func (im *importer) knownLanguage(langCode, owner string) bool {
+	if im.languages[langCode] {
+		return true
+	}
+	im.report.Warnings = append(im.report.Warnings,
+		fmt.Sprintf("%s: translation for unknown language %q left out", owner, langCode))
+	return false
+}
+
+func (im *importer) importLanguages(languages []Language) error {
+	for _, l := range languages {
+		l.Code = strings.ToUpper(strings.TrimSpace(l.Code))
+		if l.Code == "" || strings.TrimSpace(l.Name) == "" {
+			im.record("languages", ItemResult{Key: l.Code, Action: ActionInvalid, Message: "Code and name are required"})
+			continue
+		}
+		action := im.resolve(im.languages[l.Code])
+		var err error
+		switch action {
+		case ActionCreated:
+			_, err = im.tx.Exec("INSERT INTO languages (code, name, flag_url, active) VALUES (?, ?, ?, ?)",
+				l.Code, l.Name, l.FlagURL, l.Active)
+		case ActionUpdated:
+			_, err = im.tx.Exec(`
+				UPDATE languages SET name = ?, flag_url = ?, active = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE code = ?`, l.Name, l.FlagURL, l.Active, l.Code)
+		}
+		if err != nil {
+			return err
+		}
+		im.languages[l.Code] = true
+		im.record("languages", ItemResult{Key: l.Code, Action: action})
+	}
+	return nil
+}
+
+func (im *importer) importEventTypes(types []EventType) error {
+	for _, t := range types {
+		t.Code = strings.ToUpper(strings.TrimSpace(t.Code))
+		if t.Code == "" {
+			im.record("event_types", ItemResult{Key: t.Code, Action: ActionInvalid, Message: "Code is required"})
+			continue
+		}
+		var n int
+		if err := im.tx.QueryRow("SELECT COUNT(*) FROM event_types WHERE code = ?", t.Code).Scan(&n); err != nil {
+			return err
+		}
+		action := im.resolve(n > 0)
+		var err error
+		switch action {
+		case ActionCreated:
+			_, err = im.tx.Exec(`
+				INSERT INTO event_types (code, icon, gc_type_id, sort_order, active) VALUES (?, ?, ?, ?, ?)
+			`, t.Code, t.Icon, t.GCTypeID, t.SortOrder, t.Active)
+		case ActionUpdated:
+			_, err = im.tx.Exec(`
+				UPDATE event_types SET icon = ?, gc_type_id = ?, sort_order = ?, active = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE code = ?`, t.Icon, t.GCTypeID, t.SortOrder, t.Active, t.Code)
+		}
+		if err == nil && action != ActionSkipped {
+			_, err = im.tx.Exec("DELETE FROM event_type_translations WHERE type_code = ?", t.Code)
+		}
+		if err == nil && action != ActionSkipped {
+			for _, tr := range t.Translations {
+				if !im.knownLanguage(tr.LangCode, "Event type "+t.Code) || strings.TrimSpace(tr.Label) == "" {
+					continue
+				}
+				if _, err = im.tx.Exec("INSERT INTO event_type_translations (type_code, lang_code, label) VALUES (?, ?, ?)",
+					t.Code, tr.LangCode, tr.Label); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		im.record("event_types", ItemResult{Key: t.Code, Action: action})
+	}
+	return nil
+}
+
+func (im *importer) importEvents(events []Event) error {
+	for _, e := range events {
+		result := ItemResult{Key: e.UUID, SourceID: e.ID}
+		e.Title = strings.TrimSpace(e.Title)
+		e.Type = strings.ToUpper(strings.TrimSpace(e.Type))
+		if !validStates[e.State] {
+			e.State = "draft"
+		}
+		var typeCount int
+		if err := im.tx.QueryRow("SELECT COUNT(*) FROM event_types WHERE code = ?", e.Type).Scan(&typeCount); err != nil {
+			return err
+		}
+		switch {
+		case strings.TrimSpace(e.UUID) == "" || e.Title == "" || e.StartDate == "" || e.EndDate == "":
+			result.Message = "UUID, title and dates are required"
+		case typeCount == 0:
+			result.Message = "Unknown event type: " + e.Type
+		}
+		if result.Message != "" {
+			result.Action = ActionInvalid
+			im.record("events", result)
+			continue
+		}
+
+		var id int64
+		err := im.tx.QueryRow("SELECT id FROM events WHERE uuid = ?", e.UUID).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		result.Action = im.resolve(err == nil)
+		switch result.Action {
+		case ActionCreated:
+			res, err := im.tx.Exec(`
+				INSERT INTO events (uuid, state, on_home, title, geolink, type, location, start_date, end_date, image_url, ticket_url, gc_code)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, e.UUID, e.State, e.OnHome, e.Title, e.Geolink, e.Type, e.Location, e.StartDate, e.EndDate,
+				e.ImageURL, e.TicketURL, nullableString(e.GCCode))
+			if err != nil {
+				return err
+			}
+			id, _ = res.LastInsertId()
+		case ActionUpdated:
+			_, err := im.tx.Exec(`
+				UPDATE events SET state = ?, on_home = ?, title = ?, geolink = ?, type = ?, location = ?,
+				       start_date = ?, end_date = ?, image_url = ?, ticket_url = ?, gc_code = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, e.State, e.OnHome, e.Title, e.Geolink, e.Type, e.Location, e.StartDate, e.EndDate,
+				e.ImageURL, e.TicketURL, nullableString(e.GCCode), id)
+			if err != nil {
+				return err
+			}
+		}
+		if result.Action != ActionSkipped {
+			if _, err := im.tx.Exec("DELETE FROM event_translations WHERE event_id = ?", id); err != nil {
+				return err
+			}
+			for _, t := range e.Translations {
+				if !im.knownLanguage(t.LangCode, "Event "+e.Title) {
+					continue
+				}
+				if _, err := im.tx.Exec("INSERT INTO event_translations (event_id, lang_code, description) VALUES (?, ?, ?)",
+					id, t.LangCode, t.Description); err != nil {
+					return err
+				}
+			}
+		}
+		result.ID = id
+		im.record("events", result)
+	}
+	return nil
+}
+
+// findMessage returns the message sharing a translated title with m.
+// Messages have no key of their own, so their titles identify them.
+func (im *importer) findMessage(m Message) (int64, error) {
+	for _, t := range m.Translations {
+		if strings.TrimSpace(t.Title) == "" {
+			continue
+		}
+		var id int64
+		err := im.tx.QueryRow(`
+			SELECT message_id FROM message_translations WHERE lang_code = ? AND title = ?
+			ORDER BY message_id LIMIT 1`, t.LangCode, t.Title).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+// messageKey is the title a message is reported under
+func messageKey(m Message) string {
+	for _, t := range m.Translations {
+		if strings.TrimSpace(t.Title) != "" {
+			return t.Title
+		}
+	}
+	return ""
+}
+
+func (im *importer) importMessages(messages []Message) error {
+	for _, m := range messages {
+		result := ItemResult{Key: messageKey(m), SourceID: m.ID}
+		if result.Key == "" {
+			result.Action = ActionInvalid
+			result.Message = "A translated title is required"
+			im.record("messages", result)
+			continue
+		}
+		if !validStates[m.State] {
+			m.State = "draft"
+		}
+
+		id, err := im.findMessage(m)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		result.Action = im.resolve(err == nil)
+		switch result.Action {
+		case ActionCreated:
+			res, err := im.tx.Exec("INSERT INTO messages (state, priority) VALUES (?, ?)", m.State, m.Priority)
+			if err != nil {
+				return err
+			}
+			id, _ = res.LastInsertId()
+		case ActionUpdated:
+			if _, err := im.tx.Exec("UPDATE messages SET state = ?, priority = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+				m.State, m.Priority, id); err != nil {
+				return err
+			}
+		}
+		if result.Action != ActionSkipped {
+			if _, err := im.tx.Exec("DELETE FROM message_translations WHERE message_id = ?", id); err != nil {
+				return err
+			}
+			for _, t := range m.Translations {
+				if !im.knownLanguage(t.LangCode, "Message "+result.Key) {
+					continue
+				}
+				if _, err := im.tx.Exec("INSERT INTO message_translations (message_id, lang_code, title, content) VALUES (?, ?, ?, ?)",
+					id, t.LangCode, t.Title, t.Content); err != nil {
+					return err
+				}
+			}
+		}
+		result.ID = id
+		im.record("messages", result)
+	}
+	return nil
+}
+
+func (im *importer) importStaticContent(content []StaticContent) error {
+	for _, c := range content {
+		result := ItemResult{Key: c.Property + " (" + c.LangCode + ")"}
+		if strings.TrimSpace(c.Property) == "" {
+			result.Action = ActionInvalid
+			result.Message = "Property is required"
+			im.record("static_content", result)
+			continue
+		}
+		if !im.languages[c.LangCode] {
+			result.Action = ActionInvalid
+			result.Message = "Unknown language: " + c.LangCode
+			im.record("static_content", result)
+			continue
+		}
+
+		var n int
+		if err := im.tx.QueryRow("SELECT COUNT(*) FROM static_content WHERE property = ? AND lang_code = ?",
+			c.Property, c.LangCode).Scan(&n); err != nil {
+			return err
+		}
+		result.Action = im.resolve(n > 0)
+		var err error
+		switch result.Action {
+		case ActionCreated:
+			_, err = im.tx.Exec("INSERT INTO static_content (property, lang_code, content) VALUES (?, ?, ?)",
+				c.Property, c.LangCode, c.Content)
+		case ActionUpdated:
+			_, err = im.tx.Exec(`
+				UPDATE static_content SET content = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE property = ? AND lang_code = ?`, c.Content, c.Property, c.LangCode)
+		}
+		if err != nil {
+			return err
+		}
+		im.record("static_content", result)
+	}
+	return nil
+}
+
+func (im *importer) importSocials(socials []Social) error {
+	for _, s := range socials {
+		result := ItemResult{Key: s.Platform, SourceID: s.ID}
+		if strings.TrimSpace(s.Platform) == "" || strings.TrimSpace(s.URL) == "" {
+			result.Action = ActionInvalid
+			result.Message = "Platform and URL are required"
+			im.record("socials", result)
+			continue
+		}
+
+		var id int64
+		err := im.tx.QueryRow("SELECT id FROM socials WHERE LOWER(platform) = LOWER(?) ORDER BY id LIMIT 1", s.Platform).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		result.Action = im.resolve(err == nil)
+		switch result.Action {
+		case ActionCreated:
+			res, err := im.tx.Exec("INSERT INTO socials (platform, url, icon, active, sort_order) VALUES (?, ?, ?, ?, ?)",
+				s.Platform, s.URL, s.Icon, s.Active, s.SortOrder)
+			if err != nil {
+				return err
+			}
+			id, _ = res.LastInsertId()
+		case ActionUpdated:
+			if _, err := im.tx.Exec(`
+				UPDATE socials SET platform = ?, url = ?, icon = ?, active = ?, sort_order = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?`, s.Platform, s.URL, s.Icon, s.Active, s.SortOrder, id); err != nil {
+				return err
+			}
+		}
+		result.ID = id
+		im.record("socials", result)
+	}
+	return nil
+}
+
+func (im *importer) importGoldenKeyMonths(months []GoldenKeyMonth) error {
+	for _, m := range months {
+		result := ItemResult{Key: strconv.Itoa(m.MonthNumber), SourceID: m.ID}
+		if m.MonthNumber < 1 || strings.TrimSpace(m.MonthName) == "" || m.LiveDate == "" {
+			result.Action = ActionInvalid
+			result.Message = "Month number, name and live date are required"
+			im.record("golden_key", result)
+			continue
+		}
+
+		var id int64
+		err := im.tx.QueryRow("SELECT id FROM golden_key_months WHERE month_number = ?", m.MonthNumber).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		result.Action = im.resolve(err == nil)
+		switch result.Action {
+		case ActionCreated:
+			res, err := im.tx.Exec(`
+				INSERT INTO golden_key_months (month_number, month_name, live_date, is_found, finder_name, finder_image, found_date)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, m.MonthNumber, m.MonthName, m.LiveDate, m.IsFound, nullableString(m.FinderName),
+				nullableString(m.FinderImage), nullableString(m.FoundDate))
+			if err != nil {
+				return err
+			}
+			id, _ = res.LastInsertId()
+		case ActionUpdated:
+			if _, err := im.tx.Exec(`
+				UPDATE golden_key_months SET month_name = ?, live_date = ?, is_found = ?, finder_name = ?,
+				       finder_image = ?, found_date = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, m.MonthName, m.LiveDate, m.IsFound, nullableString(m.FinderName),
+				nullableString(m.FinderImage), nullableString(m.FoundDate), id); err != nil {
+				return err
+			}
+		}
+		if result.Action != ActionSkipped {
+			if _, err := im.tx.Exec("DELETE FROM golden_key_hints WHERE month_id = ?", id); err != nil {
+				return err
+			}
+			for _, h := range m.Hints {
+				if _, err := im.tx.Exec("INSERT INTO golden_key_hints (month_id, sort_order, content, image_url) VALUES (?, ?, ?, ?)",
+					id, h.SortOrder, h.Content, nullableString(h.ImageURL)); err != nil {
+					return err
+				}
+			}
+		}
+		result.ID = id
+		im.record("golden_key", result)
+	}
+	return nil
+}
+
+func (im *importer) importShopItems(items []ShopItem) error {
+	for _, item := range items {
+		item.Title = strings.TrimSpace(item.Title)
+		result := ItemResult{Key: item.Title, SourceID: item.ID}
+		if item.Title == "" {
+			result.Action = ActionInvalid
+			result.Message = "Title is required"
+			im.record("shop_items", result)
+			continue
+		}
+
+		var id int64
+		err := im.tx.QueryRow("SELECT id FROM shop_items WHERE title = ? ORDER BY id LIMIT 1", item.Title).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		result.Action = im.resolve(err == nil)
+		switch result.Action {
+		case ActionCreated:
+			res, err := im.tx.Exec(`
+				INSERT INTO shop_items (title, description, price_cents, member_price_cents, image_url, stock_quantity,
+				                        allow_pickup, pickup_label, allow_shipping, shipping_regions, auto_confirm, active, sort_order)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, item.Title, item.Description, item.PriceCents, item.MemberPriceCents, item.ImageURL, item.StockQuantity,
+				item.AllowPickup, item.PickupLabel, item.AllowShipping, item.ShippingRegions, item.AutoConfirm,
+				item.Active, item.SortOrder)
+			if err != nil {
+				return err
+			}
+			id, _ = res.LastInsertId()
+		case ActionUpdated:
+			// The stock is counted down by orders here, so it is kept
+			if _, err := im.tx.Exec(`
+				UPDATE shop_items SET description = ?, price_cents = ?, member_price_cents = ?, image_url = ?,
+				       allow_pickup = ?, pickup_label = ?, allow_shipping = ?, shipping_regions = ?, auto_confirm = ?,
+				       active = ?, sort_order = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, item.Description, item.PriceCents, item.MemberPriceCents, item.ImageURL, item.AllowPickup,
+				item.PickupLabel, item.AllowShipping, item.ShippingRegions, item.AutoConfirm, item.Active,
+				item.SortOrder, id); err != nil {
+				return err
+			}
+		}
+		if result.Action != ActionSkipped {
+			if _, err := im.tx.Exec("DELETE FROM shop_item_translations WHERE item_id = ?", id); err != nil {
+				return err
+			}
+			for _, t := range item.Translations {
+				if !im.knownLanguage(t.LangCode, "Shop item "+item.Title) {
+					continue
+				}
+				if _, err := im.tx.Exec("INSERT INTO shop_item_translations (item_id, lang_code, description) VALUES (?, ?, ?)",
+					id, t.LangCode, t.Description); err != nil {
+					return err
+				}
+			}
+		}
+		result.ID = id
+		im.record("shop_items", result)
+	}
+	return nil
+}
+
+// missingImages lists the uploads the bundle refers to that are not in
+// this environment's storage
+func missingImages(ctx context.Context, store storage.Storage, b *Bundle, report *Report) error {
+	objects, err := store.List(ctx, "images/")
+	if err != nil {
+		return err
+	}
+	stored := map[string]bool{}
+	for _, o := range objects {
+		stored[media.Key(path.Base(o.Key))] = true
+	}
+	missing := map[string]bool{}
+	for _, value := range b.imageFields() {
+		for key, name := range media.References(value) {
+			if !stored[key] {
+				missing[name] = true
+			}
+		}
+	}
+	for name := range missing {
+		report.MissingImages = append(report.MissingImages, name)
+	}
+	sort.Strings(report.MissingImages)
+	return nil
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
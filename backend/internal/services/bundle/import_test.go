@@ -0,0 +1,178 @@
+package bundle
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+)
+
+func TestMain(m *testing.M) {
+	// Migrations log a lot
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if err := db.SeedDefaults(); err != nil {
+		t.Fatalf("SeedDefaults: %v", err)
+	}
+	return db
+}
+
+func exec(t *testing.T, db *database.DB, query string, args ...interface{}) int64 {
+	t.Helper()
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func importBundle(t *testing.T, db *database.DB, b *Bundle, opts Options) (*Report, error) {
+	t.Helper()
+	return Import(context.Background(), db, storage.NewLocal(t.TempDir()), b, opts)
+}
+
+// testBundle holds one row that exists in the test database and one that
+// does not, for both sections
+func testBundle() *Bundle {
+	return &Bundle{
+		Version: FormatVersion,
+		StaticContent: []StaticContent{
+			{Property: "BundleTest", LangCode: "NL", Content: "nieuw"},
+			{Property: "BundleTest", LangCode: "EN", Content: "new"},
+		},
+		Socials: []Social{
+			{ID: 7, Platform: "Mastodon", URL: "https://new.example", Active: true},
+			{ID: 8, Platform: "Bluesky", URL: "https://bsky.example", Active: true},
+		},
+	}
+}
+
+func staticContent(t *testing.T, db *database.DB, property, langCode string) string {
+	t.Helper()
+	var content string
+	db.QueryRow("SELECT content FROM static_content WHERE property = ? AND lang_code = ?", property, langCode).Scan(&content)
+	return content
+}
+
+func socialURL(t *testing.T, db *database.DB, platform string) string {
+	t.Helper()
+	var url string
+	db.QueryRow("SELECT url FROM socials WHERE platform = ?", platform).Scan(&url)
+	return url
+}
+
+func TestImportPolicies(t *testing.T) {
+	tests := []struct {
+		policy     Policy
+		wantAction string
+		wantNL     string
+		wantURL    string
+	}{
+		{PolicySkip, ActionSkipped, "oud", "https://old.example"},
+		{PolicyOverwrite, ActionUpdated, "nieuw", "https://new.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			db := newTestDB(t)
+			exec(t, db, "INSERT INTO static_content (property, lang_code, content) VALUES ('BundleTest', 'NL', 'oud')")
+			socialID := exec(t, db, "INSERT INTO socials (platform, url, icon, active) VALUES ('Mastodon', 'https://old.example', '', 1)")
+
+			report, err := importBundle(t, db, testBundle(), Options{Policy: tt.policy})
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+
+			for _, section := range []string{"static_content", "socials"} {
+				items := report.Sections[section].Items
+				if len(items) != 2 || items[0].Action != tt.wantAction || items[1].Action != ActionCreated {
+					t.Errorf("%s items = %+v, want %s and %s", section, items, tt.wantAction, ActionCreated)
+				}
+			}
+			if got := staticContent(t, db, "BundleTest", "NL"); got != tt.wantNL {
+				t.Errorf("existing content = %q, want %q", got, tt.wantNL)
+			}
+			if got := staticContent(t, db, "BundleTest", "EN"); got != "new" {
+				t.Errorf("new content = %q, want %q", got, "new")
+			}
+			if got := socialURL(t, db, "Mastodon"); got != tt.wantURL {
+				t.Errorf("existing social URL = %q, want %q", got, tt.wantURL)
+			}
+			// A skipped row still maps to the row it matched
+			if got := report.IDMap["socials"]["7"]; got != socialID {
+				t.Errorf("social 7 maps to %d, want %d", got, socialID)
+			}
+		})
+	}
+}
+
+func TestImportUnknownPolicy(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := importBundle(t, db, testBundle(), Options{Policy: "merge"}); err == nil {
+		t.Error("Import with an unknown policy succeeded")
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	db := newTestDB(t)
+
+	report, err := importBundle(t, db, testBundle(), Options{Policy: PolicyOverwrite, DryRun: true})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !report.DryRun || report.Sections["static_content"].Created != 2 || report.Sections["socials"].Created != 2 {
+		t.Errorf("report = %+v, want a dry run creating 2 rows per section", report)
+	}
+
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM static_content WHERE property = 'BundleTest'").Scan(&n)
+	if n != 0 {
+		t.Errorf("dry run wrote %d static content rows", n)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM socials").Scan(&n)
+	if n != 0 {
+		t.Errorf("dry run wrote %d socials", n)
+	}
+}
+
+func TestImportRollsBackOnFailure(t *testing.T) {
+	db := newTestDB(t)
+	// The second social can't be stored, after the static content and the
+	// first social were written
+	exec(t, db, `
+		CREATE TRIGGER fail_bluesky BEFORE INSERT ON socials WHEN NEW.platform = 'Bluesky'
+		BEGIN SELECT RAISE(ABORT, 'broken row'); END
+	`)
+
+	if _, err := importBundle(t, db, testBundle(), Options{Policy: PolicyOverwrite}); err == nil {
+		t.Fatal("Import succeeded with a failing row")
+	}
+
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM static_content WHERE property = 'BundleTest'").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d static content rows left after a failed import", n)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM socials").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d socials left after a failed import", n)
+	}
+}
@@ -0,0 +1,104 @@
+// Package catalog reads and writes translation catalogs in the formats of
+// translation tools: gettext PO, XLIFF 1.2 and CSV. A catalog holds one
+// target language; every entry has the key, the source text to translate
+// from and its translation.
+package catalog
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// Format is a catalog file format
+type Format string
+
+const (
+	FormatPO    Format = "po"
+	FormatXLIFF Format = "xliff"
+	FormatCSV   Format = "csv"
+)
+
+// ErrInvalidFile is returned for files that can't be read as the format
+var ErrInvalidFile = errors.New("invalid catalog file")
+
+// Catalog is the content of a catalog file
+type Catalog struct {
+	SourceLang string
+	TargetLang string
+	Entries    []Entry
+}
+
+type Entry struct {
+	Key    string
+	Source string
+	Target string
+	// Fuzzy marks a translation the translator flagged as needing review
+	Fuzzy bool
+}
+
+// ParseFormat accepts the format names and file extensions
+func ParseFormat(name string) (Format, bool) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "po", "pot":
+		return FormatPO, true
+	case "xliff", "xlf":
+		return FormatXLIFF, true
+	case "csv":
+		return FormatCSV, true
+	}
+	return "", false
+}
+
+// FormatOf guesses the format from a file name
+func FormatOf(filename string) (Format, bool) {
+	return ParseFormat(path.Ext(filename))
+}
+
+// MarksReview reports whether entries of the format say if they still need
+// review: the fuzzy flag of PO and the target state of XLIFF. CSV has no
+// such flag.
+func (f Format) MarksReview() bool {
+	return f == FormatPO || f == FormatXLIFF
+}
+
+func (f Format) Extension() string {
+	if f == FormatXLIFF {
+		return "xlf"
+	}
+	return string(f)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatPO:
+		return "text/x-gettext-translation; charset=utf-8"
+	case FormatXLIFF:
+		return "application/x-xliff+xml; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Encode writes c in format f
+func Encode(w io.Writer, f Format, c *Catalog) error {
+	switch f {
+	case FormatPO:
+		return encodePO(w, c)
+	case FormatXLIFF:
+		return encodeXLIFF(w, c)
+	}
+	return encodeCSV(w, c)
+}
+
+// Decode reads a catalog in format f. Languages the file doesn't name are
+// left empty.
+func Decode(r io.Reader, f Format) (*Catalog, error) {
+	switch f {
+	case FormatPO:
+		return decodePO(r)
+	case FormatXLIFF:
+		return decodeXLIFF(r)
+	}
+	return decodeCSV(r)
+}
@@ -0,0 +1,78 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSV catalogs have a header row "key,NL,FR": the key, the source language
+// and the target language. When reading, the last column is the
+// translation and a second of three or more columns the source text.
+
+func encodeCSV(w io.Writer, c *Catalog) error {
+	// BOM so Excel detects UTF-8
+	if _, err := io.WriteString(w, "\xef\xbb\xbf"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"key", strings.ToUpper(c.SourceLang), strings.ToUpper(c.TargetLang)})
+	for _, e := range c.Entries {
+		cw.Write([]string{e.Key, e.Source, e.Target})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func decodeCSV(r io.Reader) (*Catalog, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	if len(records) == 0 || len(records[0]) < 2 {
+		return nil, fmt.Errorf("%w: expected a header row with a key and a translation column", ErrInvalidFile)
+	}
+
+	header := records[0]
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	target := len(header) - 1
+	c := &Catalog{TargetLang: headerLanguage(header[target])}
+	source := -1
+	if len(header) > 2 {
+		source = 1
+		c.SourceLang = headerLanguage(header[source])
+	}
+
+	for _, record := range records[1:] {
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		e := Entry{Key: strings.TrimSpace(record[0])}
+		if target < len(record) {
+			e.Target = record[target]
+		}
+		if source >= 0 && source < len(record) {
+			e.Source = record[source]
+		}
+		c.Entries = append(c.Entries, e)
+	}
+	return c, nil
+}
+
+// headerLanguage reads a two letter language code column name; other
+// names, such as "translation", name no language
+func headerLanguage(name string) string {
+	code := languageCode(name)
+	if len(code) != 2 {
+		return ""
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return ""
+		}
+	}
+	return code
+}
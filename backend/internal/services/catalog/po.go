@@ -0,0 +1,199 @@
+package catalog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PO files key their entries with msgctxt, so msgid can hold the source
+// text translators see in their tools
+
+func encodePO(w io.Writer, c *Catalog) error {
+	bw := bufio.NewWriter(w)
+	header := fmt.Sprintf("Language: %s\nX-Source-Language: %s\nMIME-Version: 1.0\n"+
+		"Content-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\nPO-Revision-Date: %s\n",
+		strings.ToLower(c.TargetLang), strings.ToLower(c.SourceLang), time.Now().Format("2006-01-02 15:04-0700"))
+	writePOString(bw, "msgid", "")
+	writePOString(bw, "msgstr", header)
+
+	for _, e := range c.Entries {
+		bw.WriteString("\n")
+		if e.Fuzzy {
+			bw.WriteString("#, fuzzy\n")
+		}
+		writePOString(bw, "msgctxt", e.Key)
+		writePOString(bw, "msgid", e.Source)
+		writePOString(bw, "msgstr", e.Target)
+	}
+	return bw.Flush()
+}
+
+// writePOString writes a keyword and its string, split after each newline
+// as gettext does
+func writePOString(w *bufio.Writer, keyword, s string) {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= 1 {
+		fmt.Fprintf(w, "%s %s\n", keyword, quotePO(s))
+		return
+	}
+	fmt.Fprintf(w, "%s \"\"\n", keyword)
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s\n", quotePO(line))
+	}
+}
+
+func quotePO(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func unquotePO(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("expected a quoted string")
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s)-1 {
+			return "", fmt.Errorf("unfinished escape")
+		}
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func decodePO(r io.Reader) (*Catalog, error) {
+	c := &Catalog{}
+	var (
+		fields  map[string]*string
+		current *string
+		fuzzy   bool
+	)
+	flush := func() {
+		defer func() { fields, current, fuzzy = nil, nil, false }()
+		if fields == nil || fields["msgid"] == nil {
+			return
+		}
+		ctxt, id, str := fields["msgctxt"], *fields["msgid"], ""
+		if fields["msgstr"] != nil {
+			str = *fields["msgstr"]
+		}
+		if ctxt == nil && id == "" {
+			c.parseHeader(str)
+			return
+		}
+		key := id
+		if ctxt != nil {
+			key = *ctxt
+		}
+		c.Entries = append(c.Entries, Entry{Key: key, Source: id, Target: str, Fuzzy: fuzzy})
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+			// A comment after the strings starts the next entry
+			if current != nil {
+				flush()
+			}
+			if strings.HasPrefix(line, "#,") && strings.Contains(line, "fuzzy") {
+				fuzzy = true
+			}
+		case strings.HasPrefix(line, `"`):
+			if current == nil {
+				return nil, fmt.Errorf("%w: line %d: string without keyword", ErrInvalidFile, n)
+			}
+			s, err := unquotePO(line)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, n, err)
+			}
+			*current += s
+		default:
+			keyword, rest, _ := strings.Cut(line, " ")
+			if keyword == "msgstr[0]" {
+				keyword = "msgstr"
+			}
+			// Entries without a blank line between them
+			if (keyword == "msgctxt" || keyword == "msgid") && fields != nil && fields["msgstr"] != nil {
+				flush()
+			}
+			s, err := unquotePO(strings.TrimSpace(rest))
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, n, err)
+			}
+			if fields == nil {
+				fields = map[string]*string{}
+			}
+			switch keyword {
+			case "msgctxt", "msgid", "msgstr":
+				fields[keyword] = &s
+				current = fields[keyword]
+			case "msgid_plural":
+				current = new(string)
+			default:
+				if !strings.HasPrefix(keyword, "msgstr[") {
+					return nil, fmt.Errorf("%w: line %d: unknown keyword %s", ErrInvalidFile, n, keyword)
+				}
+				// Other plural forms are not used
+				current = new(string)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	flush()
+	return c, nil
+}
+
+// parseHeader takes the languages from the PO header entry
+func (c *Catalog) parseHeader(header string) {
+	for _, line := range strings.Split(header, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "Language":
+			c.TargetLang = languageCode(value)
+		case "X-Source-Language":
+			c.SourceLang = languageCode(value)
+		}
+	}
+}
+
+// languageCode turns "fr_BE" or "fr-BE" into "FR"
+func languageCode(value string) string {
+	value = strings.TrimSpace(value)
+	if i := strings.IndexAny(value, "_-@."); i >= 0 {
+		value = value[:i]
+	}
+	return strings.ToUpper(value)
+}
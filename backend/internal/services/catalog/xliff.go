@@ -0,0 +1,98 @@
+package catalog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const xliffNamespace = "urn:oasis:names:tc:xliff:document:1.2"
+
+// XLIFF 1.2, as read by most translation tools. Decoding ignores the
+// namespace, so files without one are accepted too.
+type xliffDocument struct {
+	XMLName xml.Name    `xml:"xliff"`
+	Xmlns   string      `xml:"xmlns,attr,omitempty"`
+	Version string      `xml:"version,attr"`
+	Files   []xliffFile `xml:"file"`
+}
+
+type xliffFile struct {
+	Original       string           `xml:"original,attr"`
+	SourceLanguage string           `xml:"source-language,attr"`
+	TargetLanguage string           `xml:"target-language,attr,omitempty"`
+	Datatype       string           `xml:"datatype,attr"`
+	Units          []xliffTransUnit `xml:"body>trans-unit"`
+}
+
+type xliffTransUnit struct {
+	ID     string       `xml:"id,attr"`
+	Source string       `xml:"source"`
+	Target *xliffTarget `xml:"target"`
+}
+
+type xliffTarget struct {
+	State string `xml:"state,attr,omitempty"`
+	Text  string `xml:",chardata"`
+}
+
+func encodeXLIFF(w io.Writer, c *Catalog) error {
+	file := xliffFile{
+		Original:       "static_content",
+		SourceLanguage: strings.ToLower(c.SourceLang),
+		TargetLanguage: strings.ToLower(c.TargetLang),
+		Datatype:       "plaintext",
+		Units:          make([]xliffTransUnit, 0, len(c.Entries)),
+	}
+	for _, e := range c.Entries {
+		target := &xliffTarget{Text: e.Target, State: "translated"}
+		switch {
+		case e.Target == "":
+			target.State = "needs-translation"
+		case e.Fuzzy:
+			target.State = "needs-review-translation"
+		}
+		file.Units = append(file.Units, xliffTransUnit{ID: e.Key, Source: e.Source, Target: target})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(xliffDocument{Xmlns: xliffNamespace, Version: "1.2", Files: []xliffFile{file}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func decodeXLIFF(r io.Reader) (*Catalog, error) {
+	var doc xliffDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	if doc.Version != "" && !strings.HasPrefix(doc.Version, "1.") {
+		return nil, fmt.Errorf("%w: XLIFF %s is not supported, use 1.2", ErrInvalidFile, doc.Version)
+	}
+
+	c := &Catalog{}
+	for _, file := range doc.Files {
+		if c.SourceLang == "" {
+			c.SourceLang = languageCode(file.SourceLanguage)
+		}
+		if c.TargetLang == "" {
+			c.TargetLang = languageCode(file.TargetLanguage)
+		}
+		for _, unit := range file.Units {
+			e := Entry{Key: unit.ID, Source: unit.Source}
+			if unit.Target != nil {
+				e.Target = unit.Target.Text
+				e.Fuzzy = strings.HasPrefix(unit.Target.State, "needs-")
+			}
+			c.Entries = append(c.Entries, e)
+		}
+	}
+	return c, nil
+}
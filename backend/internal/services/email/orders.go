@@ -0,0 +1,425 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"strings"
+	texttemplate "text/template"
+
+	"gopkg.in/gomail.v2"
+)
+
+// OrderEmail holds everything the buyer and admin order mails display
+type OrderEmail struct {
+	OrderID            int64
+	LangCode           string
+	BuyerEmail         string
+	ItemTitle          string
+	Quantity           int
+	AmountDisplay      string
+	FulfillmentType    string
+	PickupLabel        string
+	PickupTime         string
+	PickupQRURL        string
+	ShippingName       string
+	ShippingAddress    string
+	ShippingCity       string
+	ShippingPostalCode string
+	ShippingCountry    string
+	Carrier            string
+	TrackingNumber     string
+	TrackingURL        string
+	StatusURL          string
+	InvoiceURL         string
+	Attachments        []Attachment
+}
+
+// Attachment is a file sent along with a mail
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// orderMailKind selects the strings and template block for a buyer mail
+type orderMailKind string
+
+const (
+	orderMailConfirmation orderMailKind = "confirmation"
+	orderMailReady        orderMailKind = "ready"
+	orderMailShipped      orderMailKind = "shipped"
+	orderMailRefunded     orderMailKind = "refunded"
+)
+
+// defaultMailLang is used when the buyer's language has no translations
+const defaultMailLang = "NL"
+
+// orderMailStrings holds the translated copy for buyer mails, keyed by
+// language and then by "<kind>.<part>" or a shared label.
+var orderMailStrings = map[string]map[string]string{
+	"NL": {
+		"confirmation.subject": "Bevestiging van je bestelling #%d",
+		"confirmation.intro":   "Bedankt voor je bestelling! We hebben je betaling goed ontvangen.",
+		"ready.subject":        "Je bestelling #%d ligt klaar",
+		"ready.intro":          "Goed nieuws: je bestelling ligt klaar om opgehaald te worden.",
+		"shipped.subject":      "Je bestelling #%d is verzonden",
+		"shipped.intro":        "Je bestelling is onderweg.",
+		"refunded.subject":     "Terugbetaling van bestelling #%d",
+		"refunded.intro":       "Je bestelling werd terugbetaald. Het bedrag staat binnen enkele werkdagen terug op je rekening.",
+		"greeting":             "Hallo,",
+		"order":                "Bestelling",
+		"item":                 "Artikel",
+		"quantity":             "Aantal",
+		"total":                "Totaal",
+		"pickup":               "Ophalen",
+		"pickup_time":          "Tijdstip",
+		"pickup_qr":            "Toon deze QR-code bij het ophalen",
+		"shipping":             "Verzendadres",
+		"tracking":             "Track & trace",
+		"invoice":              "Download je factuur",
+		"status":               "Bekijk de status van je bestelling",
+		"footer":               "Vragen? Antwoord gerust op deze e-mail of gebruik het contactformulier op onze website.",
+		"lookup.subject":       "Je bestellingen bij Geocaching Brughia",
+		"lookup.intro":         "Je vroeg een overzicht van je bestellingen aan. Via onderstaande link bekijk je ze allemaal.",
+		"lookup.link":          "Bekijk je bestellingen",
+		"lookup.expiry":        "Deze link is 24 uur geldig. Heb je dit niet aangevraagd? Dan mag je deze e-mail negeren.",
+	},
+	"EN": {
+		"confirmation.subject": "Confirmation of your order #%d",
+		"confirmation.intro":   "Thank you for your order! We have received your payment.",
+		"ready.subject":        "Your order #%d is ready",
+		"ready.intro":          "Good news: your order is ready to be picked up.",
+		"shipped.subject":      "Your order #%d has been shipped",
+		"shipped.intro":        "Your order is on its way.",
+		"refunded.subject":     "Refund for order #%d",
+		"refunded.intro":       "Your order has been refunded. The amount will be back on your account within a few business days.",
+		"greeting":             "Hello,",
+		"order":                "Order",
+		"item":                 "Item",
+		"quantity":             "Quantity",
+		"total":                "Total",
+		"pickup":               "Pickup",
+		"pickup_time":          "Time",
+		"pickup_qr":            "Show this QR code when picking up your order",
+		"shipping":             "Shipping address",
+		"tracking":             "Tracking",
+		"invoice":              "Download your invoice",
+		"status":               "View your order status",
+		"footer":               "Questions? Simply reply to this email or use the contact form on our website.",
+		"lookup.subject":       "Your orders at Geocaching Brughia",
+		"lookup.intro":         "You requested an overview of your orders. Use the link below to see all of them.",
+		"lookup.link":          "View your orders",
+		"lookup.expiry":        "This link is valid for 24 hours. Didn't request this? You can safely ignore this email.",
+	},
+	"FR": {
+		"confirmation.subject": "Confirmation de votre commande n°%d",
+		"confirmation.intro":   "Merci pour votre commande ! Nous avons bien reçu votre paiement.",
+		"ready.subject":        "Votre commande n°%d est prête",
+		"ready.intro":          "Bonne nouvelle : votre commande est prête à être retirée.",
+		"shipped.subject":      "Votre commande n°%d a été expédiée",
+		"shipped.intro":        "Votre commande est en route.",
+		"refunded.subject":     "Remboursement de la commande n°%d",
+		"refunded.intro":       "Votre commande a été remboursée. Le montant sera de retour sur votre compte d'ici quelques jours ouvrables.",
+		"greeting":             "Bonjour,",
+		"order":                "Commande",
+		"item":                 "Article",
+		"quantity":             "Quantité",
+		"total":                "Total",
+		"pickup":               "Retrait",
+		"pickup_time":          "Horaire",
+		"pickup_qr":            "Présentez ce QR code lors du retrait",
+		"shipping":             "Adresse de livraison",
+		"tracking":             "Suivi",
+		"invoice":              "Télécharger votre facture",
+		"status":               "Voir le statut de votre commande",
+		"footer":               "Des questions ? Répondez simplement à cet e-mail ou utilisez le formulaire de contact sur notre site.",
+		"lookup.subject":       "Vos commandes chez Geocaching Brughia",
+		"lookup.intro":         "Vous avez demandé un aperçu de vos commandes. Le lien ci-dessous vous permet de toutes les consulter.",
+		"lookup.link":          "Voir vos commandes",
+		"lookup.expiry":        "Ce lien est valable 24 heures. Vous n'avez rien demandé ? Vous pouvez ignorer cet e-mail.",
+	},
+	"DE": {
+		"confirmation.subject": "Bestätigung Ihrer Bestellung #%d",
+		"confirmation.intro":   "Vielen Dank für Ihre Bestellung! Wir haben Ihre Zahlung erhalten.",
+		"ready.subject":        "Ihre Bestellung #%d liegt bereit",
+		"ready.intro":          "Gute Nachricht: Ihre Bestellung liegt zur Abholung bereit.",
+		"shipped.subject":      "Ihre Bestellung #%d wurde versandt",
+		"shipped.intro":        "Ihre Bestellung ist unterwegs.",
+		"refunded.subject":     "Rückerstattung der Bestellung #%d",
+		"refunded.intro":       "Ihre Bestellung wurde erstattet. Der Betrag ist in einigen Werktagen wieder auf Ihrem Konto.",
+		"greeting":             "Hallo,",
+		"order":                "Bestellung",
+		"item":                 "Artikel",
+		"quantity":             "Menge",
+		"total":                "Gesamt",
+		"pickup":               "Abholung",
+		"pickup_time":          "Zeitfenster",
+		"pickup_qr":            "Zeigen Sie diesen QR-Code bei der Abholung vor",
+		"shipping":             "Lieferadresse",
+		"tracking":             "Sendungsverfolgung",
+		"invoice":              "Rechnung herunterladen",
+		"status":               "Status Ihrer Bestellung ansehen",
+		"footer":               "Fragen? Antworten Sie einfach auf diese E-Mail oder nutzen Sie das Kontaktformular auf unserer Website.",
+		"lookup.subject":       "Ihre Bestellungen bei Geocaching Brughia",
+		"lookup.intro":         "Sie haben eine Übersicht Ihrer Bestellungen angefordert. Über den folgenden Link sehen Sie alle Bestellungen.",
+		"lookup.link":          "Bestellungen ansehen",
+		"lookup.expiry":        "Dieser Link ist 24 Stunden gültig. Nicht angefordert? Dann können Sie diese E-Mail ignorieren.",
+	},
+}
+
+// orderMailText returns the translation for key, falling back to the default language
+func orderMailText(lang, key string) string {
+	if strs, ok := orderMailStrings[strings.ToUpper(lang)]; ok {
+		if s, ok := strs[key]; ok {
+			return s
+		}
+	}
+	return orderMailStrings[defaultMailLang][key]
+}
+
+type orderMailData struct {
+	OrderEmail
+	Kind orderMailKind
+	T    func(key string) string
+}
+
+var orderMailHTML = htmltemplate.Must(htmltemplate.New("order").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #2c5530;">Geocaching Brughia</h1>
+
+    <p>{{call .T "greeting"}}</p>
+    <p>{{call .T (printf "%s.intro" .Kind)}}</p>
+
+    <table style="background: #f8f9fa; padding: 15px; margin: 20px 0; border-left: 4px solid #28a745;">
+        <tr><td><strong>{{call .T "order"}}:</strong></td><td>#{{.OrderID}}</td></tr>
+        <tr><td><strong>{{call .T "item"}}:</strong></td><td>{{.ItemTitle}}</td></tr>
+        <tr><td><strong>{{call .T "quantity"}}:</strong></td><td>{{.Quantity}}</td></tr>
+        <tr><td><strong>{{call .T "total"}}:</strong></td><td>{{.AmountDisplay}}</td></tr>
+        {{- if eq .FulfillmentType "pickup"}}
+        <tr><td><strong>{{call .T "pickup"}}:</strong></td><td>{{.PickupLabel}}</td></tr>
+        {{- if .PickupTime}}
+        <tr><td><strong>{{call .T "pickup_time"}}:</strong></td><td>{{.PickupTime}}</td></tr>
+        {{- end}}
+        {{- else}}
+        <tr><td style="vertical-align: top;"><strong>{{call .T "shipping"}}:</strong></td><td>{{.ShippingName}}<br>{{.ShippingAddress}}<br>{{.ShippingPostalCode}} {{.ShippingCity}}<br>{{.ShippingCountry}}</td></tr>
+        {{- end}}
+        {{- if .TrackingNumber}}
+        <tr><td><strong>{{call .T "tracking"}}:</strong></td><td>{{if .Carrier}}{{.Carrier}} {{end}}{{if .TrackingURL}}<a href="{{.TrackingURL}}" style="color: #2c5530;">{{.TrackingNumber}}</a>{{else}}{{.TrackingNumber}}{{end}}</td></tr>
+        {{- end}}
+    </table>
+    {{- if and .PickupQRURL (or (eq .Kind "confirmation") (eq .Kind "ready"))}}
+
+    <p>{{call .T "pickup_qr"}}</p>
+    <p><img src="{{.PickupQRURL}}" alt="QR" width="192" height="192"></p>
+    {{- end}}
+    {{- if .StatusURL}}
+
+    <p><a href="{{.StatusURL}}" style="color: #2c5530;">{{call .T "status"}}</a></p>
+    {{- end}}
+    {{- if .InvoiceURL}}
+
+    <p><a href="{{.InvoiceURL}}" style="color: #2c5530;">{{call .T "invoice"}}</a></p>
+    {{- end}}
+
+    <hr style="border: none; border-top: 1px solid #eee; margin: 30px 0;">
+    <p style="font-size: 12px; color: #666;">{{call .T "footer"}}</p>
+</body>
+</html>
+`))
+
+var orderMailPlain = texttemplate.Must(texttemplate.New("order").Parse(`{{call .T "greeting"}}
+
+{{call .T (printf "%s.intro" .Kind)}}
+
+{{call .T "order"}}: #{{.OrderID}}
+{{call .T "item"}}: {{.ItemTitle}}
+{{call .T "quantity"}}: {{.Quantity}}
+{{call .T "total"}}: {{.AmountDisplay}}
+{{if eq .FulfillmentType "pickup"}}{{call .T "pickup"}}: {{.PickupLabel}}
+{{if .PickupTime}}{{call .T "pickup_time"}}: {{.PickupTime}}
+{{end}}{{else}}{{call .T "shipping"}}:
+{{.ShippingName}}
+{{.ShippingAddress}}
+{{.ShippingPostalCode}} {{.ShippingCity}}
+{{.ShippingCountry}}
+{{end}}{{if .TrackingNumber}}{{call .T "tracking"}}: {{if .Carrier}}{{.Carrier}} {{end}}{{.TrackingNumber}}
+{{if .TrackingURL}}{{.TrackingURL}}
+{{end}}{{end}}{{if .StatusURL}}
+{{call .T "status"}}: {{.StatusURL}}
+{{end}}{{if .InvoiceURL}}
+{{call .T "invoice"}}: {{.InvoiceURL}}
+{{end}}
+--
+{{call .T "footer"}}
+`))
+
+// SendOrderConfirmation mails the buyer after a successful payment
+func (s *Service) SendOrderConfirmation(o OrderEmail) {
+	s.sendOrderMail(orderMailConfirmation, o)
+}
+
+// SendOrderReadyForPickup tells the buyer the order can be collected
+func (s *Service) SendOrderReadyForPickup(o OrderEmail) {
+	s.sendOrderMail(orderMailReady, o)
+}
+
+// SendOrderShipped tells the buyer the order was handed to the carrier
+func (s *Service) SendOrderShipped(o OrderEmail) {
+	s.sendOrderMail(orderMailShipped, o)
+}
+
+// SendOrderRefunded tells the buyer the order was refunded
+func (s *Service) SendOrderRefunded(o OrderEmail) {
+	s.sendOrderMail(orderMailRefunded, o)
+}
+
+func (s *Service) sendOrderMail(kind orderMailKind, o OrderEmail) {
+	if s.dialer == nil {
+		log.Printf("Email not configured, skipping %s mail for order #%d", kind, o.OrderID)
+		return
+	}
+
+	data := orderMailData{
+		OrderEmail: o,
+		Kind:       kind,
+		T:          func(key string) string { return orderMailText(o.LangCode, key) },
+	}
+
+	var htmlBody, plainBody bytes.Buffer
+	if err := orderMailHTML.Execute(&htmlBody, data); err != nil {
+		log.Printf("Failed to render %s mail for order #%d: %v", kind, o.OrderID, err)
+		return
+	}
+	if err := orderMailPlain.Execute(&plainBody, data); err != nil {
+		log.Printf("Failed to render %s mail for order #%d: %v", kind, o.OrderID, err)
+		return
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.cfg.From)
+	m.SetHeader("To", o.BuyerEmail)
+	if s.cfg.NotificationEmail != "" {
+		m.SetHeader("Reply-To", s.cfg.NotificationEmail)
+	}
+	m.SetHeader("Subject", fmt.Sprintf(orderMailText(o.LangCode, string(kind)+".subject"), o.OrderID))
+	m.SetBody("text/plain", plainBody.String())
+	m.AddAlternative("text/html", htmlBody.String())
+	for _, a := range o.Attachments {
+		data := a.Data
+		m.Attach(a.Filename,
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}),
+			gomail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}),
+		)
+	}
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		log.Printf("Failed to send %s mail for order #%d: %v", kind, o.OrderID, err)
+	} else {
+		log.Printf("Order %s mail sent for order #%d", kind, o.OrderID)
+	}
+}
+
+// SendNewOrderNotification tells the admins a new order has been paid
+func (s *Service) SendNewOrderNotification(o OrderEmail) {
+	if s.dialer == nil || s.cfg.NotificationEmail == "" {
+		log.Printf("Email not configured, skipping new order notification for order #%d", o.OrderID)
+		return
+	}
+
+	fulfillment := "Ophalen: " + o.PickupLabel
+	if o.PickupTime != "" {
+		fulfillment += " (" + o.PickupTime + ")"
+	}
+	if o.FulfillmentType == "shipping" {
+		fulfillment = fmt.Sprintf("Verzenden naar: %s, %s, %s %s, %s",
+			o.ShippingName, o.ShippingAddress, o.ShippingPostalCode, o.ShippingCity, o.ShippingCountry)
+	}
+
+	plainBody := fmt.Sprintf(`NIEUWE BESTELLING BETAALD
+
+Bestelling: #%d
+Koper: %s
+Artikel: %s
+Aantal: %d
+Totaal: %s
+%s
+`, o.OrderID, o.BuyerEmail, o.ItemTitle, o.Quantity, o.AmountDisplay, fulfillment)
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.cfg.From)
+	m.SetHeader("To", s.cfg.NotificationEmail)
+	m.SetHeader("Subject", fmt.Sprintf("[Nieuwe Bestelling] #%d - %s (x%d)", o.OrderID, o.ItemTitle, o.Quantity))
+	m.SetBody("text/plain", plainBody)
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		log.Printf("Failed to send new order notification for order #%d: %v", o.OrderID, err)
+	} else {
+		log.Printf("New order notification sent for order #%d", o.OrderID)
+	}
+}
+
+var lookupMailHTML = htmltemplate.Must(htmltemplate.New("lookup").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #2c5530;">Geocaching Brughia</h1>
+
+    <p>{{call .T "greeting"}}</p>
+    <p>{{call .T "lookup.intro"}}</p>
+
+    <p style="margin: 30px 0;">
+        <a href="{{.URL}}" style="background: #28a745; color: white; padding: 12px 24px; text-decoration: none; border-radius: 5px;">{{call .T "lookup.link"}}</a>
+    </p>
+
+    <p style="font-size: 12px; color: #666;">{{call .T "lookup.expiry"}}</p>
+</body>
+</html>
+`))
+
+// SendOrderLookup mails a buyer a time-limited link listing all their orders
+func (s *Service) SendOrderLookup(toEmail, lang, url string) {
+	if s.dialer == nil {
+		log.Printf("Email not configured, skipping order lookup mail")
+		return
+	}
+
+	t := func(key string) string { return orderMailText(lang, key) }
+	var htmlBody bytes.Buffer
+	if err := lookupMailHTML.Execute(&htmlBody, struct {
+		URL string
+		T   func(key string) string
+	}{url, t}); err != nil {
+		log.Printf("Failed to render order lookup mail: %v", err)
+		return
+	}
+	plainBody := fmt.Sprintf("%s\n\n%s\n\n%s: %s\n\n%s\n",
+		t("greeting"), t("lookup.intro"), t("lookup.link"), url, t("lookup.expiry"))
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.cfg.From)
+	m.SetHeader("To", toEmail)
+	if s.cfg.NotificationEmail != "" {
+		m.SetHeader("Reply-To", s.cfg.NotificationEmail)
+	}
+	m.SetHeader("Subject", t("lookup.subject"))
+	m.SetBody("text/plain", plainBody)
+	m.AddAlternative("text/html", htmlBody.String())
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		log.Printf("Failed to send order lookup mail: %v", err)
+	} else {
+		log.Printf("Order lookup mail sent")
+	}
+}
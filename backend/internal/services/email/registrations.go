@@ -0,0 +1,269 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"strings"
+	texttemplate "text/template"
+
+	"gopkg.in/gomail.v2"
+)
+
+// RegistrationEmail holds what the event registration mails display
+type RegistrationEmail struct {
+	RegistrationID int64
+	LangCode       string
+	Email          string
+	Name           string
+	EventTitle     string
+	EventDate      string
+	EventLocation  string
+	AmountDisplay  string // empty for free registrations
+	Refunded       bool
+	Tickets        []TicketEmail
+	StatusURL      string
+}
+
+// TicketEmail is one attendee's ticket
+type TicketEmail struct {
+	Name       string
+	TicketType string
+	QRURL      string
+}
+
+// registrationMailKind selects the strings and template blocks of a registration mail
+type registrationMailKind string
+
+const (
+	registrationMailConfirmed  registrationMailKind = "confirmed"
+	registrationMailWaitlisted registrationMailKind = "waitlisted"
+	registrationMailOffered    registrationMailKind = "offered"
+	registrationMailCancelled  registrationMailKind = "cancelled"
+)
+
+// registrationMailStrings extends orderMailStrings with the registration
+// copy; shared labels such as "greeting" and "footer" come from there.
+var registrationMailStrings = map[string]map[string]string{
+	"NL": {
+		"confirmed.subject":  "Je tickets voor %s",
+		"confirmed.intro":    "Je inschrijving is bevestigd. Hieronder vind je de tickets, toon ze aan de ingang.",
+		"waitlisted.subject": "Je staat op de wachtlijst voor %s",
+		"waitlisted.intro":   "Het evenement is volzet, maar je staat op de wachtlijst. Komt er een plaats vrij, dan laten we het je meteen weten.",
+		"offered.subject":    "Er is een plaats vrijgekomen voor %s",
+		"offered.intro":      "Goed nieuws: er is plaats vrijgekomen. Rond je inschrijving binnen 24 uur af, daarna gaat de plaats naar de volgende op de wachtlijst.",
+		"offered.link":       "Inschrijving afronden",
+		"cancelled.subject":  "Je inschrijving voor %s is geannuleerd",
+		"cancelled.intro":    "Je inschrijving werd geannuleerd.",
+		"cancelled.refund":   "Het betaalde bedrag wordt teruggestort en staat binnen enkele werkdagen terug op je rekening.",
+		"event":              "Evenement",
+		"date":               "Datum",
+		"location":           "Locatie",
+		"attendees":          "Deelnemers",
+		"ticket":             "Ticket",
+		"status":             "Bekijk je inschrijving",
+	},
+	"EN": {
+		"confirmed.subject":  "Your tickets for %s",
+		"confirmed.intro":    "Your registration is confirmed. Your tickets are below, please show them at the entrance.",
+		"waitlisted.subject": "You are on the waiting list for %s",
+		"waitlisted.intro":   "The event is full, but you are on the waiting list. We will let you know as soon as a spot opens up.",
+		"offered.subject":    "A spot opened up for %s",
+		"offered.intro":      "Good news: a spot has opened up. Please complete your registration within 24 hours, after that the spot goes to the next person on the waiting list.",
+		"offered.link":       "Complete registration",
+		"cancelled.subject":  "Your registration for %s has been cancelled",
+		"cancelled.intro":    "Your registration has been cancelled.",
+		"cancelled.refund":   "The amount you paid is being refunded and will be back on your account within a few business days.",
+		"event":              "Event",
+		"date":               "Date",
+		"location":           "Location",
+		"attendees":          "Attendees",
+		"ticket":             "Ticket",
+		"status":             "View your registration",
+	},
+	"FR": {
+		"confirmed.subject":  "Vos billets pour %s",
+		"confirmed.intro":    "Votre inscription est confirmée. Vous trouverez vos billets ci-dessous, présentez-les à l'entrée.",
+		"waitlisted.subject": "Vous êtes sur la liste d'attente pour %s",
+		"waitlisted.intro":   "L'événement est complet, mais vous êtes sur la liste d'attente. Nous vous préviendrons dès qu'une place se libère.",
+		"offered.subject":    "Une place s'est libérée pour %s",
+		"offered.intro":      "Bonne nouvelle : une place s'est libérée. Finalisez votre inscription dans les 24 heures, ensuite la place revient à la personne suivante sur la liste d'attente.",
+		"offered.link":       "Finaliser l'inscription",
+		"cancelled.subject":  "Votre inscription pour %s a été annulée",
+		"cancelled.intro":    "Votre inscription a été annulée.",
+		"cancelled.refund":   "Le montant payé vous est remboursé et sera de retour sur votre compte d'ici quelques jours ouvrables.",
+		"event":              "Événement",
+		"date":               "Date",
+		"location":           "Lieu",
+		"attendees":          "Participants",
+		"ticket":             "Billet",
+		"status":             "Voir votre inscription",
+	},
+	"DE": {
+		"confirmed.subject":  "Ihre Tickets für %s",
+		"confirmed.intro":    "Ihre Anmeldung ist bestätigt. Unten finden Sie Ihre Tickets, bitte zeigen Sie diese am Eingang vor.",
+		"waitlisted.subject": "Sie stehen auf der Warteliste für %s",
+		"waitlisted.intro":   "Die Veranstaltung ist ausgebucht, aber Sie stehen auf der Warteliste. Wir melden uns, sobald ein Platz frei wird.",
+		"offered.subject":    "Ein Platz ist frei geworden für %s",
+		"offered.intro":      "Gute Nachricht: Ein Platz ist frei geworden. Bitte schließen Sie Ihre Anmeldung innerhalb von 24 Stunden ab, danach geht der Platz an die nächste Person auf der Warteliste.",
+		"offered.link":       "Anmeldung abschließen",
+		"cancelled.subject":  "Ihre Anmeldung für %s wurde storniert",
+		"cancelled.intro":    "Ihre Anmeldung wurde storniert.",
+		"cancelled.refund":   "Der bezahlte Betrag wird erstattet und ist in einigen Werktagen wieder auf Ihrem Konto.",
+		"event":              "Veranstaltung",
+		"date":               "Datum",
+		"location":           "Ort",
+		"attendees":          "Teilnehmer",
+		"ticket":             "Ticket",
+		"status":             "Anmeldung ansehen",
+	},
+}
+
+// registrationMailText returns the translation for key, falling back to the
+// default language and then to the shared order mail strings
+func registrationMailText(lang, key string) string {
+	if strs, ok := registrationMailStrings[strings.ToUpper(lang)]; ok {
+		if s, ok := strs[key]; ok {
+			return s
+		}
+	}
+	if s, ok := registrationMailStrings[defaultMailLang][key]; ok {
+		return s
+	}
+	return orderMailText(lang, key)
+}
+
+type registrationMailData struct {
+	RegistrationEmail
+	Kind registrationMailKind
+	T    func(key string) string
+}
+
+var registrationMailHTML = htmltemplate.Must(htmltemplate.New("registration").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #2c5530;">Geocaching Brughia</h1>
+
+    <p>{{call .T "greeting"}}</p>
+    <p>{{call .T (printf "%s.intro" .Kind)}}{{if and (eq .Kind "cancelled") .Refunded}} {{call .T "cancelled.refund"}}{{end}}</p>
+
+    <table style="background: #f8f9fa; padding: 15px; margin: 20px 0; border-left: 4px solid #28a745;">
+        <tr><td><strong>{{call .T "event"}}:</strong></td><td>{{.EventTitle}}</td></tr>
+        {{- if .EventDate}}
+        <tr><td><strong>{{call .T "date"}}:</strong></td><td>{{.EventDate}}</td></tr>
+        {{- end}}
+        {{- if .EventLocation}}
+        <tr><td><strong>{{call .T "location"}}:</strong></td><td>{{.EventLocation}}</td></tr>
+        {{- end}}
+        <tr><td style="vertical-align: top;"><strong>{{call .T "attendees"}}:</strong></td><td>{{range $i, $t := .Tickets}}{{if $i}}<br>{{end}}{{$t.Name}}{{if $t.TicketType}} ({{$t.TicketType}}){{end}}{{end}}</td></tr>
+        {{- if .AmountDisplay}}
+        <tr><td><strong>{{call .T "total"}}:</strong></td><td>{{.AmountDisplay}}</td></tr>
+        {{- end}}
+    </table>
+    {{- if eq .Kind "confirmed"}}
+    {{- range .Tickets}}
+
+    <div style="border: 1px dashed #2c5530; padding: 15px; margin: 15px 0; text-align: center;">
+        <p style="margin: 0;"><strong>{{call $.T "ticket"}}: {{.Name}}</strong>{{if .TicketType}}<br>{{.TicketType}}{{end}}</p>
+        <img src="{{.QRURL}}" alt="QR" width="192" height="192">
+    </div>
+    {{- end}}
+    {{- end}}
+    {{- if and (eq .Kind "offered") .StatusURL}}
+
+    <p style="margin: 30px 0;">
+        <a href="{{.StatusURL}}" style="background: #28a745; color: white; padding: 12px 24px; text-decoration: none; border-radius: 5px;">{{call .T "offered.link"}}</a>
+    </p>
+    {{- else if .StatusURL}}
+
+    <p><a href="{{.StatusURL}}" style="color: #2c5530;">{{call .T "status"}}</a></p>
+    {{- end}}
+
+    <hr style="border: none; border-top: 1px solid #eee; margin: 30px 0;">
+    <p style="font-size: 12px; color: #666;">{{call .T "footer"}}</p>
+</body>
+</html>
+`))
+
+var registrationMailPlain = texttemplate.Must(texttemplate.New("registration").Parse(`{{call .T "greeting"}}
+
+{{call .T (printf "%s.intro" .Kind)}}{{if and (eq .Kind "cancelled") .Refunded}} {{call .T "cancelled.refund"}}{{end}}
+
+{{call .T "event"}}: {{.EventTitle}}
+{{if .EventDate}}{{call .T "date"}}: {{.EventDate}}
+{{end}}{{if .EventLocation}}{{call .T "location"}}: {{.EventLocation}}
+{{end}}{{if .AmountDisplay}}{{call .T "total"}}: {{.AmountDisplay}}
+{{end}}
+{{call .T "attendees"}}:
+{{range .Tickets}}- {{.Name}}{{if .TicketType}} ({{.TicketType}}){{end}}
+{{end}}{{if eq .Kind "confirmed"}}
+{{range .Tickets}}{{call $.T "ticket"}} {{.Name}}: {{.QRURL}}
+{{end}}{{end}}{{if .StatusURL}}
+{{if eq .Kind "offered"}}{{call .T "offered.link"}}{{else}}{{call .T "status"}}{{end}}: {{.StatusURL}}
+{{end}}
+--
+{{call .T "footer"}}
+`))
+
+// SendRegistrationConfirmed mails the tickets of a confirmed registration
+func (s *Service) SendRegistrationConfirmed(r RegistrationEmail) {
+	s.sendRegistrationMail(registrationMailConfirmed, r)
+}
+
+// SendRegistrationWaitlisted tells the registrant the event is full
+func (s *Service) SendRegistrationWaitlisted(r RegistrationEmail) {
+	s.sendRegistrationMail(registrationMailWaitlisted, r)
+}
+
+// SendRegistrationOffered tells a waitlisted registrant a paid spot is held for them
+func (s *Service) SendRegistrationOffered(r RegistrationEmail) {
+	s.sendRegistrationMail(registrationMailOffered, r)
+}
+
+// SendRegistrationCancelled tells the registrant the registration was cancelled
+func (s *Service) SendRegistrationCancelled(r RegistrationEmail) {
+	s.sendRegistrationMail(registrationMailCancelled, r)
+}
+
+func (s *Service) sendRegistrationMail(kind registrationMailKind, r RegistrationEmail) {
+	if s.dialer == nil {
+		log.Printf("Email not configured, skipping %s mail for registration #%d", kind, r.RegistrationID)
+		return
+	}
+
+	data := registrationMailData{
+		RegistrationEmail: r,
+		Kind:              kind,
+		T:                 func(key string) string { return registrationMailText(r.LangCode, key) },
+	}
+
+	var htmlBody, plainBody bytes.Buffer
+	if err := registrationMailHTML.Execute(&htmlBody, data); err != nil {
+		log.Printf("Failed to render %s mail for registration #%d: %v", kind, r.RegistrationID, err)
+		return
+	}
+	if err := registrationMailPlain.Execute(&plainBody, data); err != nil {
+		log.Printf("Failed to render %s mail for registration #%d: %v", kind, r.RegistrationID, err)
+		return
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.cfg.From)
+	m.SetHeader("To", r.Email)
+	if s.cfg.NotificationEmail != "" {
+		m.SetHeader("Reply-To", s.cfg.NotificationEmail)
+	}
+	m.SetHeader("Subject", fmt.Sprintf(registrationMailText(r.LangCode, string(kind)+".subject"), r.EventTitle))
+	m.SetBody("text/plain", plainBody.String())
+	m.AddAlternative("text/html", htmlBody.String())
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		log.Printf("Failed to send %s mail for registration #%d: %v", kind, r.RegistrationID, err)
+	} else {
+		log.Printf("Registration %s mail sent for registration #%d", kind, r.RegistrationID)
+	}
+}
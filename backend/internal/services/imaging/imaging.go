@@ -0,0 +1,270 @@
+// Package imaging prepares uploaded images for the web: it strips metadata
+// (EXIF with GPS positions, XMP, comments), applies the EXIF orientation,
+// limits the stored size and renders smaller widths, plus lossless WebP
+// variants of PNG and WebP images.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Widths are the variant widths rendered for every image wider than them
+var Widths = []int{320, 640, 1024, 1600}
+
+const (
+	// MaxWidth is the width originals are scaled down to
+	MaxWidth = 2560
+	// MaxPixels rejects images that would take too much memory to decode
+	MaxPixels = 50_000_000
+
+	jpegQuality = 85
+)
+
+var (
+	ErrTooLarge = errors.New("imaging: image has too many pixels")
+	ErrInvalid  = errors.New("imaging: invalid or corrupt image")
+)
+
+// Variant is a rendered size or format of an image
+type Variant struct {
+	// Width is 0 for a full-size variant
+	Width int
+	Ext   string
+	Data  []byte
+}
+
+// Result is a processed upload
+type Result struct {
+	Original []byte
+	Variants []Variant
+	// Width and Height are the dimensions of the stored original
+	Width  int
+	Height int
+}
+
+// Process strips the metadata of an uploaded image and renders its
+// variants. ext is the lower-case extension including the dot.
+//
+// JPEG and PNG originals above MaxWidth are scaled down; smaller widths get
+// a variant in the same format. Lossless WebP variants are only added for
+// PNG and WebP sources and only when smaller than their fallback, since
+// they lose against JPEG for photos: JPEG uploads never get WebP variants.
+// WebP originals get JPEG (or PNG when transparent) variants. GIFs are
+// neither scaled nor given variants, since they may be animated; only
+// their comments and XMP are removed.
+func Process(data []byte, ext string) (*Result, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if ext == ".gif" {
+		original, ok := StripGIFMetadata(data)
+		if !ok {
+			// Re-encoding keeps the frames and the looping but nothing else
+			g, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				return nil, ErrInvalid
+			}
+			var buf bytes.Buffer
+			if err := gif.EncodeAll(&buf, g); err != nil {
+				return nil, ErrInvalid
+			}
+			original = buf.Bytes()
+		}
+		return &Result{Original: original, Width: cfg.Width, Height: cfg.Height}, nil
+	}
+
+	img, err := decode(data, ext)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	result := &Result{}
+	width := img.Bounds().Dx()
+
+	// Metadata is stripped from the file as uploaded where possible, so
+	// originals are not recompressed without need
+	var stripped bool
+	switch ext {
+	case ".webp":
+		// There is no lossy WebP encoder, so WebP originals keep their size
+		result.Original, stripped = StripWebPMetadata(data)
+	case ".jpg", ".jpeg":
+		if width <= MaxWidth && jpegOrientation(data) == 1 {
+			result.Original, stripped = StripJPEGMetadata(data)
+		}
+	case ".png":
+		if width <= MaxWidth {
+			result.Original, stripped = StripPNGMetadata(data)
+		}
+	}
+	if !stripped {
+		if width > MaxWidth && ext != ".webp" {
+			img = Resize(img, MaxWidth)
+			width = MaxWidth
+		}
+		if result.Original, err = encode(img, ext); err != nil {
+			return nil, err
+		}
+	}
+	result.Width = width
+	result.Height = img.Bounds().Dy()
+	if ext == ".png" {
+		if v, ok := smallerWebP(img, result.Original); ok {
+			result.Variants = append(result.Variants, Variant{Ext: ".webp", Data: v})
+		}
+	}
+
+	fallbackExt := ext
+	if ext == ".webp" {
+		fallbackExt = ".jpg"
+		if !opaque(img) {
+			fallbackExt = ".png"
+		}
+	}
+
+	for _, w := range Widths {
+		if w >= width {
+			break
+		}
+		scaled := Resize(img, w)
+		fallback, err := encode(scaled, fallbackExt)
+		if err != nil {
+			return nil, err
+		}
+		result.Variants = append(result.Variants, Variant{Width: w, Ext: fallbackExt, Data: fallback})
+		if ext == ".png" || ext == ".webp" {
+			if v, ok := smallerWebP(scaled, fallback); ok {
+				result.Variants = append(result.Variants, Variant{Width: w, Ext: ".webp", Data: v})
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func decode(data []byte, ext string) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch ext {
+	case ".jpg", ".jpeg":
+		img, err := jpeg.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return orient(img, jpegOrientation(data)), nil
+	case ".png":
+		return png.Decode(r)
+	case ".webp":
+		return webp.Decode(r)
+	}
+	return nil, ErrInvalid
+}
+
+func encode(img image.Image, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch ext {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case ".png":
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	case ".webp":
+		err = EncodeWebP(&buf, img)
+	default:
+		err = ErrInvalid
+	}
+	return buf.Bytes(), err
+}
+
+func smallerWebP(img image.Image, than []byte) ([]byte, bool) {
+	data, err := encode(img, ".webp")
+	if err != nil || len(data) >= len(than) {
+		return nil, false
+	}
+	return data, true
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// Resize scales img to width, keeping the aspect ratio
+func Resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orient turns an image the way its EXIF orientation (1-8) says
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+
+	// Orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+	return dst
+}
+
+// VariantName is the file name of a variant of filename; width 0 is the
+// full-size variant
+func VariantName(filename string, width int, ext string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if width == 0 {
+		return base + ext
+	}
+	return base + "_w" + strconv.Itoa(width) + ext
+}
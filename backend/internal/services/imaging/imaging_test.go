@@ -0,0 +1,80 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestProcessJPEG(t *testing.T) {
+	// A portrait photo stored sideways, as phones do
+	data := withJPEGSegments(encodeJPEG(t, testImage(800, 600, false)), jpegSegment(0xe1, exifWithGPS(6)))
+	if got := jpegOrientation(data); got != 6 {
+		t.Fatalf("jpegOrientation = %d, want 6", got)
+	}
+
+	result, err := Process(data, ".jpg")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	assertNoMetadata(t, result.Original)
+	if result.Width != 600 || result.Height != 800 {
+		t.Errorf("size = %dx%d, want 600x800", result.Width, result.Height)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(result.Original))
+	if err != nil || cfg.Width != 600 || cfg.Height != 800 {
+		t.Errorf("stored original is %dx%d (%v), want 600x800", cfg.Width, cfg.Height, err)
+	}
+	// Photos get JPEG variants only
+	widths := []int{}
+	for _, v := range result.Variants {
+		if v.Ext != ".jpg" {
+			t.Errorf("variant %d%s of a JPEG", v.Width, v.Ext)
+		}
+		assertNoMetadata(t, v.Data)
+		widths = append(widths, v.Width)
+	}
+	if len(widths) != 1 || widths[0] != 320 {
+		t.Errorf("variant widths = %v, want [320]", widths)
+	}
+}
+
+func TestProcessPNG(t *testing.T) {
+	// A screenshot-like image: flat colours compress far better as WebP
+	img := image.NewNRGBA(image.Rect(0, 0, 3000, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 3000; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x / 300 * 25), 80, uint8(y / 100 * 60), 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+
+	result, err := Process(buf.Bytes(), ".png")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if result.Width != MaxWidth || result.Height != 341 {
+		t.Errorf("size = %dx%d, want %dx341", result.Width, result.Height, MaxWidth)
+	}
+	variants := map[string]bool{}
+	for _, v := range result.Variants {
+		name := VariantName("a.png", v.Width, v.Ext)
+		variants[name] = true
+		if v.Ext == ".webp" {
+			if _, err := webp.Decode(bytes.NewReader(v.Data)); err != nil {
+				t.Errorf("%s does not decode: %v", name, err)
+			}
+		}
+	}
+	for _, name := range []string{"a.webp", "a_w320.png", "a_w320.webp", "a_w640.png", "a_w1024.png", "a_w1600.png", "a_w1600.webp"} {
+		if !variants[name] {
+			t.Errorf("no variant %s in %v", name, variants)
+		}
+	}
+}
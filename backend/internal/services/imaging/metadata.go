@@ -0,0 +1,290 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// The Strip functions remove metadata without re-encoding the image. They
+// report false when the file cannot be walked safely; Process then
+// re-encodes the decoded pixels instead, which drops all metadata too.
+
+// StripJPEGMetadata drops APP segments other than JFIF, ICC profiles and
+// the Adobe colour transform, comments and anything after the image (such
+// as multi-picture thumbnails)
+func StripJPEGMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, false
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+
+	for i := 2; i+1 < len(data); {
+		if data[i] != 0xff {
+			return nil, false
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff: // fill byte
+			i++
+			continue
+		case marker == 0xd9: // end of image
+			return append(out, 0xff, 0xd9), true
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, false
+		}
+		if keepJPEGSegment(marker, data[i+4:end]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+
+		if marker == 0xda {
+			// Entropy-coded data runs until the next marker other than
+			// stuffed bytes and restart markers
+			j := i
+			for j+1 < len(data) && !(data[j] == 0xff && data[j+1] != 0 && (data[j+1] < 0xd0 || data[j+1] > 0xd7)) {
+				j++
+			}
+			out = append(out, data[i:j]...)
+			i = j
+		}
+	}
+	return nil, false
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xfe: // comment
+		return false
+	case marker == 0xe0: // JFIF
+		return true
+	case marker == 0xe2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xee: // Adobe
+		return true
+	case marker >= 0xe1 && marker <= 0xef: // EXIF, XMP, IPTC and others
+		return false
+	}
+	return true
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, 1 when unknown
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return 1
+		}
+		payload := data[i+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return exifOrientation(payload[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads tag 0x0112 from the first IFD of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		p := ifd + 2 + e*12
+		if p+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[p:]) == 0x0112 && order.Uint16(tiff[p+2:]) == 3 {
+			if v := int(order.Uint16(tiff[p+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are text, EXIF and timestamp chunks
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true,
+}
+
+// StripPNGMetadata drops text, EXIF and timestamp chunks
+func StripPNGMetadata(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, false
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, false
+		}
+		chunkType := string(data[i+4 : i+8])
+		if !pngMetadataChunks[chunkType] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if chunkType == "IEND" {
+			return out, true
+		}
+	}
+	return nil, false
+}
+
+// StripWebPMetadata drops the EXIF and XMP chunks of an extended WebP and
+// clears their flags
+func StripWebPMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, false
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size&1
+		if size < 0 || end > len(data) {
+			// The padding byte of the last chunk is sometimes missing
+			if end == len(data)+1 {
+				end = len(data)
+			} else {
+				return nil, false
+			}
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size >= 1 {
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, true
+}
+
+// StripGIFMetadata drops comment extensions and XMP application extensions.
+// Frames, palettes, timing and the looping extension are kept as they are.
+func StripGIFMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, false
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, false
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	// subBlocks returns the end of the data sub-blocks starting at j
+	subBlocks := func(j int) int {
+		for j < len(data) {
+			size := int(data[j])
+			j += 1 + size
+			if size == 0 {
+				return j
+			}
+		}
+		return -1
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3b: // trailer
+			return append(out, 0x3b), true
+		case 0x2c: // image descriptor
+			if i+10 > len(data) {
+				return nil, false
+			}
+			j := i + 10
+			if data[i+9]&0x80 != 0 {
+				j += 3 << (data[i+9]&0x07 + 1)
+			}
+			// LZW minimum code size, then the image data
+			end := -1
+			if j < len(data) {
+				end = subBlocks(j + 1)
+			}
+			if end < 0 || end > len(data) {
+				return nil, false
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, false
+			}
+			end := subBlocks(i + 2)
+			if end < 0 || end > len(data) {
+				return nil, false
+			}
+			label, payload := data[i+1], data[i+2:end]
+			isXMP := label == 0xff && len(payload) >= 12 && payload[0] == 11 && string(payload[1:12]) == "XMP DataXMP"
+			if label != 0xfe && !isXMP {
+				out = append(out, data[i:end]...)
+			}
+			i = end
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,294 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// gpsPosition is the text of the GPS latitude in the EXIF fixtures; it must
+// not survive stripping
+const gpsPosition = "51.2093N"
+
+// exifWithGPS returns an EXIF APP1 payload with an orientation and a GPS
+// IFD holding the latitude
+func exifWithGPS(orientation int) []byte {
+	be := binary.BigEndian
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	// IFD0: orientation and the GPS IFD pointer
+	tiff = be.AppendUint16(tiff, 2)
+	tiff = be.AppendUint16(tiff, 0x0112)
+	tiff = be.AppendUint16(tiff, 3)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint16(tiff, uint16(orientation))
+	tiff = be.AppendUint16(tiff, 0)
+	tiff = be.AppendUint16(tiff, 0x8825)
+	tiff = be.AppendUint16(tiff, 4)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint32(tiff, 8+2+2*12+4)
+	tiff = be.AppendUint32(tiff, 0)
+	// GPS IFD: the latitude as text, stored after the IFD
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = be.AppendUint16(tiff, 0x0002)
+	tiff = be.AppendUint16(tiff, 2)
+	tiff = be.AppendUint32(tiff, uint32(len(gpsPosition)+1))
+	tiff = be.AppendUint32(tiff, uint32(len(tiff)+4+4))
+	tiff = be.AppendUint32(tiff, 0)
+	tiff = append(tiff, gpsPosition+"\x00"...)
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+const xmpPacket = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description exif:GPSLatitude="` + gpsPosition + `"/></rdf:RDF></x:xmpmeta>`
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	return append([]byte{0xff, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+}
+
+// testImage returns a w×h gradient; with alpha it is partly transparent
+func testImage(w, h int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint8(255)
+			if alpha {
+				a = uint8(x * 255 / w)
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 13), uint8(x*y + 40), a})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withJPEGSegments inserts segments right after the start of image
+func withJPEGSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+func assertNoMetadata(t *testing.T, data []byte) {
+	t.Helper()
+	for _, needle := range []string{gpsPosition, "Exif\x00\x00", "xmpmeta", "a comment"} {
+		if bytes.Contains(data, []byte(needle)) {
+			t.Errorf("stripped file still contains %q", needle)
+		}
+	}
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	base := encodeJPEG(t, testImage(40, 30, false))
+	icc := jpegSegment(0xe2, []byte("ICC_PROFILE\x00\x01\x01profile"))
+	data := withJPEGSegments(base,
+		jpegSegment(0xe1, exifWithGPS(1)),
+		jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00"+xmpPacket)),
+		icc,
+		jpegSegment(0xfe, []byte("a comment")),
+		jpegSegment(0xed, []byte("Photoshop 3.0\x008BIM")),
+	)
+
+	stripped, ok := StripJPEGMetadata(data)
+	if !ok {
+		t.Fatal("StripJPEGMetadata failed")
+	}
+	assertNoMetadata(t, stripped)
+	// Only the metadata goes: the colour profile and the scan are untouched
+	if want := withJPEGSegments(base, icc); !bytes.Equal(stripped, want) {
+		t.Errorf("stripped JPEG is %d bytes, want the %d bytes of the original with its profile", len(stripped), len(want))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(40, 30, true))
+	base := buf.Bytes()
+	// Metadata chunks go after IHDR, which is 25 bytes long
+	ihdrEnd := len(pngSignature) + 25
+	data := append([]byte{}, base[:ihdrEnd]...)
+	data = append(data, pngChunk("eXIf", exifWithGPS(1)[6:])...)
+	data = append(data, pngChunk("tEXt", []byte("Comment\x00a comment"))...)
+	data = append(data, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmpPacket))...)
+	data = append(data, pngChunk("tIME", []byte{0x07, 0xea, 1, 2, 3, 4, 5})...)
+	data = append(data, base[ihdrEnd:]...)
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("fixture does not decode: %v", err)
+	}
+
+	stripped, ok := StripPNGMetadata(data)
+	if !ok {
+		t.Fatal("StripPNGMetadata failed")
+	}
+	assertNoMetadata(t, stripped)
+	if !bytes.Equal(stripped, base) {
+		t.Errorf("stripped PNG is %d bytes, want the %d bytes of the original", len(stripped), len(base))
+	}
+}
+
+func riffChunk(chunkType string, data []byte) []byte {
+	chunk := append([]byte(chunkType), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	img := testImage(40, 30, true)
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, img); err != nil {
+		t.Fatalf("EncodeWebP: %v", err)
+	}
+	// The VP8L chunk of the simple file, in an extended file with EXIF and XMP
+	vp8l := buf.Bytes()[12:]
+	// (without the alpha flag, which x/image/webp rejects next to VP8L)
+	vp8x := []byte{0x08 | 0x04, 0, 0, 0, 39, 0, 0, 29, 0, 0}
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, vp8l...)
+	body = append(body, riffChunk("EXIF", exifWithGPS(1)[6:])...)
+	body = append(body, riffChunk("XMP ", []byte(xmpPacket+" "))...)
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	data = append(data, body...)
+	if _, err := webp.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("fixture does not decode: %v", err)
+	}
+
+	stripped, ok := StripWebPMetadata(data)
+	if !ok {
+		t.Fatal("StripWebPMetadata failed")
+	}
+	assertNoMetadata(t, stripped)
+	if size := binary.LittleEndian.Uint32(stripped[4:]); int(size) != len(stripped)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(stripped)-8)
+	}
+	if flags := stripped[20]; flags != 0 {
+		t.Errorf("VP8X flags = %#x, want the EXIF and XMP flags cleared", flags)
+	}
+	decoded, err := webp.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped WebP does not decode: %v", err)
+	}
+	assertSamePixels(t, decoded, img)
+}
+
+func TestStripGIFMetadata(t *testing.T) {
+	frames := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 20, 10), palette.Plan9)
+		for x := 0; x < 20; x++ {
+			frame.SetColorIndex(x, i*5, uint8(x+i*40))
+		}
+		frames.Image = append(frames.Image, frame)
+		frames.Delay = append(frames.Delay, 50)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, frames); err != nil {
+		t.Fatal(err)
+	}
+	base := buf.Bytes()
+
+	// An XMP extension pads its raw packet with a "magic trailer" so it
+	// reads as sub-blocks
+	xmp := append([]byte{0x21, 0xff, 11}, "XMP DataXMP"+xmpPacket...)
+	xmp = append(xmp, 1)
+	for b := 0xff; b >= 0; b-- {
+		xmp = append(xmp, byte(b))
+	}
+	xmp = append(xmp, 0)
+	comment := append([]byte{0x21, 0xfe, 9}, "a comment\x00"...)
+
+	// After the header, the screen descriptor and the global palette
+	header := 13
+	if base[10]&0x80 != 0 {
+		header += 3 << (base[10]&0x07 + 1)
+	}
+	data := append([]byte{}, base[:header]...)
+	data = append(data, comment...)
+	data = append(data, xmp...)
+	data = append(data, base[header:len(base)-1]...)
+	data = append(data, comment...)
+	data = append(data, 0x3b)
+	if _, err := gif.DecodeAll(bytes.NewReader(data)); err != nil {
+		t.Fatalf("fixture does not decode: %v", err)
+	}
+
+	stripped, ok := StripGIFMetadata(data)
+	if !ok {
+		t.Fatal("StripGIFMetadata failed")
+	}
+	assertNoMetadata(t, stripped)
+	if !bytes.Equal(stripped, base) {
+		t.Errorf("stripped GIF is %d bytes, want the %d bytes of the original", len(stripped), len(base))
+	}
+
+	// Animated GIFs stay animated
+	result, err := Process(data, ".gif")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	decoded, err := gif.DecodeAll(bytes.NewReader(result.Original))
+	if err != nil || len(decoded.Image) != 2 || decoded.LoopCount != 0 {
+		t.Errorf("processed GIF = %d frames, loop count %d, %v", len(decoded.Image), decoded.LoopCount, err)
+	}
+	assertNoMetadata(t, result.Original)
+}
+
+// TestStripTruncated checks that no cut-off file is accepted or panics
+func TestStripTruncated(t *testing.T) {
+	var pngData, gifData, webpData bytes.Buffer
+	png.Encode(&pngData, testImage(8, 8, false))
+	gif.Encode(&gifData, testImage(8, 8, false), nil)
+	EncodeWebP(&webpData, testImage(8, 8, false))
+	files := map[string][]byte{
+		"jpeg": encodeJPEG(t, testImage(8, 8, false)),
+		"png":  pngData.Bytes(),
+		"gif":  gifData.Bytes(),
+	}
+	strip := map[string]func([]byte) ([]byte, bool){
+		"jpeg": StripJPEGMetadata,
+		"png":  StripPNGMetadata,
+		"gif":  StripGIFMetadata,
+	}
+	for format, data := range files {
+		for n := 0; n < len(data)-1; n++ {
+			if _, ok := strip[format](data[:n]); ok {
+				t.Errorf("%s cut off at %d of %d bytes was accepted", format, n, len(data))
+				break
+			}
+		}
+	}
+	// WebP chunks are copied as they are, so only the container is checked
+	for n := 0; n < 20; n++ {
+		StripWebPMetadata(webpData.Bytes()[:n])
+	}
+}
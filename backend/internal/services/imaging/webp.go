@@ -0,0 +1,686 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// This is a lossless WebP (VP8L) encoder. It uses the subtract-green and
+// predictor transforms and LZ77 backward references, without a colour
+// cache or meta prefix codes. Lossless WebP beats PNG for graphics and
+// screenshots but not JPEG for photos, which is why Process only keeps a
+// WebP variant when it is smaller than the fallback.
+
+const (
+	maxWebPDimension = 1 << 14
+
+	predictorBits = 4 // 16x16 blocks share a predictor mode
+	numLiterals   = 256
+	numLengthCode = 24
+	numDistCode   = 40
+	maxMatch      = 4096
+	minMatch      = 3
+	maxDistance   = 1<<20 - 120
+	hashBits      = 16
+	chainDepth    = 32
+)
+
+var errWebPTooLarge = errors.New("imaging: image too large for WebP")
+
+// codeLengthOrder is the order in which the code length code lengths are written
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes img as a lossless WebP image
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > maxWebPDimension || height > maxWebPDimension {
+		return errWebPTooLarge
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	}
+
+	argb := make([]uint32, width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+width*4]
+		for x := 0; x < width; x++ {
+			p := row[x*4 : x*4+4]
+			if p[3] != 0xff {
+				hasAlpha = true
+			}
+			argb[y*width+x] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+
+	// Transforms are applied in the order they are written
+	bw.write(1, 1)
+	bw.write(2, 2) // subtract green
+	subtractGreen(argb)
+
+	bw.write(1, 1)
+	bw.write(0, 2) // predictor
+	bw.write(predictorBits-2, 3)
+	modes, tilesX := choosePredictors(argb, width, height)
+	residuals := applyPredictors(argb, width, height, modes, tilesX)
+	modeImage := make([]uint32, len(modes))
+	for i, m := range modes {
+		modeImage[i] = uint32(m) << 8
+	}
+	writeEntropyImage(bw, literalTokens(modeImage), false)
+
+	bw.write(0, 1) // no more transforms
+
+	writeEntropyImage(bw, backwardReferences(residuals, width), true)
+	bw.flush()
+
+	data := bw.buf
+	chunkSize := len(data)
+	padded := chunkSize + chunkSize&1
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+padded))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(chunkSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if chunkSize&1 == 1 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nacc uint
+}
+
+// write appends the low n bits of v, least significant bit first
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nacc
+	b.nacc += n
+	for b.nacc >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nacc -= 8
+	}
+}
+
+func (b *bitWriter) flush() {
+	if b.nacc > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nacc = 0, 0
+	}
+}
+
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		bl := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | bl
+	}
+}
+
+// Pixel arithmetic works per channel, modulo 256
+
+func average2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func subPixels(a, b uint32) uint32 {
+	ag := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	rb := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+func channel(p uint32, shift uint) int {
+	return int((p >> shift) & 0xff)
+}
+
+func clamp255(v int) uint32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint32(v)
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func selectPredictor(l, t, tl uint32) uint32 {
+	pl, pt := 0, 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		pl += absInt(channel(t, shift) - channel(tl, shift))
+		pt += absInt(channel(l, shift) - channel(tl, shift))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		out |= clamp255(channel(a, shift)+channel(b, shift)-channel(c, shift)) << shift
+	}
+	return out
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		ca := channel(a, shift)
+		out |= clamp255(ca+(ca-channel(b, shift))/2) << shift
+	}
+	return out
+}
+
+// predict returns the prediction of mode for the pixel at i, which is not
+// on the top row or left column. The top-right neighbour of the rightmost
+// column is the first pixel of the current row, which is what i-width+1
+// addresses.
+func predict(mode int, argb []uint32, i, width int) uint32 {
+	l := argb[i-1]
+	t := argb[i-width]
+	tl := argb[i-width-1]
+	tr := argb[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPredictor(l, t, tl)
+	case 12:
+		return clampAddSubtractFull(l, t, tl)
+	default:
+		return clampAddSubtractHalf(average2(l, t), tl)
+	}
+}
+
+// residualCost estimates how well a residual compresses: small values in
+// either direction are cheap
+func residualCost(r uint32) int {
+	cost := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		cost += absInt(int(int8(r >> shift)))
+	}
+	return cost
+}
+
+// choosePredictors picks the predictor mode with the smallest residuals for
+// every block
+func choosePredictors(argb []uint32, width, height int) ([]uint8, int) {
+	size := 1 << predictorBits
+	tilesX := (width + size - 1) / size
+	tilesY := (height + size - 1) / size
+	modes := make([]uint8, tilesX*tilesY)
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			bestMode, bestCost := 0, -1
+			for mode := 0; mode < 14; mode++ {
+				cost := 0
+				for y := ty * size; y < (ty+1)*size && y < height; y++ {
+					if y == 0 {
+						continue
+					}
+					for x := tx * size; x < (tx+1)*size && x < width; x++ {
+						if x == 0 {
+							continue
+						}
+						i := y*width + x
+						cost += residualCost(subPixels(argb[i], predict(mode, argb, i, width)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+			modes[ty*tilesX+tx] = uint8(bestMode)
+		}
+	}
+	return modes, tilesX
+}
+
+func applyPredictors(argb []uint32, width, height int, modes []uint8, tilesX int) []uint32 {
+	residuals := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var pred uint32
+			switch {
+			case y == 0 && x == 0:
+				pred = 0xff000000
+			case y == 0:
+				pred = argb[i-1]
+			case x == 0:
+				pred = argb[i-width]
+			default:
+				mode := modes[(y>>predictorBits)*tilesX+(x>>predictorBits)]
+				pred = predict(int(mode), argb, i, width)
+			}
+			residuals[i] = subPixels(argb[i], pred)
+		}
+	}
+	return residuals
+}
+
+// token is a literal pixel or, when length > 0, a backward reference
+type token struct {
+	length int
+	value  uint32 // pixel, or distance for a backward reference
+}
+
+func literalTokens(argb []uint32) []token {
+	tokens := make([]token, len(argb))
+	for i, p := range argb {
+		tokens[i] = token{value: p}
+	}
+	return tokens
+}
+
+// backwardReferences finds repeated pixel runs with a hash chain, also
+// trying the pixel to the left and the one above
+func backwardReferences(argb []uint32, width int) []token {
+	n := len(argb)
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	hash := func(i int) uint32 {
+		return (argb[i]*0x1e35a7bd + argb[i+1]*0x9e3779b1) >> (32 - hashBits)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLen := func(from, to, max int) int {
+		l := 0
+		for l < max && argb[from+l] == argb[to+l] {
+			l++
+		}
+		return l
+	}
+
+	tokens := make([]token, 0, n/2)
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		if i+1 < n {
+			max := n - i
+			if max > maxMatch {
+				max = maxMatch
+			}
+			try := func(from int) {
+				if from < 0 || i-from > maxDistance {
+					return
+				}
+				if l := matchLen(from, i, max); l > bestLen {
+					bestLen, bestDist = l, i-from
+				}
+			}
+			try(i - 1)
+			try(i - width)
+			for c, depth := head[hash(i)], 0; c >= 0 && depth < chainDepth && bestLen < max; c, depth = prev[c], depth+1 {
+				try(int(c))
+			}
+		}
+		if bestLen >= minMatch {
+			tokens = append(tokens, token{length: bestLen, value: uint32(bestDist)})
+			for j := i; j < i+bestLen; j++ {
+				insert(j)
+			}
+			i += bestLen
+			continue
+		}
+		tokens = append(tokens, token{value: argb[i]})
+		insert(i)
+		i++
+	}
+	return tokens
+}
+
+// prefixEncode splits a length or distance into its prefix code and extra bits
+func prefixEncode(v int) (code int, extraBits uint, extra uint32) {
+	x := v - 1
+	if x < 4 {
+		return x, 0, 0
+	}
+	h := bits.Len(uint(x)) - 1
+	second := (x >> (h - 1)) & 1
+	return 2*h + second, uint(h - 1), uint32(x & (1<<(h-1) - 1))
+}
+
+// writeEntropyImage writes the prefix codes and the symbols of an image.
+// The main image additionally has a (here unused) meta prefix code flag.
+func writeEntropyImage(bw *bitWriter, tokens []token, mainImage bool) {
+	green := make([]uint32, numLiterals+numLengthCode)
+	red := make([]uint32, numLiterals)
+	blue := make([]uint32, numLiterals)
+	alpha := make([]uint32, numLiterals)
+	dist := make([]uint32, numDistCode)
+	for _, t := range tokens {
+		if t.length == 0 {
+			green[(t.value>>8)&0xff]++
+			red[(t.value>>16)&0xff]++
+			blue[t.value&0xff]++
+			alpha[t.value>>24]++
+			continue
+		}
+		lc, _, _ := prefixEncode(t.length)
+		green[numLiterals+lc]++
+		// Distances beyond the 120 codes for nearby pixels are offset by 120
+		dc, _, _ := prefixEncode(int(t.value) + 120)
+		dist[dc]++
+	}
+
+	bw.write(0, 1) // no colour cache
+	if mainImage {
+		bw.write(0, 1) // a single group of prefix codes
+	}
+	codes := [5]*prefixCode{}
+	for i, histogram := range [][]uint32{green, red, blue, alpha, dist} {
+		codes[i] = newPrefixCode(histogram, 15)
+		codes[i].writeTo(bw)
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].writeSymbol(bw, int((t.value>>8)&0xff))
+			codes[1].writeSymbol(bw, int((t.value>>16)&0xff))
+			codes[2].writeSymbol(bw, int(t.value&0xff))
+			codes[3].writeSymbol(bw, int(t.value>>24))
+			continue
+		}
+		lc, lbits, lextra := prefixEncode(t.length)
+		codes[0].writeSymbol(bw, numLiterals+lc)
+		bw.write(lextra, lbits)
+		dc, dbits, dextra := prefixEncode(int(t.value) + 120)
+		codes[4].writeSymbol(bw, dc)
+		bw.write(dextra, dbits)
+	}
+}
+
+// prefixCode is a canonical Huffman code
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint32 // bit-reversed, ready to be written LSB first
+	used    []int    // symbols with a non-zero count
+}
+
+func newPrefixCode(histogram []uint32, limit int) *prefixCode {
+	pc := &prefixCode{lengths: huffmanLengths(histogram, limit)}
+	for s, l := range pc.lengths {
+		if l > 0 {
+			pc.used = append(pc.used, s)
+		}
+	}
+	pc.codes = canonicalCodes(pc.lengths)
+	// A code with a single symbol takes no bits at all
+	if len(pc.used) <= 1 {
+		for i := range pc.lengths {
+			pc.codes[i] = 0
+		}
+	}
+	return pc
+}
+
+func (pc *prefixCode) writeSymbol(bw *bitWriter, s int) {
+	if len(pc.used) <= 1 {
+		return
+	}
+	bw.write(pc.codes[s], uint(pc.lengths[s]))
+}
+
+// writeTo writes the code: as a "simple" code for at most two small
+// symbols, otherwise as code lengths compressed with a code length code
+func (pc *prefixCode) writeTo(bw *bitWriter) {
+	if len(pc.used) <= 2 && (len(pc.used) == 0 || pc.used[len(pc.used)-1] < 256) {
+		symbols := pc.used
+		if len(symbols) == 0 {
+			symbols = []int{0}
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbols[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			bw.write(uint32(symbols[1]), 8)
+		}
+		return
+	}
+	bw.write(0, 1)
+
+	// Run-length encode the code lengths: 16 repeats the previous length
+	// 3-6 times, 17 and 18 are runs of 3-10 and 11-138 zeros
+	type clToken struct {
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}
+	var tokens []clToken
+	lengths := pc.lengths
+	for i := 0; i < len(lengths); {
+		v := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == v {
+			run++
+		}
+		i += run
+		if v == 0 {
+			for run >= 11 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				tokens = append(tokens, clToken{18, uint32(n - 11), 7})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, clToken{17, uint32(run - 3), 3})
+				run = 0
+			}
+			for ; run > 0; run-- {
+				tokens = append(tokens, clToken{symbol: 0})
+			}
+			continue
+		}
+		tokens = append(tokens, clToken{symbol: int(v)})
+		run--
+		for run >= 3 {
+			n := run
+			if n > 6 {
+				n = 6
+			}
+			tokens = append(tokens, clToken{16, uint32(n - 3), 2})
+			run -= n
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, clToken{symbol: int(v)})
+		}
+	}
+
+	histogram := make([]uint32, 19)
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	clCode := newPrefixCode(histogram, 7)
+
+	count := 4
+	for i := len(codeLengthOrder) - 1; i >= 4; i-- {
+		if clCode.lengths[codeLengthOrder[i]] > 0 {
+			count = i + 1
+			break
+		}
+	}
+	bw.write(uint32(count-4), 4)
+	for i := 0; i < count; i++ {
+		bw.write(uint32(clCode.lengths[codeLengthOrder[i]]), 3)
+	}
+
+	bw.write(0, 1) // code lengths for the whole alphabet follow
+	for _, t := range tokens {
+		clCode.writeSymbol(bw, t.symbol)
+		if t.extraBits > 0 {
+			bw.write(t.extra, t.extraBits)
+		}
+	}
+}
+
+// huffmanLengths builds Huffman code lengths of at most limit bits. When
+// the tree gets too deep, rare symbols are counted as more frequent until
+// it fits.
+func huffmanLengths(histogram []uint32, limit int) []uint8 {
+	lengths := make([]uint8, len(histogram))
+
+	type node struct {
+		count       uint64
+		left, right int
+		symbol      int
+	}
+	for minCount := uint64(1); ; minCount *= 2 {
+		var nodes []node
+		for s, c := range histogram {
+			if c > 0 {
+				count := uint64(c)
+				if count < minCount {
+					count = minCount
+				}
+				nodes = append(nodes, node{count, -1, -1, s})
+			}
+		}
+		if len(nodes) == 0 {
+			return lengths
+		}
+		if len(nodes) == 1 {
+			lengths[nodes[0].symbol] = 1
+			return lengths
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].count != nodes[j].count {
+				return nodes[i].count < nodes[j].count
+			}
+			return nodes[i].symbol < nodes[j].symbol
+		})
+
+		// Two queues: sorted leaves and internal nodes in creation order
+		leaves := len(nodes)
+		nextLeaf, nextInternal := 0, leaves
+		pick := func() int {
+			if nextLeaf < leaves && (nextInternal >= len(nodes) || nodes[nextLeaf].count <= nodes[nextInternal].count) {
+				nextLeaf++
+				return nextLeaf - 1
+			}
+			nextInternal++
+			return nextInternal - 1
+		}
+		for i := 0; i < leaves-1; i++ {
+			a := pick()
+			b := pick()
+			nodes = append(nodes, node{nodes[a].count + nodes[b].count, a, b, -1})
+		}
+
+		depth := make([]int, len(nodes))
+		for i := len(nodes) - 1; i >= leaves; i-- {
+			depth[nodes[i].left] = depth[i] + 1
+			depth[nodes[i].right] = depth[i] + 1
+		}
+		maxDepth := 0
+		for i := 0; i < leaves; i++ {
+			if depth[i] > maxDepth {
+				maxDepth = depth[i]
+			}
+		}
+		if maxDepth <= limit {
+			for i := 0; i < leaves; i++ {
+				lengths[nodes[i].symbol] = uint8(depth[i])
+			}
+			return lengths
+		}
+	}
+}
+
+// canonicalCodes assigns codes in order of length and then symbol, and
+// reverses them since the bit stream is read least significant bit first
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [16]int
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[s] = bits.Reverse32(next[l]) >> (32 - uint(l))
+		next[l]++
+	}
+	return codes
+}
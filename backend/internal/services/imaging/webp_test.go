@@ -0,0 +1,91 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// assertSamePixels compares got with want converted to NRGBA, which is
+// what a lossless encoder has to keep exactly
+func assertSamePixels(t *testing.T, got, want image.Image) {
+	t.Helper()
+	if got.Bounds().Dx() != want.Bounds().Dx() || got.Bounds().Dy() != want.Bounds().Dy() {
+		t.Fatalf("size = %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+	expected := image.NewNRGBA(image.Rect(0, 0, want.Bounds().Dx(), want.Bounds().Dy()))
+	draw.Draw(expected, expected.Rect, want, want.Bounds().Min, draw.Src)
+	gb := got.Bounds()
+	for y := 0; y < expected.Rect.Dy(); y++ {
+		for x := 0; x < expected.Rect.Dx(); x++ {
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			if w := expected.NRGBAAt(x, y); g != w {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 67, 45))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+
+	// Repeats far apart exercise the backward references
+	pattern := image.NewNRGBA(image.Rect(0, 0, 300, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 300; x++ {
+			pattern.SetNRGBA(x, y, color.NRGBA{uint8(x % 37 * 6), uint8(y % 5 * 50), 200, 255})
+		}
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 33, 17))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 3)
+	}
+
+	large := testImage(1100, 40, false)
+
+	tests := map[string]image.Image{
+		"opaque gradient":       testImage(64, 48, false),
+		"transparent gradient":  testImage(50, 31, true),
+		"noise with alpha":      noise,
+		"repeated pattern":      pattern,
+		"one pixel":             testImage(1, 1, true),
+		"one row":               testImage(200, 1, false),
+		"one column":            testImage(1, 150, true),
+		"grayscale":             gray,
+		"premultiplied RGBA":    image.NewRGBA(image.Rect(0, 0, 9, 9)),
+		"sub-image":             large.SubImage(image.Rect(1000, 10, 1100, 35)),
+		"wider than one buffer": large,
+	}
+	for name, img := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, img); err != nil {
+				t.Fatalf("EncodeWebP: %v", err)
+			}
+			cfg, err := webp.DecodeConfig(bytes.NewReader(buf.Bytes()))
+			if err != nil || cfg.Width != img.Bounds().Dx() || cfg.Height != img.Bounds().Dy() {
+				t.Fatalf("DecodeConfig = %+v, %v", cfg, err)
+			}
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			assertSamePixels(t, decoded, img)
+		})
+	}
+}
+
+func TestEncodeWebPTooLarge(t *testing.T) {
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 10), image.Rect(0, 0, maxWebPDimension+1, 1)} {
+		if err := EncodeWebP(&bytes.Buffer{}, image.NewGray(r)); err != errWebPTooLarge {
+			t.Errorf("EncodeWebP(%v) error = %v, want errWebPTooLarge", r, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package invoice renders shop invoices as PDF.
+package invoice
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/pdf"
+)
+
+// Seller is the organisation issuing the invoice
+type Seller struct {
+	Name             string `json:"name"`
+	Address          string `json:"address"`
+	EnterpriseNumber string `json:"enterprise_number"`
+	VATNumber        string `json:"vat_number"`
+	Email            string `json:"email"`
+}
+
+// Line is a single invoice line. Prices include VAT.
+type Line struct {
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitCents   int    `json:"unit_cents"`
+}
+
+// Invoice is a snapshot of everything printed on an invoice. It is stored
+// when the invoice is issued so later changes to settings or items don't
+// alter issued invoices. Number and IssuedAt live in their own columns.
+type Invoice struct {
+	Number          string    `json:"-"`
+	IssuedAt        time.Time `json:"-"`
+	OrderID         int64     `json:"order_id"`
+	Seller          Seller    `json:"seller"`
+	BuyerEmail      string    `json:"buyer_email"`
+	BuyerName       string    `json:"buyer_name"`
+	BuyerAddress    string    `json:"buyer_address"`
+	Lines           []Line    `json:"lines"`
+	Currency        string    `json:"currency"`
+	VATRate         float64   `json:"vat_rate"`
+	VATNote         string    `json:"vat_note"`
+	PaymentProvider string    `json:"payment_provider"`
+	PaymentRef      string    `json:"payment_ref"`
+}
+
+// TotalCents is the amount paid, VAT included
+func (inv Invoice) TotalCents() int {
+	total := 0
+	for _, l := range inv.Lines {
+		total += l.Quantity * l.UnitCents
+	}
+	return total
+}
+
+// VATCents is the VAT contained in the total
+func (inv Invoice) VATCents() int {
+	if inv.VATRate <= 0 {
+		return 0
+	}
+	total := float64(inv.TotalCents())
+	return int(math.Round(total * inv.VATRate / (100 + inv.VATRate)))
+}
+
+const (
+	margin   = 50.0
+	fontSize = 10.0
+)
+
+// Render produces the invoice PDF
+func Render(inv Invoice) ([]byte, error) {
+	doc := pdf.New(pdf.A4Width, pdf.A4Height)
+	doc.SetTitle("Factuur " + inv.Number)
+	p := doc.AddPage()
+	right := doc.Width() - margin
+
+	// Seller block
+	y := margin + 20
+	p.Text(margin, y, 18, pdf.Bold, inv.Seller.Name)
+	y += 18
+	for _, line := range strings.Split(inv.Seller.Address, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			p.Text(margin, y, fontSize, pdf.Regular, line)
+			y += 13
+		}
+	}
+	if inv.Seller.EnterpriseNumber != "" {
+		p.Text(margin, y, fontSize, pdf.Regular, "Ondernemingsnummer: "+inv.Seller.EnterpriseNumber)
+		y += 13
+	}
+	if inv.Seller.VATNumber != "" {
+		p.Text(margin, y, fontSize, pdf.Regular, "Btw-nummer: "+inv.Seller.VATNumber)
+		y += 13
+	}
+	if inv.Seller.Email != "" {
+		p.Text(margin, y, fontSize, pdf.Regular, inv.Seller.Email)
+		y += 13
+	}
+
+	// Invoice details, top right
+	p.TextRight(right, margin+20, 18, pdf.Bold, "FACTUUR")
+	details := [][2]string{
+		{"Factuurnummer", inv.Number},
+		{"Datum", inv.IssuedAt.Format("02/01/2006")},
+		{"Bestelling", fmt.Sprintf("#%d", inv.OrderID)},
+	}
+	dy := margin + 38
+	for _, d := range details {
+		p.TextRight(right-110, dy, fontSize, pdf.Bold, d[0]+":")
+		p.TextRight(right, dy, fontSize, pdf.Regular, d[1])
+		dy += 13
+	}
+
+	// Buyer block
+	y = math.Max(y, dy) + 25
+	p.Text(margin, y, fontSize, pdf.Bold, "Factuur aan")
+	y += 14
+	if inv.BuyerName != "" {
+		p.Text(margin, y, fontSize, pdf.Regular, inv.BuyerName)
+		y += 13
+	}
+	for _, line := range strings.Split(inv.BuyerAddress, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			p.Text(margin, y, fontSize, pdf.Regular, line)
+			y += 13
+		}
+	}
+	p.Text(margin, y, fontSize, pdf.Regular, inv.BuyerEmail)
+	y += 35
+
+	// Line table
+	colQty := right - 190
+	colUnit := right - 90
+	p.FillRect(margin, y-13, right-margin, 19, 0.92)
+	p.Text(margin+5, y, fontSize, pdf.Bold, "Omschrijving")
+	p.TextRight(colQty, y, fontSize, pdf.Bold, "Aantal")
+	p.TextRight(colUnit, y, fontSize, pdf.Bold, "Eenheidsprijs")
+	p.TextRight(right-5, y, fontSize, pdf.Bold, "Bedrag")
+	y += 22
+
+	for _, l := range inv.Lines {
+		desc := pdf.WrapText(l.Description, fontSize, pdf.Regular, colQty-margin-60)
+		p.TextRight(colQty, y, fontSize, pdf.Regular, fmt.Sprintf("%d", l.Quantity))
+		p.TextRight(colUnit, y, fontSize, pdf.Regular, formatAmount(l.UnitCents, inv.Currency))
+		p.TextRight(right-5, y, fontSize, pdf.Regular, formatAmount(l.Quantity*l.UnitCents, inv.Currency))
+		for _, d := range desc {
+			p.Text(margin+5, y, fontSize, pdf.Regular, d)
+			y += 13
+		}
+		y += 4
+	}
+	p.Line(margin, y-6, right, y-6, 0.5)
+	y += 10
+
+	// Totals
+	total := inv.TotalCents()
+	vat := inv.VATCents()
+	if inv.VATRate > 0 {
+		p.TextRight(colUnit, y, fontSize, pdf.Regular, "Totaal excl. btw")
+		p.TextRight(right-5, y, fontSize, pdf.Regular, formatAmount(total-vat, inv.Currency))
+		y += 14
+		p.TextRight(colUnit, y, fontSize, pdf.Regular, fmt.Sprintf("Btw %s%%", formatRate(inv.VATRate)))
+		p.TextRight(right-5, y, fontSize, pdf.Regular, formatAmount(vat, inv.Currency))
+		y += 14
+	}
+	p.TextRight(colUnit, y, 12, pdf.Bold, "Totaal")
+	p.TextRight(right-5, y, 12, pdf.Bold, formatAmount(total, inv.Currency))
+	y += 30
+
+	if inv.VATNote != "" {
+		for _, line := range pdf.WrapText(inv.VATNote, fontSize, pdf.Regular, right-margin) {
+			p.Text(margin, y, fontSize, pdf.Regular, line)
+			y += 13
+		}
+		y += 8
+	}
+
+	paid := "Betaald"
+	if inv.PaymentProvider != "" {
+		paid += " via " + providerLabel(inv.PaymentProvider)
+	}
+	if inv.PaymentRef != "" {
+		paid += " (ref. " + inv.PaymentRef + ")"
+	}
+	p.Text(margin, y, fontSize, pdf.Regular, paid+".")
+
+	p.TextCenter(doc.Width()/2, doc.Height()-margin, 8, pdf.Regular,
+		fmt.Sprintf("%s - factuur %s", inv.Seller.Name, inv.Number))
+
+	return doc.Bytes()
+}
+
+func formatAmount(cents int, currency string) string {
+	symbol := "€"
+	if currency == "USD" {
+		symbol = "$"
+	}
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%s %d,%02d", sign, symbol, cents/100, cents%100)
+}
+
+func formatRate(rate float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", rate), "0")
+	return strings.Replace(strings.TrimSuffix(s, "."), ".", ",", 1)
+}
+
+// discountLabels name a discount line in the buyer's language
+var discountLabels = map[string]string{
+	"NL": "Korting",
+	"EN": "Discount",
+	"FR": "Remise",
+	"DE": "Rabatt",
+}
+
+// DiscountLabel returns the label of a discount line for an order placed in
+// lang, Dutch for languages without one
+func DiscountLabel(lang string) string {
+	if label, ok := discountLabels[strings.ToUpper(lang)]; ok {
+		return label
+	}
+	return discountLabels["NL"]
+}
+
+func providerLabel(name string) string {
+	switch name {
+	case "stripe":
+		return "Stripe"
+	case "mollie":
+		return "Mollie"
+	}
+	return name
+}
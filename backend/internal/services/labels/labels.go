@@ -0,0 +1,159 @@
+// Package labels renders address labels for shipped orders as PDF, laid
+// out on A4 sheets of self-adhesive labels.
+package labels
+
+import (
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/pdf"
+)
+
+// mm converts millimetres to points
+const mm = 72 / 25.4
+
+// Layout describes a sheet of labels. Sizes are in millimetres.
+type Layout struct {
+	Columns    int
+	Rows       int
+	Width      float64
+	Height     float64
+	MarginTop  float64
+	MarginLeft float64
+	GapX       float64
+	GapY       float64
+}
+
+// PerSheet is the number of labels on one sheet
+func (l Layout) PerSheet() int {
+	return l.Columns * l.Rows
+}
+
+// Layouts are the common A4 label sheets, named columns x rows
+var Layouts = map[string]Layout{
+	"3x8": {Columns: 3, Rows: 8, Width: 70, Height: 37, MarginTop: 0.5, MarginLeft: 0},
+	"3x7": {Columns: 3, Rows: 7, Width: 70, Height: 42.3, MarginTop: 0.5, MarginLeft: 0},
+	"2x7": {Columns: 2, Rows: 7, Width: 99.1, Height: 38.1, MarginTop: 15.1, MarginLeft: 4.7, GapX: 2.5},
+	"2x4": {Columns: 2, Rows: 4, Width: 105, Height: 74.25},
+}
+
+// HomeCountry is where parcels are sent from
+const HomeCountry = "BE"
+
+// DefaultLayout is used when no layout is requested
+const DefaultLayout = "3x8"
+
+// Label is one recipient address
+type Label struct {
+	Name       string
+	Address    string
+	PostalCode string
+	City       string
+	Country    string // ISO code, printed as the country name
+	Reference  string // small print, e.g. the order number
+}
+
+// padding inside a label, in points
+const padding = 4 * mm
+
+// Render lays the labels out on as many sheets as needed. Skip leaves the
+// first positions of the first sheet empty so partly used sheets can be
+// reused. Sender, when set, is printed as a small return address.
+func Render(layout Layout, labels []Label, skip int, sender string) ([]byte, error) {
+	doc := pdf.New(pdf.A4Width, pdf.A4Height)
+	doc.SetTitle("Verzendlabels")
+
+	if skip < 0 || skip >= layout.PerSheet() {
+		skip = 0
+	}
+
+	var page *pdf.Page
+	for i, l := range labels {
+		pos := (skip + i) % layout.PerSheet()
+		if page == nil || pos == 0 {
+			page = doc.AddPage()
+		}
+		col, row := pos%layout.Columns, pos/layout.Columns
+		x := (layout.MarginLeft + float64(col)*(layout.Width+layout.GapX)) * mm
+		y := (layout.MarginTop + float64(row)*(layout.Height+layout.GapY)) * mm
+		drawLabel(page, x, y, layout.Width*mm, layout.Height*mm, l, sender)
+	}
+	if page == nil {
+		doc.AddPage()
+	}
+
+	return doc.Bytes()
+}
+
+func drawLabel(p *pdf.Page, x, y, w, h float64, l Label, sender string) {
+	inner := w - 2*padding
+	top := y + padding
+
+	if sender != "" {
+		top += 6
+		p.Text(x+padding, top, 6, pdf.Regular, fit("Afz.: "+sender, 6, pdf.Regular, inner))
+		top += 4
+	}
+
+	lines := []string{l.Name}
+	lines = append(lines, strings.Split(strings.TrimSpace(l.Address), "\n")...)
+	lines = append(lines, strings.TrimSpace(l.PostalCode+" "+l.City))
+	// Domestic mail doesn't need a country line
+	if name := countryName(l.Country); name != "" && !strings.EqualFold(l.Country, HomeCountry) {
+		lines = append(lines, strings.ToUpper(name))
+	}
+
+	// Shrink the text when the address doesn't fit the label height
+	size := 10.0
+	bottom := y + h - padding
+	if l.Reference != "" {
+		bottom -= 7
+	}
+	for size > 6 && top+float64(len(lines))*size*1.2 > bottom {
+		size -= 0.5
+	}
+
+	for i, line := range lines {
+		font := pdf.Regular
+		if i == 0 {
+			font = pdf.Bold
+		}
+		top += size * 1.2
+		p.Text(x+padding, top, size, font, fit(line, size, font, inner))
+	}
+
+	if l.Reference != "" {
+		p.TextRight(x+w-padding, y+h-padding, 6, pdf.Regular, l.Reference)
+	}
+}
+
+// fit truncates s with an ellipsis to at most width points
+func fit(s string, size float64, font pdf.Font, width float64) string {
+	if pdf.TextWidth(s, size, font) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && pdf.TextWidth(string(r)+"…", size, font) > width {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "…"
+}
+
+// countryNames are printed on the last address line, in the sender's
+// language (Dutch) as postal services recommend.
+var countryNames = map[string]string{
+	"BE": "België", "NL": "Nederland", "FR": "Frankrijk", "DE": "Duitsland", "LU": "Luxemburg",
+	"GB": "Verenigd Koninkrijk", "ES": "Spanje", "IT": "Italië", "PT": "Portugal", "AT": "Oostenrijk",
+	"CH": "Zwitserland", "IE": "Ierland", "DK": "Denemarken", "SE": "Zweden", "NO": "Noorwegen",
+	"FI": "Finland", "PL": "Polen", "CZ": "Tsjechië", "SK": "Slowakije", "HU": "Hongarije",
+	"RO": "Roemenië", "BG": "Bulgarije", "HR": "Kroatië", "SI": "Slovenië", "EE": "Estland",
+	"LV": "Letland", "LT": "Litouwen", "GR": "Griekenland",
+}
+
+// countryName returns the printed country, or the code itself when unknown
+func countryName(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if name, ok := countryNames[code]; ok {
+		return name
+	}
+	return code
+}
@@ -0,0 +1,446 @@
+// Package media keeps track of uploaded images: which ones exist, where
+// they are used and which ones nothing refers to any more.
+package media
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/imaging"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+	_ "golang.org/x/image/webp"
+)
+
+// MinOrphanAge is the grace period before an unused image may be deleted,
+// so an image uploaded for a form that is still being filled in survives
+const MinOrphanAge = 24 * time.Hour
+
+// uploadPattern matches the names saveImage gives to uploads
+var uploadPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.(jpg|jpeg|png|gif|webp)$`)
+
+// referencePattern finds uploads in column values: bare file names, image
+// URLs and resized variants alike
+var referencePattern = regexp.MustCompile(`(?i)([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})(?:_w[0-9]+)?\.(?:jpg|jpeg|png|gif|webp)`)
+
+// ContentTypes maps upload extensions to their MIME type
+var ContentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// Usage is a place an image is shown
+type Usage struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// sources are the queries returning every column that can refer to an
+// image, as (id, label, value) rows
+var sources = []struct {
+	kind  string
+	query string
+}{
+	{"event", "SELECT CAST(id AS TEXT), title, COALESCE(image_url, '') FROM events"},
+	{"event_description", `
+		SELECT CAST(e.id AS TEXT), e.title || ' (' || t.lang_code || ')', COALESCE(t.description, '')
+		FROM event_translations t JOIN events e ON e.id = t.event_id`},
+	{"event_media", `
+		SELECT CAST(e.id AS TEXT), e.title, m.filename
+		FROM event_media m JOIN events e ON e.id = m.event_id WHERE m.kind = 'image'`},
+	{"event_type", "SELECT code, code, icon FROM event_types"},
+	{"golden_key_finder", "SELECT CAST(id AS TEXT), month_name, COALESCE(finder_image, '') FROM golden_key_months"},
+	{"golden_key_hint", `
+		SELECT CAST(m.id AS TEXT), m.month_name, COALESCE(h.image_url, '') || ' ' || h.content
+		FROM golden_key_hints h JOIN golden_key_months m ON m.id = h.month_id`},
+	{"golden_key_settings", "SELECT '1', 'Golden key', banner_text || ' ' || rules FROM golden_key_settings"},
+	{"shop_item", "SELECT CAST(id AS TEXT), title, COALESCE(image_url, '') || ' ' || description FROM shop_items"},
+	{"shop_item_description", `
+		SELECT CAST(i.id AS TEXT), i.title || ' (' || t.lang_code || ')', t.description
+		FROM shop_item_translations t JOIN shop_items i ON i.id = t.item_id`},
+	{"message", `
+		SELECT CAST(message_id AS TEXT), COALESCE(title, '') || ' (' || lang_code || ')', COALESCE(content, '')
+		FROM message_translations`},
+	{"static_content", "SELECT property, property || ' (' || lang_code || ')', COALESCE(content, '') FROM static_content"},
+	{"language", "SELECT code, name, COALESCE(flag_url, '') FROM languages"},
+	{"social", "SELECT CAST(id AS TEXT), platform, COALESCE(icon, '') FROM socials"},
+	// Older versions keep their images, so restoring one never brings back
+	// a broken image
+	{"revision", `
+		SELECT entity || ':' || entity_id, entity || ' ' || entity_id || ' (revision history)', GROUP_CONCAT(data, ' ')
+		FROM revisions GROUP BY entity, entity_id`},
+}
+
+// Usages returns where each upload is used, keyed by the UUID part of its
+// file name
+func Usages(db *database.DB) (map[string][]Usage, error) {
+	usages := map[string][]Usage{}
+	for _, source := range sources {
+		rows, err := db.Query(source.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, label, value string
+			if err := rows.Scan(&id, &label, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			seen := map[string]bool{}
+			for _, match := range referencePattern.FindAllStringSubmatch(value, -1) {
+				key := strings.ToLower(match[1])
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				usages[key] = append(usages[key], Usage{Kind: source.kind, ID: id, Label: label})
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return usages, nil
+}
+
+// References returns the uploads value refers to, keyed like Usages, with
+// the file name as it is written in value
+func References(value string) map[string]string {
+	refs := map[string]string{}
+	for _, match := range referencePattern.FindAllStringSubmatch(value, -1) {
+		key := strings.ToLower(match[1])
+		if _, ok := refs[key]; !ok {
+			refs[key] = match[0]
+		}
+	}
+	return refs
+}
+
+// Key is the part of an upload's file name that references are matched on
+func Key(filename string) string {
+	return strings.ToLower(strings.TrimSuffix(filename, filepath.Ext(filename)))
+}
+
+// IsUpload reports whether filename is the original of an upload
+func IsUpload(filename string) bool {
+	return uploadPattern.MatchString(filename)
+}
+
+// Register records a new upload; uploadedBy is nil for uploads without a
+// known user. A row Sync added while the upload was being written is
+// completed.
+func Register(db *database.DB, filename, originalName string, size int64, width, height int, uploadedBy *int64) error {
+	_, err := db.Exec(`
+		INSERT INTO media (filename, original_name, content_type, size_bytes, width, height, uploaded_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(filename) DO UPDATE SET
+			original_name = excluded.original_name, size_bytes = excluded.size_bytes,
+			width = excluded.width, height = excluded.height, uploaded_by = excluded.uploaded_by,
+			updated_at = CURRENT_TIMESTAMP
+	`, filename, originalName, ContentTypes[strings.ToLower(filepath.Ext(filename))], size, width, height, uploadedBy)
+	return err
+}
+
+// ImageKey is the storage key of an uploaded image or variant
+func ImageKey(filename string) string {
+	return "images/" + filename
+}
+
+// originals lists the uploads in storage. A .webp file is the full-size
+// variant of a PNG when one with the same name exists.
+func originals(ctx context.Context, store storage.Storage) (map[string]storage.Object, error) {
+	objects, err := store.List(ctx, ImageKey(""))
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, o := range objects {
+		names[path.Base(o.Key)] = true
+	}
+	files := map[string]storage.Object{}
+	for _, o := range objects {
+		name := path.Base(o.Key)
+		if !IsUpload(name) {
+			continue
+		}
+		if filepath.Ext(name) == ".webp" && names[imaging.VariantName(name, 0, ".png")] {
+			continue
+		}
+		files[name] = o
+	}
+	return files, nil
+}
+
+// Sync registers stored uploads that have no media row (those from before
+// the library existed). Rows whose file is gone are kept with their alt
+// texts, since an empty listing may just be a misconfigured bucket.
+func Sync(ctx context.Context, db *database.DB, store storage.Storage) error {
+	files, err := originals(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	rows, err := db.Query("SELECT filename FROM media")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			rows.Close()
+			return err
+		}
+		known[filename] = true
+	}
+	rows.Close()
+
+	for filename, obj := range files {
+		if known[filename] {
+			continue
+		}
+		var width, height int
+		if body, _, err := store.Get(ctx, obj.Key); err == nil {
+			if cfg, _, err := image.DecodeConfig(body); err == nil {
+				width, height = cfg.Width, cfg.Height
+			}
+			body.Close()
+		}
+		// The file time stands in for the upload time
+		modified := obj.ModTime.UTC().Format("2006-01-02 15:04:05")
+		_, err := db.Exec(`
+			INSERT OR IGNORE INTO media (filename, content_type, size_bytes, width, height, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, filename, ContentTypes[filepath.Ext(filename)], obj.Size, width, height, modified, modified)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reprocess runs the stored uploads through imaging.Process, so images
+// uploaded before it existed lose their metadata (GPS positions included)
+// and get their variants. Uploads that would come out the same are left
+// alone, so it can be run again. It returns the names of the rewritten
+// uploads; one that fails to process is logged and skipped.
+func Reprocess(ctx context.Context, db *database.DB, store storage.Storage) ([]string, error) {
+	if err := Sync(ctx, db, store); err != nil {
+		return nil, err
+	}
+	objects, err := store.List(ctx, ImageKey(""))
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]bool{}
+	for _, o := range objects {
+		stored[path.Base(o.Key)] = true
+	}
+	files, err := originals(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	rewritten := []string{}
+	for _, filename := range filenames {
+		body, _, err := store.Get(ctx, files[filename].Key)
+		if err == storage.ErrNotFound {
+			continue // removed since the listing
+		}
+		if err != nil {
+			return rewritten, err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return rewritten, err
+		}
+
+		ext := strings.ToLower(filepath.Ext(filename))
+		processed, err := imaging.Process(data, ext)
+		if err != nil {
+			log.Printf("Reprocessing image %s failed: %v", filename, err)
+			continue
+		}
+		changed := !bytes.Equal(processed.Original, data)
+		for _, v := range processed.Variants {
+			if !stored[imaging.VariantName(filename, v.Width, v.Ext)] {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		// Variants first, like a new upload
+		for _, v := range processed.Variants {
+			if err := store.Put(ctx, ImageKey(imaging.VariantName(filename, v.Width, v.Ext)), v.Data, ContentTypes[v.Ext]); err != nil {
+				return rewritten, err
+			}
+		}
+		if err := store.Put(ctx, ImageKey(filename), processed.Original, ContentTypes[ext]); err != nil {
+			return rewritten, err
+		}
+		_, err = db.Exec(`
+			UPDATE media SET size_bytes = ?, width = ?, height = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE filename = ?
+		`, len(processed.Original), processed.Width, processed.Height, filename)
+		if err != nil {
+			return rewritten, err
+		}
+		rewritten = append(rewritten, filename)
+	}
+	return rewritten, nil
+}
+
+// Variants lists the stored variants of an upload: resized widths and the
+// full-size WebP, in any format
+func Variants(ctx context.Context, store storage.Storage, filename string) ([]storage.Object, error) {
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	objects, err := store.List(ctx, ImageKey(base))
+	if err != nil {
+		return nil, err
+	}
+	variants := []storage.Object{}
+	for _, o := range objects {
+		name := path.Base(o.Key)
+		if name != filepath.Base(filename) && (strings.HasPrefix(name, base+".") || strings.HasPrefix(name, base+"_w")) {
+			variants = append(variants, o)
+		}
+	}
+	return variants, nil
+}
+
+// RemoveFiles deletes an upload and its variants
+func RemoveFiles(ctx context.Context, store storage.Storage, filename string) error {
+	filename = filepath.Base(filename)
+	variants, err := Variants(ctx, store, filename)
+	if err != nil {
+		return err
+	}
+	// The original goes first, so a failure never leaves it without variants
+	if err := store.Delete(ctx, ImageKey(filename)); err != nil {
+		return err
+	}
+	for _, v := range variants {
+		if err := store.Delete(ctx, v.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Orphan is an upload nothing refers to
+type Orphan struct {
+	ID        int64     `json:"id"`
+	Filename  string    `json:"filename"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Orphans returns the uploads that are not used anywhere and are older than
+// minAge, which is raised to MinOrphanAge
+func Orphans(ctx context.Context, db *database.DB, store storage.Storage, minAge time.Duration) ([]Orphan, error) {
+	if minAge < MinOrphanAge {
+		minAge = MinOrphanAge
+	}
+	if err := Sync(ctx, db, store); err != nil {
+		return nil, err
+	}
+	usages, err := Usages(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, filename, size_bytes, created_at FROM media
+		WHERE created_at < ? ORDER BY created_at
+	`, time.Now().UTC().Add(-minAge).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orphans := []Orphan{}
+	for rows.Next() {
+		var o Orphan
+		if err := rows.Scan(&o.ID, &o.Filename, &o.SizeBytes, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(usages[Key(o.Filename)]) == 0 {
+			orphans = append(orphans, o)
+		}
+	}
+	return orphans, rows.Err()
+}
+
+// Cleanup deletes the orphans older than minAge and returns them; with
+// dryRun it only returns them
+func Cleanup(ctx context.Context, db *database.DB, store storage.Storage, minAge time.Duration, dryRun bool) ([]Orphan, error) {
+	orphans, err := Orphans(ctx, db, store, minAge)
+	if err != nil || dryRun {
+		return orphans, err
+	}
+	for _, o := range orphans {
+		if _, err := db.Exec("DELETE FROM media WHERE id = ?", o.ID); err != nil {
+			return nil, err
+		}
+		if err := RemoveFiles(ctx, store, o.Filename); err != nil {
+			return nil, err
+		}
+	}
+	return orphans, nil
+}
+
+// StartCleanupScheduler deletes unused uploads older than orphanDays once
+// a day. It stops when ctx is cancelled.
+func StartCleanupScheduler(ctx context.Context, db *database.DB, store storage.Storage, orphanDays int) {
+	if orphanDays <= 0 {
+		log.Println("Media cleanup disabled (MEDIA_ORPHAN_DAYS is 0)")
+		return
+	}
+
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	log.Printf("Media cleanup started (checking daily, removing unused images after %d days)", orphanDays)
+
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := Cleanup(ctx, db, store, time.Duration(orphanDays)*24*time.Hour, false)
+			if err != nil {
+				log.Printf("Media cleanup failed: %v", err)
+				continue
+			}
+			if len(removed) > 0 {
+				log.Printf("Media cleanup removed %d unused images", len(removed))
+			}
+		case <-ctx.Done():
+			log.Println("Media cleanup stopped")
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
+)
+
+func TestMain(m *testing.M) {
+	// Migrations log a lot
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// photoWithGPS returns a JPEG whose EXIF segment carries a GPS position
+func photoWithGPS(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 700, 400))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00GPS 51.2093N")
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	return append(append([]byte{0xff, 0xd8}, segment...), buf.Bytes()[2:]...)
+}
+
+func TestReprocess(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	store := storage.NewLocal(dir)
+	ctx := context.Background()
+
+	// Uploaded before uploads were processed: no media row, no variants
+	const filename = "0a1b2c3d-0000-4000-8000-000000000001.jpg"
+	original := photoWithGPS(t)
+	if err := store.Put(ctx, ImageKey(filename), original, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	rewritten, err := Reprocess(ctx, db, store)
+	if err != nil {
+		t.Fatalf("Reprocess: %v", err)
+	}
+	if len(rewritten) != 1 || rewritten[0] != filename {
+		t.Fatalf("rewritten = %v, want [%s]", rewritten, filename)
+	}
+
+	body, obj, err := store.Get(ctx, ImageKey(filename))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if bytes.Contains(data, []byte("51.2093N")) || bytes.Contains(data, []byte("Exif")) {
+		t.Error("the stored image still has its EXIF data")
+	}
+	if _, err := store.Stat(ctx, ImageKey("0a1b2c3d-0000-4000-8000-000000000001_w320.jpg")); err != nil {
+		t.Errorf("no 320 wide variant: %v", err)
+	}
+	var size int64
+	var width int
+	db.QueryRow("SELECT size_bytes, width FROM media WHERE filename = ?", filename).Scan(&size, &width)
+	if size != obj.Size || width != 700 {
+		t.Errorf("media row size, width = %d, %d; want %d, 700", size, width, obj.Size)
+	}
+
+	// Running it again changes nothing
+	if rewritten, err := Reprocess(ctx, db, store); err != nil || len(rewritten) != 0 {
+		t.Errorf("second Reprocess = %v, %v; want nothing rewritten", rewritten, err)
+	}
+}
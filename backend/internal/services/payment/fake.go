@@ -0,0 +1,131 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Fake is an in-memory Provider for development and tests. Checkouts
+// redirect straight to the success URL and webhooks are accepted unsigned:
+//
+//	{"id": "evt_1", "kind": "paid", "order_id": 12, "payment_id": "fake_pay_12"}
+//
+// Event registrations use "registration_id" and "fake_reg_<id>" instead.
+type Fake struct {
+	mu        sync.Mutex
+	Checkouts []CheckoutRequest
+	Refunds   []FakeRefund
+	Cancelled []Checkout
+	// Err, when set, is returned by CreateCheckout and Refund
+	Err error
+}
+
+type FakeRefund struct {
+	PaymentID   string
+	AmountCents int
+	Currency    string
+}
+
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	f.Checkouts = append(f.Checkouts, req)
+
+	return &Checkout{
+		PaymentID: fakePaymentID(req),
+		URL:       req.SuccessURL,
+	}, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, paymentID string, amountCents int, currency string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+	f.Refunds = append(f.Refunds, FakeRefund{PaymentID: paymentID, AmountCents: amountCents, Currency: currency})
+	return nil
+}
+
+func (f *Fake) CancelCheckout(ctx context.Context, checkout Checkout) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Cancelled = append(f.Cancelled, checkout)
+	return nil
+}
+
+// PaymentFees charges a made-up 25 cents + 1.4% on checkouts made by this instance
+func (f *Fake) PaymentFees(ctx context.Context, paymentID string) (*Fees, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.Checkouts {
+		if fakePaymentID(c) == paymentID {
+			amount := c.TotalCents()
+			fee := 25 + amount*14/1000
+			return &Fees{AmountCents: amount, FeeCents: fee, NetCents: amount - fee}, nil
+		}
+	}
+	return nil, &ProviderError{Provider: "fake", Message: "unknown payment"}
+}
+
+func fakePaymentID(req CheckoutRequest) string {
+	if req.RegistrationID != 0 {
+		return fmt.Sprintf("fake_reg_%d", req.RegistrationID)
+	}
+	return fmt.Sprintf("fake_pay_%d", req.OrderID)
+}
+
+func (f *Fake) ParseWebhook(r *http.Request) (*Event, error) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return f.DecodeEvent(payload)
+}
+
+func (f *Fake) DecodeEvent(payload []byte) (*Event, error) {
+	var fe struct {
+		ID             string    `json:"id"`
+		Kind           EventKind `json:"kind"`
+		OrderID        int64     `json:"order_id"`
+		RegistrationID int64     `json:"registration_id"`
+		PaymentID      string    `json:"payment_id"`
+		AmountRefunded int       `json:"amount_refunded"`
+		Reason         string    `json:"reason"`
+	}
+	if err := json.Unmarshal(payload, &fe); err != nil {
+		return nil, err
+	}
+	if fe.ID == "" {
+		return nil, fmt.Errorf("fake event without id")
+	}
+
+	return &Event{
+		ID:             fe.ID,
+		Type:           "fake." + string(fe.Kind),
+		Kind:           fe.Kind,
+		OrderID:        fe.OrderID,
+		RegistrationID: fe.RegistrationID,
+		PaymentID:      fe.PaymentID,
+		AmountRefunded: fe.AmountRefunded,
+		Reason:         fe.Reason,
+		Payload:        payload,
+	}, nil
+}
@@ -0,0 +1,163 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMollieDecodeEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		id       string
+		kind     EventKind
+		refunded int
+		reason   string
+	}{
+		{
+			name:    "paid",
+			payload: `{"id":"tr_1","status":"paid","amount":{"currency":"EUR","value":"15.00"},"metadata":{"order_id":"12"}}`,
+			id:      "tr_1:paid:0:0",
+			kind:    EventPaid,
+		},
+		{
+			name:    "open",
+			payload: `{"id":"tr_1","status":"open","amount":{"currency":"EUR","value":"15.00"},"metadata":{"order_id":"12"}}`,
+			id:      "tr_1:open:0:0",
+			kind:    EventPending,
+		},
+		{
+			name:    "canceled",
+			payload: `{"id":"tr_1","status":"canceled","amount":{"currency":"EUR","value":"15.00"},"metadata":{"order_id":"12"}}`,
+			id:      "tr_1:canceled:0:0",
+			kind:    EventFailed,
+		},
+		{
+			name:    "expired",
+			payload: `{"id":"tr_1","status":"expired","amount":{"currency":"EUR","value":"15.00"},"metadata":{"order_id":"12"}}`,
+			id:      "tr_1:expired:0:0",
+			kind:    EventExpired,
+		},
+		{
+			name:     "refunded in full",
+			payload:  `{"id":"tr_1","status":"paid","amount":{"currency":"EUR","value":"15.00"},"amountRefunded":{"currency":"EUR","value":"15.00"},"metadata":{"order_id":"12"}}`,
+			id:       "tr_1:paid:1500:0",
+			kind:     EventRefunded,
+			refunded: 1500,
+		},
+		{
+			name:     "refunded in part",
+			payload:  `{"id":"tr_1","status":"paid","amount":{"currency":"EUR","value":"15.00"},"amountRefunded":{"currency":"EUR","value":"2.5"},"metadata":{"order_id":"12"}}`,
+			id:       "tr_1:paid:250:0",
+			kind:     EventPartiallyRefunded,
+			refunded: 250,
+		},
+		{
+			name:    "charged back",
+			payload: `{"id":"tr_1","status":"paid","amount":{"currency":"EUR","value":"15.00"},"amountChargedBack":{"currency":"EUR","value":"15.00"},"metadata":{"order_id":"12"}}`,
+			id:      "tr_1:paid:0:1500",
+			kind:    EventDisputed,
+			reason:  "chargeback",
+		},
+	}
+
+	m := NewMollie("test_key")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := m.DecodeEvent([]byte(tt.payload))
+			if err != nil {
+				t.Fatalf("DecodeEvent: %v", err)
+			}
+			if ev.ID != tt.id {
+				t.Errorf("ID = %q, want %q", ev.ID, tt.id)
+			}
+			if ev.Kind != tt.kind {
+				t.Errorf("Kind = %q, want %q", ev.Kind, tt.kind)
+			}
+			if ev.OrderID != 12 || ev.PaymentID != "tr_1" {
+				t.Errorf("OrderID, PaymentID = %d, %q", ev.OrderID, ev.PaymentID)
+			}
+			if ev.AmountRefunded != tt.refunded {
+				t.Errorf("AmountRefunded = %d, want %d", ev.AmountRefunded, tt.refunded)
+			}
+			if ev.Reason != tt.reason {
+				t.Errorf("Reason = %q, want %q", ev.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMollieDecodeEventRegistration(t *testing.T) {
+	ev, err := NewMollie("test_key").DecodeEvent([]byte(`{"id":"tr_2","status":"paid","amount":{"value":"5.00"},"metadata":{"registration_id":"4"}}`))
+	if err != nil {
+		t.Fatalf("DecodeEvent: %v", err)
+	}
+	if ev.RegistrationID != 4 || ev.OrderID != 0 {
+		t.Errorf("RegistrationID, OrderID = %d, %d", ev.RegistrationID, ev.OrderID)
+	}
+}
+
+func TestMollieParseWebhook(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test_key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/payments/tr_1" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"detail":"No payment exists with token tr_x."}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"tr_1","status":"paid","amount":{"currency":"EUR","value":"15.00"},"metadata":{"order_id":"12"}}`)
+	}))
+	defer api.Close()
+
+	m := NewMollie("test_key")
+	m.baseURL = api.URL
+
+	webhook := func(id string) (*Event, error) {
+		form := url.Values{"id": {id}}
+		r := httptest.NewRequest("POST", "/shop/webhook/mollie", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return m.ParseWebhook(r)
+	}
+
+	ev, err := webhook("tr_1")
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+	if ev.Kind != EventPaid || ev.OrderID != 12 {
+		t.Errorf("ParseWebhook = %+v", ev)
+	}
+
+	// Only payment IDs are accepted; anything else is not from Mollie
+	if _, err := webhook("../admin"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("ParseWebhook(../admin) error = %v, want ErrInvalidSignature", err)
+	}
+
+	// A payment Mollie doesn't know is a provider error, retried later
+	_, err = webhook("tr_x")
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) {
+		t.Fatalf("ParseWebhook(tr_x) error = %v, want a ProviderError", err)
+	}
+	if providerErr.Message != "No payment exists with token tr_x." {
+		t.Errorf("Message = %q", providerErr.Message)
+	}
+}
+
+func TestMollieAmounts(t *testing.T) {
+	for value, cents := range map[string]int{"15.00": 1500, "2.5": 250, "0.05": 5, "7": 700, " 1.239 ": 123} {
+		if got := parseMollieAmount(value); got != cents {
+			t.Errorf("parseMollieAmount(%q) = %d, want %d", value, got, cents)
+		}
+	}
+	if got := formatMollieAmount(1505); got != "15.05" {
+		t.Errorf("formatMollieAmount(1505) = %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+// Package payment abstracts the checkout providers used by the shop.
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// EventKind is the provider-independent meaning of a webhook event
+type EventKind string
+
+const (
+	EventPaid              EventKind = "paid"
+	EventPending           EventKind = "pending"
+	EventFailed            EventKind = "failed"
+	EventExpired           EventKind = "expired"
+	EventRefunded          EventKind = "refunded"
+	EventPartiallyRefunded EventKind = "partially_refunded"
+	EventDisputed          EventKind = "disputed"
+	EventIgnored           EventKind = "ignored"
+)
+
+// ErrInvalidSignature is returned when a webhook cannot be authenticated
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// CheckoutRequest describes a single-item order to be paid. Event
+// registrations set RegistrationID instead of OrderID.
+type CheckoutRequest struct {
+	OrderID         int64
+	RegistrationID  int64
+	ItemID          int64
+	ItemName        string
+	Description     string
+	ImageURL        string
+	Quantity        int
+	UnitAmountCents int
+	// DiscountCents is taken off the order total, DiscountCode labels it
+	DiscountCents   int
+	DiscountCode    string
+	Currency        string
+	BuyerEmail      string
+	FulfillmentType string
+	SuccessURL      string
+	CancelURL       string
+	WebhookURL      string
+}
+
+// TotalCents returns the amount the buyer is charged
+func (r CheckoutRequest) TotalCents() int {
+	return r.UnitAmountCents*r.Quantity - r.DiscountCents
+}
+
+// Checkout is a created hosted checkout the buyer is redirected to
+type Checkout struct {
+	SessionID string // Stripe checkout session ID, empty for other providers
+	PaymentID string // Provider payment reference, if known at creation
+	URL       string
+}
+
+// Event is a verified, normalized webhook event
+type Event struct {
+	ID             string
+	Type           string
+	Kind           EventKind
+	OrderID        int64
+	RegistrationID int64
+	PaymentID      string
+	AmountRefunded int
+	Reason         string
+	Payload        []byte
+}
+
+// Provider is implemented by every payment backend
+type Provider interface {
+	Name() string
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error)
+	// ParseWebhook authenticates an incoming webhook and normalizes it.
+	ParseWebhook(r *http.Request) (*Event, error)
+	// DecodeEvent normalizes a previously stored, already verified payload.
+	DecodeEvent(payload []byte) (*Event, error)
+	Refund(ctx context.Context, paymentID string, amountCents int, currency string) error
+}
+
+// Fees is what a provider kept from a captured payment
+type Fees struct {
+	AmountCents int // gross amount captured by the provider
+	FeeCents    int
+	NetCents    int
+}
+
+// FeeReporter is implemented by providers that can report the fees of a
+// single payment, used for reconciliation.
+type FeeReporter interface {
+	PaymentFees(ctx context.Context, paymentID string) (*Fees, error)
+}
+
+// CheckoutCanceller is implemented by providers that can close a checkout
+// that was not paid yet, so a buyer who starts over can't pay twice.
+type CheckoutCanceller interface {
+	CancelCheckout(ctx context.Context, checkout Checkout) error
+}
+
+// ProviderError carries a message from the provider that is safe to show
+type ProviderError struct {
+	Provider string
+	Message  string
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Provider, e.Message)
+}
+
+func parseOrderID(s string) int64 {
+	id, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return id
+}
@@ -0,0 +1,344 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stripeAPIBase = "https://api.stripe.com/v1"
+
+// Stripe implements Provider using Stripe Checkout
+type Stripe struct {
+	secretKey     string
+	webhookSecret string
+	baseURL       string
+	client        *http.Client
+}
+
+func NewStripe(secretKey, webhookSecret string) *Stripe {
+	return &Stripe{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		baseURL:       stripeAPIBase,
+		client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (s *Stripe) Name() string { return "stripe" }
+
+func (s *Stripe) CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	itemName := req.ItemName
+	if req.Quantity > 1 {
+		itemName = fmt.Sprintf("%s (x%d)", req.ItemName, req.Quantity)
+	}
+
+	formData := url.Values{}
+	formData.Set("mode", "payment")
+	formData.Set("success_url", req.SuccessURL)
+	formData.Set("cancel_url", req.CancelURL)
+	formData.Set("customer_email", req.BuyerEmail)
+	formData.Set("line_items[0][quantity]", strconv.Itoa(req.Quantity))
+	formData.Set("line_items[0][price_data][currency]", strings.ToLower(req.Currency))
+	formData.Set("line_items[0][price_data][unit_amount]", strconv.Itoa(req.UnitAmountCents))
+	formData.Set("line_items[0][price_data][product_data][name]", itemName)
+	if req.Description != "" {
+		formData.Set("line_items[0][price_data][product_data][description]", req.Description)
+	}
+	if req.ImageURL != "" {
+		formData.Set("line_items[0][price_data][product_data][images][0]", req.ImageURL)
+	}
+
+	if req.RegistrationID != 0 {
+		registrationID := strconv.FormatInt(req.RegistrationID, 10)
+		formData.Set("metadata[registration_id]", registrationID)
+		formData.Set("payment_intent_data[metadata][registration_id]", registrationID)
+	} else {
+		orderID := strconv.FormatInt(req.OrderID, 10)
+		formData.Set("metadata[order_id]", orderID)
+		formData.Set("metadata[item_id]", strconv.FormatInt(req.ItemID, 10))
+		formData.Set("metadata[fulfillment_type]", req.FulfillmentType)
+		formData.Set("payment_intent_data[metadata][order_id]", orderID)
+	}
+
+	// Discounts become a single-use coupon so Stripe shows them on the receipt
+	if req.DiscountCents > 0 {
+		couponID, err := s.createCoupon(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		formData.Set("discounts[0][coupon]", couponID)
+		formData.Set("metadata[discount_code]", req.DiscountCode)
+	}
+
+	var session struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := s.post(ctx, "/checkout/sessions", formData, &session); err != nil {
+		return nil, err
+	}
+
+	return &Checkout{SessionID: session.ID, URL: session.URL}, nil
+}
+
+func (s *Stripe) createCoupon(ctx context.Context, req CheckoutRequest) (string, error) {
+	formData := url.Values{}
+	formData.Set("amount_off", strconv.Itoa(req.DiscountCents))
+	formData.Set("currency", strings.ToLower(req.Currency))
+	formData.Set("duration", "once")
+	formData.Set("max_redemptions", "1")
+	if req.DiscountCode != "" {
+		formData.Set("name", req.DiscountCode)
+	}
+	formData.Set("metadata[order_id]", strconv.FormatInt(req.OrderID, 10))
+
+	var coupon struct {
+		ID string `json:"id"`
+	}
+	if err := s.post(ctx, "/coupons", formData, &coupon); err != nil {
+		return "", err
+	}
+	return coupon.ID, nil
+}
+
+func (s *Stripe) Refund(ctx context.Context, paymentID string, amountCents int, currency string) error {
+	formData := url.Values{}
+	formData.Set("payment_intent", paymentID)
+	if amountCents > 0 {
+		formData.Set("amount", strconv.Itoa(amountCents))
+	}
+	return s.post(ctx, "/refunds", formData, nil)
+}
+
+// CancelCheckout expires an open checkout session
+func (s *Stripe) CancelCheckout(ctx context.Context, checkout Checkout) error {
+	if checkout.SessionID == "" {
+		return nil
+	}
+	return s.post(ctx, "/checkout/sessions/"+url.PathEscape(checkout.SessionID)+"/expire", url.Values{}, nil)
+}
+
+// PaymentFees reads the balance transaction of the payment intent's charge
+func (s *Stripe) PaymentFees(ctx context.Context, paymentID string) (*Fees, error) {
+	var pi struct {
+		LatestCharge *struct {
+			BalanceTransaction *struct {
+				Amount int `json:"amount"`
+				Fee    int `json:"fee"`
+				Net    int `json:"net"`
+			} `json:"balance_transaction"`
+		} `json:"latest_charge"`
+	}
+
+	query := url.Values{}
+	query.Set("expand[]", "latest_charge.balance_transaction")
+	if err := s.get(ctx, "/payment_intents/"+url.PathEscape(paymentID), query, &pi); err != nil {
+		return nil, err
+	}
+	if pi.LatestCharge == nil || pi.LatestCharge.BalanceTransaction == nil {
+		return nil, &ProviderError{Provider: "stripe", Message: "payment has no balance transaction yet"}
+	}
+
+	bt := pi.LatestCharge.BalanceTransaction
+	return &Fees{AmountCents: bt.Amount, FeeCents: bt.Fee, NetCents: bt.Net}, nil
+}
+
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.do(req, out)
+}
+
+func (s *Stripe) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, out)
+}
+
+func (s *Stripe) do(req *http.Request, out interface{}) error {
+	req.SetBasicAuth(s.secretKey, "")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Stripe: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		var stripeErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &stripeErr)
+		msg := stripeErr.Error.Message
+		if msg == "" {
+			msg = fmt.Sprintf("request failed with status %d", resp.StatusCode)
+		}
+		return &ProviderError{Provider: "stripe", Message: msg}
+	}
+
+	if out != nil {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
+
+func (s *Stripe) ParseWebhook(r *http.Request) (*Event, error) {
+	if s.webhookSecret == "" {
+		return nil, fmt.Errorf("stripe webhook secret not configured")
+	}
+
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := r.Header.Get("Stripe-Signature")
+	if sig == "" || !verifyStripeSignature(payload, sig, s.webhookSecret) {
+		return nil, ErrInvalidSignature
+	}
+
+	return s.DecodeEvent(payload)
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object stripeEventObject `json:"object"`
+	} `json:"data"`
+}
+
+// stripeEventObject holds the fields we use from checkout sessions, payment
+// intents, charges and disputes; unused fields stay zero for other objects.
+type stripeEventObject struct {
+	ID             string `json:"id"`
+	Object         string `json:"object"`
+	PaymentIntent  string `json:"payment_intent"`
+	PaymentStatus  string `json:"payment_status"`
+	Amount         int    `json:"amount"`
+	AmountRefunded int    `json:"amount_refunded"`
+	Refunded       bool   `json:"refunded"`
+	Reason         string `json:"reason"`
+	Metadata       struct {
+		OrderID        string `json:"order_id"`
+		RegistrationID string `json:"registration_id"`
+	} `json:"metadata"`
+}
+
+func (s *Stripe) DecodeEvent(payload []byte) (*Event, error) {
+	var se stripeEvent
+	if err := json.Unmarshal(payload, &se); err != nil {
+		return nil, err
+	}
+	if se.ID == "" {
+		return nil, fmt.Errorf("stripe event without id")
+	}
+
+	obj := se.Data.Object
+	ev := &Event{
+		ID:             se.ID,
+		Type:           se.Type,
+		Kind:           EventIgnored,
+		OrderID:        parseOrderID(obj.Metadata.OrderID),
+		RegistrationID: parseOrderID(obj.Metadata.RegistrationID),
+		PaymentID:      obj.PaymentIntent,
+		Payload:        payload,
+	}
+	if obj.Object == "payment_intent" {
+		ev.PaymentID = obj.ID
+	}
+
+	switch se.Type {
+	case "checkout.session.completed":
+		// Delayed payment methods (SEPA, some Bancontact flows) complete the
+		// session before the money arrives; wait for async_payment_succeeded.
+		if obj.PaymentStatus == "unpaid" {
+			ev.Kind = EventPending
+		} else {
+			ev.Kind = EventPaid
+		}
+	case "checkout.session.async_payment_succeeded":
+		ev.Kind = EventPaid
+	case "checkout.session.async_payment_failed", "payment_intent.payment_failed":
+		ev.Kind = EventFailed
+	case "checkout.session.expired":
+		ev.Kind = EventExpired
+	case "charge.refunded":
+		ev.AmountRefunded = obj.AmountRefunded
+		if obj.Refunded || obj.AmountRefunded >= obj.Amount {
+			ev.Kind = EventRefunded
+		} else {
+			ev.Kind = EventPartiallyRefunded
+		}
+	case "charge.dispute.created":
+		ev.Kind = EventDisputed
+		ev.Reason = obj.Reason
+	}
+
+	return ev, nil
+}
+
+func verifyStripeSignature(payload []byte, header, secret string) bool {
+	var timestamp string
+	var signatures []string
+
+	parts := strings.Split(header, ",")
+	for _, part := range parts {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+
+	if timestamp == "" || len(signatures) == 0 {
+		return false
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > 5*time.Minute || age < -5*time.Minute {
+		return false
+	}
+
+	signedPayload := fmt.Sprintf("%d.%s", ts, string(payload))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signedPayload))
+	expectedSig := hex.EncodeToString(mac.Sum(nil))
+
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(expectedSig), []byte(sig)) {
+			return true
+		}
+	}
+
+	return false
+}
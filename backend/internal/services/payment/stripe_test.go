@@ -0,0 +1,180 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripeDecodeEvent(t *testing.T) {
+	tests := []struct {
+		name           string
+		payload        string
+		kind           EventKind
+		orderID        int64
+		registrationID int64
+		paymentID      string
+		refunded       int
+		reason         string
+	}{
+		{
+			name:      "session completed",
+			payload:   `{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"object":"checkout.session","payment_intent":"pi_1","payment_status":"paid","metadata":{"order_id":"12"}}}}`,
+			kind:      EventPaid,
+			orderID:   12,
+			paymentID: "pi_1",
+		},
+		{
+			name:      "session completed before a delayed payment",
+			payload:   `{"id":"evt_2","type":"checkout.session.completed","data":{"object":{"object":"checkout.session","payment_intent":"pi_2","payment_status":"unpaid","metadata":{"order_id":"12"}}}}`,
+			kind:      EventPending,
+			orderID:   12,
+			paymentID: "pi_2",
+		},
+		{
+			name:      "delayed payment succeeded",
+			payload:   `{"id":"evt_3","type":"checkout.session.async_payment_succeeded","data":{"object":{"payment_intent":"pi_2","metadata":{"order_id":"12"}}}}`,
+			kind:      EventPaid,
+			orderID:   12,
+			paymentID: "pi_2",
+		},
+		{
+			name:      "payment intent failed",
+			payload:   `{"id":"evt_4","type":"payment_intent.payment_failed","data":{"object":{"object":"payment_intent","id":"pi_4","metadata":{"order_id":"7"}}}}`,
+			kind:      EventFailed,
+			orderID:   7,
+			paymentID: "pi_4",
+		},
+		{
+			name:           "session expired for a registration",
+			payload:        `{"id":"evt_5","type":"checkout.session.expired","data":{"object":{"metadata":{"registration_id":"3"}}}}`,
+			kind:           EventExpired,
+			registrationID: 3,
+		},
+		{
+			name:      "charge refunded in full",
+			payload:   `{"id":"evt_6","type":"charge.refunded","data":{"object":{"object":"charge","payment_intent":"pi_6","amount":1500,"amount_refunded":1500,"refunded":true}}}`,
+			kind:      EventRefunded,
+			paymentID: "pi_6",
+			refunded:  1500,
+		},
+		{
+			name:      "charge refunded in part",
+			payload:   `{"id":"evt_7","type":"charge.refunded","data":{"object":{"object":"charge","payment_intent":"pi_7","amount":1500,"amount_refunded":500}}}`,
+			kind:      EventPartiallyRefunded,
+			paymentID: "pi_7",
+			refunded:  500,
+		},
+		{
+			name:      "dispute",
+			payload:   `{"id":"evt_8","type":"charge.dispute.created","data":{"object":{"object":"dispute","payment_intent":"pi_8","reason":"fraudulent"}}}`,
+			kind:      EventDisputed,
+			paymentID: "pi_8",
+			reason:    "fraudulent",
+		},
+		{
+			name:    "other event types",
+			payload: `{"id":"evt_9","type":"customer.created","data":{"object":{"object":"customer"}}}`,
+			kind:    EventIgnored,
+		},
+	}
+
+	s := NewStripe("sk_test", "whsec_test")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := s.DecodeEvent([]byte(tt.payload))
+			if err != nil {
+				t.Fatalf("DecodeEvent: %v", err)
+			}
+			if ev.Kind != tt.kind {
+				t.Errorf("Kind = %q, want %q", ev.Kind, tt.kind)
+			}
+			if ev.OrderID != tt.orderID || ev.RegistrationID != tt.registrationID {
+				t.Errorf("OrderID, RegistrationID = %d, %d, want %d, %d", ev.OrderID, ev.RegistrationID, tt.orderID, tt.registrationID)
+			}
+			if ev.PaymentID != tt.paymentID {
+				t.Errorf("PaymentID = %q, want %q", ev.PaymentID, tt.paymentID)
+			}
+			if ev.AmountRefunded != tt.refunded {
+				t.Errorf("AmountRefunded = %d, want %d", ev.AmountRefunded, tt.refunded)
+			}
+			if ev.Reason != tt.reason {
+				t.Errorf("Reason = %q, want %q", ev.Reason, tt.reason)
+			}
+			if string(ev.Payload) != tt.payload {
+				t.Error("Payload is not the original payload")
+			}
+		})
+	}
+}
+
+func TestStripeDecodeEventInvalid(t *testing.T) {
+	s := NewStripe("sk_test", "whsec_test")
+	for _, payload := range []string{`not json`, `{"type":"checkout.session.completed"}`} {
+		if _, err := s.DecodeEvent([]byte(payload)); err == nil {
+			t.Errorf("DecodeEvent(%s) succeeded", payload)
+		}
+	}
+}
+
+// stripeSignature signs payload the way Stripe does
+func stripeSignature(payload, secret string, at time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", at.Unix(), payload)
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestStripeParseWebhook(t *testing.T) {
+	const payload = `{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"payment_intent":"pi_1","payment_status":"paid","metadata":{"order_id":"12"}}}}`
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		wantErr   error
+	}{
+		{"valid", payload, stripeSignature(payload, "whsec_test", now), nil},
+		{"one of several signatures", payload, stripeSignature(payload, "whsec_test", now) + ",v1=deadbeef", nil},
+		{"missing header", payload, "", ErrInvalidSignature},
+		{"other secret", payload, stripeSignature(payload, "whsec_other", now), ErrInvalidSignature},
+		{"tampered payload", strings.Replace(payload, `"12"`, `"13"`, 1), stripeSignature(payload, "whsec_test", now), ErrInvalidSignature},
+		{"too old", payload, stripeSignature(payload, "whsec_test", now.Add(-10*time.Minute)), ErrInvalidSignature},
+		{"no timestamp", payload, "v1=deadbeef", ErrInvalidSignature},
+	}
+
+	s := NewStripe("sk_test", "whsec_test")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/shop/webhook/stripe", strings.NewReader(tt.body))
+			if tt.signature != "" {
+				r.Header.Set("Stripe-Signature", tt.signature)
+			}
+			ev, err := s.ParseWebhook(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseWebhook error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (ev.ID != "evt_1" || ev.Kind != EventPaid) {
+				t.Errorf("ParseWebhook = %+v", ev)
+			}
+		})
+	}
+}
+
+func TestStripeParseWebhookWithoutSecret(t *testing.T) {
+	const payload = `{"id":"evt_1","type":"checkout.session.completed"}`
+	r := httptest.NewRequest("POST", "/shop/webhook/stripe", strings.NewReader(payload))
+	r.Header.Set("Stripe-Signature", stripeSignature(payload, "", time.Now()))
+
+	// Not configured is not a bad signature: the provider should retry
+	_, err := NewStripe("sk_test", "").ParseWebhook(r)
+	if err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("ParseWebhook error = %v, want a configuration error", err)
+	}
+}
@@ -0,0 +1,321 @@
+// Package pdf is a small PDF writer for the documents the backend generates
+// (invoices, labels, QR codes). It only supports what we need: the standard
+// Helvetica fonts with WinAnsi encoding, lines, rectangles, text and images.
+//
+// Coordinates are in points with the origin at the top-left corner of the
+// page; y grows downwards. Text is positioned by its baseline.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Font selects one of the built-in fonts
+type Font int
+
+const (
+	Regular Font = iota
+	Bold
+)
+
+var fontNames = map[Font]string{
+	Regular: "Helvetica",
+	Bold:    "Helvetica-Bold",
+}
+
+// Document is a PDF under construction
+type Document struct {
+	width  float64
+	height float64
+	pages  []*Page
+	images []*Image
+	title  string
+}
+
+// Image is a picture added to a document, drawn with Page.Image
+type Image struct {
+	index  int
+	width  int
+	height int
+	rgb    []byte
+	alpha  []byte
+}
+
+// Page holds the drawing operations of a single page
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+}
+
+// New creates an empty document with the given page size in points
+func New(width, height float64) *Document {
+	return &Document{width: width, height: height}
+}
+
+// SetTitle sets the title shown by PDF viewers
+func (d *Document) SetTitle(title string) {
+	d.title = title
+}
+
+// AddPage appends a blank page and returns it for drawing
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Width and Height return the page size in points
+func (d *Document) Width() float64  { return d.width }
+func (d *Document) Height() float64 { return d.height }
+
+// AddImage adds img to the document so pages can draw it
+func (d *Document) AddImage(img image.Image) *Image {
+	b := img.Bounds()
+	im := &Image{
+		index:  len(d.images),
+		width:  b.Dx(),
+		height: b.Dy(),
+		rgb:    make([]byte, 0, b.Dx()*b.Dy()*3),
+		alpha:  make([]byte, 0, b.Dx()*b.Dy()),
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			// Colours are alpha-premultiplied; the PDF soft mask expects them straight
+			if a > 0 {
+				r, g, bl = r*0xffff/a, g*0xffff/a, bl*0xffff/a
+			}
+			im.rgb = append(im.rgb, byte(r>>8), byte(g>>8), byte(bl>>8))
+			im.alpha = append(im.alpha, byte(a>>8))
+		}
+	}
+	d.images = append(d.images, im)
+	return im
+}
+
+// Text draws s with its baseline starting at (x, y)
+func (p *Page) Text(x, y, size float64, font Font, s string) {
+	fmt.Fprintf(&p.content, "BT /F%d %s Tf %s %s Td (%s) Tj ET\n",
+		font+1, num(size), num(x), num(p.doc.height-y), escapeText(s))
+}
+
+// TextRight draws s so that it ends at x
+func (p *Page) TextRight(x, y, size float64, font Font, s string) {
+	p.Text(x-TextWidth(s, size, font), y, size, font, s)
+}
+
+// TextCenter draws s centered on x
+func (p *Page) TextCenter(x, y, size float64, font Font, s string) {
+	p.Text(x-TextWidth(s, size, font)/2, y, size, font, s)
+}
+
+// Line draws a straight line of the given stroke width
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n",
+		num(width), num(x1), num(p.doc.height-y1), num(x2), num(p.doc.height-y2))
+}
+
+// FillRect fills a rectangle with a gray level between 0 (black) and 1 (white)
+func (p *Page) FillRect(x, y, w, h, gray float64) {
+	fmt.Fprintf(&p.content, "q %s g %s %s %s %s re f Q\n",
+		num(gray), num(x), num(p.doc.height-y-h), num(w), num(h))
+}
+
+// SetFillRGB sets the colour of the text drawn after it
+func (p *Page) SetFillRGB(r, g, b uint8) {
+	fmt.Fprintf(&p.content, "%s %s %s rg\n", num(float64(r)/255), num(float64(g)/255), num(float64(b)/255))
+}
+
+// FillRectRGB fills a rectangle with a colour
+func (p *Page) FillRectRGB(x, y, w, h float64, r, g, b uint8) {
+	fmt.Fprintf(&p.content, "q %s %s %s rg %s %s %s %s re f Q\n",
+		num(float64(r)/255), num(float64(g)/255), num(float64(b)/255),
+		num(x), num(p.doc.height-y-h), num(w), num(h))
+}
+
+// Image draws img scaled into the rectangle with its top-left corner at (x, y)
+func (p *Page) Image(img *Image, x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n",
+		num(w), num(h), num(x), num(p.doc.height-y-h), img.index+1)
+}
+
+// StrokeRect outlines a rectangle
+func (p *Page) StrokeRect(x, y, w, h, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s %s %s re S\n",
+		num(width), num(x), num(p.doc.height-y-h), num(w), num(h))
+}
+
+// WrapText splits s into lines no wider than maxWidth, breaking on spaces.
+// Existing newlines are kept.
+func WrapText(s string, size float64, font Font, maxWidth float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		words := strings.Fields(paragraph)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := words[0]
+		for _, w := range words[1:] {
+			if TextWidth(line+" "+w, size, font) > maxWidth {
+				lines = append(lines, line)
+				line = w
+				continue
+			}
+			line += " " + w
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Bytes serialises the document
+func (d *Document) Bytes() ([]byte, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+
+	// Object numbers: 1 catalog, 2 pages, 3-4 fonts, 5 info, then per page
+	// a page object followed by its content stream, then per image the
+	// image followed by its alpha mask.
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+i*2)
+	}
+
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	for _, f := range []Font{Regular, Bold} {
+		obj(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fontNames[f]))
+	}
+	obj(fmt.Sprintf("<< /Producer (Geocaching Brughia) /Title (%s) >>", escapeText(d.title)))
+
+	firstImage := 6 + len(d.pages)*2
+	xobjects := ""
+	if len(d.images) > 0 {
+		refs := make([]string, len(d.images))
+		for i := range d.images {
+			refs[i] = fmt.Sprintf("/Im%d %d 0 R", i+1, firstImage+i*2)
+		}
+		xobjects = fmt.Sprintf(" /XObject << %s >>", strings.Join(refs, " "))
+	}
+
+	for i, p := range d.pages {
+		stream, err := deflate(p.content.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >>%s >> /Contents %d 0 R >>",
+			num(d.width), num(d.height), xobjects, 7+i*2))
+		obj(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(stream), stream))
+	}
+
+	for i, im := range d.images {
+		rgb, err := deflate(im.rgb)
+		if err != nil {
+			return nil, err
+		}
+		alpha, err := deflate(im.alpha)
+		if err != nil {
+			return nil, err
+		}
+		obj(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /SMask %d 0 R /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+			im.width, im.height, firstImage+i*2+1, len(rgb), rgb))
+		obj(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+			im.width, im.height, len(alpha), alpha))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes(), nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// num formats a coordinate without trailing zeros
+func num(f float64) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// escapeText converts s to WinAnsi and escapes it for a PDF string literal.
+// Characters outside WinAnsi are replaced by '?'.
+func escapeText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		c, ok := toWinAnsi(r)
+		if !ok {
+			c = '?'
+		}
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n', '\r', '\t':
+			b.WriteByte(' ')
+		default:
+			if c < 0x20 {
+				continue
+			}
+			if c >= 0x80 {
+				fmt.Fprintf(&b, "\\%03o", c)
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// winAnsiExtras maps the runes WinAnsi places in 0x80-0x9F
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+func toWinAnsi(r rune) (byte, bool) {
+	if r < 0x80 || (r >= 0xA0 && r <= 0xFF) {
+		return byte(r), true
+	}
+	c, ok := winAnsiExtras[r]
+	return c, ok
+}
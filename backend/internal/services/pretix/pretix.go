@@ -0,0 +1,326 @@
+// Package pretix is a small client for the pretix REST API.
+//
+// Only the read side is used: events, quotas and orders of one organizer.
+// The base URL is configurable so self-hosted instances and local stub
+// servers work the same as pretix.eu.
+package pretix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultAPIURL = "https://pretix.eu/api/v1"
+
+// Order status codes as used by pretix
+const (
+	StatusPending  = "n"
+	StatusPaid     = "p"
+	StatusExpired  = "e"
+	StatusCanceled = "c"
+)
+
+type Client struct {
+	baseURL   string
+	organizer string
+	token     string
+	client    *http.Client
+}
+
+func New(baseURL, organizer, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+	return &Client{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		organizer: organizer,
+		token:     token,
+		client:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (c *Client) Organizer() string { return c.organizer }
+
+// APIError carries a message from pretix that is safe to show
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("pretix: %s", e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from pretix
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// LocalizedString is a pretix i18n field, keyed by lowercase language code
+type LocalizedString map[string]string
+
+// In returns the text in lang, falling back to English and then any language
+func (s LocalizedString) In(lang string) string {
+	if v := s[strings.ToLower(lang)]; v != "" {
+		return v
+	}
+	if v := s["en"]; v != "" {
+		return v
+	}
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+type Event struct {
+	Slug      string          `json:"slug"`
+	Name      LocalizedString `json:"name"`
+	Live      bool            `json:"live"`
+	DateFrom  string          `json:"date_from"`
+	DateTo    string          `json:"date_to"`
+	PublicURL string          `json:"public_url"`
+}
+
+type Quota struct {
+	ID              int64  `json:"id"`
+	Name            string `json:"name"`
+	Size            *int   `json:"size"`
+	Closed          bool   `json:"closed"`
+	Available       bool   `json:"available"`
+	AvailableNumber *int   `json:"available_number"`
+}
+
+// Availability is the detailed state of one quota
+type Availability struct {
+	Available       bool `json:"available"`
+	AvailableNumber *int `json:"available_number"`
+	TotalSize       *int `json:"total_size"`
+	PaidOrders      int  `json:"paid_orders"`
+	PendingOrders   int  `json:"pending_orders"`
+	ExitedOrders    int  `json:"exited_orders"`
+	CartPositions   int  `json:"cart_positions"`
+	WaitingList     int  `json:"waiting_list"`
+}
+
+type Order struct {
+	Code      string     `json:"code"`
+	Status    string     `json:"status"`
+	Testmode  bool       `json:"testmode"`
+	Email     string     `json:"email"`
+	Locale    string     `json:"locale"`
+	Datetime  time.Time  `json:"datetime"`
+	Total     string     `json:"total"`
+	Positions []Position `json:"positions"`
+}
+
+type Position struct {
+	ID           int64     `json:"id"`
+	Item         int64     `json:"item"`
+	Price        string    `json:"price"`
+	AttendeeName string    `json:"attendee_name"`
+	Checkins     []Checkin `json:"checkins"`
+}
+
+type Checkin struct {
+	List     int64     `json:"list"`
+	Datetime time.Time `json:"datetime"`
+	Type     string    `json:"type"`
+}
+
+// TotalCents returns the order total in cents
+func (o Order) TotalCents() int {
+	return parseDecimalCents(o.Total)
+}
+
+// CheckedIn counts the positions that have been scanned in at least once
+func (o Order) CheckedIn() int {
+	n := 0
+	for _, p := range o.Positions {
+		for _, c := range p.Checkins {
+			// Older pretix versions have no type; every check-in was an entry
+			if c.Type == "" || c.Type == "entry" {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// Notification is the body pretix posts to a webhook. It only names the
+// object; the order is fetched back from the API, which is what
+// authenticates the call.
+type Notification struct {
+	NotificationID int64  `json:"notification_id"`
+	Organizer      string `json:"organizer"`
+	Event          string `json:"event"`
+	Code           string `json:"code"`
+	Action         string `json:"action"`
+}
+
+// ParseNotification decodes a webhook body
+func ParseNotification(body io.Reader) (*Notification, error) {
+	var n Notification
+	if err := json.NewDecoder(io.LimitReader(body, 64*1024)).Decode(&n); err != nil {
+		return nil, err
+	}
+	if n.Organizer == "" || n.Event == "" || n.Action == "" {
+		return nil, errors.New("pretix: incomplete notification")
+	}
+	return &n, nil
+}
+
+// IsOrderAction reports whether the notification is about an order
+func (n *Notification) IsOrderAction() bool {
+	return strings.HasPrefix(n.Action, "pretix.event.order.") && n.Code != ""
+}
+
+func (c *Client) eventPath(slug string) string {
+	return "/organizers/" + url.PathEscape(c.organizer) + "/events/" + url.PathEscape(slug)
+}
+
+func (c *Client) Event(ctx context.Context, slug string) (*Event, error) {
+	var ev Event
+	if err := c.get(ctx, c.eventPath(slug)+"/", &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func (c *Client) Quotas(ctx context.Context, slug string) ([]Quota, error) {
+	var quotas []Quota
+	err := c.list(ctx, c.eventPath(slug)+"/quotas/?with_availability=true", func(raw json.RawMessage) error {
+		var q Quota
+		if err := json.Unmarshal(raw, &q); err != nil {
+			return err
+		}
+		quotas = append(quotas, q)
+		return nil
+	})
+	return quotas, err
+}
+
+func (c *Client) QuotaAvailability(ctx context.Context, slug string, quotaID int64) (*Availability, error) {
+	var a Availability
+	path := c.eventPath(slug) + "/quotas/" + strconv.FormatInt(quotaID, 10) + "/availability/"
+	if err := c.get(ctx, path, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Orders returns the orders of an event. A non-zero modifiedSince only
+// returns orders changed after it.
+func (c *Client) Orders(ctx context.Context, slug string, modifiedSince time.Time) ([]Order, error) {
+	path := c.eventPath(slug) + "/orders/"
+	if !modifiedSince.IsZero() {
+		path += "?modified_since=" + url.QueryEscape(modifiedSince.UTC().Format(time.RFC3339))
+	}
+
+	var orders []Order
+	err := c.list(ctx, path, func(raw json.RawMessage) error {
+		var o Order
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return err
+		}
+		orders = append(orders, o)
+		return nil
+	})
+	return orders, err
+}
+
+func (c *Client) Order(ctx context.Context, slug, code string) (*Order, error) {
+	var o Order
+	if err := c.get(ctx, c.eventPath(slug)+"/orders/"+url.PathEscape(code)+"/", &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// maxPages stops a misbehaving server from paginating forever
+const maxPages = 200
+
+// list follows pretix pagination and hands every result to fn
+func (c *Client) list(ctx context.Context, path string, fn func(json.RawMessage) error) error {
+	next := c.baseURL + path
+	for page := 0; next != "" && page < maxPages; page++ {
+		var resp struct {
+			Next    string            `json:"next"`
+			Results []json.RawMessage `json:"results"`
+		}
+		if err := c.do(ctx, next, &resp); err != nil {
+			return err
+		}
+		for _, raw := range resp.Results {
+			if err := fn(raw); err != nil {
+				return err
+			}
+		}
+		// Never send the token to a host we were not configured for
+		if resp.Next != "" && !strings.HasPrefix(resp.Next, c.baseURL+"/") {
+			return &APIError{Message: "pagination left the configured API URL"}
+		}
+		next = resp.Next
+	}
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	return c.do(ctx, c.baseURL+path, out)
+}
+
+func (c *Client) do(ctx context.Context, fullURL string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", fullURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to pretix: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var pretixErr struct {
+			Detail string `json:"detail"`
+		}
+		json.Unmarshal(body, &pretixErr)
+		msg := pretixErr.Detail
+		if msg == "" {
+			msg = fmt.Sprintf("request failed with status %d", resp.StatusCode)
+		}
+		return &APIError{Status: resp.StatusCode, Message: msg}
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func parseDecimalCents(value string) int {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(value), ".")
+	negative := strings.HasPrefix(whole, "-")
+	w, _ := strconv.Atoi(strings.TrimPrefix(whole, "-"))
+	frac = (frac + "00")[:2]
+	f, _ := strconv.Atoi(frac)
+	cents := w*100 + f
+	if negative {
+		cents = -cents
+	}
+	return cents
+}
@@ -0,0 +1,156 @@
+package pretix
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer serves pretix API paths below /api/v1 and rejects requests
+// without the token
+func newTestServer(t *testing.T, routes map[string]string) (*httptest.Server, *[]string) {
+	t.Helper()
+	requests := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		if r.Header.Get("Authorization") != "Token tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"detail":"Invalid token."}`)
+			return
+		}
+		body, ok := routes[strings.TrimPrefix(r.URL.RequestURI(), "/api/v1")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"detail":"Not found."}`)
+			return
+		}
+		fmt.Fprint(w, strings.ReplaceAll(body, "SERVER", server.URL))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestOrders(t *testing.T) {
+	server, requests := newTestServer(t, map[string]string{
+		"/organizers/brughia/events/mega/orders/": `{"next":"SERVER/api/v1/organizers/brughia/events/mega/orders/?page=2","results":[
+			{"code":"ABC12","status":"p","email":"a@example.com","datetime":"2099-01-01T10:00:00Z","total":"25.00",
+			 "positions":[{"id":1,"checkins":[{"list":1,"type":"entry"}]},{"id":2,"checkins":[{"list":1,"type":"exit"}]},{"id":3,"checkins":[{"list":1}]}]}
+		]}`,
+		"/organizers/brughia/events/mega/orders/?page=2": `{"next":null,"results":[
+			{"code":"DEF34","status":"n","testmode":true,"total":"7.5","positions":[{"id":4}]}
+		]}`,
+		"/organizers/brughia/events/mega/orders/?modified_since=2099-01-02T03%3A04%3A05Z": `{"next":null,"results":[]}`,
+	})
+	c := New(server.URL+"/api/v1/", "brughia", "tok")
+	ctx := context.Background()
+
+	orders, err := c.Orders(ctx, "mega", time.Time{})
+	if err != nil {
+		t.Fatalf("Orders: %v", err)
+	}
+	if len(orders) != 2 || orders[0].Code != "ABC12" || orders[1].Code != "DEF34" {
+		t.Fatalf("Orders = %+v", orders)
+	}
+	if orders[0].TotalCents() != 2500 || orders[1].TotalCents() != 750 {
+		t.Errorf("TotalCents = %d, %d", orders[0].TotalCents(), orders[1].TotalCents())
+	}
+	// An exit scan is not an entry; a check-in without type is
+	if got := orders[0].CheckedIn(); got != 2 {
+		t.Errorf("CheckedIn = %d, want 2", got)
+	}
+	if !orders[1].Testmode || orders[1].Status != StatusPending {
+		t.Errorf("second order = %+v", orders[1])
+	}
+
+	since := time.Date(2099, 1, 2, 4, 4, 5, 0, time.FixedZone("CET", 3600))
+	if orders, err := c.Orders(ctx, "mega", since); err != nil || len(orders) != 0 {
+		t.Fatalf("Orders since = %+v, %v", orders, err)
+	}
+	if last := (*requests)[len(*requests)-1]; last != "/api/v1/organizers/brughia/events/mega/orders/?modified_since=2099-01-02T03%3A04%3A05Z" {
+		t.Errorf("last request = %s", last)
+	}
+}
+
+func TestEventAndQuotas(t *testing.T) {
+	server, _ := newTestServer(t, map[string]string{
+		"/organizers/brughia/events/mega/": `{"slug":"mega","name":{"nl":"Mega-event","en":"Mega event"},"live":true,"public_url":"https://pretix.test/brughia/mega/"}`,
+		"/organizers/brughia/events/mega/quotas/?with_availability=true": `{"next":null,"results":[
+			{"id":7,"name":"Tickets","size":100,"closed":false,"available":true,"available_number":40}
+		]}`,
+		"/organizers/brughia/events/mega/quotas/7/availability/": `{"available":true,"available_number":40,"total_size":100,"paid_orders":55,"pending_orders":5}`,
+	})
+	c := New(server.URL+"/api/v1", "brughia", "tok")
+	ctx := context.Background()
+
+	ev, err := c.Event(ctx, "mega")
+	if err != nil {
+		t.Fatalf("Event: %v", err)
+	}
+	if !ev.Live || ev.Name.In("NL") != "Mega-event" || ev.Name.In("fr") != "Mega event" || ev.PublicURL != "https://pretix.test/brughia/mega/" {
+		t.Errorf("Event = %+v", ev)
+	}
+
+	quotas, err := c.Quotas(ctx, "mega")
+	if err != nil || len(quotas) != 1 || quotas[0].ID != 7 || *quotas[0].Size != 100 {
+		t.Fatalf("Quotas = %+v, %v", quotas, err)
+	}
+	a, err := c.QuotaAvailability(ctx, "mega", 7)
+	if err != nil || *a.AvailableNumber != 40 || *a.TotalSize != 100 || a.PaidOrders != 55 || a.PendingOrders != 5 {
+		t.Fatalf("QuotaAvailability = %+v, %v", a, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server, requests := newTestServer(t, map[string]string{
+		"/organizers/brughia/events/mega/orders/": `{"next":"https://elsewhere.test/api/v1/organizers/brughia/events/mega/orders/?page=2","results":[]}`,
+	})
+	ctx := context.Background()
+
+	_, err := New(server.URL+"/api/v1", "brughia", "tok").Order(ctx, "mega", "NOPE1")
+	if !IsNotFound(err) || err.Error() != "pretix: Not found." {
+		t.Errorf("Order of an unknown code error = %v, want not found", err)
+	}
+
+	_, err = New(server.URL+"/api/v1", "brughia", "wrong").Event(ctx, "mega")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Status != http.StatusUnauthorized || apiErr.Message != "Invalid token." {
+		t.Errorf("Event with a wrong token error = %v", err)
+	}
+
+	// The token is never sent to another host
+	n := len(*requests)
+	if _, err := New(server.URL+"/api/v1", "brughia", "tok").Orders(ctx, "mega", time.Time{}); err == nil {
+		t.Error("Orders followed pagination to another host")
+	}
+	if len(*requests) != n+1 {
+		t.Errorf("%d requests, want 1", len(*requests)-n)
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	n, err := ParseNotification(strings.NewReader(`{"notification_id":1,"organizer":"brughia","event":"mega","code":"ABC12","action":"pretix.event.order.paid"}`))
+	if err != nil || !n.IsOrderAction() || n.Code != "ABC12" {
+		t.Fatalf("ParseNotification = %+v, %v", n, err)
+	}
+	n, err = ParseNotification(strings.NewReader(`{"organizer":"brughia","event":"mega","action":"pretix.event.live.activated"}`))
+	if err != nil || n.IsOrderAction() {
+		t.Errorf("event notification = %+v, %v", n, err)
+	}
+	for _, body := range []string{`{"organizer":"brughia","event":"mega"}`, `not json`} {
+		if _, err := ParseNotification(strings.NewReader(body)); err == nil {
+			t.Errorf("ParseNotification(%s) succeeded", body)
+		}
+	}
+}
+
+func TestParseDecimalCents(t *testing.T) {
+	for value, cents := range map[string]int{"25.00": 2500, "7.5": 750, "3": 300, "-2.50": -250, "0.056": 5, "": 0} {
+		if got := parseDecimalCents(value); got != cents {
+			t.Errorf("parseDecimalCents(%q) = %d, want %d", value, got, cents)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Package qr renders QR codes as PNG, SVG or PDF with custom colours, an
+// optional logo in the centre and an optional caption underneath.
+//
+// Captions need a font renderer, which only SVG and PDF have; PNG output
+// rejects them with ErrCaptionNeedsVector.
+package qr
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/pdf"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// defaultLogo is the club logo used when no other logo is configured
+//
+//go:embed logo.png
+var defaultLogo []byte
+
+var ErrCaptionNeedsVector = errors.New("captions are only available for SVG and PDF output")
+
+// logoFraction is the width of the logo relative to the symbol. Together
+// with its padding it stays well inside what level Q can recover.
+const logoFraction = 0.22
+
+// Options describes one QR code
+type Options struct {
+	Content string
+	// Size is the width in pixels (PNG, SVG) or points (PDF)
+	Size       int
+	Level      qrcode.RecoveryLevel
+	Foreground color.NRGBA
+	// Background with zero alpha renders transparent
+	Background color.NRGBA
+	// QuietZone keeps the 4 module white border scanners expect
+	QuietZone bool
+	Logo      image.Image
+	Caption   string
+}
+
+// DefaultLogo decodes the built-in club logo
+func DefaultLogo() (image.Image, error) {
+	return DecodeLogo(defaultLogo)
+}
+
+// DecodeLogo decodes a PNG or JPEG logo
+func DecodeLogo(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// ParseLevel maps the L/M/Q/H error correction letters to a recovery level
+func ParseLevel(s string) (qrcode.RecoveryLevel, bool) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return qrcode.Low, true
+	case "M", "":
+		return qrcode.Medium, true
+	case "Q":
+		return qrcode.High, true
+	case "H":
+		return qrcode.Highest, true
+	}
+	return qrcode.Medium, false
+}
+
+// ParseColor parses "rrggbb" or "#rrggbb"; "transparent" gives zero alpha
+func ParseColor(s string) (color.NRGBA, bool) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "#")
+	if s == "transparent" {
+		return color.NRGBA{}, true
+	}
+	if len(s) != 6 {
+		return color.NRGBA{}, false
+	}
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(s, "%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: 255}, true
+}
+
+// matrix returns the modules of the code, dark ones true
+func (o Options) matrix() ([][]bool, error) {
+	level := o.Level
+	// The logo hides modules; make sure enough of them can be recovered
+	if o.Logo != nil && level < qrcode.High {
+		level = qrcode.High
+	}
+	q, err := qrcode.New(o.Content, level)
+	if err != nil {
+		return nil, err
+	}
+	q.DisableBorder = !o.QuietZone
+	return q.Bitmap(), nil
+}
+
+// logoBox returns the square, in modules, that the logo and its padding
+// cover, snapped to whole modules
+func logoBox(modules int) (start, size int) {
+	size = int(math.Ceil(float64(modules) * (logoFraction + 0.04)))
+	if size%2 != modules%2 {
+		size++
+	}
+	return (modules - size) / 2, size
+}
+
+// captionLayout picks a font size that fits the caption on one line
+func captionLayout(caption string, width float64) (fontSize, height float64) {
+	if caption == "" {
+		return 0, 0
+	}
+	fontSize = width / 14
+	if w := pdf.TextWidth(caption, fontSize, pdf.Bold); w > width*0.92 {
+		fontSize *= width * 0.92 / w
+	}
+	return fontSize, fontSize * 2
+}
+
+// logoBackground is the colour under the logo; a transparent code still
+// needs an opaque patch for the logo to be readable
+func (o Options) logoBackground() color.NRGBA {
+	if o.Background.A == 0 {
+		return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	return o.Background
+}
+
+// PNG renders the code as a square PNG of o.Size pixels
+func PNG(o Options) ([]byte, error) {
+	if o.Caption != "" {
+		return nil, ErrCaptionNeedsVector
+	}
+	img, err := Image(o)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Image renders the code as an image of o.Size pixels. Modules are whole
+// pixels so the code stays sharp; the remainder becomes margin.
+func Image(o Options) (image.Image, error) {
+	bitmap, err := o.matrix()
+	if err != nil {
+		return nil, err
+	}
+	n := len(bitmap)
+	modulePx := o.Size / n
+	if modulePx < 1 {
+		modulePx = 1
+	}
+	size := o.Size
+	if size < n {
+		size = n
+	}
+	offset := (size - modulePx*n) / 2
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{o.Background}, image.Point{}, draw.Src)
+	fg := &image.Uniform{o.Foreground}
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				r := image.Rect(offset+x*modulePx, offset+y*modulePx, offset+(x+1)*modulePx, offset+(y+1)*modulePx)
+				draw.Draw(img, r, fg, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	if o.Logo != nil {
+		start, box := logoBox(n)
+		patch := image.Rect(offset+start*modulePx, offset+start*modulePx,
+			offset+(start+box)*modulePx, offset+(start+box)*modulePx)
+		draw.Draw(img, patch, &image.Uniform{o.logoBackground()}, image.Point{}, draw.Src)
+
+		logoPx := int(float64(modulePx*n) * logoFraction)
+		logo := scale(o.Logo, logoPx, logoPx)
+		at := image.Pt(offset+(modulePx*n-logoPx)/2, offset+(modulePx*n-logoPx)/2)
+		draw.Draw(img, logo.Bounds().Add(at), logo, image.Point{}, draw.Over)
+	}
+
+	return img, nil
+}
+
+// SVG renders the code as an SVG of o.Size units wide
+func SVG(o Options) ([]byte, error) {
+	bitmap, err := o.matrix()
+	if err != nil {
+		return nil, err
+	}
+	n := len(bitmap)
+	unit := float64(o.Size) / float64(n)
+	fontSize, captionHeight := captionLayout(o.Caption, float64(o.Size))
+	height := float64(o.Size) + captionHeight
+
+	// Everything is drawn in module units, the caption included
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%s" viewBox="0 0 %d %s" shape-rendering="crispEdges">`,
+		o.Size, svgNum(height), n, svgNum(height/unit))
+	if o.Background.A != 0 {
+		fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`, hexColor(o.Background))
+	}
+
+	// One path, one rectangle per horizontal run of dark modules
+	fmt.Fprintf(&b, `<path fill="%s" d="`, hexColor(o.Foreground))
+	for y, row := range bitmap {
+		for x := 0; x < n; x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < n && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", x, y, run, run)
+			x += run - 1
+		}
+	}
+	b.WriteString(`"/>`)
+
+	if o.Logo != nil {
+		start, box := logoBox(n)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`,
+			start, start, box, box, hexColor(o.logoBackground()))
+
+		var logoPNG bytes.Buffer
+		logoPx := int(float64(o.Size) * logoFraction)
+		if err := png.Encode(&logoPNG, scale(o.Logo, logoPx*2, logoPx*2)); err != nil {
+			return nil, err
+		}
+		logoSize := float64(n) * logoFraction
+		at := (float64(n) - logoSize) / 2
+		fmt.Fprintf(&b, `<image x="%s" y="%s" width="%s" height="%s" href="data:image/png;base64,%s"/>`,
+			svgNum(at), svgNum(at), svgNum(logoSize), svgNum(logoSize), base64.StdEncoding.EncodeToString(logoPNG.Bytes()))
+	}
+
+	if captionHeight > 0 {
+		fmt.Fprintf(&b, `<text x="%s" y="%s" font-family="Helvetica, Arial, sans-serif" font-weight="bold" font-size="%s" text-anchor="middle" fill="%s">%s</text>`,
+			svgNum(float64(n)/2), svgNum((float64(o.Size)+captionHeight*0.68)/unit), svgNum(fontSize/unit),
+			hexColor(o.Foreground), html.EscapeString(o.Caption))
+	}
+
+	b.WriteString("</svg>")
+	return []byte(b.String()), nil
+}
+
+// PDF renders the code on a page of o.Size points wide, for print
+func PDF(o Options) ([]byte, error) {
+	bitmap, err := o.matrix()
+	if err != nil {
+		return nil, err
+	}
+	n := len(bitmap)
+	size := float64(o.Size)
+	unit := size / float64(n)
+	fontSize, captionHeight := captionLayout(o.Caption, size)
+
+	doc := pdf.New(size, size+captionHeight)
+	doc.SetTitle(o.Content)
+	page := doc.AddPage()
+
+	if o.Background.A != 0 {
+		page.FillRectRGB(0, 0, size, size+captionHeight, o.Background.R, o.Background.G, o.Background.B)
+	}
+
+	fg := o.Foreground
+	for y, row := range bitmap {
+		for x := 0; x < n; x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < n && row[x+run] {
+				run++
+			}
+			page.FillRectRGB(float64(x)*unit, float64(y)*unit, float64(run)*unit, unit, fg.R, fg.G, fg.B)
+			x += run - 1
+		}
+	}
+
+	if o.Logo != nil {
+		start, box := logoBox(n)
+		bg := o.logoBackground()
+		page.FillRectRGB(float64(start)*unit, float64(start)*unit, float64(box)*unit, float64(box)*unit, bg.R, bg.G, bg.B)
+
+		logoPx := int(size * logoFraction * 2)
+		logo := doc.AddImage(scale(o.Logo, logoPx, logoPx))
+		logoSize := size * logoFraction
+		page.Image(logo, (size-logoSize)/2, (size-logoSize)/2, logoSize, logoSize)
+	}
+
+	if captionHeight > 0 {
+		page.SetFillRGB(fg.R, fg.G, fg.B)
+		page.TextCenter(size/2, size+captionHeight*0.68, fontSize, pdf.Bold, o.Caption)
+	}
+
+	return doc.Bytes()
+}
+
+// scale resizes src to w×h with bilinear filtering, keeping its aspect ratio
+// inside the box
+func scale(src image.Image, w, h int) *image.NRGBA {
+	sb := src.Bounds()
+	if sb.Dx() == 0 || sb.Dy() == 0 || w < 1 || h < 1 {
+		return image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	}
+	ratio := math.Min(float64(w)/float64(sb.Dx()), float64(h)/float64(sb.Dy()))
+	dw, dh := int(float64(sb.Dx())*ratio), int(float64(sb.Dy())*ratio)
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	offX, offY := (w-dw)/2, (h-dh)/2
+	for y := 0; y < dh; y++ {
+		sy := (float64(y)+0.5)/ratio - 0.5
+		for x := 0; x < dw; x++ {
+			sx := (float64(x)+0.5)/ratio - 0.5
+			dst.SetNRGBA(offX+x, offY+y, bilinear(src, sb, sx, sy))
+		}
+	}
+	return dst
+}
+
+func bilinear(src image.Image, b image.Rectangle, x, y float64) color.NRGBA {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	clamp := func(v, lo, hi int) int {
+		if v < lo {
+			return lo
+		}
+		if v > hi {
+			return hi
+		}
+		return v
+	}
+	at := func(px, py int) [4]float64 {
+		r, g, bl, a := src.At(b.Min.X+clamp(px, 0, b.Dx()-1), b.Min.Y+clamp(py, 0, b.Dy()-1)).RGBA()
+		return [4]float64{float64(r), float64(g), float64(bl), float64(a)}
+	}
+
+	c00, c10, c01, c11 := at(x0, y0), at(x0+1, y0), at(x0, y0+1), at(x0+1, y0+1)
+	var out [4]float64
+	for i := range out {
+		top := c00[i]*(1-fx) + c10[i]*fx
+		bottom := c01[i]*(1-fx) + c11[i]*fx
+		out[i] = top*(1-fy) + bottom*fy
+	}
+
+	// Interpolated premultiplied values; convert back to straight alpha
+	a := out[3]
+	if a == 0 {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{
+		R: uint8(out[0] * 0xffff / a / 257),
+		G: uint8(out[1] * 0xffff / a / 257),
+		B: uint8(out[2] * 0xffff / a / 257),
+		A: uint8(a / 257),
+	}
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func svgNum(f float64) string {
+	s := fmt.Sprintf("%.3f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
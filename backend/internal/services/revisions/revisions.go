@@ -0,0 +1,545 @@
+// Package revisions keeps the saved versions of content as JSON snapshots
+// of its database rows, and restores them.
+package revisions
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxRevisions is how many revisions are kept per entity
+const maxRevisions = 100
+
+// ErrNotFound is returned for unknown revisions and for entities without
+// rows to snapshot
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a revision refers to rows that no longer
+// exist, such as a language removed since
+var ErrConflict = errors.New("revision refers to removed content")
+
+// source describes where the rows of an entity are: one row of table
+// identified by key, and the rows of each child table referring to it
+type source struct {
+	table    string
+	key      string
+	children []child
+	// keep are columns a restore leaves alone, such as stock that changes
+	// with orders
+	keep []string
+}
+
+type child struct {
+	table  string
+	parent string
+	// under is the child whose rows parent refers to, for rows one level
+	// further down such as the captions of an event's photos
+	under *child
+}
+
+// where selects the rows of the child belonging to an entity
+func (c child) where() string {
+	if c.under == nil {
+		return c.parent + " = ?"
+	}
+	return fmt.Sprintf("%s IN (SELECT id FROM %s WHERE %s)", c.parent, c.under.table, c.under.where())
+}
+
+var eventMedia = child{table: "event_media", parent: "event_id"}
+
+// sources are the entities with a revision history. Static content has no
+// main row: its entity ID is the property.
+var sources = map[string]source{
+	"event": {
+		table: "events", key: "id", keep: []string{"uuid"},
+		children: []child{
+			{table: "event_translations", parent: "event_id"},
+			eventMedia,
+			{table: "event_media_translations", parent: "media_id", under: &eventMedia},
+		},
+	},
+	"message": {
+		table: "messages", key: "id",
+		children: []child{{table: "message_translations", parent: "message_id"}},
+	},
+	"shop_item": {
+		table: "shop_items", key: "id", keep: []string{"stock_quantity"},
+		children: []child{{table: "shop_item_translations", parent: "item_id"}},
+	},
+	"static_content": {
+		children: []child{{table: "static_content", parent: "property"}},
+	},
+	"golden_key_month": {
+		table: "golden_key_months", key: "id",
+		children: []child{{table: "golden_key_hints", parent: "month_id"}},
+	},
+}
+
+// untracked columns change on every save and are not part of a snapshot
+var untracked = map[string]bool{"created_at": true, "updated_at": true}
+
+// Known reports whether entity has a revision history
+func Known(entity string) bool {
+	_, ok := sources[entity]
+	return ok
+}
+
+// Snapshot holds the rows of an entity, column name to value
+type Snapshot struct {
+	Row      map[string]interface{}              `json:"row,omitempty"`
+	Children map[string][]map[string]interface{} `json:"children"`
+}
+
+type Revision struct {
+	ID       int64  `json:"id"`
+	Entity   string `json:"entity"`
+	EntityID string `json:"entity_id"`
+	AuthorID *int64 `json:"author_id,omitempty"`
+	// Author is empty for versions saved outside the history, recorded
+	// just before the next tracked change
+	Author    string    `json:"author"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      *Snapshot `json:"data,omitempty"`
+}
+
+// Author is the user saving a version
+type Author struct {
+	ID   int64
+	Name string
+}
+
+// querier is a *database.DB or a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Take snapshots the current rows of an entity
+func Take(db querier, entity, id string) (*Snapshot, error) {
+	src, ok := sources[entity]
+	if !ok {
+		return nil, fmt.Errorf("unknown entity %q", entity)
+	}
+	s := &Snapshot{Children: map[string][]map[string]interface{}{}}
+	if src.table != "" {
+		rows, err := selectRows(db, src.table, src.key+" = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, ErrNotFound
+		}
+		s.Row = rows[0]
+	}
+	for _, c := range src.children {
+		rows, err := selectRows(db, c.table, c.where(), id)
+		if err != nil {
+			return nil, err
+		}
+		s.Children[c.table] = rows
+	}
+	if s.Row == nil && len(s.Children[src.children[0].table]) == 0 {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+// Record saves the current state of an entity as a revision, unless it is
+// the same as the latest one. author is nil for a version of unknown origin.
+// It returns the ID of the new revision, or 0 when nothing changed.
+func Record(db *database.DB, entity, id string, author *Author, note string) (int64, error) {
+	s, err := Take(db, entity, id)
+	if err != nil {
+		return 0, err
+	}
+	return RecordSnapshot(db, entity, id, s, author, note)
+}
+
+// RecordSnapshot saves s, taken earlier, as a revision of an entity, as
+// Record does. It lets a state from before a change be kept only once the
+// change succeeded.
+func RecordSnapshot(db *database.DB, entity, id string, s *Snapshot, author *Author, note string) (int64, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var latest string
+	err = db.QueryRow("SELECT data FROM revisions WHERE entity = ? AND entity_id = ? ORDER BY id DESC LIMIT 1",
+		entity, id).Scan(&latest)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if latest == string(data) && note == "" {
+		return 0, nil
+	}
+
+	var authorID *int64
+	name := ""
+	if author != nil {
+		authorID, name = &author.ID, author.Name
+	}
+	result, err := db.Exec("INSERT INTO revisions (entity, entity_id, data, author_id, author, note) VALUES (?, ?, ?, ?, ?, ?)",
+		entity, id, string(data), authorID, name, note)
+	if err != nil {
+		return 0, err
+	}
+	revisionID, _ := result.LastInsertId()
+
+	_, err = db.Exec(`
+		DELETE FROM revisions WHERE entity = ? AND entity_id = ? AND id NOT IN (
+			SELECT id FROM revisions WHERE entity = ? AND entity_id = ? ORDER BY id DESC LIMIT ?
+		)`, entity, id, entity, id, maxRevisions)
+	return revisionID, err
+}
+
+// List returns the revisions of an entity, newest first, without their data
+func List(db *database.DB, entity, id string) ([]Revision, error) {
+	rows, err := db.Query(`
+		SELECT id, entity, entity_id, author_id, author, note, created_at
+		FROM revisions WHERE entity = ? AND entity_id = ? ORDER BY id DESC
+	`, entity, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []Revision{}
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.ID, &r.Entity, &r.EntityID, &r.AuthorID, &r.Author, &r.Note, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// Get returns a revision with its data
+func Get(db *database.DB, id int64) (*Revision, error) {
+	return get(db, "id = ?", id)
+}
+
+// Previous returns the revision of the same entity before r, or nil
+func Previous(db *database.DB, r *Revision) (*Revision, error) {
+	prev, err := get(db, "entity = ? AND entity_id = ? AND id < ? ORDER BY id DESC LIMIT 1", r.Entity, r.EntityID, r.ID)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return prev, err
+}
+
+func get(db *database.DB, where string, args ...interface{}) (*Revision, error) {
+	var r Revision
+	var data string
+	err := db.QueryRow("SELECT id, entity, entity_id, author_id, author, note, created_at, data FROM revisions WHERE "+where, args...).
+		Scan(&r.ID, &r.Entity, &r.EntityID, &r.AuthorID, &r.Author, &r.Note, &r.CreatedAt, &data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Data, err = decodeSnapshot(data)
+	if err != nil {
+		return nil, fmt.Errorf("revision #%d: %w", r.ID, err)
+	}
+	return &r, nil
+}
+
+// Restore writes a revision back. The entity is created again when it was
+// deleted since; child rows are replaced. It returns ErrConflict when the
+// revision refers to rows removed since.
+func Restore(db *database.DB, r *Revision) error {
+	err := restore(db, r)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
+
+func restore(db *database.DB, r *Revision) error {
+	src, ok := sources[r.Entity]
+	if !ok {
+		return fmt.Errorf("unknown entity %q", r.Entity)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if src.table != "" && r.Data.Row != nil {
+		existing, err := selectRows(tx, src.table, src.key+" = ?", r.EntityID)
+		if err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			err = insertRow(tx, src.table, r.Data.Row)
+		} else {
+			err = updateRow(tx, src, r.EntityID, r.Data.Row)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", src.table, err)
+		}
+	}
+	// Rows further down go first, before the rows they refer to. Tables a
+	// revision from before they were tracked has no rows for are left alone.
+	for i := len(src.children) - 1; i >= 0; i-- {
+		c := src.children[i]
+		if _, ok := r.Data.Children[c.table]; !ok {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", c.table, c.where()), r.EntityID); err != nil {
+			return err
+		}
+	}
+	for _, c := range src.children {
+		for _, row := range r.Data.Children[c.table] {
+			if err := insertRow(tx, c.table, row); err != nil {
+				return fmt.Errorf("%s: %w", c.table, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Change is a field that differs between two snapshots
+type Change struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Diff lists the fields that differ from before to after. Child rows are
+// matched by language, or else by ID, as in
+// "event_translations[NL].description"; rows further down also by the ID
+// of the row they belong to, as in "event_media_translations[#4 NL].caption".
+func Diff(before, after *Snapshot) []Change {
+	// A field that is missing on one side, such as on an added
+	// translation, counts as empty. A table only one side tracked is left
+	// out.
+	a, b := flatten(before, after), flatten(after, before)
+	changes := []Change{}
+	for field, value := range b {
+		if a[field] != value {
+			changes = append(changes, Change{Field: field, Before: a[field], After: value})
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok && value != "" {
+			changes = append(changes, Change{Field: field, Before: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// flatten returns the fields of s, with only the child tables other has
+// too when there is an other
+func flatten(s, other *Snapshot) map[string]string {
+	fields := map[string]string{}
+	if s == nil {
+		return fields
+	}
+	for column, value := range s.Row {
+		fields[column] = formatValue(value)
+	}
+	for table, rows := range s.Children {
+		if other != nil {
+			if _, ok := other.Children[table]; !ok {
+				continue
+			}
+		}
+		for _, row := range rows {
+			c, _ := childOf(table)
+			identity, skip := "#"+formatValue(row["id"]), "id"
+			if lang, ok := row["lang_code"]; ok {
+				identity, skip = formatValue(lang), "lang_code"
+				if c.under != nil {
+					identity = "#" + formatValue(row[c.parent]) + " " + identity
+				}
+			}
+			for column, value := range row {
+				if column == skip || column == "id" || column == c.parent {
+					continue
+				}
+				fields[fmt.Sprintf("%s[%s].%s", table, identity, column)] = formatValue(value)
+			}
+		}
+	}
+	return fields
+}
+
+func childOf(table string) (child, bool) {
+	for _, src := range sources {
+		for _, c := range src.children {
+			if c.table == table {
+				return c, true
+			}
+		}
+	}
+	return child{}, false
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// columns returns the columns of a table and whether each holds dates
+func columns(q querier, table string) (map[string]bool, []string, error) {
+	rows, err := q.Query(fmt.Sprintf("SELECT name, type FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	dates := map[string]bool{}
+	names := []string{}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, nil, err
+		}
+		typ = strings.ToUpper(typ)
+		dates[name] = strings.Contains(typ, "DATE") || strings.Contains(typ, "TIME")
+		names = append(names, name)
+	}
+	return dates, names, rows.Err()
+}
+
+// selectRows reads the rows of table matching where, with value for its
+// placeholder. Dates are read as stored, not as time.Time, so a restore
+// writes them back unchanged.
+func selectRows(q querier, table, where, value string) ([]map[string]interface{}, error) {
+	dates, names, err := columns(q, table)
+	if err != nil {
+		return nil, err
+	}
+	selected := []string{}
+	for _, name := range names {
+		if untracked[name] {
+			continue
+		}
+		if dates[name] {
+			selected = append(selected, fmt.Sprintf("CAST(%s AS TEXT) AS %s", name, name))
+		} else {
+			selected = append(selected, name)
+		}
+	}
+	rows, err := q.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id", strings.Join(selected, ", "), table, where), value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(selected))
+		dest := make([]interface{}, len(selected))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := map[string]interface{}{}
+		i := 0
+		for _, name := range names {
+			if untracked[name] {
+				continue
+			}
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[name] = values[i]
+			i++
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// insertRow inserts the columns of row that the table still has
+func insertRow(tx *sql.Tx, table string, row map[string]interface{}) error {
+	_, names, err := columns(tx, table)
+	if err != nil {
+		return err
+	}
+	cols, args := []string{}, []interface{}{}
+	for _, name := range names {
+		if value, ok := row[name]; ok {
+			cols = append(cols, name)
+			args = append(args, value)
+		}
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(cols, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")), args...)
+	return err
+}
+
+// updateRow writes row over the main row of an entity
+func updateRow(tx *sql.Tx, src source, id string, row map[string]interface{}) error {
+	_, names, err := columns(tx, src.table)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{src.key: true}
+	for _, name := range src.keep {
+		keep[name] = true
+	}
+	sets, args := []string{}, []interface{}{}
+	for _, name := range names {
+		if name == "updated_at" {
+			sets = append(sets, "updated_at = CURRENT_TIMESTAMP")
+			continue
+		}
+		if value, ok := row[name]; ok && !keep[name] {
+			sets = append(sets, name+" = ?")
+			args = append(args, value)
+		}
+	}
+	args = append(args, id)
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", src.table, strings.Join(sets, ", "), src.key), args...)
+	return err
+}
+
+// decodeSnapshot keeps integers exact instead of turning them into floats
+func decodeSnapshot(data string) (*Snapshot, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	var s Snapshot
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	normalize(s.Row)
+	for _, rows := range s.Children {
+		for _, row := range rows {
+			normalize(row)
+		}
+	}
+	return &s, nil
+}
+
+func normalize(row map[string]interface{}) {
+	for column, value := range row {
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				row[column] = i
+			} else if f, err := n.Float64(); err == nil {
+				row[column] = f
+			}
+		}
+	}
+}
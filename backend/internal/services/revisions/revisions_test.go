@@ -0,0 +1,254 @@
+package revisions
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
+)
+
+func TestMain(m *testing.M) {
+	// Migrations log a lot
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if err := db.SeedDefaults(); err != nil {
+		t.Fatalf("SeedDefaults: %v", err)
+	}
+	return db
+}
+
+func exec(t *testing.T, db *database.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+func record(t *testing.T, db *database.DB, entity, id string) int64 {
+	t.Helper()
+	revisionID, err := Record(db, entity, id, nil, "")
+	if err != nil {
+		t.Fatalf("Record %s %s: %v", entity, id, err)
+	}
+	return revisionID
+}
+
+func restoreRevision(t *testing.T, db *database.DB, revisionID int64) {
+	t.Helper()
+	rev, err := Get(db, revisionID)
+	if err != nil {
+		t.Fatalf("Get #%d: %v", revisionID, err)
+	}
+	if err := Restore(db, rev); err != nil {
+		t.Fatalf("Restore #%d: %v", revisionID, err)
+	}
+}
+
+// newEvent adds event 1 with a Dutch description and a photo with a caption
+func newEvent(t *testing.T, db *database.DB) {
+	t.Helper()
+	exec(t, db, `INSERT INTO events (id, uuid, title, type, start_date, end_date)
+		VALUES (1, 'uuid-1', 'Picknick', 'REGULAR', '2026-06-01 10:00:00', '2026-06-01 12:00:00')`)
+	exec(t, db, "INSERT INTO event_translations (event_id, lang_code, description) VALUES (1, 'NL', 'In het park')")
+	exec(t, db, "INSERT INTO event_media (id, event_id, kind, filename, original_name) VALUES (4, 1, 'image', 'park.jpg', 'park.jpg')")
+	exec(t, db, "INSERT INTO event_media_translations (media_id, lang_code, caption) VALUES (4, 'NL', 'De vijver')")
+}
+
+func queryString(t *testing.T, db *database.DB, query string) string {
+	t.Helper()
+	var s string
+	if err := db.QueryRow(query).Scan(&s); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return s
+}
+
+func TestRestoreEvent(t *testing.T) {
+	db := newTestDB(t)
+	newEvent(t, db)
+	first := record(t, db, "event", "1")
+
+	exec(t, db, "UPDATE events SET title = 'BBQ', uuid = 'uuid-2' WHERE id = 1")
+	exec(t, db, "UPDATE event_translations SET description = 'Aan de vijver' WHERE event_id = 1")
+	exec(t, db, "DELETE FROM event_media_translations WHERE media_id = 4")
+	record(t, db, "event", "1")
+
+	restoreRevision(t, db, first)
+	if title := queryString(t, db, "SELECT title FROM events WHERE id = 1"); title != "Picknick" {
+		t.Errorf("title = %q, want Picknick", title)
+	}
+	if uuid := queryString(t, db, "SELECT uuid FROM events WHERE id = 1"); uuid != "uuid-2" {
+		t.Errorf("uuid = %q, want uuid-2 kept", uuid)
+	}
+	if d := queryString(t, db, "SELECT description FROM event_translations WHERE event_id = 1 AND lang_code = 'NL'"); d != "In het park" {
+		t.Errorf("description = %q, want In het park", d)
+	}
+	if c := queryString(t, db, "SELECT caption FROM event_media_translations WHERE media_id = 4 AND lang_code = 'NL'"); c != "De vijver" {
+		t.Errorf("caption = %q, want De vijver", c)
+	}
+}
+
+func TestRestoreDeletedEvent(t *testing.T) {
+	db := newTestDB(t)
+	newEvent(t, db)
+	last := record(t, db, "event", "1")
+	exec(t, db, "DELETE FROM events WHERE id = 1")
+
+	restoreRevision(t, db, last)
+	if title := queryString(t, db, "SELECT title FROM events WHERE id = 1"); title != "Picknick" {
+		t.Errorf("title = %q, want Picknick", title)
+	}
+	if uuid := queryString(t, db, "SELECT uuid FROM events WHERE id = 1"); uuid != "uuid-1" {
+		t.Errorf("uuid = %q, want uuid-1", uuid)
+	}
+	if c := queryString(t, db, "SELECT caption FROM event_media_translations WHERE media_id = 4"); c != "De vijver" {
+		t.Errorf("caption = %q, want De vijver", c)
+	}
+}
+
+func TestRestoreLeavesUntrackedMediaAlone(t *testing.T) {
+	db := newTestDB(t)
+	newEvent(t, db)
+
+	// A revision from before photos were part of an event's history
+	exec(t, db, `INSERT INTO revisions (entity, entity_id, data, author) VALUES ('event', '1', ?, '')`,
+		`{"row":{"id":1,"uuid":"uuid-1","state":"draft","on_home":0,"title":"Oud","type":"REGULAR","start_date":"2026-06-01 10:00:00","end_date":"2026-06-01 12:00:00"},"children":{"event_translations":[]}}`)
+	var old int64
+	if err := db.QueryRow("SELECT MAX(id) FROM revisions").Scan(&old); err != nil {
+		t.Fatal(err)
+	}
+
+	restoreRevision(t, db, old)
+	if title := queryString(t, db, "SELECT title FROM events WHERE id = 1"); title != "Oud" {
+		t.Errorf("title = %q, want Oud", title)
+	}
+	if c := queryString(t, db, "SELECT caption FROM event_media_translations WHERE media_id = 4"); c != "De vijver" {
+		t.Errorf("caption = %q, want the photo left alone", c)
+	}
+}
+
+func TestRestoreKeepsStock(t *testing.T) {
+	db := newTestDB(t)
+	exec(t, db, "INSERT INTO shop_items (id, title, price_cents, stock_quantity) VALUES (1, 'Coin', 1500, 10)")
+	first := record(t, db, "shop_item", "1")
+	exec(t, db, "UPDATE shop_items SET title = 'Geocoin', price_cents = 1800, stock_quantity = 3 WHERE id = 1")
+
+	restoreRevision(t, db, first)
+	var title string
+	var price, stock int
+	if err := db.QueryRow("SELECT title, price_cents, stock_quantity FROM shop_items WHERE id = 1").Scan(&title, &price, &stock); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Coin" || price != 1500 {
+		t.Errorf("item = %q at %d, want Coin at 1500", title, price)
+	}
+	if stock != 3 {
+		t.Errorf("stock = %d, want 3 left as sold since", stock)
+	}
+}
+
+func TestGetKeepsIntegersExact(t *testing.T) {
+	db := newTestDB(t)
+	// Beyond what a float64 holds exactly
+	const price = int64(1<<53 + 1)
+	exec(t, db, "INSERT INTO shop_items (id, title, price_cents) VALUES (1, 'Coin', ?)", price)
+	id := record(t, db, "shop_item", "1")
+
+	rev, err := Get(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := rev.Data.Row["price_cents"].(int64); !ok || got != price {
+		t.Errorf("price_cents = %#v, want int64 %d", rev.Data.Row["price_cents"], price)
+	}
+	if got, ok := rev.Data.Row["id"].(int64); !ok || got != 1 {
+		t.Errorf("id = %#v, want int64 1", rev.Data.Row["id"])
+	}
+
+	exec(t, db, "UPDATE shop_items SET price_cents = 0 WHERE id = 1")
+	restoreRevision(t, db, id)
+	var restored int64
+	if err := db.QueryRow("SELECT price_cents FROM shop_items WHERE id = 1").Scan(&restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored != price {
+		t.Errorf("restored price_cents = %d, want %d", restored, price)
+	}
+}
+
+func TestRestoreRemovedLanguage(t *testing.T) {
+	db := newTestDB(t)
+	exec(t, db, "INSERT INTO languages (code, name, flag_url, active) VALUES ('IT', 'Italiano', '', 1)")
+	exec(t, db, "INSERT INTO messages (id, state, priority) VALUES (1, 'published', 0)")
+	exec(t, db, "INSERT INTO message_translations (message_id, lang_code, title, content) VALUES (1, 'IT', 'Ciao', '')")
+	id := record(t, db, "message", "1")
+	exec(t, db, "DELETE FROM languages WHERE code = 'IT'")
+
+	rev, err := Get(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(db, rev); !errors.Is(err, ErrConflict) {
+		t.Errorf("Restore = %v, want ErrConflict", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := &Snapshot{
+		Row: map[string]interface{}{"id": int64(1), "title": "Picknick"},
+		Children: map[string][]map[string]interface{}{
+			"event_translations": {
+				{"id": int64(1), "event_id": int64(1), "lang_code": "NL", "description": "In het park"},
+				{"id": int64(2), "event_id": int64(1), "lang_code": "EN", "description": "In the park"},
+			},
+			"event_media_translations": {
+				{"id": int64(1), "media_id": int64(4), "lang_code": "NL", "caption": "De vijver"},
+				{"id": int64(2), "media_id": int64(5), "lang_code": "NL", "caption": "De brug"},
+			},
+		},
+	}
+	// Rewritten rows get new IDs and may come back in another order
+	after := &Snapshot{
+		Row: map[string]interface{}{"id": int64(1), "title": "Picknick"},
+		Children: map[string][]map[string]interface{}{
+			"event_translations": {
+				{"id": int64(7), "event_id": int64(1), "lang_code": "EN", "description": "By the pond"},
+				{"id": int64(8), "event_id": int64(1), "lang_code": "NL", "description": "In het park"},
+			},
+			"event_media_translations": {
+				{"id": int64(3), "media_id": int64(5), "lang_code": "NL", "caption": "De brug"},
+				{"id": int64(4), "media_id": int64(4), "lang_code": "NL", "caption": "Het meer"},
+			},
+		},
+	}
+
+	changes := Diff(before, after)
+	want := []Change{
+		{Field: "event_media_translations[#4 NL].caption", Before: "De vijver", After: "Het meer"},
+		{Field: "event_translations[EN].description", Before: "In the park", After: "By the pond"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Diff = %+v, want %+v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
+)
+
+// S3 stores files in an S3-compatible bucket (AWS, MinIO, Garage,
+// Scaleway...). Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	// prefix is prepended to every key, so a bucket can be shared
+	prefix    string
+	pathStyle bool
+	client    *http.Client
+}
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3MaxURLExpiry    = 7 * 24 * time.Hour
+)
+
+func NewS3(cfg config.StorageConfig) (*S3, error) {
+	if cfg.S3Bucket == "" || cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+		return nil, fmt.Errorf("s3 storage needs a bucket, access key and secret key")
+	}
+	endpoint := cfg.S3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.S3Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	region := cfg.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	prefix := strings.Trim(cfg.S3Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{
+		endpoint:  u,
+		region:    region,
+		bucket:    cfg.S3Bucket,
+		accessKey: cfg.S3AccessKey,
+		secretKey: cfg.S3SecretKey,
+		prefix:    prefix,
+		pathStyle: cfg.S3PathStyle,
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (s *S3) Name() string {
+	return "s3"
+}
+
+// objectURL returns the URL of an object, or of the bucket for key ""
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	rawPath := u.EscapedPath()
+	if s.pathStyle {
+		rawPath += "/" + s3Escape(s.bucket, true)
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	if key != "" || !s.pathStyle {
+		rawPath += "/" + s3Escape(key, false)
+	}
+	u.Path, _ = url.PathUnescape(rawPath)
+	u.RawPath = rawPath
+	return &u
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(s.prefix+key), nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(s.prefix+key), nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, objectFromHeader(key, resp), nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*Object, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(s.prefix+key), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectFromHeader(key, resp), nil
+}
+
+func objectFromHeader(key string, resp *http.Response) *Object {
+	obj := &Object{Key: key, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.ModTime = t
+	}
+	return obj
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(s.prefix+key), nil, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		Size         int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.prefix + prefix},
+			"delimiter": {"/"},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL(""), query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: invalid list response: %w", err)
+		}
+		for _, c := range result.Contents {
+			obj := Object{Key: strings.TrimPrefix(c.Key, s.prefix), Size: c.Size}
+			if t, err := time.Parse(time.RFC3339, c.LastModified); err == nil {
+				obj.ModTime = t
+			}
+			objects = append(objects, obj)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// SignedURL returns a presigned GET URL, valid for at most a week
+func (s *S3) SignedURL(ctx context.Context, key string, expiry time.Duration, downloadName string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if expiry <= 0 || expiry > s3MaxURLExpiry {
+		expiry = s3MaxURLExpiry
+	}
+	u := s.objectURL(s.prefix + key)
+	now := time.Now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {s.accessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if downloadName != "" {
+		query.Set("response-content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", downloadName))
+	}
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		s3CanonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(now, canonical))
+	u.RawQuery = s3CanonicalQuery(query)
+	return u.String(), nil
+}
+
+// do sends a signed request; error statuses are returned as errors and
+// 404 as ErrNotFound
+func (s *S3) do(ctx context.Context, method string, u *url.URL, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	if query != nil {
+		u.RawQuery = s3CanonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		xml.Unmarshal(data, &s3Err)
+		if s3Err.Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("s3: %s %s failed with status %d: %s %s", method, u.Path, resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return resp, nil
+}
+
+// sign adds the Authorization header of AWS Signature Version 4
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := time.Now().UTC()
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+			values[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, s.scope(now), signedHeaders, s.signature(now, canonical)))
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(t time.Time, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format("20060102T150405Z"),
+		s.scope(t),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery encodes a query string sorted by name and value, the way
+// the signature expects it
+func s3CanonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and '/'
+// unless escapeSlash is set
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !escapeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,489 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
+)
+
+// fakeS3 is an in-memory bucket behind path-style URLs that checks
+// Signature Version 4 the way S3 does, from the request it receives
+type fakeS3 struct {
+	bucket    string
+	accessKey string
+	secretKey string
+	region    string
+	// pageSize limits list responses, so continuation is used
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// newFakeS3 starts a fake bucket and returns it with a client for it
+func newFakeS3(t *testing.T, prefix string) (*fakeS3, *S3) {
+	t.Helper()
+	f := &fakeS3{bucket: "geocaching", accessKey: "AKTEST", secretKey: "secret/key+1", region: "eu-west-1", pageSize: 2, objects: map[string]fakeObject{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3(config.StorageConfig{
+		S3Endpoint:  server.URL,
+		S3Region:    f.region,
+		S3Bucket:    f.bucket,
+		S3AccessKey: f.accessKey,
+		S3SecretKey: f.secretKey,
+		S3Prefix:    prefix,
+		S3PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	return f, s
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := f.verify(r, body); err != nil {
+		f.fail(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	bucketPath := "/" + f.bucket
+	if r.URL.Path != bucketPath && !strings.HasPrefix(r.URL.Path, bucketPath+"/") {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, bucketPath), "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query())
+	case key == "":
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type"), modTime: time.Now().UTC().Truncate(time.Second)}
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.fail(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		if disposition := r.URL.Query().Get("response-content-disposition"); disposition != "" {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+// list answers ListObjectsV2 with at most pageSize keys per page
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		f.fail(w, http.StatusBadRequest, "InvalidArgument", "only ListObjectsV2 is supported")
+		return
+	}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	keys := []string{}
+	for key := range f.objects {
+		rest := strings.TrimPrefix(key, prefix)
+		if rest == key && prefix != "" {
+			continue
+		}
+		if delimiter != "" && strings.Contains(rest, delimiter) {
+			continue // a common prefix, which the client doesn't use
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	type content struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		Size         int    `xml:"Size"`
+	}
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Contents              []content `xml:"Contents"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}{}
+	end := start + f.pageSize
+	if end >= len(keys) {
+		end = len(keys)
+	} else {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	for _, key := range keys[start:end] {
+		obj := f.objects[key]
+		result.Contents = append(result.Contents, content{key, obj.modTime.Format(time.RFC3339), len(obj.data)})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify checks the signature of a request signed in the Authorization
+// header or in the query string of a presigned URL
+func (f *fakeS3) verify(r *http.Request, body []byte) error {
+	query := r.URL.Query()
+	var algorithm, credential, signedHeaders, signature, amzDate, payloadHash string
+	if query.Has("X-Amz-Signature") {
+		algorithm = query.Get("X-Amz-Algorithm")
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		payloadHash = "UNSIGNED-PAYLOAD"
+		query.Del("X-Amz-Signature")
+
+		signedAt, err := time.Parse("20060102T150405Z", amzDate)
+		expires, _ := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires <= 0 || expires > 604800 {
+			return fmt.Errorf("invalid X-Amz-Date or X-Amz-Expires")
+		}
+		if time.Now().After(signedAt.Add(time.Duration(expires) * time.Second)) {
+			return fmt.Errorf("request has expired")
+		}
+	} else {
+		auth := r.Header.Get("Authorization")
+		algorithm, auth, _ = strings.Cut(auth, " ")
+		for _, part := range strings.Split(auth, ", ") {
+			name, value, _ := strings.Cut(part, "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		sum := sha256.Sum256(body)
+		if payloadHash != hex.EncodeToString(sum[:]) {
+			return fmt.Errorf("x-amz-content-sha256 does not match the body")
+		}
+		for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+			if !strings.Contains(";"+signedHeaders+";", ";"+required+";") {
+				return fmt.Errorf("%s is not signed", required)
+			}
+		}
+		signedAt, err := time.Parse("20060102T150405Z", amzDate)
+		if err != nil || time.Since(signedAt).Abs() > 15*time.Minute {
+			return fmt.Errorf("invalid X-Amz-Date %q", amzDate)
+		}
+	}
+	if algorithm != "AWS4-HMAC-SHA256" {
+		return fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	scope := amzDate[:8] + "/" + f.region + "/s3/aws4_request"
+	if credential != f.accessKey+"/"+scope {
+		return fmt.Errorf("invalid credential %q", credential)
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(pairs)
+	canonical := strings.Join([]string{r.Method, r.URL.EscapedPath(), strings.Join(pairs, "&"), headers.String(), signedHeaders, payloadHash}, "\n")
+
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + f.secretKey)
+	for _, part := range []string{amzDate[:8], f.region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(key))) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// awsEscape is the URI encoding of Signature Version 4
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func TestS3PutGetDelete(t *testing.T) {
+	f, s := newFakeS3(t, "/site/")
+	ctx := context.Background()
+
+	for key, contentType := range map[string]string{"images/a.png": "image/png", "files/route map (v2).pdf": "application/pdf"} {
+		t.Run(key, func(t *testing.T) {
+			data := []byte("content of " + key)
+			if err := s.Put(ctx, key, data, contentType); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if stored, ok := f.objects["site/"+key]; !ok || string(stored.data) != string(data) || stored.contentType != contentType {
+				t.Fatalf("bucket has %+v under site/%s", stored, key)
+			}
+
+			body, obj, err := s.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			got, _ := io.ReadAll(body)
+			body.Close()
+			if string(got) != string(data) {
+				t.Errorf("Get = %q, want %q", got, data)
+			}
+			if obj.Key != key || obj.Size != int64(len(data)) || obj.ContentType != contentType || obj.ModTime.IsZero() {
+				t.Errorf("Get object = %+v", obj)
+			}
+
+			stat, err := s.Stat(ctx, key)
+			if err != nil || stat.Size != int64(len(data)) || stat.ContentType != contentType {
+				t.Errorf("Stat = %+v, %v", stat, err)
+			}
+
+			if err := s.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, _, err := s.Get(ctx, key); err != ErrNotFound {
+				t.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+			}
+			if _, err := s.Stat(ctx, key); err != ErrNotFound {
+				t.Errorf("Stat after Delete error = %v, want ErrNotFound", err)
+			}
+			// Deleting what is gone is not an error
+			if err := s.Delete(ctx, key); err != nil {
+				t.Errorf("second Delete: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3InvalidKey(t *testing.T) {
+	_, s := newFakeS3(t, "")
+	for _, key := range []string{"", "../db.sqlite", "images/../../x", "/images/a.png", `images\a.png`} {
+		if err := s.Put(context.Background(), key, []byte("x"), ""); err != ErrInvalidKey {
+			t.Errorf("Put(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestS3List(t *testing.T) {
+	f, s := newFakeS3(t, "site")
+	ctx := context.Background()
+	for _, key := range []string{"images/e.png", "images/a.png", "images/c_400.webp", "images/b.png", "images/c.png", "files/x.pdf"} {
+		if err := s.Put(ctx, key, []byte(key), ""); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	// Neither outside the prefix nor in a deeper directory
+	f.objects["images/outside.png"] = fakeObject{data: []byte("x")}
+	f.objects["site/images/sub/deeper.png"] = fakeObject{data: []byte("x")}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"images/", []string{"images/a.png", "images/b.png", "images/c.png", "images/c_400.webp", "images/e.png"}},
+		{"images/c", []string{"images/c.png", "images/c_400.webp"}},
+		{"files/", []string{"files/x.pdf"}},
+		{"downloads/", []string{}},
+	}
+	for _, tt := range tests {
+		objects, err := s.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%s): %v", tt.prefix, err)
+		}
+		keys := []string{}
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+			if o.Size != int64(len(o.Key)) || o.ModTime.IsZero() {
+				t.Errorf("List(%s): %+v", tt.prefix, o)
+			}
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("List(%s) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+}
+
+func TestS3SignedURL(t *testing.T) {
+	_, s := newFakeS3(t, "site")
+	ctx := context.Background()
+	if err := s.Put(ctx, "files/route map.pdf", []byte("%PDF"), "application/pdf"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	signed, err := s.SignedURL(ctx, "files/route map.pdf", time.Hour, "route map.pdf")
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	u, _ := url.Parse(signed)
+	if u.Query().Get("X-Amz-Expires") != "3600" || u.Query().Get("X-Amz-SignedHeaders") != "host" {
+		t.Errorf("SignedURL query = %s", u.RawQuery)
+	}
+
+	// The URL works without credentials
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatalf("GET signed URL: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "%PDF" {
+		t.Fatalf("GET signed URL = %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename="route map.pdf"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	// Changing the object or the lifetime breaks the signature
+	for name, change := range map[string]func(q url.Values){
+		"other key":    nil,
+		"longer valid": func(q url.Values) { q.Set("X-Amz-Expires", "7200") },
+	} {
+		tampered := *u
+		if change == nil {
+			tampered.Path = strings.Replace(tampered.Path, "route", "other", 1)
+			tampered.RawPath = ""
+		} else {
+			q := tampered.Query()
+			change(q)
+			tampered.RawQuery = q.Encode()
+		}
+		resp, err := http.Get(tampered.String())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403", name, resp.StatusCode)
+		}
+	}
+
+	// Longer than S3 allows is cut to a week
+	signed, _ = s.SignedURL(ctx, "files/route map.pdf", 30*24*time.Hour, "")
+	u, _ = url.Parse(signed)
+	if got := u.Query().Get("X-Amz-Expires"); got != "604800" {
+		t.Errorf("X-Amz-Expires = %s, want 604800", got)
+	}
+	if u.Query().Has("response-content-disposition") {
+		t.Error("URL without download name has a content disposition")
+	}
+}
+
+func TestS3WrongCredentials(t *testing.T) {
+	f, s := newFakeS3(t, "")
+	s.secretKey = "wrong"
+	err := s.Put(context.Background(), "images/a.png", []byte("x"), "image/png")
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with a wrong secret error = %v", err)
+	}
+	if len(f.objects) != 0 {
+		t.Errorf("bucket has %d objects", len(f.objects))
+	}
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	tests := []struct {
+		endpoint  string
+		region    string
+		pathStyle bool
+		key       string
+		want      string
+	}{
+		{"", "eu-west-1", false, "images/a b.png", "https://geocaching.s3.eu-west-1.amazonaws.com/images/a%20b.png"},
+		{"", "eu-west-1", false, "", "https://geocaching.s3.eu-west-1.amazonaws.com/"},
+		{"http://localhost:9000/", "", true, "images/a.png", "http://localhost:9000/geocaching/images/a.png"},
+		{"http://localhost:9000", "", true, "", "http://localhost:9000/geocaching"},
+		{"https://storage.example.com/s3", "", true, "files/x.pdf", "https://storage.example.com/s3/geocaching/files/x.pdf"},
+	}
+	for _, tt := range tests {
+		s, err := NewS3(config.StorageConfig{S3Endpoint: tt.endpoint, S3Region: tt.region, S3Bucket: "geocaching", S3AccessKey: "a", S3SecretKey: "s", S3PathStyle: tt.pathStyle})
+		if err != nil {
+			t.Fatalf("NewS3(%s): %v", tt.endpoint, err)
+		}
+		if got := s.objectURL(tt.key).String(); got != tt.want {
+			t.Errorf("objectURL(%q) with %q = %s, want %s", tt.key, tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestNewS3Invalid(t *testing.T) {
+	valid := config.StorageConfig{S3Bucket: "b", S3AccessKey: "a", S3SecretKey: "s"}
+	for name, change := range map[string]func(c *config.StorageConfig){
+		"no bucket":     func(c *config.StorageConfig) { c.S3Bucket = "" },
+		"no secret":     func(c *config.StorageConfig) { c.S3SecretKey = "" },
+		"no scheme":     func(c *config.StorageConfig) { c.S3Endpoint = "localhost:9000" },
+		"other scheme":  func(c *config.StorageConfig) { c.S3Endpoint = "ftp://localhost" },
+		"invalid URL":   func(c *config.StorageConfig) { c.S3Endpoint = "http://[::1" },
+		"empty address": func(c *config.StorageConfig) { c.S3Endpoint = "https://" },
+	} {
+		cfg := valid
+		change(&cfg)
+		if _, err := NewS3(cfg); err == nil {
+			t.Errorf("%s: NewS3 succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package storage keeps uploaded files on the local disk or in an
+// S3-compatible bucket. Keys are slash-separated paths such as
+// "images/<uuid>.png"; the layout is flat below the first directory.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+	// ErrNoURL is returned by backends that cannot hand out direct URLs
+	ErrNoURL = errors.New("storage: backend has no direct URLs")
+)
+
+// Prefixes are the directories files are stored in
+var Prefixes = []string{"images/", "files/"}
+
+// Object describes a stored file
+type Object struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Storage is implemented by every storage backend
+type Storage interface {
+	Name() string
+	// Put stores data under key, replacing what was there
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens a stored file. The reader is an io.ReadSeeker when the
+	// backend supports it.
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	Stat(ctx context.Context, key string) (*Object, error)
+	// Delete removes a file; a missing file is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the files whose key starts with prefix, in the
+	// directory the prefix points into
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// SignedURL returns a URL the file can be fetched from directly for
+	// the given time. A non-empty downloadName makes it an attachment.
+	SignedURL(ctx context.Context, key string, expiry time.Duration, downloadName string) (string, error)
+}
+
+// New returns the backend selected in cfg. dataDir is the root of the
+// local backend.
+func New(cfg config.StorageConfig, dataDir string) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(dataDir), nil
+	case "s3":
+		return NewS3(cfg)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// Copy copies the files below prefixes from src to dst. Files dst already
+// has with the same size are skipped, so an interrupted copy can be run
+// again.
+func Copy(ctx context.Context, dst, src Storage, prefixes []string) (copied, skipped int, err error) {
+	for _, prefix := range prefixes {
+		objects, err := src.List(ctx, prefix)
+		if err != nil {
+			return copied, skipped, fmt.Errorf("listing %s failed: %w", prefix, err)
+		}
+		for _, o := range objects {
+			if existing, err := dst.Stat(ctx, o.Key); err == nil && existing.Size == o.Size {
+				skipped++
+				continue
+			} else if err != nil && err != ErrNotFound {
+				return copied, skipped, fmt.Errorf("checking %s failed: %w", o.Key, err)
+			}
+			body, obj, err := src.Get(ctx, o.Key)
+			if err == ErrNotFound {
+				continue // removed since the listing
+			}
+			if err != nil {
+				return copied, skipped, fmt.Errorf("reading %s failed: %w", o.Key, err)
+			}
+			data, err := io.ReadAll(body)
+			body.Close()
+			if err != nil {
+				return copied, skipped, fmt.Errorf("reading %s failed: %w", o.Key, err)
+			}
+			contentType := obj.ContentType
+			if contentType == "" {
+				contentType = mime.TypeByExtension(path.Ext(o.Key))
+			}
+			if err := dst.Put(ctx, o.Key, data, contentType); err != nil {
+				return copied, skipped, fmt.Errorf("writing %s failed: %w", o.Key, err)
+			}
+			copied++
+		}
+	}
+	return copied, skipped, nil
+}
+
+// cleanKey rejects keys that would leave the storage root
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != key || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
+
+// Local stores files below a directory on disk
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+func (l *Local) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Write next to the target and rename, so a file is never served half
+	// written
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, nil, ErrNotFound
+	}
+	return f, &Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		return nil, ErrNotFound
+	}
+	return &Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	dir, namePrefix := path.Split(prefix)
+	entries, err := os.ReadDir(filepath.Join(l.root, filepath.FromSlash(dir)))
+	if os.IsNotExist(err) {
+		return []Object{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	objects := []Object{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, namePrefix) || strings.HasPrefix(name, ".upload-") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, Object{Key: dir + name, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func (l *Local) SignedURL(ctx context.Context, key string, expiry time.Duration, downloadName string) (string, error) {
+	return "", ErrNoURL
+}
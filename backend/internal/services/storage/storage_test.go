@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	local := NewLocal(dir)
+	ctx := context.Background()
+	for key, data := range map[string]string{"images/a.png": "png", "images/a_400.webp": "webp", "files/x.pdf": "pdf"} {
+		if err := local.Put(ctx, key, []byte(data), ""); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	// Neither the database nor backups are uploads
+	os.WriteFile(filepath.Join(dir, "geocaching.db"), []byte("db"), 0644)
+	os.MkdirAll(filepath.Join(dir, "backups"), 0755)
+	os.WriteFile(filepath.Join(dir, "backups", "backup.tar.gz"), []byte("tar"), 0644)
+
+	f, s3 := newFakeS3(t, "site")
+	// Already copied by an earlier, interrupted run
+	s3.Put(ctx, "images/a.png", []byte("png"), "image/png")
+
+	copied, skipped, err := Copy(ctx, s3, local, Prefixes)
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if copied != 2 || skipped != 1 {
+		t.Errorf("Copy = %d copied, %d skipped; want 2, 1", copied, skipped)
+	}
+	want := map[string]string{"site/images/a.png": "image/png", "site/images/a_400.webp": "image/webp", "site/files/x.pdf": "application/pdf"}
+	if len(f.objects) != len(want) {
+		t.Errorf("bucket has %d objects, want %d", len(f.objects), len(want))
+	}
+	for key, contentType := range want {
+		if obj, ok := f.objects[key]; !ok || obj.contentType != contentType {
+			t.Errorf("%s: %+v, want content type %s", key, obj, contentType)
+		}
+	}
+
+	// A changed file is copied again
+	local.Put(ctx, "files/x.pdf", []byte("new pdf"), "")
+	if copied, skipped, err := Copy(ctx, s3, local, Prefixes); err != nil || copied != 1 || skipped != 2 {
+		t.Errorf("second Copy = %d, %d, %v; want 1, 2, nil", copied, skipped, err)
+	}
+	if got := string(f.objects["site/files/x.pdf"].data); got != "new pdf" {
+		t.Errorf("files/x.pdf = %q", got)
+	}
+}
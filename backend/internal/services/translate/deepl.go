@@ -0,0 +1,107 @@
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	deeplAPIURL     = "https://api.deepl.com/v2"
+	deeplFreeAPIURL = "https://api-free.deepl.com/v2"
+	// deeplMaxTexts is how many texts DeepL accepts per request
+	deeplMaxTexts = 50
+)
+
+// deeplTargets maps site languages to the regional variant DeepL requires
+// as target
+var deeplTargets = map[string]string{
+	"EN": "EN-GB",
+	"PT": "PT-PT",
+}
+
+// DeepL translates with the DeepL API. Free plan keys end in ":fx" and use
+// the free endpoint.
+type DeepL struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func NewDeepL(apiKey string) *DeepL {
+	baseURL := deeplAPIURL
+	if strings.HasSuffix(apiKey, ":fx") {
+		baseURL = deeplFreeAPIURL
+	}
+	return &DeepL{
+		apiKey:  apiKey,
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (d *DeepL) Name() string { return "deepl" }
+
+func (d *DeepL) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	target = strings.ToUpper(target)
+	if variant, ok := deeplTargets[target]; ok {
+		target = variant
+	}
+
+	result := make([]string, 0, len(texts))
+	for start := 0; start < len(texts); start += deeplMaxTexts {
+		end := start + deeplMaxTexts
+		if end > len(texts) {
+			end = len(texts)
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"text":        texts[start:end],
+			"source_lang": strings.ToUpper(source),
+			"target_lang": target,
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL+"/translate", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "DeepL-Auth-Key "+d.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("deepl: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("deepl: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			var apiErr struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(data, &apiErr)
+			if apiErr.Message == "" {
+				apiErr.Message = resp.Status
+			}
+			return nil, &APIError{Service: "deepl", Status: resp.StatusCode, Message: apiErr.Message}
+		}
+
+		var parsed struct {
+			Translations []struct {
+				Text string `json:"text"`
+			} `json:"translations"`
+		}
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			return nil, fmt.Errorf("deepl: invalid response: %w", err)
+		}
+		for _, t := range parsed.Translations {
+			result = append(result, t.Text)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,44 @@
+package translate
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Fake is a Translator for development and tests. It prefixes every text
+// with the target language, "[EN] Hallo", and records the requests.
+type Fake struct {
+	mu       sync.Mutex
+	Requests []FakeRequest
+	// Err, when set, is returned by Translate
+	Err error
+}
+
+type FakeRequest struct {
+	Texts  []string
+	Source string
+	Target string
+}
+
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	f.Requests = append(f.Requests, FakeRequest{Texts: texts, Source: source, Target: target})
+
+	result := make([]string, len(texts))
+	for i, text := range texts {
+		result[i] = "[" + strings.ToUpper(target) + "] " + text
+	}
+	return result, nil
+}
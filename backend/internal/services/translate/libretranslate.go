@@ -0,0 +1,75 @@
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LibreTranslate translates with a (self-hosted) LibreTranslate server.
+// The API key is only needed for servers that require one.
+type LibreTranslate struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func NewLibreTranslate(baseURL, apiKey string) *LibreTranslate {
+	return &LibreTranslate{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		// Self-hosted servers on small machines can be slow
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (l *LibreTranslate) Name() string { return "libretranslate" }
+
+func (l *LibreTranslate) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	payload := map[string]interface{}{
+		"q":      texts,
+		"source": strings.ToLower(source),
+		"target": strings.ToLower(target),
+		"format": "text",
+	}
+	if l.apiKey != "" {
+		payload["api_key"] = l.apiKey
+	}
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("libretranslate: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("libretranslate: %w", err)
+	}
+
+	var parsed struct {
+		TranslatedText []string `json:"translatedText"`
+		Error          string   `json:"error"`
+	}
+	json.Unmarshal(data, &parsed)
+	if resp.StatusCode != http.StatusOK {
+		if parsed.Error == "" {
+			parsed.Error = resp.Status
+		}
+		return nil, &APIError{Service: "libretranslate", Status: resp.StatusCode, Message: parsed.Error}
+	}
+	if parsed.TranslatedText == nil {
+		return nil, fmt.Errorf("libretranslate: invalid response")
+	}
+	return parsed.TranslatedText, nil
+}
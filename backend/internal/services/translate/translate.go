@@ -0,0 +1,144 @@
+// Package translate proposes machine translations of site content through
+// a pluggable Translator (DeepL, LibreTranslate or a fake for development).
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
+)
+
+// Translator is implemented by every machine translation backend.
+// Languages are the site's language codes, such as "NL" or "EN".
+type Translator interface {
+	Name() string
+	// Translate returns one translation per text, in the same order
+	Translate(ctx context.Context, texts []string, source, target string) ([]string, error)
+}
+
+// APIError carries a message from the translation service
+type APIError struct {
+	Service string
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Service, e.Message)
+}
+
+// New returns the translator selected in cfg, or nil when machine
+// translation is not configured
+func New(cfg config.TranslatorConfig) (Translator, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "deepl":
+		return NewDeepL(cfg.DeepLKey), nil
+	case "libretranslate":
+		return NewLibreTranslate(cfg.LibreTranslateURL, cfg.LibreTranslateKey), nil
+	case "fake":
+		return NewFake(), nil
+	}
+	return nil, fmt.Errorf("unknown translator %q", cfg.Provider)
+}
+
+// Texts translates stored texts in one request. Rich text, the editor's
+// JSON document, is translated text node by node so its formatting
+// survives; blank texts stay blank.
+func Texts(ctx context.Context, t Translator, texts []string, source, target string) ([]string, error) {
+	// segments are the strings sent to the translator; docs[i] holds the
+	// parsed document of texts[i] and nodes[i] its text nodes
+	segments := []string{}
+	docs := make([]interface{}, len(texts))
+	nodes := make([][]map[string]interface{}, len(texts))
+	plain := make([]int, len(texts))
+
+	for i, text := range texts {
+		plain[i] = -1
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if doc, ok := parseDocument(text); ok {
+			docs[i] = doc
+			nodes[i] = textNodes(doc, nil)
+			for _, node := range nodes[i] {
+				segments = append(segments, node["text"].(string))
+			}
+			continue
+		}
+		plain[i] = len(segments)
+		segments = append(segments, text)
+	}
+	if len(segments) == 0 {
+		return texts, nil
+	}
+
+	translated, err := t.Translate(ctx, segments, source, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(translated) != len(segments) {
+		return nil, fmt.Errorf("%s returned %d translations for %d texts", t.Name(), len(translated), len(segments))
+	}
+
+	result := make([]string, len(texts))
+	next := 0
+	for i, text := range texts {
+		switch {
+		case plain[i] >= 0:
+			result[i] = translated[plain[i]]
+			next++
+		case docs[i] != nil:
+			for _, node := range nodes[i] {
+				node["text"] = translated[next]
+				next++
+			}
+			result[i] = encodeDocument(docs[i])
+		default:
+			result[i] = text
+		}
+	}
+	return result, nil
+}
+
+// parseDocument recognizes the JSON documents of the rich text editor
+func parseDocument(text string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil, false
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(trimmed), &doc); err != nil || doc["type"] != "doc" {
+		return nil, false
+	}
+	return doc, true
+}
+
+// textNodes collects the text nodes of a document in reading order
+func textNodes(node interface{}, found []map[string]interface{}) []map[string]interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if text, ok := n["text"].(string); ok && n["type"] == "text" && strings.TrimSpace(text) != "" {
+			found = append(found, n)
+		}
+		if content, ok := n["content"].([]interface{}); ok {
+			for _, child := range content {
+				found = textNodes(child, found)
+			}
+		}
+	}
+	return found
+}
+
+func encodeDocument(doc interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(doc)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,99 @@
+package translate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTexts(t *testing.T) {
+	texts := []string{
+		"Hallo",
+		"  ",
+		`{"type":"doc","content":[{"type":"paragraph","content":[` +
+			`{"type":"text","text":"Zoek & vind "},` +
+			`{"type":"text","marks":[{"type":"link","attrs":{"href":"https://example.com/?a=1&b=2"}}],"text":"<de cache>"},` +
+			`{"type":"text","text":" "}]},` +
+			`{"type":"image","attrs":{"src":"/api/images/a.png"}},` +
+			`{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"Punt"}]}]}]}]}`,
+		"",
+		`{"type":"other","text":"not a document"}`,
+		"{ not json",
+	}
+	f := NewFake()
+	got, err := Texts(context.Background(), f, texts, "NL", "EN")
+	if err != nil {
+		t.Fatalf("Texts: %v", err)
+	}
+
+	// Everything goes in one request, in reading order; blank texts and
+	// blank text nodes are not sent
+	if len(f.Requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(f.Requests))
+	}
+	sent := f.Requests[0]
+	wantSent := []string{"Hallo", "Zoek & vind ", "<de cache>", "Punt", `{"type":"other","text":"not a document"}`, "{ not json"}
+	if strings.Join(sent.Texts, "|") != strings.Join(wantSent, "|") || sent.Source != "NL" || sent.Target != "EN" {
+		t.Errorf("request = %+v, want texts %q", sent, wantSent)
+	}
+
+	// Formatting, links and images survive; keys come back sorted, and
+	// nothing is HTML-escaped
+	want := []string{
+		"[EN] Hallo",
+		"  ",
+		`{"content":[{"content":[` +
+			`{"text":"[EN] Zoek & vind ","type":"text"},` +
+			`{"marks":[{"attrs":{"href":"https://example.com/?a=1&b=2"},"type":"link"}],"text":"[EN] <de cache>","type":"text"},` +
+			`{"text":" ","type":"text"}],"type":"paragraph"},` +
+			`{"attrs":{"src":"/api/images/a.png"},"type":"image"},` +
+			`{"content":[{"content":[{"content":[{"text":"[EN] Punt","type":"text"}],"type":"paragraph"}],"type":"listItem"}],"type":"bulletList"}],"type":"doc"}`,
+		"",
+		`[EN] {"type":"other","text":"not a document"}`,
+		"[EN] { not json",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("text %d = %s\nwant %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTextsBlank(t *testing.T) {
+	f := NewFake()
+	texts := []string{"", " ", `{"type":"doc","content":[{"type":"paragraph"}]}`}
+	got, err := Texts(context.Background(), f, texts, "NL", "EN")
+	if err != nil {
+		t.Fatalf("Texts: %v", err)
+	}
+	if len(f.Requests) != 0 {
+		t.Errorf("blank texts were sent: %+v", f.Requests)
+	}
+	if strings.Join(got, "|") != strings.Join(texts, "|") {
+		t.Errorf("Texts = %q, want them unchanged", got)
+	}
+}
+
+// shortTranslator drops the last translation
+type shortTranslator struct{}
+
+func (shortTranslator) Name() string { return "short" }
+
+func (shortTranslator) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	return texts[:len(texts)-1], nil
+}
+
+func TestTextsErrors(t *testing.T) {
+	if _, err := Texts(context.Background(), shortTranslator{}, []string{"a", "b"}, "NL", "EN"); err == nil {
+		t.Error("a missing translation was not reported")
+	}
+
+	f := NewFake()
+	f.Err = &APIError{Service: "fake", Status: 456, Message: "Quota exceeded"}
+	_, err := Texts(context.Background(), f, []string{"Hallo"}, "NL", "EN")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "Quota exceeded" {
+		t.Errorf("error = %v, want the APIError", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package xlsx writes simple Office Open XML spreadsheets: one or more
+// sheets of plain rows with a bold header. Strings are written inline, so
+// no shared string table is needed.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Sheet is a worksheet with a header row followed by data rows. Cells may
+// be strings, integers or floats; anything else is formatted with %v.
+type Sheet struct {
+	Name    string
+	Headers []string
+	Rows    [][]interface{}
+}
+
+// Write encodes the sheets as an .xlsx file
+func Write(w io.Writer, sheets []Sheet) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypes(len(sheets))},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", workbook(sheets)},
+		{"xl/_rels/workbook.xml.rels", workbookRels(len(sheets))},
+		{"xl/styles.xml", styles},
+	}
+	for i, s := range sheets {
+		files = append(files, struct {
+			name    string
+			content string
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), worksheet(s)})
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const rootRels = xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// Style 0 is the default, style 1 is bold (used for headers)
+const styles = xmlHeader + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="1"><fill><patternFill patternType="none"/></fill></fills>` +
+	`<borders count="1"><border/></borders>` +
+	`<cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf fontId="0"/><xf fontId="1" applyFont="1"/></cellXfs>` +
+	`</styleSheet>`
+
+func contentTypes(n int) string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func workbook(sheets []Sheet) string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, s := range sheets {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(sheetName(s.Name, i)), i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.String()
+}
+
+func workbookRels(n int) string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, n+1)
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func worksheet(s Sheet) string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	writeRow := func(rowNum int, cells []interface{}, style int) {
+		fmt.Fprintf(&b, `<row r="%d">`, rowNum)
+		for col, v := range cells {
+			ref := columnName(col) + strconv.Itoa(rowNum)
+			styleAttr := ""
+			if style > 0 {
+				styleAttr = fmt.Sprintf(` s="%d"`, style)
+			}
+			switch n := v.(type) {
+			case nil:
+				continue
+			case int:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%d</v></c>`, ref, styleAttr, n)
+			case int64:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%d</v></c>`, ref, styleAttr, n)
+			case float64:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%s</v></c>`, ref, styleAttr, strconv.FormatFloat(n, 'f', -1, 64))
+			default:
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">%s</t></is></c>`, ref, styleAttr, escape(fmt.Sprint(v)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+
+	headers := make([]interface{}, len(s.Headers))
+	for i, h := range s.Headers {
+		headers[i] = h
+	}
+	writeRow(1, headers, 1)
+	for i, row := range s.Rows {
+		writeRow(i+2, row, 0)
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// columnName converts a zero-based column index to A, B, ..., Z, AA, ...
+func columnName(col int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name
+}
+
+// sheetName makes a name Excel accepts: max 31 chars, none of []:*?/\
+func sheetName(name string, index int) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	if len([]rune(name)) > 31 {
+		name = string([]rune(name)[:31])
+	}
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", index+1)
+	}
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	// Control characters other than tab/newline are invalid in XML 1.0
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -12,7 +12,10 @@ import (
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/config"
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/database"
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/router"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/backup"
 	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/email"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/media"
+	"github.com/FoxyHunter7/geocachingbrughia-backend/internal/services/storage"
 	"github.com/joho/godotenv"
 )
 
@@ -30,6 +33,21 @@ func main() {
 		log.Fatalf("Configuration error: %v", err)
 	}
 
+	// Maintenance subcommands (backup, restore, ...) run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1:]))
+	}
+
+	// Hold the database lock while serving, so a restore cannot swap the
+	// database out from under the server
+	release, err := database.Lock(cfg.DatabasePath)
+	if err == database.ErrInUse {
+		log.Fatalf("Database %s is in use: is a restore or another server running?", cfg.DatabasePath)
+	} else if err != nil {
+		log.Fatalf("Failed to lock database: %v", err)
+	}
+	defer release()
+
 	// Initialize database
 	db, err := database.New(cfg.DatabasePath)
 	if err != nil {
@@ -50,6 +68,13 @@ func main() {
 	// Initialize email service
 	emailService := email.New(cfg.SMTP)
 
+	// Initialize storage for uploaded images and files
+	store, err := storage.New(cfg.Storage, cfg.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	log.Printf("Storage: %s", store.Name())
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -57,8 +82,14 @@ func main() {
 	// Start reminder scheduler (checks every hour)
 	go emailService.StartReminderScheduler(ctx, db, cfg.ReminderDays)
 
+	// Start unused image cleanup (checks daily, off unless MEDIA_ORPHAN_DAYS is set)
+	go media.StartCleanupScheduler(ctx, db, store, cfg.MediaOrphanDays)
+
+	// Start scheduled backups (every BACKUP_INTERVAL_HOURS, 0 disables them)
+	go backup.StartScheduler(ctx, db, store, cfg.Backup.Dir, cfg.Backup.IntervalHours, cfg.Backup.Keep)
+
 	// Set up router
-	r := router.New(db, cfg, emailService)
+	r := router.New(db, cfg, emailService, store)
 
 	// Create HTTP server
 	addr := ":" + cfg.Port
@@ -58,6 +58,24 @@ export default function setupRouter() {
         component: EventsView,
         alias: StaticContentProvider.ROUTES.navEvents.aliases
       },
+      {
+        path: '/event/check-in',
+        name: "eventCheckIn",
+        props: false,
+        component: () => import('@/views/EventCheckInView.vue')
+      },
+      {
+        path: '/event/registrations/:token',
+        name: "eventRegistration",
+        props: true,
+        component: () => import('@/views/EventRegistrationView.vue')
+      },
+      {
+        path: '/event/tickets/:code',
+        name: "eventTicket",
+        props: true,
+        component: () => import('@/views/EventTicketView.vue')
+      },
       {
         path: '/event/:uuid',
         name: "eventDetail",
@@ -192,6 +210,18 @@ export default function setupRouter() {
         props: true,
         component: () => import('@/views/ShopCancelView.vue')
       },
+      {
+        path: '/shop/orders',
+        name: "shopOrderLookup",
+        props: false,
+        component: () => import('@/views/ShopOrderLookupView.vue')
+      },
+      {
+        path: '/shop/orders/:token',
+        name: "shopOrder",
+        props: true,
+        component: () => import('@/views/ShopOrderView.vue')
+      },
       {
         path: '/:pathMatch(.*)',
         name: "NotFound",
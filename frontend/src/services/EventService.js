@@ -1,4 +1,5 @@
-import { fetchFromServer } from "./fetcher"
+import config from "../data/config.js"
+import { fetchFromServer, fetchToServer } from "./fetcher"
 
 async function getAllEvents(admin = false, page = null, search= "", perPage = null, sortBy = "", sortDirection = "asc") {
     return fetchFromServer("events", admin, page, search, perPage, sortBy, sortDirection);
@@ -8,4 +9,66 @@ async function getHomePageEvents() {
     return fetchFromServer("home_events");
 }
 
-export { getAllEvents, getHomePageEvents };
\ No newline at end of file
+// Registration status, by the token in the registrant's mails
+async function getEventRegistration(token) {
+    return fetchFromServer(`events/registrations/${encodeURIComponent(token)}`);
+}
+
+async function startEventRegistrationCheckout(token) {
+    return fetchToServer(`events/registrations/${encodeURIComponent(token)}/checkout`, "POST", "", false);
+}
+
+async function cancelEventRegistration(token) {
+    return fetchToServer(`events/registrations/${encodeURIComponent(token)}/cancel`, "POST", "", false);
+}
+
+// A ticket by the code and signature in its QR code, or null when the
+// signature doesn't match
+async function getEventTicket(code, sig) {
+    const params = new URLSearchParams({ sig });
+    try {
+        const response = await fetch(`${config.apiUrl}events/tickets/${encodeURIComponent(code)}?${params}`, {
+            headers: { "Accept": "application/json" }
+        });
+        if (!response.ok) {
+            return null;
+        }
+        return await response.json();
+    } catch (err) {
+        console.error("Failed to fetch (endpoint: events/tickets)");
+        return null;
+    }
+}
+
+// Door check-in with a volunteer key. Returns the HTTP status with the
+// response body, as the scanner shows rejections too; status 0 when the
+// server can't be reached.
+async function checkinRequest(method, key, body = undefined) {
+    try {
+        const response = await fetch(`${config.apiUrl}events/check-in`, {
+            method,
+            headers: {
+                "Accept": "application/json",
+                "Content-Type": "application/json",
+                "X-Checkin-Key": key
+            },
+            body
+        });
+        const data = await response.json().catch(() => null);
+        return { status: response.status, data };
+    } catch (err) {
+        console.error(`Failed to ${method} (endpoint: events/check-in)`);
+        return { status: 0, data: null };
+    }
+}
+
+async function volunteerCheckIn(key, code) {
+    return checkinRequest("POST", key, JSON.stringify({ code }));
+}
+
+async function getVolunteerCheckIns(key) {
+    return checkinRequest("GET", key);
+}
+
+export { getAllEvents, getHomePageEvents, getEventRegistration, startEventRegistrationCheckout, cancelEventRegistration, getEventTicket,
+    volunteerCheckIn, getVolunteerCheckIns };
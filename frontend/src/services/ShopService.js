@@ -1,3 +1,4 @@
+import config from "../data/config.js"
 import { fetchFromServer, fetchToServer, deleteFromServer } from "./fetcher";
 
 // Public shop
@@ -17,6 +18,33 @@ export async function createCheckoutSession(data) {
     return fetchToServer("shop/checkout", "POST", JSON.stringify(data), false);
 }
 
+// Order status, by the token in the buyer's mails
+export async function getShopOrder(token) {
+    return fetchFromServer(`shop/orders/${encodeURIComponent(token)}`);
+}
+
+export async function requestShopOrderLookup(email, lang) {
+    return fetchToServer("shop/orders/lookup", "POST", JSON.stringify({ email, lang }), false);
+}
+
+// The orders of the signed link in the lookup mail, or null when the link
+// is invalid or expired
+export async function getShopOrderLookup(email, expires, sig) {
+    const params = new URLSearchParams({ email, expires, sig });
+    try {
+        const response = await fetch(`${config.apiUrl}shop/orders/lookup?${params}`, {
+            headers: { "Accept": "application/json" }
+        });
+        if (!response.ok) {
+            return null;
+        }
+        return await response.json();
+    } catch (err) {
+        console.error("Failed to fetch (endpoint: shop/orders/lookup)");
+        return null;
+    }
+}
+
 // Admin shop settings
 export async function getAdminShopSettings() {
     return fetchFromServer("admin/shop/settings", true);
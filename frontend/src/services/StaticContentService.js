@@ -67,7 +67,7 @@ class StaticContentProvider {
 
     async #fetchDitcionary() {
         try {
-            const response = await fetch(`${config.apiUrl}static`);
+            const response = await fetch(`${config.apiUrl}static?lang=all`);
 
             if (!response.ok) {
                 throw new Error("Bad fetch response", response);